@@ -125,6 +125,16 @@ type Args struct {
 
 	DocsCmd *DocsGenerateArgs `arg:"subcommand:docs" help:"generate documentation"`
 
+	LspCmd *LspArgs `arg:"subcommand:lsp" help:"run the mcl language server"`
+
+	FmtCmd *FmtArgs `arg:"subcommand:fmt" help:"format mcl code"`
+
+	EventsCmd *EventsArgs `arg:"subcommand:events" help:"query the local resource event log"`
+
+	TestCmd *TestArgs `arg:"subcommand:test" help:"run the unit tests of mcl code"`
+
+	ReplCmd *ReplArgs `arg:"subcommand:repl" help:"run an interactive mcl shell"`
+
 	// This never runs, it gets preempted in the real main() function.
 	// XXX: Can we do it nicely with the new arg parser? can it ignore all args?
 	EtcdCmd *EtcdArgs `arg:"subcommand:etcd" help:"run standalone etcd"`
@@ -173,6 +183,26 @@ func (obj *Args) Run(ctx context.Context, data *cliUtil.Data) (bool, error) {
 		return cmd.Run(ctx, data)
 	}
 
+	if cmd := obj.LspCmd; cmd != nil {
+		return cmd.Run(ctx, data)
+	}
+
+	if cmd := obj.FmtCmd; cmd != nil {
+		return cmd.Run(ctx, data)
+	}
+
+	if cmd := obj.EventsCmd; cmd != nil {
+		return cmd.Run(ctx, data)
+	}
+
+	if cmd := obj.TestCmd; cmd != nil {
+		return cmd.Run(ctx, data)
+	}
+
+	if cmd := obj.ReplCmd; cmd != nil {
+		return cmd.Run(ctx, data)
+	}
+
 	// NOTE: we could return true, fmt.Errorf("...") if more than one did
 	return false, nil // nobody activated
 }
@@ -34,15 +34,18 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"time"
 
 	cliUtil "github.com/purpleidea/mgmt/cli/util"
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/etcd"
 	"github.com/purpleidea/mgmt/etcd/client"
+	"github.com/purpleidea/mgmt/etcd/deployer"
 	etcdfs "github.com/purpleidea/mgmt/etcd/fs"
 	etcdSSH "github.com/purpleidea/mgmt/etcd/ssh"
 	"github.com/purpleidea/mgmt/gapi"
 	"github.com/purpleidea/mgmt/lib"
+	"github.com/purpleidea/mgmt/pgp"
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
 
@@ -50,6 +53,12 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	// rolloutPollInterval is how often we look at the hosts during a
+	// rollout.
+	rolloutPollInterval = 1 * time.Second
+)
+
 // DeployArgs is the CLI parsing structure and type of the parsed result. This
 // particular one contains all the common flags for the `deploy` subcommand
 // which all frontends can use.
@@ -71,6 +80,26 @@ type DeployArgs struct {
 
 	NoAutoEdges bool `arg:"--no-autoedges" help:"skip the autoedges stage"`
 
+	// SignKey is the path to a private pgp key which the deploy is signed
+	// with. The hosts can be told to only run deploys which are signed.
+	SignKey string `arg:"--sign-key,env:MGMT_SIGN_KEY" help:"sign the deploy with the private pgp key at this path"`
+
+	// Canary, CanaryPercent and BatchSize turn on a gradual rollout, where
+	// only some of the hosts get the new deploy at first. We wait for
+	// those to converge before we continue, and we roll back if more than
+	// MaxFailures of them fail or don't converge within RolloutTimeout.
+	Canary         []string `arg:"--canary,separate" help:"hosts which get the new deploy first"`
+	CanaryPercent  int      `arg:"--canary-percent" help:"percentage of hosts which get the new deploy first"`
+	BatchSize      int      `arg:"--batch-size" help:"number of hosts which get the new deploy at a time after the canaries"`
+	MaxFailures    int      `arg:"--max-failures" help:"roll back if more than this many hosts fail"`
+	RolloutTimeout int      `arg:"--rollout-timeout" default:"600" help:"seconds to wait for each batch of hosts to converge"`
+
+	DeployStatus   *cliUtil.DeployStatusArgs   `arg:"subcommand:status" help:"show the status of a deploy and its rollout"`
+	DeployList     *cliUtil.DeployListArgs     `arg:"subcommand:list" help:"list the previous deploys"`
+	DeployShow     *cliUtil.DeployShowArgs     `arg:"subcommand:show" help:"show a deploy and the files it contains"`
+	DeployDiff     *cliUtil.DeployDiffArgs     `arg:"subcommand:diff" help:"show the differences between two deploys"`
+	DeployRollback *cliUtil.DeployRollbackArgs `arg:"subcommand:rollback" help:"publish an earlier deploy again as a new deploy"`
+
 	DeployEmpty      *cliUtil.EmptyArgs      `arg:"subcommand:empty" help:"deploy empty payload"`
 	DeployLang       *cliUtil.LangArgs       `arg:"subcommand:lang" help:"deploy lang (mcl) payload"`
 	DeployYaml       *cliUtil.YamlArgs       `arg:"subcommand:yaml" help:"deploy yaml graph payload"`
@@ -88,6 +117,22 @@ type DeployArgs struct {
 // fact, you can deploy with multiple different frontends, one after another, on
 // the same engine.
 func (obj *DeployArgs) Run(ctx context.Context, data *cliUtil.Data) (bool, error) {
+	if cmd := obj.DeployStatus; cmd != nil {
+		return obj.status(ctx, data, cmd)
+	}
+	if cmd := obj.DeployList; cmd != nil {
+		return obj.list(ctx, data, cmd)
+	}
+	if cmd := obj.DeployShow; cmd != nil {
+		return obj.show(ctx, data, cmd)
+	}
+	if cmd := obj.DeployDiff; cmd != nil {
+		return obj.diff(ctx, data, cmd)
+	}
+	if cmd := obj.DeployRollback; cmd != nil {
+		return obj.rollback(ctx, data, cmd)
+	}
+
 	var name string
 	var args interface{}
 	if cmd := obj.DeployEmpty; cmd != nil {
@@ -292,17 +337,198 @@ func (obj *DeployArgs) Run(ctx context.Context, data *cliUtil.Data) (bool, error
 	deploy.Sema = obj.Sema
 
 	deploy.NoAutoEdges = obj.NoAutoEdges
+	deploy.FsURI = etcdFs.URI()
 
 	str, err := deploy.ToB64()
 	if err != nil {
 		return false, errwrap.Wrapf(err, "encoding error")
 	}
 
+	signature, err := obj.sign(ctx, world, id, hash, &str, etcdFs, Logf)
+	if err != nil {
+		return false, err
+	}
+
+	if len(obj.Canary) > 0 || obj.CanaryPercent > 0 || obj.BatchSize > 0 {
+		return obj.rollout(ctx, world, id, max, hash, pHash, &str, signature, Logf)
+	}
+
 	Logf("pushing...")
 	// this nominally checks the previous git hash matches our expectation
-	if err := world.AddDeploy(ctx, id, hash, pHash, &str); err != nil {
+	if err := world.AddDeploy(ctx, id, hash, pHash, &str, signature); err != nil {
 		return false, errwrap.Wrapf(err, "could not create deploy id `%d`", id)
 	}
 	Logf("success, id: %d", id)
 	return true, nil
 }
+
+// sign signs the deploy if we were given a key, and returns the signature for
+// the hosts to check. It's empty if there is no key. It must run right before
+// the deploy is added, because the signature also covers the hash of the deploy
+// that is currently the newest. The signature is stored along with the deploy.
+// It also covers the content of every file in the deploy file system.
+func (obj *DeployArgs) sign(ctx context.Context, world engine.World, id uint64, hash string, data *string, fs engine.Fs, Logf func(format string, v ...interface{})) (string, error) {
+	if obj.SignKey == "" {
+		return "", nil
+	}
+	if fs == nil {
+		return "", fmt.Errorf("the deploy has no file system to sign")
+	}
+	key, err := pgp.Import(obj.SignKey)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "can't import the signing key")
+	}
+	fsHash, err := deployer.FsHash(fs)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "can't hash the deploy file system")
+	}
+
+	var pHash string
+	if id > 1 {
+		if pHash, err = world.GetDeployHash(ctx, id-1); err != nil {
+			return "", err
+		}
+	}
+	signature, err := key.Sign(deployer.SignedMessage(id, hash, pHash, *data, fsHash))
+	if err != nil {
+		return "", err
+	}
+	Logf("signed with key: %s", key.Entity.PrimaryKey.KeyIdShortString())
+	return signature, nil
+}
+
+// signRollout signs the current state of the rollout if we have a key. It must
+// run before each change to the rollout is stored.
+func signRollout(key *pgp.PGP, rollout *deployer.Rollout) error {
+	if key == nil {
+		return nil
+	}
+	msg, err := rollout.SignedMessage()
+	if err != nil {
+		return err
+	}
+	signature, err := key.Sign(msg)
+	if err != nil {
+		return errwrap.Wrapf(err, "can't sign the rollout")
+	}
+	rollout.Signature = signature
+	return nil
+}
+
+// rollout pushes the deploy with a rollout policy and then drives the rollout
+// until every host runs it, or until it gets rolled back. If we're interrupted,
+// the rollout stays where it is, and no more hosts get the new deploy.
+func (obj *DeployArgs) rollout(ctx context.Context, world engine.World, id, max uint64, hash, pHash string, data *string, signature string, Logf func(format string, v ...interface{})) (bool, error) {
+	if obj.RolloutTimeout <= 0 {
+		return false, fmt.Errorf("the rollout timeout must be positive")
+	}
+
+	// the hosts which aren't admitted yet keep running the last good one
+	previous := max
+	if max > 0 {
+		r, err := world.GetRollout(ctx, max)
+		if err != nil {
+			return false, errwrap.Wrapf(err, "could not get the previous rollout")
+		}
+		if r != nil && r.Stage == deployer.RolloutStageRolledBack {
+			previous = r.Previous
+		}
+		if r != nil && r.Stage != deployer.RolloutStageDone && r.Stage != deployer.RolloutStageRolledBack {
+			if !obj.Force {
+				return false, fmt.Errorf("the rollout of deploy %d is still in progress", max)
+			}
+			previous = r.Previous // the hosts can't all run max yet
+		}
+	}
+
+	rollout := &deployer.Rollout{
+		ID:            id,
+		Previous:      previous,
+		Canaries:      obj.Canary,
+		CanaryPercent: obj.CanaryPercent,
+		BatchSize:     obj.BatchSize,
+		MaxFailures:   obj.MaxFailures,
+	}
+	if err := rollout.Validate(); err != nil {
+		return false, errwrap.Wrapf(err, "invalid rollout")
+	}
+	hosts, err := world.GetHosts(ctx)
+	if err != nil {
+		return false, err
+	}
+	rollout.Start(deployer.SortedHosts(hosts))
+
+	// The hosts only publish their converged state if their converger runs,
+	// which needs a --converged-timeout. An admitted host that doesn't could
+	// never converge, and we'd wait for the timeout, and then roll back.
+	if len(hosts) == 0 {
+		return false, fmt.Errorf("no hosts publish their converged state, run them with --converged-timeout")
+	}
+	for _, x := range rollout.Hosts {
+		if _, exists := hosts[x]; !exists {
+			return false, fmt.Errorf("host `%s` doesn't publish its converged state, run it with --converged-timeout", x)
+		}
+	}
+
+	// every state of the rollout is signed, so that the hosts can trust it
+	var key *pgp.PGP
+	if obj.SignKey != "" {
+		if key, err = pgp.Import(obj.SignKey); err != nil {
+			return false, errwrap.Wrapf(err, "can't import the signing key")
+		}
+	}
+	if err := signRollout(key, rollout); err != nil {
+		return false, err
+	}
+
+	Logf("pushing...")
+	if err := world.AddDeployRollout(ctx, id, hash, pHash, data, signature, rollout); err != nil {
+		return false, errwrap.Wrapf(err, "could not create deploy id `%d`", id)
+	}
+	Logf("success, id: %d", id)
+	Logf("rollout: %s: %s", rollout.Stage, rollout.Message)
+
+	timeout := time.Duration(obj.RolloutTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+	for rollout.Stage != deployer.RolloutStageDone && rollout.Stage != deployer.RolloutStageRolledBack {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			Logf("rollout: interrupted while at the %s stage", rollout.Stage)
+			return false, ctx.Err()
+		}
+
+		hosts, err := world.GetHosts(ctx)
+		if err != nil {
+			Logf("rollout: error getting hosts: %+v", err)
+			continue
+		}
+		status, err := world.GetDeployStatus(ctx, id)
+		if err != nil {
+			Logf("rollout: error getting status: %+v", err)
+			continue
+		}
+
+		count := len(rollout.Hosts)
+		if !rollout.Step(deployer.SortedHosts(hosts), status, time.Now().After(deadline)) {
+			continue
+		}
+		if len(rollout.Hosts) != count { // a new batch gets a fresh timeout
+			deadline = time.Now().Add(timeout)
+		}
+		Logf("rollout: %s: %s", rollout.Stage, rollout.Message)
+		if err := signRollout(key, rollout); err != nil {
+			return false, err
+		}
+		if err := world.SetRollout(ctx, rollout); err != nil {
+			return false, errwrap.Wrapf(err, "could not update the rollout")
+		}
+	}
+
+	if rollout.Stage == deployer.RolloutStageRolledBack {
+		return false, fmt.Errorf("deploy %d was rolled back", id)
+	}
+	return true, nil
+}
@@ -0,0 +1,454 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/gapi"
+	"github.com/purpleidea/mgmt/lib"
+	"github.com/purpleidea/mgmt/pgp"
+	"github.com/purpleidea/mgmt/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"github.com/kylelemons/godebug/diff"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/openpgp"
+)
+
+const (
+	// diffContext is the number of unchanged lines that we show around each
+	// change in a diff.
+	diffContext = 3
+)
+
+// getDeploy returns the decoded deploy with the specified id.
+func getDeploy(ctx context.Context, world engine.World, id uint64) (*gapi.Deploy, error) {
+	if id == 0 {
+		return nil, fmt.Errorf("deploy ids start at one")
+	}
+	str, err := world.GetDeploy(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	deploy, err := gapi.NewDeployFromB64(str)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not decode deploy id `%d`", id)
+	}
+	deploy.ID = id
+	return deploy, nil
+}
+
+// deployFs returns the file system that the deploy stored its code in. It is
+// nil if the deploy has no files.
+func deployFs(world engine.World, deploy *gapi.Deploy) (engine.Fs, error) {
+	uri := deploy.URI()
+	if uri == "" {
+		return nil, nil
+	}
+	return world.Fs(uri)
+}
+
+// deployFiles returns a map of path to contents of every file in the deploy.
+func deployFiles(world engine.World, deploy *gapi.Deploy) (map[string]string, error) {
+	result := make(map[string]string)
+	fs, err := deployFs(world, deploy)
+	if err != nil || fs == nil {
+		return result, err
+	}
+	walkFn := func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		data, err := afero.ReadFile(fs, name)
+		if err != nil {
+			return err
+		}
+		result[name] = string(data)
+		return nil
+	}
+	if err := afero.Walk(fs, "/", walkFn); err != nil {
+		return nil, errwrap.Wrapf(err, "could not read the files of deploy id `%d`", deploy.ID)
+	}
+	return result, nil
+}
+
+// deployFields returns the displayed settings of a deploy in a stable order.
+func deployFields(deploy *gapi.Deploy, hash string) [][2]string {
+	if hash == "" {
+		hash = "-" // added without git
+	}
+	return [][2]string{
+		{"gapi", deploy.Name},
+		{"hash", hash},
+		{"noop", fmt.Sprintf("%t", deploy.Noop)},
+		{"sema", fmt.Sprintf("%d", deploy.Sema)},
+		{"autoedges", fmt.Sprintf("%t", !deploy.NoAutoEdges)},
+	}
+}
+
+// list is the run for the `deploy list` subcommand. It shows every deploy that
+// is stored, the oldest first. The output goes to stdout.
+func (obj *DeployArgs) list(ctx context.Context, data *cliUtil.Data, args *cliUtil.DeployListArgs) (bool, error) {
+	Logf := func(format string, v ...interface{}) {
+		data.Flags.Logf("deploy: "+format, v...)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+
+	world, cleanup, err := obj.deployWorld(data, Logf)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+
+	deploys, err := world.GetDeploys(ctx)
+	if err != nil {
+		return false, err
+	}
+	ids := []uint64{}
+	for id := range deploys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	fmt.Printf("%-6s  %-10s  %-12s  %s\n", "id", "gapi", "hash", "rollout")
+	for _, id := range ids {
+		name := "?" // we might not be able to decode old deploys
+		if deploy, err := gapi.NewDeployFromB64(deploys[id]); err == nil {
+			name = deploy.Name
+		}
+		hash, err := world.GetDeployHash(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if len(hash) > 12 {
+			hash = hash[:12] // short form
+		}
+		if hash == "" {
+			hash = "-"
+		}
+		stage := "-"
+		rollout, err := world.GetRollout(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if rollout != nil {
+			stage = rollout.Stage
+		}
+		fmt.Printf("%-6d  %-10s  %-12s  %s\n", id, name, hash, stage)
+	}
+
+	return true, nil
+}
+
+// show is the run for the `deploy show` subcommand. It shows the settings of
+// the deploy and the tree of files which were stored with it. It can extract
+// those files into a directory. The output goes to stdout.
+func (obj *DeployArgs) show(ctx context.Context, data *cliUtil.Data, args *cliUtil.DeployShowArgs) (bool, error) {
+	Logf := func(format string, v ...interface{}) {
+		data.Flags.Logf("deploy: "+format, v...)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+
+	world, cleanup, err := obj.deployWorld(data, Logf)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+
+	deploy, err := getDeploy(ctx, world, args.ID)
+	if err != nil {
+		return false, err
+	}
+	hash, err := world.GetDeployHash(ctx, args.ID)
+	if err != nil {
+		return false, err
+	}
+	rollout, err := world.GetRollout(ctx, args.ID)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Printf("deploy: %d\n", deploy.ID)
+	for _, x := range deployFields(deploy, hash) {
+		fmt.Printf("%s: %s\n", x[0], x[1])
+	}
+	if rollout != nil {
+		fmt.Printf("rollout: %s\n", rollout.Stage)
+	}
+	signature, err := world.GetDeploySignature(ctx, args.ID)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("signed: %t\n", signature != "")
+
+	fs, err := deployFs(world, deploy)
+	if err != nil {
+		return false, err
+	}
+	if fs == nil {
+		fmt.Printf("files: none\n")
+		return true, nil
+	}
+	fmt.Printf("uri: %s\n", fs.URI())
+	tree, err := util.FsTree(fs, "/")
+	if err != nil {
+		return false, errwrap.Wrapf(err, "could not read the files")
+	}
+	fmt.Printf("\n%s", tree)
+
+	if args.Output == "" {
+		return true, nil
+	}
+	files, err := deployFiles(world, deploy)
+	if err != nil {
+		return false, err
+	}
+	for name, content := range files {
+		p := filepath.Join(args.Output, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return false, errwrap.Wrapf(err, "could not extract the files")
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			return false, errwrap.Wrapf(err, "could not extract the files")
+		}
+	}
+	Logf("extracted %d file(s) to: %s", len(files), args.Output)
+
+	return true, nil
+}
+
+// diff is the run for the `deploy diff` subcommand. It shows the settings that
+// differ between the two deploys, and the changes to each file. The output goes
+// to stdout.
+func (obj *DeployArgs) diff(ctx context.Context, data *cliUtil.Data, args *cliUtil.DeployDiffArgs) (bool, error) {
+	Logf := func(format string, v ...interface{}) {
+		data.Flags.Logf("deploy: "+format, v...)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+
+	world, cleanup, err := obj.deployWorld(data, Logf)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+
+	fields := [][][2]string{}
+	files := []map[string]string{}
+	for _, id := range []uint64{args.A, args.B} {
+		deploy, err := getDeploy(ctx, world, id)
+		if err != nil {
+			return false, err
+		}
+		hash, err := world.GetDeployHash(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		m, err := deployFiles(world, deploy)
+		if err != nil {
+			return false, err
+		}
+		fields = append(fields, deployFields(deploy, hash))
+		files = append(files, m)
+	}
+
+	fmt.Printf("--- deploy %d\n", args.A)
+	fmt.Printf("+++ deploy %d\n", args.B)
+	for i, x := range fields[0] {
+		if y := fields[1][i]; x[1] != y[1] {
+			fmt.Printf("%s: %s -> %s\n", x[0], x[1], y[1])
+		}
+	}
+
+	names := []string{}
+	for name := range files[0] {
+		names = append(names, name)
+	}
+	for name := range files[1] {
+		if _, exists := files[0][name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		a, inA := files[0][name]
+		b, inB := files[1][name]
+		switch {
+		case !inB:
+			fmt.Printf("only in %d: %s\n", args.A, name)
+		case !inA:
+			fmt.Printf("only in %d: %s\n", args.B, name)
+		case a == b:
+			// same
+		case strings.Contains(a, "\x00") || strings.Contains(b, "\x00"):
+			fmt.Printf("binary file differs: %s\n", name)
+		default:
+			fmt.Printf("diff %s\n", name)
+			fmt.Printf("%s", diffLines(a, b))
+		}
+	}
+
+	return true, nil
+}
+
+// diffLines returns the changed lines between the two strings, with a few lines
+// of context around each change, in a style similar to a unified diff.
+func diffLines(a, b string) string {
+	chunks := diff.DiffChunks(strings.Split(a, "\n"), strings.Split(b, "\n"))
+	var s strings.Builder
+	for i, c := range chunks {
+		for _, x := range c.Deleted {
+			s.WriteString("-" + x + "\n")
+		}
+		for _, x := range c.Added {
+			s.WriteString("+" + x + "\n")
+		}
+
+		head := 0 // context after this change
+		if len(c.Deleted)+len(c.Added) > 0 {
+			head = diffContext
+		}
+		tail := 0 // context before the next change
+		if i < len(chunks)-1 {
+			tail = diffContext
+		}
+		eq := c.Equal
+		if head+tail < len(eq) {
+			for _, x := range eq[:head] {
+				s.WriteString(" " + x + "\n")
+			}
+			s.WriteString("@@\n") // skipped some lines
+			eq = eq[len(eq)-tail:]
+		}
+		for _, x := range eq {
+			s.WriteString(" " + x + "\n")
+		}
+	}
+	return s.String()
+}
+
+// rollback is the run for the `deploy rollback` subcommand. It publishes the
+// payload of an earlier deploy again as a new deploy. The new deploy carries
+// the hash of the newest deploy, so that the hash chain stays intact, and the
+// next deploy from git doesn't need to be forced. If any rollout flags were
+// given, then it is rolled out like any other deploy.
+func (obj *DeployArgs) rollback(ctx context.Context, data *cliUtil.Data, args *cliUtil.DeployRollbackArgs) (bool, error) {
+	Logf := func(format string, v ...interface{}) {
+		data.Flags.Logf("deploy: "+format, v...)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+
+	world, cleanup, err := obj.deployWorld(data, Logf)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+
+	max, err := world.GetMaxDeployID(ctx)
+	if err != nil {
+		return false, errwrap.Wrapf(err, "error getting max deploy id")
+	}
+	if args.ID == 0 || args.ID > max {
+		return false, fmt.Errorf("there is no deploy id `%d`", args.ID)
+	}
+	if args.ID == max {
+		return false, fmt.Errorf("deploy id `%d` is already the newest", args.ID)
+	}
+	str, err := world.GetDeploy(ctx, args.ID)
+	if err != nil {
+		return false, err
+	}
+	deploy, err := gapi.NewDeployFromB64(str) // check it
+	if err != nil {
+		return false, errwrap.Wrapf(err, "could not decode deploy id `%d`", args.ID)
+	}
+	var fs engine.Fs // only needed to sign it
+	if obj.SignKey != "" {
+		// We only sign what a trusted key signed before, and we sign
+		// the same copy of the files that its signature was checked in.
+		if args.DeployKeyring == "" {
+			return false, fmt.Errorf("a deploy keyring is needed to check deploy id `%d` before signing it again", args.ID)
+		}
+		keyring, err := pgp.ReadKeyring(args.DeployKeyring)
+		if err != nil {
+			return false, errwrap.Wrapf(err, "can't read deploy keyring")
+		}
+		var entity *openpgp.Entity
+		fs, entity, err = lib.VerifyDeploy(ctx, world, keyring, args.ID, deploy, str)
+		if err != nil {
+			return false, errwrap.Wrapf(err, "refusing to sign deploy id `%d` again", args.ID)
+		}
+		for name := range entity.Identities {
+			Logf("deploy id %d was signed by: %s", args.ID, name)
+			break // just show one
+		}
+	}
+	hash, err := world.GetDeployHash(ctx, max)
+	if err != nil {
+		return false, err
+	}
+
+	id := max + 1
+	Logf("rolling back to deploy id %d as id %d", args.ID, id)
+	signature, err := obj.sign(ctx, world, id, hash, &str, fs, Logf)
+	if err != nil {
+		return false, err
+	}
+	if len(obj.Canary) > 0 || obj.CanaryPercent > 0 || obj.BatchSize > 0 {
+		return obj.rollout(ctx, world, id, max, hash, hash, &str, signature, Logf)
+	}
+
+	// the hash is unchanged, so this checks that nobody raced us
+	if err := world.AddDeploy(ctx, id, hash, hash, &str, signature); err != nil {
+		return false, errwrap.Wrapf(err, "could not create deploy id `%d`", id)
+	}
+	Logf("success, id: %d", id)
+	return true, nil
+}
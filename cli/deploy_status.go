@@ -0,0 +1,173 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/etcd"
+	"github.com/purpleidea/mgmt/lib"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+// deployWorld returns a world which the deploy subcommands that only look at
+// the existing deploys can use. It returns a cleanup function which must be
+// called when we are done.
+func (obj *DeployArgs) deployWorld(data *cliUtil.Data, Logf func(format string, v ...interface{})) (engine.World, func(), error) {
+	if obj.SSHURL != "" {
+		return nil, nil, fmt.Errorf("--ssh-url is not implemented yet")
+	}
+	world := &etcd.World{
+		Seeds:          obj.Seeds,
+		NS:             lib.NS,
+		MetadataPrefix: lib.MetadataPrefix,
+		StoragePrefix:  lib.StoragePrefix,
+	}
+	worldInit := &engine.WorldInit{
+		Hostname: "",
+		Debug:    data.Flags.Debug,
+		Logf: func(format string, v ...interface{}) {
+			Logf("world: etcd: "+format, v...)
+		},
+	}
+	if err := world.Init(worldInit); err != nil {
+		return nil, nil, errwrap.Wrapf(err, "world Init failed")
+	}
+	cleanup := func() {
+		err := errwrap.Wrapf(world.Close(), "world Close failed")
+		if err != nil {
+			Logf("close error: %+v", err)
+		}
+	}
+	return world, cleanup, nil
+}
+
+// status is the run for the `deploy status` subcommand. It shows the deploy,
+// where its rollout is, and what each host reported. The output goes to stdout.
+func (obj *DeployArgs) status(ctx context.Context, data *cliUtil.Data, args *cliUtil.DeployStatusArgs) (bool, error) {
+	Logf := func(format string, v ...interface{}) {
+		data.Flags.Logf("deploy: "+format, v...)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+
+	world, cleanup, err := obj.deployWorld(data, Logf)
+	if err != nil {
+		return false, err
+	}
+	defer cleanup()
+
+	id := args.ID
+	if id == 0 {
+		if id, err = world.GetMaxDeployID(ctx); err != nil {
+			return false, errwrap.Wrapf(err, "error getting max deploy id")
+		}
+	}
+	if id == 0 {
+		fmt.Printf("no deploys\n")
+		return true, nil
+	}
+	if _, err := world.GetDeploy(ctx, id); err != nil {
+		return false, err
+	}
+
+	rollout, err := world.GetRollout(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	hosts, err := world.GetHosts(ctx)
+	if err != nil {
+		return false, err
+	}
+	status, err := world.GetDeployStatus(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Printf("deploy: %d\n", id)
+	if rollout == nil {
+		fmt.Printf("rollout: none\n")
+	} else {
+		fmt.Printf("rollout: %s\n", rollout.Stage)
+		fmt.Printf("previous: %d\n", rollout.Previous)
+		fmt.Printf("admitted: %d of %d host(s)\n", len(rollout.Hosts), len(hosts))
+		if len(rollout.Failed) > 0 {
+			fmt.Printf("failed: %s (max %d)\n", strings.Join(rollout.Failed, ", "), rollout.MaxFailures)
+		}
+		if rollout.Message != "" {
+			fmt.Printf("message: %s\n", rollout.Message)
+		}
+	}
+
+	// show the running hosts and anyone who reported on this deploy
+	names := []string{}
+	for x := range hosts {
+		names = append(names, x)
+	}
+	for x := range status {
+		if _, exists := hosts[x]; !exists {
+			names = append(names, x)
+		}
+	}
+	sort.Strings(names)
+
+	width := len("host")
+	for _, x := range names {
+		if len(x) > width {
+			width = len(x)
+		}
+	}
+	fmt.Printf("\n%-*s  %-8s  %-10s  %s\n", width, "host", "deploy", "status", "converged")
+	for _, x := range names {
+		running := id
+		if rollout != nil {
+			running = rollout.DeployFor(x)
+		}
+		s := status[x]
+		if s == "" {
+			s = "-"
+		}
+		converged := "-" // not running
+		if c, exists := hosts[x]; exists {
+			converged = fmt.Sprintf("%t", c)
+		}
+		fmt.Printf("%-*s  %-8d  %-10s  %s\n", width, x, running, s, converged)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,123 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/engine/eventlog"
+	"github.com/purpleidea/mgmt/engine/graph"
+	"github.com/purpleidea/mgmt/lib"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+// EventsArgs is the CLI parsing structure and type of the parsed result. This
+// particular one contains all the common flags for the `events` subcommand.
+type EventsArgs struct {
+	cliUtil.EventsArgs // embedded config (can't be a pointer) https://github.com/alexflint/go-arg/issues/240
+}
+
+// Run executes the correct subcommand. It errors if there's ever an error. It
+// returns true if we did activate one of the subcommands. It returns false if
+// we did not. This information is used so that the top-level parser can return
+// usage or help information if no subcommand activates. This particular Run is
+// the run for the main `events` subcommand. It reads the event log of the local
+// resource engine and prints each matching record to stdout as a JSON object on
+// its own line.
+func (obj *EventsArgs) Run(ctx context.Context, data *cliUtil.Data) (bool, error) {
+	program := cliUtil.SafeProgram(data.Program)
+
+	prefix, err := lib.DefaultPrefix(program)
+	if err != nil {
+		return false, err
+	}
+	if p := obj.Prefix; p != nil {
+		prefix = *p
+	}
+	p := path.Join(prefix, lib.EngineDir, graph.EventsFile)
+
+	filter := &eventlog.Filter{
+		Kind: obj.Kind,
+		Name: obj.Name,
+		Type: eventlog.Type(obj.Type),
+	}
+	if obj.Since != "" {
+		since, err := parseSince(obj.Since, time.Now())
+		if err != nil {
+			return false, err
+		}
+		filter.Since = since
+	}
+	if obj.Last < 0 {
+		return false, fmt.Errorf("the number of events must not be negative")
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		return false, errwrap.Wrapf(err, "can't find the event log")
+	}
+	records, err := eventlog.Read(p, filter)
+	if err != nil {
+		return false, err
+	}
+	if n := obj.Last; n > 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// parseSince parses either a duration which is that long before now, or an
+// RFC3339 time.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("the duration must not be negative")
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("can't parse `%s` as a duration or as an RFC3339 time", s)
+	}
+	return t, nil
+}
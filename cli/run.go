@@ -31,6 +31,7 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
@@ -38,6 +39,7 @@ import (
 	"syscall"
 
 	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/engine/graph"
 	"github.com/purpleidea/mgmt/gapi"
 	"github.com/purpleidea/mgmt/lib"
 	"github.com/purpleidea/mgmt/util"
@@ -241,5 +243,40 @@ func (obj *RunArgs) Run(ctx context.Context, data *cliUtil.Data) (bool, error) {
 	if reterr != nil {
 		return false, reterr
 	}
+
+	if obj.Plan {
+		return true, planOutput(main.PlanReport(), obj.PlanFormat)
+	}
 	return true, nil
 }
+
+// planOutput prints the plan report in the requested format. It returns an
+// error with a distinct exit code if the plan contains any changes.
+func planOutput(report *graph.PlanReport, format string) error {
+	if report == nil {
+		return fmt.Errorf("no plan was generated")
+	}
+
+	switch format {
+	case lib.PlanFormatJSON:
+		b, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return errwrap.Wrapf(err, "could not encode the plan")
+		}
+		fmt.Printf("%s\n", b)
+
+	default:
+		fmt.Print(report.String())
+	}
+
+	if report.Errors > 0 {
+		return fmt.Errorf("plan had %d errors", report.Errors)
+	}
+	if report.Changes > 0 {
+		return &cliUtil.ExitError{
+			Err:  cliUtil.PlanChanges,
+			Code: cliUtil.PlanChangesExitCode,
+		}
+	}
+	return nil
+}
@@ -152,6 +152,41 @@ type LangPuppetArgs struct {
 	// end LangArgs
 }
 
+// DeployStatusArgs is the deploy status CLI parsing structure and type of the
+// parsed result.
+type DeployStatusArgs struct {
+	ID uint64 `arg:"positional" help:"deploy id to look at (the newest if zero)"`
+}
+
+// DeployListArgs is the deploy list CLI parsing structure and type of the
+// parsed result.
+type DeployListArgs struct{}
+
+// DeployShowArgs is the deploy show CLI parsing structure and type of the parsed
+// result.
+type DeployShowArgs struct {
+	ID     uint64 `arg:"positional,required" help:"deploy id to show"`
+	Output string `arg:"--output" help:"extract the deployed files into this directory"`
+}
+
+// DeployDiffArgs is the deploy diff CLI parsing structure and type of the parsed
+// result.
+type DeployDiffArgs struct {
+	A uint64 `arg:"positional,required" help:"deploy id to diff from"`
+	B uint64 `arg:"positional,required" help:"deploy id to diff to"`
+}
+
+// DeployRollbackArgs is the deploy rollback CLI parsing structure and type of
+// the parsed result.
+type DeployRollbackArgs struct {
+	ID uint64 `arg:"positional,required" help:"deploy id to publish again"`
+
+	// DeployKeyring is the path to a keyring of the public keys which we
+	// trust to sign deploys. A deploy is only signed again if it was signed
+	// by one of them, so it must be set along with the signing key.
+	DeployKeyring string `arg:"--deploy-keyring,env:MGMT_DEPLOY_KEYRING" help:"only sign again a deploy signed by a key in this keyring"`
+}
+
 // SetupPkgArgs is the setup service CLI parsing structure and type of the
 // parsed result.
 type SetupPkgArgs struct {
@@ -202,3 +237,45 @@ type DocsGenerateArgs struct {
 	NoResources bool   `arg:"--no-resources" help:"skip resource doc generation"`
 	NoFunctions bool   `arg:"--no-functions" help:"skip function doc generation"`
 }
+
+// LspArgs is the language server CLI parsing structure and type of the parsed
+// result.
+type LspArgs struct {
+	ModulePath string `arg:"--module-path,env:MGMT_MODULE_PATH" help:"choose the modules path (absolute)"`
+}
+
+// FmtArgs is the mcl formatter CLI parsing structure and type of the parsed
+// result.
+type FmtArgs struct {
+	Check bool     `arg:"--check" help:"list the files which aren't formatted and exit with an error if there are any"`
+	Write bool     `arg:"-w" help:"write the result back to the files instead of to stdout"`
+	Paths []string `arg:"positional" help:"files or directories to format (stdin if none)"`
+}
+
+// TestArgs is the mcl unit test runner CLI parsing structure and type of the
+// parsed result.
+type TestArgs struct {
+	Run        string   `arg:"--run" help:"only run the tests whose file name matches this regular expression"`
+	Verbose    bool     `arg:"-v,--verbose" help:"print the result of every test, not just the failures"`
+	JUnit      string   `arg:"--junit" help:"also write the results as JUnit XML to this file"`
+	Timeout    int      `arg:"--timeout" default:"60" help:"seconds to wait for the functions of each test to produce values"`
+	ModulePath string   `arg:"--module-path,env:MGMT_MODULE_PATH" help:"choose the modules path (absolute)"`
+	Paths      []string `arg:"positional" help:"test files or directories to search for them (the current directory if none)"`
+}
+
+// ReplArgs is the mcl REPL CLI parsing structure and type of the parsed result.
+type ReplArgs struct {
+	Hostname   string `arg:"--hostname" help:"hostname to use for the $hostname variable (the real one if empty)"`
+	ModulePath string `arg:"--module-path,env:MGMT_MODULE_PATH" help:"choose the modules path (absolute)"`
+}
+
+// EventsArgs is the event log query CLI parsing structure and type of the
+// parsed result.
+type EventsArgs struct {
+	Prefix *string `arg:"--prefix,env:MGMT_PREFIX" help:"specify a path to the working prefix directory"`
+	Kind   string  `arg:"--kind" help:"only show the events of this kind of resource"`
+	Name   string  `arg:"--name" help:"only show the events of resources with this name"`
+	Type   string  `arg:"--type" help:"only show the events of this type"`
+	Since  string  `arg:"--since" help:"only show the events since this duration ago (eg: 1h) or RFC3339 time"`
+	Last   int     `arg:"--last" help:"only show this many of the newest events (all if zero)"`
+}
@@ -0,0 +1,87 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// RegisteredTools is a global map of all the standalone tools which the cli can
+// run. Tools live in packages that the cli package can't import directly, such
+// as the language tools which would otherwise cause an import cycle. You should
+// never touch this map directly. Use methods like RegisterTool instead.
+var RegisteredTools = make(map[string]func() Tool) // must initialize this map
+
+// RegisterTool takes a tool and its name and makes it available for use. There
+// is no matching Unregister function.
+func RegisterTool(name string, fn func() Tool) {
+	if _, ok := RegisteredTools[name]; ok {
+		panic(fmt.Sprintf("a tool named %s is already registered", name))
+	}
+	RegisteredTools[name] = fn
+}
+
+// ToolNames returns a sorted list of the names of all registered tools.
+func ToolNames() []string {
+	names := []string{}
+	for name := range RegisteredTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ToolInfo is the set of input values passed into the Main method of a tool.
+type ToolInfo struct {
+	// Args are the CLI args that are populated after parsing the args list.
+	// They need to be converted to the struct you are expecting to read it.
+	Args interface{}
+
+	Debug bool
+	Logf  func(format string, v ...interface{})
+}
+
+// Tool is the interface that a standalone tool must implement.
+type Tool interface {
+	// Main runs the tool until it finishes or the context closes.
+	Main(ctx context.Context, info *ToolInfo) error
+}
+
+// LookupTool returns a new instance of the named tool, or an error if it was
+// not registered. This usually means the binary was built without it.
+func LookupTool(name string) (Tool, error) {
+	fn, exists := RegisteredTools[name]
+	if !exists {
+		return nil, fmt.Errorf("tool %s is not registered", name)
+	}
+	return fn(), nil
+}
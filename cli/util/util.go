@@ -48,8 +48,33 @@ const (
 	// MissingEquals means we probably hit the parsing bug.
 	// XXX: see: https://github.com/alexflint/go-arg/issues/239
 	MissingEquals = Error("missing equals sign for list element")
+
+	// PlanChanges means the plan mode found resources which would change.
+	PlanChanges = Error("plan has pending changes")
+
+	// PlanChangesExitCode is the exit code used when the plan mode found
+	// resources which would change. This lets CI tell the difference
+	// between a clean plan, a plan with changes, and a failure.
+	PlanChangesExitCode = 2
 )
 
+// ExitError is an error which also specifies the exit code that the program
+// should exit with. It's useful when a non-zero exit code carries a meaning
+// other than a generic failure.
+type ExitError struct {
+	// Err is the underlying error.
+	Err error
+
+	// Code is the exit code to use.
+	Code int
+}
+
+// Error fulfills the error interface of this type.
+func (obj *ExitError) Error() string { return obj.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (obj *ExitError) Unwrap() error { return obj.Err }
+
 // CliParseError returns a consistent error if we have a CLI parsing issue.
 func CliParseError(err error) error {
 	return errwrap.Wrapf(err, "cli parse error")
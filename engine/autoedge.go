@@ -93,6 +93,16 @@ type ResUID interface {
 	IsReversed() bool // true means this resource happens before the generator
 }
 
+// MultiResUID is an optional interface that a ResUID can implement. If Multi
+// returns true, then an automatic edge is added to every resource that the UID
+// matches, instead of only to the first one that is found. This is useful for
+// resources which must happen before all of the resources of some kind.
+type MultiResUID interface {
+	ResUID
+
+	Multi() bool
+}
+
 // The BaseUID struct is used to provide a unique resource identifier.
 type BaseUID struct {
 	Name string // name and kind are the values of where this is coming from
@@ -0,0 +1,329 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package eventlog contains a bounded, on-disk log of the structured events
+// which the resource engine emits. It stores one JSON record per line. When the
+// file gets too big, it gets rotated into a single older file, so that the
+// newest events are always kept, and the total size stays bounded.
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// DefaultMaxSize is the default maximum size in bytes of each of the two
+	// files which make up the event log.
+	DefaultMaxSize = 8 * 1024 * 1024 // 8MiB
+
+	// RotatedSuffix is added to the end of the event log path to get the
+	// path of the older file that it gets rotated into.
+	RotatedSuffix = ".1"
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	// TypeWatch is an event that Watch sent to the engine. If Watch failed,
+	// then the error is included.
+	TypeWatch Type = "watch"
+
+	// TypeWatchRetry is a Watch that failed and which is being retried.
+	TypeWatchRetry Type = "watch-retry"
+
+	// TypeCheckApplyStart is a CheckApply which is starting.
+	TypeCheckApplyStart Type = "checkapply-start"
+
+	// TypeCheckApply is a CheckApply which has finished. It includes the
+	// result, the duration, and any error.
+	TypeCheckApply Type = "checkapply"
+
+	// TypeRetry is a CheckApply that failed and which is being retried.
+	TypeRetry Type = "retry"
+
+	// TypeRefresh is a refresh notification that the resource received.
+	TypeRefresh Type = "refresh"
+
+	// TypeSendRecv is a changed value that the resource received from a
+	// send/recv sender.
+	TypeSendRecv Type = "sendrecv"
+)
+
+const (
+	// ResultOK means that the state was already correct.
+	ResultOK = "ok"
+
+	// ResultChanged means that the state was not correct, and that it was
+	// changed, unless we are in noop mode.
+	ResultChanged = "changed"
+
+	// ResultError means that CheckApply errored.
+	ResultError = "error"
+)
+
+// Record is a single event in the log.
+type Record struct {
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+
+	// Kind is the kind of resource that the event is for.
+	Kind string `json:"kind"`
+
+	// Name is the name of the resource that the event is for.
+	Name string `json:"name"`
+
+	// Type is the type of event.
+	Type Type `json:"type"`
+
+	// Noop is true if CheckApply was run in noop mode.
+	Noop bool `json:"noop,omitempty"`
+
+	// Result is the result of a CheckApply. It is one of the Result*
+	// constants.
+	Result string `json:"result,omitempty"`
+
+	// Duration is how long the CheckApply took, in nanoseconds.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Error is the error message, if there was one.
+	Error string `json:"error,omitempty"`
+
+	// Retry is the number of retries which are left. It is -1 if there are
+	// infinitely many.
+	Retry int16 `json:"retry,omitempty"`
+
+	// Send is the resource and field which sent a value for send/recv.
+	Send string `json:"send,omitempty"`
+
+	// Recv is the field which received a value for send/recv.
+	Recv string `json:"recv,omitempty"`
+}
+
+// Log is an event log which is stored on disk. It is safe for concurrent use.
+// All of the public fields must be set before Init is called.
+type Log struct {
+	// Path is the file that the events are written to. The older events
+	// get rotated into a file of the same name with the RotatedSuffix.
+	Path string
+
+	// MaxSize is the maximum size in bytes of each of the two files. If it
+	// is zero, then DefaultMaxSize is used.
+	MaxSize int64
+
+	mutex *sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// Init opens the event log, and creates it if it doesn't exist yet.
+func (obj *Log) Init() error {
+	if obj.Path == "" {
+		return fmt.Errorf("the Path is empty")
+	}
+	if obj.MaxSize < 0 {
+		return fmt.Errorf("the MaxSize is negative")
+	}
+	if obj.MaxSize == 0 {
+		obj.MaxSize = DefaultMaxSize
+	}
+	obj.mutex = &sync.Mutex{}
+
+	if err := os.MkdirAll(path.Dir(obj.Path), 0775); err != nil {
+		return errwrap.Wrapf(err, "can't create event log directory")
+	}
+	return obj.open()
+}
+
+// open opens the event log file for appending, and looks up its current size.
+// The errors in the log can contain paths and command output, so the file is
+// only readable by its owner, even if an older version created it with looser
+// permissions.
+func (obj *Log) open() error {
+	file, err := os.OpenFile(obj.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errwrap.Wrapf(err, "can't open event log")
+	}
+	if err := file.Chmod(0600); err != nil {
+		file.Close() // ignore error
+		return errwrap.Wrapf(err, "can't chmod event log")
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close() // ignore error
+		return errwrap.Wrapf(err, "can't stat event log")
+	}
+	obj.file = file
+	obj.size = fileInfo.Size()
+	return nil
+}
+
+// rotate moves the current event log file into the rotated one, which replaces
+// the older events, and then opens a new, empty file.
+func (obj *Log) rotate() error {
+	if err := obj.file.Close(); err != nil {
+		return errwrap.Wrapf(err, "can't close event log")
+	}
+	obj.file = nil
+	if err := os.Rename(obj.Path, obj.Path+RotatedSuffix); err != nil {
+		return errwrap.Wrapf(err, "can't rotate event log")
+	}
+	return obj.open()
+}
+
+// Add writes a record to the event log. If the record would make the file too
+// big, then the file gets rotated first.
+func (obj *Log) Add(record *Record) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return errwrap.Wrapf(err, "can't encode record")
+	}
+	b = append(b, '\n')
+
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if obj.file == nil {
+		return fmt.Errorf("the event log is closed")
+	}
+
+	if obj.size > 0 && obj.size+int64(len(b)) > obj.MaxSize {
+		if err := obj.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := obj.file.Write(b)
+	obj.size += int64(n)
+	return errwrap.Wrapf(err, "can't write record")
+}
+
+// Close closes the event log. Any records that are added afterwards error.
+func (obj *Log) Close() error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if obj.file == nil {
+		return nil // already closed
+	}
+	err := obj.file.Close()
+	obj.file = nil
+	return errwrap.Wrapf(err, "can't close event log")
+}
+
+// Filter picks which records to return when reading the event log. Any field
+// which is empty or zero matches everything.
+type Filter struct {
+	// Kind is the kind of resource to match.
+	Kind string
+
+	// Name is the name of resource to match.
+	Name string
+
+	// Type is the type of event to match.
+	Type Type
+
+	// Since matches the events which happened at this time or later.
+	Since time.Time
+}
+
+// Match returns true if the record matches the filter.
+func (obj *Filter) Match(record *Record) bool {
+	if obj == nil {
+		return true
+	}
+	if obj.Kind != "" && obj.Kind != record.Kind {
+		return false
+	}
+	if obj.Name != "" && obj.Name != record.Name {
+		return false
+	}
+	if obj.Type != "" && obj.Type != record.Type {
+		return false
+	}
+	if !obj.Since.IsZero() && record.Time.Before(obj.Since) {
+		return false
+	}
+	return true
+}
+
+// Read returns the records in the event log at this path which match the
+// filter, from the oldest to the newest. It can be used while the log is being
+// written. Any partially written lines are skipped.
+func Read(p string, filter *Filter) ([]*Record, error) {
+	records := []*Record{}
+	for _, x := range []string{p + RotatedSuffix, p} {
+		file, err := os.Open(x)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "can't open event log")
+		}
+		rs, err := readRecords(file, filter)
+		file.Close() // ignore error, we're only reading
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "can't read event log: %s", x)
+		}
+		records = append(records, rs...)
+	}
+	return records, nil
+}
+
+// readRecords returns the records from this reader which match the filter.
+func readRecords(r io.Reader, filter *Filter) ([]*Record, error) {
+	records := []*Record{}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything without a newline wasn't completely written.
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		record := &Record{}
+		if err := json.Unmarshal(line, record); err != nil {
+			continue // skip any corrupt lines
+		}
+		if !filter.Match(record) {
+			continue
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,161 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package eventlog
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestLog1(t *testing.T) {
+	p := path.Join(t.TempDir(), "events", "events.json")
+	log := &Log{
+		Path:    p,
+		MaxSize: 1024,
+	}
+	if err := log.Init(); err != nil {
+		t.Errorf("init failed: %+v", err)
+		return
+	}
+	now := time.Now()
+	count := 100
+	for i := 0; i < count; i++ {
+		record := &Record{
+			Time: now.Add(time.Duration(i) * time.Second),
+			Kind: "svc",
+			Name: fmt.Sprintf("svc%d", i),
+			Type: TypeWatch,
+		}
+		if err := log.Add(record); err != nil {
+			t.Errorf("add failed: %+v", err)
+			return
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Errorf("close failed: %+v", err)
+		return
+	}
+	if err := log.Add(&Record{}); err == nil {
+		t.Errorf("add after close should fail")
+	}
+
+	for _, x := range []string{p, p + RotatedSuffix} {
+		fileInfo, err := os.Stat(x)
+		if err != nil {
+			t.Errorf("stat failed: %+v", err)
+			return
+		}
+		if size := fileInfo.Size(); size > log.MaxSize {
+			t.Errorf("file %s is too big: %d", x, size)
+		}
+		if mode := fileInfo.Mode().Perm(); mode != 0600 {
+			t.Errorf("file %s has mode %o, expected 600", x, mode)
+		}
+	}
+
+	records, err := Read(p, nil)
+	if err != nil {
+		t.Errorf("read failed: %+v", err)
+		return
+	}
+	if len(records) == 0 || len(records) >= count {
+		t.Errorf("unexpected number of records: %d", len(records))
+		return
+	}
+	// the newest records must be kept, and in order
+	for i, record := range records {
+		expected := fmt.Sprintf("svc%d", count-len(records)+i)
+		if record.Name != expected {
+			t.Errorf("record %d has name %s, expected %s", i, record.Name, expected)
+		}
+	}
+
+	// a partially written line at the end gets skipped
+	file, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0664)
+	if err != nil {
+		t.Errorf("open failed: %+v", err)
+		return
+	}
+	if _, err := file.WriteString(`{"kind":"svc","na`); err != nil {
+		t.Errorf("write failed: %+v", err)
+	}
+	file.Close()
+	partial, err := Read(p, nil)
+	if err != nil {
+		t.Errorf("read failed: %+v", err)
+		return
+	}
+	if len(partial) != len(records) {
+		t.Errorf("partial line was not skipped")
+	}
+}
+
+func TestFilter1(t *testing.T) {
+	now := time.Now()
+	record := &Record{
+		Time: now,
+		Kind: "svc",
+		Name: "nginx",
+		Type: TypeCheckApply,
+	}
+
+	type test struct { // an individual test
+		name   string
+		filter *Filter
+		match  bool
+	}
+	testCases := []test{
+		{"nil", nil, true},
+		{"empty", &Filter{}, true},
+		{"kind", &Filter{Kind: "svc"}, true},
+		{"wrong kind", &Filter{Kind: "file"}, false},
+		{"name", &Filter{Kind: "svc", Name: "nginx"}, true},
+		{"wrong name", &Filter{Name: "httpd"}, false},
+		{"type", &Filter{Type: TypeCheckApply}, true},
+		{"wrong type", &Filter{Type: TypeWatch}, false},
+		{"since", &Filter{Since: now.Add(-time.Hour)}, true},
+		{"exactly", &Filter{Since: now}, true},
+		{"too new", &Filter{Since: now.Add(time.Second)}, false},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		filter, match := tc.filter, tc.match
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			if m := filter.Match(record); m != match {
+				t.Errorf("expected match: %t, got: %t", match, m)
+			}
+		})
+	}
+}
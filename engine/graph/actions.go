@@ -37,6 +37,7 @@ import (
 	"time"
 
 	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/eventlog"
 	engineUtil "github.com/purpleidea/mgmt/engine/util"
 	"github.com/purpleidea/mgmt/pgraph"
 	"github.com/purpleidea/mgmt/util/errwrap"
@@ -117,11 +118,12 @@ func (obj *Engine) Process(ctx context.Context, vertex pgraph.Vertex) error {
 	if obj.Debug && len(semas) > 0 {
 		obj.Logf("%s: Sema: P(%s)", res, strings.Join(semas, ", "))
 	}
-	if err := obj.semaLock(semas); err != nil { // lock
+	unlock, serr := obj.semaLock(ctx, semas) // lock
+	defer unlock()                           // unlock whatever we acquired
+	if serr != nil {
 		// NOTE: in practice, this might not ever be truly necessary...
-		return fmt.Errorf("shutdown of semaphores")
+		return errwrap.Wrapf(serr, "shutdown of semaphores")
 	}
-	defer obj.semaUnlock(semas) // unlock
 	if obj.Debug && len(semas) > 0 {
 		defer obj.Logf("%s: Sema: V(%s)", res, strings.Join(semas, ", "))
 	}
@@ -221,6 +223,11 @@ func (obj *Engine) Process(ctx context.Context, vertex pgraph.Vertex) error {
 						continue
 					}
 					obj.Logf("Send/Recv: %v.%s -> %v.%s", send.Res, send.Key, r, s)
+					obj.record(r, &eventlog.Record{
+						Type: eventlog.TypeSendRecv,
+						Send: fmt.Sprintf("%s.%s", send.Res, send.Key),
+						Recv: s,
+					})
 					// if send.Changed == true, at least one was updated
 					// invalidate cache, mark as dirty
 					obj.state[v].setDirty()
@@ -246,6 +253,9 @@ func (obj *Engine) Process(ctx context.Context, vertex pgraph.Vertex) error {
 
 	// lookup the refresh (notification) variable
 	refresh = obj.RefreshPending(vertex) // do i need to perform a refresh?
+	if refresh {
+		obj.record(res, &eventlog.Record{Type: eventlog.TypeRefresh})
+	}
 	refreshableRes, isRefreshableRes := vertex.(engine.RefreshableRes)
 	if isRefreshableRes {
 		refreshableRes.SetRefresh(refresh) // tell the resource
@@ -284,8 +294,26 @@ func (obj *Engine) Process(ctx context.Context, vertex pgraph.Vertex) error {
 		if obj.Debug {
 			obj.Logf("%s: CheckApply(%t)", res, !noop)
 		}
+		obj.record(res, &eventlog.Record{
+			Type: eventlog.TypeCheckApplyStart,
+			Noop: noop,
+		})
+		start := time.Now()
 		// if this fails, don't UpdateTimestamp()
 		checkOK, err = res.CheckApply(ctx, !noop)
+		result := eventlog.ResultChanged
+		if err != nil {
+			result = eventlog.ResultError
+		} else if checkOK {
+			result = eventlog.ResultOK
+		}
+		obj.record(res, &eventlog.Record{
+			Type:     eventlog.TypeCheckApply,
+			Noop:     noop,
+			Result:   result,
+			Duration: time.Since(start),
+			Error:    errorString(err),
+		})
 		if !checkOK && obj.Debug { // don't log on (checkOK == true)
 			obj.Logf("%s: CheckApply(%t): Return(%t, %s)", res, !noop, checkOK, engineUtil.CleanError(err))
 		}
@@ -519,10 +547,20 @@ func (obj *Engine) Worker(vertex pgraph.Vertex) error {
 			delay = res.MetaParams().Delay
 
 			if retry < 0 { // infinite retries
+				obj.record(res, &eventlog.Record{
+					Type:  eventlog.TypeWatchRetry,
+					Error: errorString(err),
+					Retry: retry,
+				})
 				continue
 			}
 			if retry > 0 { // don't decrement past 0
 				retry--
+				obj.record(res, &eventlog.Record{
+					Type:  eventlog.TypeWatchRetry,
+					Error: errorString(err),
+					Retry: retry,
+				})
 				obj.state[vertex].init.Logf("retrying Watch after %.4f seconds (%d left)", float64(delay)/1000, retry)
 				continue
 			}
@@ -564,6 +602,10 @@ Loop:
 			// If the Watch method exits with an error, then this
 			// channel will get that error propagated to it, which
 			// we then save so we can return it to the caller of us.
+			obj.record(res, &eventlog.Record{
+				Type:  eventlog.TypeWatch,
+				Error: errorString(err),
+			})
 			if err != nil {
 				failed = true
 				close(obj.state[vertex].watchDone)   // causes doneCtx to cancel
@@ -649,6 +691,10 @@ Loop:
 					if !ok {
 						return reterr // we only return when chan closes
 					}
+					obj.record(res, &eventlog.Record{
+						Type:  eventlog.TypeWatch,
+						Error: errorString(e),
+					})
 					if e != nil {
 						failed = true
 						close(obj.state[vertex].limitDone) // causes doneCtx to cancel
@@ -708,6 +754,10 @@ Loop:
 						if !ok {
 							return reterr // we only return when chan closes
 						}
+						obj.record(res, &eventlog.Record{
+							Type:  eventlog.TypeWatch,
+							Error: errorString(e),
+						})
 						if e != nil {
 							failed = true
 							close(obj.state[vertex].retryDone) // causes doneCtx to cancel
@@ -769,10 +819,20 @@ Loop:
 			delay = res.MetaParams().Delay
 
 			if metas.CheckApplyRetry < 0 { // infinite retries
+				obj.record(res, &eventlog.Record{
+					Type:  eventlog.TypeRetry,
+					Error: errorString(err),
+					Retry: metas.CheckApplyRetry,
+				})
 				continue
 			}
 			if metas.CheckApplyRetry > 0 { // don't decrement past 0
 				metas.CheckApplyRetry--
+				obj.record(res, &eventlog.Record{
+					Type:  eventlog.TypeRetry,
+					Error: errorString(err),
+					Retry: metas.CheckApplyRetry,
+				})
 				obj.state[vertex].init.Logf(
 					"retrying CheckApply after %.4f seconds (%d left)",
 					float64(delay)/1000,
@@ -118,6 +118,10 @@ func addEdgesByMatchingUIDS(res engine.EdgeableRes, uids []engine.ResUID, graph
 	// loop through each uid, and see if it matches any vertex
 	for _, uid := range uids {
 		var found = false
+		multi := false // does this uid match more than one vertex?
+		if u, ok := uid.(engine.MultiResUID); ok {
+			multi = u.Multi()
+		}
 		// uid is a ResUID object
 		for _, v := range graph.Vertices() { // search
 			r, ok := v.(engine.EdgeableRes)
@@ -151,7 +155,9 @@ func addEdgesByMatchingUIDS(res engine.EdgeableRes, uids []engine.ResUID, graph
 					graph.AddEdge(res, r, edge)
 				}
 				found = true
-				break
+				if !multi {
+					break
+				}
 			}
 		}
 		result = append(result, found)
@@ -37,12 +37,15 @@ import (
 	"os"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/purpleidea/mgmt/converger"
 	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/eventlog"
 	"github.com/purpleidea/mgmt/engine/local"
 	engineUtil "github.com/purpleidea/mgmt/engine/util"
 	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/prometheus"
 	"github.com/purpleidea/mgmt/util/errwrap"
 	"github.com/purpleidea/mgmt/util/semaphore"
 )
@@ -66,6 +69,10 @@ type Engine struct {
 	Local *local.API
 	World engine.World
 
+	// Prometheus is an optional handle to the metrics that we update. If
+	// it is nil, then no metrics are collected.
+	Prometheus *prometheus.Prometheus
+
 	// Prefix is a unique directory prefix which can be used. It should be
 	// created if needed.
 	Prefix string
@@ -86,6 +93,8 @@ type Engine struct {
 
 	wg *sync.WaitGroup // wg for the whole engine (only used for close)
 
+	events *eventlog.Log // structured event log of all the resources
+
 	paused    bool // are we paused?
 	fastPause bool
 	isClosing bool // are we shutting down?
@@ -129,6 +138,13 @@ func (obj *Engine) Init() error {
 
 	obj.paused = true // start off true, so we can Resume after first Commit
 
+	obj.events = &eventlog.Log{
+		Path: path.Join(obj.Prefix, EventsFile),
+	}
+	if err := obj.events.Init(); err != nil {
+		return errwrap.Wrapf(err, "can't open the event log")
+	}
+
 	obj.Exporter = &Exporter{
 		World: obj.World,
 		Debug: obj.Debug,
@@ -197,6 +213,10 @@ func (obj *Engine) Commit() error {
 	//defer obj.mutex.Unlock()
 
 	// TODO: Does this hurt performance or graph changes ?
+	swapStart := time.Now()
+	defer func() {
+		obj.Prometheus.ObserveGraphSwapDuration(time.Since(swapStart)) // ignore error
+	}()
 
 	activeMetas := make(map[engine.ResPtrUID]struct{})
 	for vertex := range obj.state {
@@ -277,6 +297,9 @@ func (obj *Engine) Commit() error {
 		if err := obj.state[vertex].Init(); err != nil {
 			return errwrap.Wrapf(err, "the Res did not Init")
 		}
+		if err := obj.Prometheus.AddManagedResource(res.String(), res.Kind()); err != nil {
+			return errwrap.Wrapf(err, "can't add the resource to prometheus")
+		}
 
 		fn := func() error {
 			// start the Worker
@@ -342,6 +365,9 @@ func (obj *Engine) Commit() error {
 		if err := obj.state[vertex].Cleanup(); err != nil {
 			return errwrap.Wrapf(err, "the Res did not Cleanup")
 		}
+		if err := obj.Prometheus.RemoveManagedResource(res.String(), res.Kind()); err != nil {
+			return errwrap.Wrapf(err, "can't remove the resource from prometheus")
+		}
 
 		// delete to free up memory from old graphs
 		fn := func() error {
@@ -546,6 +572,10 @@ func (obj *Engine) Shutdown() error {
 	}
 
 	obj.wg.Wait() // for now, this doesn't need to be a separate Wait() method
+
+	if err := obj.events.Close(); err != nil {
+		reterr = errwrap.Append(reterr, err)
+	}
 	return reterr
 }
 
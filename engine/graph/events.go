@@ -0,0 +1,96 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package graph
+
+import (
+	"time"
+
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/eventlog"
+	engineUtil "github.com/purpleidea/mgmt/engine/util"
+)
+
+const (
+	// EventsFile is the name of the file in the engine prefix where the
+	// structured event log of all the resources is stored.
+	EventsFile = "events.json"
+)
+
+// record adds an event for this resource to the event log, and updates any of
+// the prometheus metrics which count this type of event. An error is logged but
+// not returned, since a problem with the event log should never stop the
+// engine.
+func (obj *Engine) record(res engine.Res, record *eventlog.Record) {
+	obj.observe(res, record)
+
+	if obj.events == nil {
+		return
+	}
+	record.Time = time.Now()
+	record.Kind = res.Kind()
+	record.Name = res.Name()
+	if err := obj.events.Add(record); err != nil {
+		obj.Logf("%s: event log: %s", res, engineUtil.CleanError(err))
+	}
+}
+
+// observe updates the prometheus metrics which count this type of event. These
+// can't error, so we ignore those.
+func (obj *Engine) observe(res engine.Res, record *eventlog.Record) {
+	kind := res.Kind()
+	switch record.Type {
+	case eventlog.TypeWatch:
+		if record.Error == "" {
+			obj.Prometheus.UpdateWatchEventsTotal(kind)
+		}
+
+	case eventlog.TypeWatchRetry:
+		obj.Prometheus.UpdateRetriesTotal(kind, "watch")
+
+	case eventlog.TypeRetry:
+		obj.Prometheus.UpdateRetriesTotal(kind, "checkapply")
+
+	case eventlog.TypeCheckApply:
+		apply := !record.Noop
+		eventful := record.Result == eventlog.ResultChanged
+		errorful := record.Result == eventlog.ResultError
+		obj.Prometheus.UpdateCheckApplyTotal(kind, apply, eventful, errorful)
+		obj.Prometheus.ObserveCheckApplyDuration(kind, record.Duration)
+	}
+}
+
+// errorString returns the cleaned up message of an error, or the empty string
+// if it is nil. It is used to fill in the error field of the event records.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return engineUtil.CleanError(err)
+}
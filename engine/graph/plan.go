@@ -0,0 +1,209 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/purpleidea/mgmt/engine"
+	engineUtil "github.com/purpleidea/mgmt/engine/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+// PlanResult is the planned outcome for a single resource.
+type PlanResult struct {
+	// Kind is the kind of the resource.
+	Kind string `json:"kind"`
+
+	// Name is the name of the resource.
+	Name string `json:"name"`
+
+	// Change is true if the resource is not in the desired state, and it
+	// would be changed if the graph were applied.
+	Change bool `json:"change"`
+
+	// Diffs is the list of known differences. It is only populated if the
+	// resource implements the DiffableRes interface.
+	Diffs []*engine.PlanDiff `json:"diffs,omitempty"`
+
+	// Error is the error message if we could not determine the state.
+	Error string `json:"error,omitempty"`
+}
+
+// String returns the usual kind[name] representation of the resource.
+func (obj *PlanResult) String() string {
+	return fmt.Sprintf("%s[%s]", obj.Kind, obj.Name)
+}
+
+// PlanReport is the result of planning a graph. It lists what every resource
+// would do if the graph were applied.
+type PlanReport struct {
+	// Results contains one entry per resource in topological order.
+	Results []*PlanResult `json:"results"`
+
+	// Changes is the number of resources which would change.
+	Changes int `json:"changes"`
+
+	// Errors is the number of resources which could not be planned.
+	Errors int `json:"errors"`
+}
+
+// String returns a human readable version of the report.
+func (obj *PlanReport) String() string {
+	s := ""
+	for _, x := range obj.Results {
+		switch {
+		case x.Error != "":
+			s += fmt.Sprintf("! %s: %s\n", x, x.Error)
+		case x.Change:
+			s += fmt.Sprintf("~ %s\n", x)
+		default:
+			s += fmt.Sprintf("= %s\n", x)
+		}
+		for _, d := range x.Diffs {
+			s += fmt.Sprintf("    %s: %s -> %s\n", d.Field, d.Have, d.Want)
+		}
+	}
+	s += fmt.Sprintf("plan: %d resources, %d to change, %d errors\n", len(obj.Results), obj.Changes, obj.Errors)
+	return s
+}
+
+// Plan runs CheckApply with apply set to false on every resource of the loaded
+// graph once, in topological order, and returns a report of what would change.
+// The loaded graph is not committed, and no reversal data is stored, so it is
+// safe to Abort it afterwards. Errors from individual resources are stored in
+// the report, only errors that prevent the plan from running at all are
+// returned.
+func (obj *Engine) Plan(ctx context.Context) (*PlanReport, error) {
+	if obj.nextGraph == nil {
+		return nil, fmt.Errorf("there is no loaded graph to plan")
+	}
+
+	indexes, err := obj.nextGraph.TopologicalSort()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "the graph is not a dag")
+	}
+
+	report := &PlanReport{
+		Results: []*PlanResult{},
+	}
+	for _, vertex := range indexes {
+		res, ok := vertex.(engine.Res)
+		if !ok {
+			return nil, fmt.Errorf("vertex `%s` is not a Res", vertex)
+		}
+
+		result := &PlanResult{
+			Kind: res.Kind(),
+			Name: res.Name(),
+		}
+		report.Results = append(report.Results, result)
+
+		if err := obj.planRes(ctx, res, result); err != nil {
+			result.Error = strings.TrimSpace(err.Error())
+			report.Errors++
+			continue
+		}
+		if result.Change {
+			report.Changes++
+		}
+	}
+
+	return report, nil
+}
+
+// planRes runs the plan for a single resource and stores the outcome into the
+// result.
+func (obj *Engine) planRes(ctx context.Context, res engine.Res, result *PlanResult) error {
+	if err := engine.Validate(res); err != nil {
+		return errwrap.Wrapf(err, "the Res did not Validate")
+	}
+
+	pathUID := engineUtil.ResPathUID(res)
+	state := &State{
+		Graph:  obj.nextGraph,
+		Vertex: res,
+
+		Program:  obj.Program,
+		Version:  obj.Version,
+		Hostname: obj.Hostname,
+
+		Local:  obj.Local,
+		World:  obj.World,
+		Prefix: fmt.Sprintf("%s/", path.Join(obj.statePrefix(), pathUID)),
+
+		Debug: obj.Debug,
+		Logf: func(format string, v ...interface{}) {
+			obj.Logf("plan: "+res.String()+": "+format, v...)
+		},
+
+		plan: true,
+	}
+	if err := state.Init(); err != nil {
+		return errwrap.Wrapf(err, "the Res did not Init")
+	}
+	defer state.Cleanup() // XXX: should we report this error?
+
+	// Pull in whatever the upstream resources were able to send to us, so
+	// that the receiving values are as close to the real run as possible.
+	if r, ok := res.(engine.RecvableRes); ok {
+		if _, err := SendRecv(r, nil); err != nil {
+			return errwrap.Wrapf(err, "could not SendRecv")
+		}
+	}
+
+	checkOK, err := res.CheckApply(ctx, false) // never apply!
+	if err != nil {
+		return err
+	}
+	result.Change = !checkOK
+	if checkOK {
+		return nil
+	}
+
+	r, ok := res.(engine.DiffableRes)
+	if !ok {
+		return nil // we don't know any more details
+	}
+	diffs, err := r.Diff(ctx)
+	if err != nil {
+		return errwrap.Wrapf(err, "could not Diff")
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Field < diffs[j].Field
+	})
+	result.Diffs = diffs
+
+	return nil
+}
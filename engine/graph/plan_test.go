@@ -0,0 +1,235 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/traits"
+	"github.com/purpleidea/mgmt/pgraph"
+)
+
+// planTestRes is a noop resource which records what the engine does with it.
+type planTestRes struct {
+	traits.Base
+	traits.Reversible
+
+	// CheckOK is what CheckApply returns.
+	CheckOK bool
+
+	// Err is what CheckApply errors with, if not nil.
+	Err error
+
+	mutex *sync.Mutex
+	calls *[]string // shared between all the resources of a test
+}
+
+func (obj *planTestRes) record(format string, v ...interface{}) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	*obj.calls = append(*obj.calls, fmt.Sprintf(format, v...))
+}
+
+func (obj *planTestRes) Default() engine.Res { return &planTestRes{} }
+
+func (obj *planTestRes) Validate() error { return nil }
+
+func (obj *planTestRes) Init(*engine.Init) error { return nil }
+
+func (obj *planTestRes) Cleanup() error { return nil }
+
+func (obj *planTestRes) Watch(ctx context.Context) error {
+	return fmt.Errorf("the plan should not Watch")
+}
+
+func (obj *planTestRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
+	obj.record("CheckApply(%s, %t)", obj.Name(), apply)
+	if apply {
+		return false, fmt.Errorf("the plan should not apply")
+	}
+	return obj.CheckOK, obj.Err
+}
+
+func (obj *planTestRes) Cmp(r engine.Res) error { return nil }
+
+func (obj *planTestRes) Reversed() (engine.ReversibleRes, error) {
+	obj.record("Reversed(%s)", obj.Name())
+	return &planTestRes{}, nil
+}
+
+func TestPlanReportString(t *testing.T) {
+	report := &PlanReport{
+		Results: []*PlanResult{
+			{
+				Kind: "file",
+				Name: "/tmp/foo",
+			},
+			{
+				Kind:   "file",
+				Name:   "/tmp/bar",
+				Change: true,
+				Diffs: []*engine.PlanDiff{
+					{
+						Field: "mode",
+						Have:  "0644",
+						Want:  "0600",
+					},
+				},
+			},
+			{
+				Kind:  "svc",
+				Name:  "baz",
+				Error: "some error",
+			},
+		},
+		Changes: 1,
+		Errors:  1,
+	}
+	expected := "= file[/tmp/foo]\n" +
+		"~ file[/tmp/bar]\n" +
+		"    mode: 0644 -> 0600\n" +
+		"! svc[baz]: some error\n" +
+		"plan: 3 resources, 1 to change, 1 errors\n"
+	if s := report.String(); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestPlan1(t *testing.T) {
+	mutex := &sync.Mutex{}
+	calls := []string{}
+	res := func(name string, checkOK bool, err error) *planTestRes {
+		r := &planTestRes{
+			CheckOK: checkOK,
+			Err:     err,
+			mutex:   mutex,
+			calls:   &calls,
+		}
+		r.SetKind("plantest")
+		r.SetName(name)
+		// reversing is enabled, so a real run would store the reversal
+		r.SetReversibleMeta(&engine.ReversibleMeta{Disabled: false})
+		return r
+	}
+	r1 := res("r1", true, nil)
+	r2 := res("r2", false, nil)
+	r3 := res("r3", false, fmt.Errorf("some error"))
+
+	g, err := pgraph.NewGraph("TestGraph")
+	if err != nil {
+		t.Errorf("error creating graph: %v", err)
+		return
+	}
+	// add them backwards so that the order comes from the edges
+	g.AddVertex(r3, r2, r1)
+	g.AddEdge(r1, r2, &engine.Edge{Name: "e1"})
+	g.AddEdge(r2, r3, &engine.Edge{Name: "e2"})
+
+	prefix := t.TempDir()
+	ge := &Engine{
+		Program:  "mgmt",
+		Hostname: "h1",
+		Prefix:   prefix,
+		Logf:     t.Logf,
+	}
+	if err := ge.Init(); err != nil {
+		t.Errorf("could not init engine: %v", err)
+		return
+	}
+	if err := ge.Load(g); err != nil {
+		t.Errorf("could not load graph: %v", err)
+		return
+	}
+	report, err := ge.Plan(context.Background())
+	if err := ge.Abort(); err != nil {
+		t.Errorf("could not abort graph: %v", err)
+	}
+	if err != nil {
+		t.Errorf("plan failed: %v", err)
+		return
+	}
+
+	// CheckApply only runs without apply, in topological order, and the
+	// reversal is never looked at
+	expected := []string{
+		"CheckApply(r1, false)",
+		"CheckApply(r2, false)",
+		"CheckApply(r3, false)",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls: %v, got: %v", expected, calls)
+	}
+
+	if report.Changes != 1 || report.Errors != 1 || len(report.Results) != 3 {
+		t.Errorf("unexpected report:\n%s", report)
+		return
+	}
+	for i, x := range []struct {
+		name   string
+		change bool
+		err    string
+	}{
+		{"r1", false, ""},
+		{"r2", true, ""},
+		{"r3", false, "some error"},
+	} {
+		result := report.Results[i]
+		if result.Name != x.name || result.Change != x.change || result.Error != x.err {
+			t.Errorf("result #%d: expected: %s/%t/%q, got: %s/%t/%q", i, x.name, x.change, x.err, result.Name, result.Change, result.Error)
+		}
+	}
+
+	// nothing was stored that a real run would need to reverse later
+	err = filepath.WalkDir(prefix, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ReverseFile {
+			return fmt.Errorf("found reversal file: %s", p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("the plan left something behind: %v", err)
+	}
+
+	if err := ge.Shutdown(); err != nil {
+		t.Errorf("could not shutdown engine: %v", err)
+	}
+}
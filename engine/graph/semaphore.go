@@ -30,11 +30,13 @@
 package graph
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/util/errwrap"
 	"github.com/purpleidea/mgmt/util/semaphore"
 )
@@ -42,12 +44,39 @@ import (
 // SemaSep is the trailing separator to split the semaphore id from the size.
 const SemaSep = ":"
 
-// semaLock acquires the list of semaphores in the graph.
-func (obj *Engine) semaLock(semas []string) error {
+// semaLock acquires the list of semaphores in the graph. It returns a function
+// which must always be called to release whichever of those semaphores were
+// acquired, even if an error occurred. Semaphores which end with the cluster
+// suffix are acquired across the whole cluster with the help of the World.
+func (obj *Engine) semaLock(ctx context.Context, semas []string) (func() error, error) {
 	var reterr error
 	sort.Strings(semas) // very important to avoid deadlock in the dag!
 
+	unlocks := []func() error{}
+	unlock := func() error {
+		var reterr error
+		for _, fn := range unlocks { // same order to remove partial locks
+			reterr = errwrap.Append(reterr, fn()) // list of errors
+		}
+		return reterr
+	}
+
 	for _, id := range semas {
+		if name, ok := SemaCluster(id); ok {
+			world, ok := obj.World.(engine.SemaWorld)
+			if !ok {
+				reterr = errwrap.Append(reterr, fmt.Errorf("world does not support cluster semaphores"))
+				continue
+			}
+			fn, err := world.SemaLock(ctx, name, SemaSize(name)) // lock!
+			if err != nil {
+				reterr = errwrap.Append(reterr, err) // list of errors
+				continue
+			}
+			unlocks = append(unlocks, fn)
+			continue
+		}
+
 		obj.slock.Lock()          // semaphore creation lock
 		sema, ok := obj.semas[id] // lookup
 		if !ok {
@@ -57,28 +86,25 @@ func (obj *Engine) semaLock(semas []string) error {
 		}
 		obj.slock.Unlock()
 
-		err := sema.P(1)                     // lock!
-		reterr = errwrap.Append(reterr, err) // list of errors
+		if err := sema.P(1); err != nil { // lock!
+			reterr = errwrap.Append(reterr, err) // list of errors
+			continue
+		}
+		unlocks = append(unlocks, func() error {
+			return sema.V(1) // unlock!
+		})
 	}
-	return reterr
+	return unlock, reterr
 }
 
-// semaUnlock releases the list of semaphores in the graph.
-func (obj *Engine) semaUnlock(semas []string) error {
-	var reterr error
-	sort.Strings(semas) // unlock in the same order to remove partial locks
-
-	for _, id := range semas {
-		sema, ok := obj.semas[id] // lookup
-		if !ok {
-			// programming error!
-			panic(fmt.Sprintf("graph: sema: %s does not exist", id))
-		}
-
-		err := sema.V(1)                     // unlock!
-		reterr = errwrap.Append(reterr, err) // list of errors
+// SemaCluster returns the semaphore id without the cluster suffix, and true, if
+// the id is a cluster-wide semaphore. Otherwise it returns the id unchanged and
+// false.
+func SemaCluster(id string) (string, bool) {
+	if !strings.HasSuffix(id, engine.SemaClusterSuffix) {
+		return id, false
 	}
-	return reterr
+	return strings.TrimSuffix(id, engine.SemaClusterSuffix), true
 }
 
 // SemaSize returns the size integer associated with the semaphore id. It
@@ -47,3 +47,22 @@ func TestSemaSize(t *testing.T) {
 		}
 	}
 }
+
+func TestSemaCluster(t *testing.T) {
+	type result struct {
+		name    string
+		cluster bool
+	}
+	pairs := map[string]result{
+		"id:42@cluster": {"id:42", true},
+		"db@cluster":    {"db", true},
+		"id:42":         {"id:42", false},
+		"cluster":       {"cluster", false},
+	}
+	for id, r := range pairs {
+		name, cluster := SemaCluster(id)
+		if name != r.name || cluster != r.cluster {
+			t.Errorf("sema id `%s`, expected: `%s`/%t, got: `%s`/%t", id, r.name, r.cluster, name, cluster)
+		}
+	}
+}
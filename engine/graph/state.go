@@ -137,6 +137,10 @@ type State struct {
 	tuid *converger.UID // secondary converger
 
 	init *engine.Init // a copy of the init struct passed to res Init
+
+	// plan is true if this state is only used to compute a plan. In this
+	// mode, no reversal information is stored or cleaned up.
+	plan bool
 }
 
 // Init initializes structures like channels.
@@ -275,7 +279,9 @@ func (obj *State) Init() error {
 	}
 
 	// write the reverse request to the disk...
-	if err := obj.ReversalInit(); err != nil {
+	if obj.plan {
+		// skip it, a plan must not leave anything behind
+	} else if err := obj.ReversalInit(); err != nil {
 		return err // TODO: test this code path...
 	}
 
@@ -315,7 +321,9 @@ func (obj *State) Cleanup() error {
 
 	var reverr error
 	// clear the reverse request from the disk...
-	if err := obj.ReversalCleanup(); err != nil {
+	if obj.plan {
+		// skip it, we didn't run anything
+	} else if err := obj.ReversalCleanup(); err != nil {
 		// TODO: test this code path...
 		// TODO: should this be an error or a warning?
 		reverr = err
@@ -32,6 +32,7 @@ package engine
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
@@ -113,7 +114,11 @@ type MetaParams struct {
 	// Sema is a list of semaphore ids in the form `id` or `id:count`. If
 	// you don't specify a count, then 1 is assumed. The sema of `foo` which
 	// has a count equal to 1, is different from a sema named `foo:1` which
-	// also has a count equal to 1, but is a different semaphore.
+	// also has a count equal to 1, but is a different semaphore. If the id
+	// ends with the `@cluster` suffix, as in `id@cluster` or `id:2@cluster`,
+	// then the semaphore is shared across every host in the cluster. These
+	// are held with an etcd lease, so the slot of a host that dies is freed
+	// once its lease expires.
 	Sema []string `yaml:"sema"`
 
 	// Rewatch specifies whether we re-run the Watch worker during a swap
@@ -238,6 +243,10 @@ func (obj *MetaParams) Validate() error {
 		if s == "" {
 			return fmt.Errorf("semaphore is empty")
 		}
+		s = strings.TrimSuffix(s, SemaClusterSuffix)
+		if s == "" {
+			return fmt.Errorf("semaphore id is empty")
+		}
 		if _, err := strconv.Atoi(s); err == nil { // standalone int
 			return fmt.Errorf("semaphore format is invalid")
 		}
@@ -0,0 +1,60 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package engine
+
+import (
+	"context"
+)
+
+// PlanDiff describes a single difference between the current state of a
+// resource and the state that it would be changed to if it were applied.
+type PlanDiff struct {
+	// Field is the name of the resource field that differs.
+	Field string `json:"field"`
+
+	// Have is a human readable representation of the current value.
+	Have string `json:"have"`
+
+	// Want is a human readable representation of the desired value.
+	Want string `json:"want"`
+}
+
+// DiffableRes is the interface a resource can implement to describe what it
+// would change. It is used by the plan mode to produce a more useful report
+// than just listing which resources are not in the correct state.
+type DiffableRes interface {
+	Res // implement everything in Res but add the additional requirements
+
+	// Diff returns the list of differences between the current state and
+	// the desired state. It is only called after CheckApply has been run
+	// with apply set to false and returned false. It must not make any
+	// changes to the system.
+	Diff(ctx context.Context) ([]*PlanDiff, error)
+}
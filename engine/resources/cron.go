@@ -33,6 +33,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/user"
 	"path"
 	"strings"
@@ -88,6 +89,7 @@ type CronRes struct {
 	traits.Edgeable
 	traits.Recvable
 	traits.Refreshable // needed because we embed a svc res
+	traits.Reversible
 
 	init *engine.Init
 
@@ -462,6 +464,87 @@ func (obj *CronRes) Cmp(r engine.Res) error {
 	return obj.file.Cmp(r)
 }
 
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *CronRes) Copy() engine.CopyableRes {
+	return &CronRes{
+		Unit:               obj.Unit,
+		State:              obj.State,
+		Startup:            obj.Startup,
+		Session:            obj.Session,
+		Trigger:            obj.Trigger,
+		Time:               obj.Time,
+		AccuracySec:        obj.AccuracySec,
+		RandomizedDelaySec: obj.RandomizedDelaySec,
+		Persistent:         obj.Persistent,
+		WakeSystem:         obj.WakeSystem,
+		RemainAfterElapse:  obj.RemainAfterElapse,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. A timer
+// that was already there before we ran is never removed.
+func (obj *CronRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*CronRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	p, err := obj.UnitFilePath()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error generating unit file path")
+	}
+
+	// This runs before CheckApply, so we can see if the timer is already
+	// there. We only reverse what we're going to change so that we never
+	// remove a timer that was there before we ran.
+	_, err = os.Stat(p)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errwrap.Wrapf(err, "could not stat the unit file for reversal")
+	}
+	exists := err == nil
+
+	if obj.State == "exists" && exists {
+		// It was there before us, so we leave it there even if we're
+		// changing it. We don't know what it contained before.
+		return nil, nil
+	}
+	if obj.State == "absent" && !exists {
+		return nil, nil // it wasn't there, so there's nothing to restore
+	}
+
+	// We can only bring back a removed timer if we know what it contained.
+	if obj.State == "absent" && obj.Trigger == "" {
+		return nil, nil
+	}
+
+	if obj.State == "exists" {
+		res.State = "absent"
+	}
+	if obj.State == "absent" {
+		res.State = "exists"
+	}
+	if obj.Startup == "enabled" {
+		res.Startup = "disabled"
+	}
+	if obj.Startup == "disabled" {
+		res.Startup = "enabled"
+	}
+
+	return res, nil
+}
+
 // CronUID is a unique resource identifier.
 type CronUID struct {
 	// NOTE: There is also a name variable in the BaseUID struct, this is
@@ -33,6 +33,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -102,6 +103,17 @@ type ExecRes struct {
 	// able to find the program you're trying to run.
 	Shell string `lang:"shell" yaml:"shell"`
 
+	// ExitCodes is the list of exit codes of the Cmd which count as a
+	// success. Any other exit code is an error. If this is empty, then only
+	// zero is a success, which is the usual convention.
+	ExitCodes []int `lang:"exitcodes" yaml:"exitcodes"`
+
+	// ParseJSON specifies that the stdout of the Cmd is JSON. If it isn't
+	// valid JSON, then this is an error, and if it is, then it is also
+	// sent as the JSON field, in the compact form. You can decode it with
+	// the encoding.json_decode function.
+	ParseJSON bool `lang:"parsejson" yaml:"parsejson"`
+
 	// Timeout is the number of seconds to wait before sending a Kill to the
 	// running command. If the Kill is received before the process exits,
 	// then this be treated as an error.
@@ -132,12 +144,32 @@ type ExecRes struct {
 	// scenario or timeout will cause the resource to error.
 	IfCmd string `lang:"ifcmd" yaml:"ifcmd"`
 
+	// IfExitCodes is the list of exit codes of the IfCmd which mean that
+	// the Cmd *will* be run. With any other exit code, it won't be. If this
+	// is empty, then only zero will cause it to run.
+	IfExitCodes []int `lang:"ifexitcodes" yaml:"ifexitcodes"`
+
 	// IfCwd is the Cwd for the IfCmd. See the docs for Cwd.
 	IfCwd string `lang:"ifcwd" yaml:"ifcwd"`
 
 	// IfShell is the Shell for the IfCmd. See the docs for Shell.
 	IfShell string `lang:"ifshell" yaml:"ifshell"`
 
+	// Unless is the command that runs to guard against running the Cmd,
+	// with the opposite logic of the IfCmd. If this command succeeds, then
+	// the Cmd will not be run. If it returns a non-zero result, then the
+	// Cmd *will* be run. If both are specified, then they must both agree
+	// for the Cmd to run. Any error scenario will cause the resource to
+	// error.
+	Unless string `lang:"unless" yaml:"unless"`
+
+	// UnlessCwd is the Cwd for the Unless command. See the docs for Cwd.
+	UnlessCwd string `lang:"unlesscwd" yaml:"unlesscwd"`
+
+	// UnlessShell is the Shell for the Unless command. See the docs for
+	// Shell.
+	UnlessShell string `lang:"unlessshell" yaml:"unlessshell"`
+
 	// Creates is the absolute file path to check for before running the
 	// main cmd. If this path exists, then the cmd will not run. More
 	// precisely we attempt to `stat` the file, so it must succeed for a
@@ -229,6 +261,25 @@ func (obj *ExecRes) Validate() error {
 		return fmt.Errorf("the Creates param must be an absolute path")
 	}
 
+	for _, x := range append(obj.ExitCodes, obj.IfExitCodes...) {
+		if x < 0 || x > 255 {
+			return fmt.Errorf("the exit code (%d) must be between 0 and 255", x)
+		}
+	}
+	if len(obj.IfExitCodes) > 0 && obj.IfCmd == "" {
+		return fmt.Errorf("the IfExitCodes param requires an IfCmd")
+	}
+	if (obj.UnlessCwd != "" || obj.UnlessShell != "") && obj.Unless == "" {
+		return fmt.Errorf("the UnlessCwd and UnlessShell params require Unless")
+	}
+	// A guard command that is only whitespace would have nothing to run.
+	if obj.IfCmd != "" && strings.TrimSpace(obj.IfCmd) == "" {
+		return fmt.Errorf("the IfCmd can't be only whitespace")
+	}
+	if obj.Unless != "" && strings.TrimSpace(obj.Unless) == "" {
+		return fmt.Errorf("the Unless can't be only whitespace")
+	}
+
 	// check that, if an user or a group is set, we're running as root
 	if obj.User != "" || obj.Group != "" {
 		currentUser, err := user.Current()
@@ -448,64 +499,12 @@ func (obj *ExecRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	}
 
 	if obj.IfCmd != "" { // if there is no onlyif check, we should just run
-		var cmdName string
-		var cmdArgs []string
-		if obj.IfShell == "" {
-			// call without a shell
-			// FIXME: are there still whitespace splitting issues?
-			split := strings.Fields(obj.IfCmd)
-			cmdName = split[0]
-			//d, _ := os.Getwd() // TODO: how does this ever error ?
-			//cmdName = path.Join(d, cmdName)
-			cmdArgs = split[1:]
-		} else {
-			cmdName = obj.IfShell // usually bash, or sh
-			cmdArgs = []string{"-c", obj.IfCmd}
-		}
-		cmd := exec.Command(cmdName, cmdArgs...)
-		cmd.Dir = obj.IfCwd // run program in pwd if ""
-		// ignore signals sent to parent process (we're in our own group)
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: true,
-			Pgid:    0,
-		}
-
-		// if we have an user and group, use them
-		var err error
-		if cmd.SysProcAttr.Credential, err = obj.getCredential(); err != nil {
-			return false, errwrap.Wrapf(err, "error while setting credential")
+		exitStatus, err := obj.guardCmd("ifcmd", obj.IfCmd, obj.IfShell, obj.IfCwd)
+		if err != nil {
+			return false, err
 		}
-
-		var out splitWriter
-		out.Init()
-		cmd.Stdout = out.Stdout
-		cmd.Stderr = out.Stderr
-
-		if err := cmd.Run(); err != nil {
-			exitErr, ok := err.(*exec.ExitError) // embeds an os.ProcessState
-			if !ok {
-				// command failed in some bad way
-				return false, errwrap.Wrapf(err, "ifcmd failed in some bad way")
-			}
-			pStateSys := exitErr.Sys() // (*os.ProcessState) Sys
-			wStatus, ok := pStateSys.(syscall.WaitStatus)
-			if !ok {
-				return false, errwrap.Wrapf(err, "could not get exit status of ifcmd")
-			}
-			exitStatus := wStatus.ExitStatus()
-			if exitStatus == 0 {
-				// i'm not sure if this could happen
-				return false, errwrap.Wrapf(err, "unexpected ifcmd exit status of zero")
-			}
-
-			obj.init.Logf("ifcmd: %s", strings.Join(cmd.Args, " "))
+		if !exitCodeIn(exitStatus, obj.IfExitCodes) {
 			obj.init.Logf("ifcmd exited with: %d, skipping cmd", exitStatus)
-			if s := out.String(); s == "" {
-				obj.init.Logf("ifcmd out empty!")
-			} else {
-				obj.init.Logf("ifcmd out:")
-				obj.init.Logf("%s", s)
-			}
 			//if err := obj.checkApplyWriteCache(); err != nil {
 			//	return false, err
 			//}
@@ -515,11 +514,20 @@ func (obj *ExecRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 			}
 			return true, nil // don't run
 		}
-		if s := out.String(); s == "" {
-			obj.init.Logf("ifcmd out empty!")
-		} else {
-			obj.init.Logf("ifcmd out:")
-			obj.init.Logf("%s", s)
+	}
+
+	if obj.Unless != "" {
+		exitStatus, err := obj.guardCmd("unless", obj.Unless, obj.UnlessShell, obj.UnlessCwd)
+		if err != nil {
+			return false, err
+		}
+		if exitStatus == 0 {
+			obj.init.Logf("unless succeeded, skipping cmd")
+			obj.safety()
+			if err := obj.send(); err != nil {
+				return false, err
+			}
+			return true, nil // don't run
 		}
 	}
 
@@ -658,7 +666,18 @@ func (obj *ExecRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 			return false, errwrap.Wrapf(err, "error running cmd")
 		}
 		exitStatus := wStatus.ExitStatus()
-		if !wStatus.Signaled() { // not a timeout or cancel (no signal)
+		if wStatus.Signaled() { // a timeout or cancel
+			sig := wStatus.Signal()
+
+			// we get this on timeout, because ctx calls cmd.Process.Kill()
+			if sig == syscall.SIGKILL {
+				return false, errwrap.Wrapf(err, "cmd timeout, exit status: %d", exitStatus)
+			}
+
+			return false, errwrap.Wrapf(err, "unknown cmd error, signal: %s, exit status: %d", sig, exitStatus)
+		}
+
+		if !exitCodeIn(exitStatus, obj.ExitCodes) {
 			// most commands error in this way
 			if s := out.String(); s == "" {
 				obj.init.Logf("exit status %d", exitStatus)
@@ -668,17 +687,22 @@ func (obj *ExecRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 
 			return false, errwrap.Wrapf(err, "cmd error") // exit status will be in the error
 		}
-		sig := wStatus.Signal()
-
-		// we get this on timeout, because ctx calls cmd.Process.Kill()
-		if sig == syscall.SIGKILL {
-			return false, errwrap.Wrapf(err, "cmd timeout, exit status: %d", exitStatus)
-		}
-
-		return false, errwrap.Wrapf(err, "unknown cmd error, signal: %s, exit status: %d", sig, exitStatus)
+		obj.init.Logf("exit status %d is a success", exitStatus)
 
 	} else if err != nil {
 		return false, errwrap.Wrapf(err, "general cmd error")
+
+	} else if !exitCodeIn(0, obj.ExitCodes) {
+		if s := out.String(); s != "" {
+			obj.init.Logf("cmd error: %s", s)
+		}
+		return false, fmt.Errorf("cmd error: exit status 0 is not a success")
+	}
+
+	if obj.ParseJSON {
+		if _, err := compactJSON(out.Stdout.String()); err != nil {
+			return false, errwrap.Wrapf(err, "the cmd stdout is not valid JSON")
+		}
 	}
 
 	// TODO: if we printed the stdout while the command is running, this
@@ -775,11 +799,119 @@ func (obj *ExecRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 
 // send is a helper to avoid duplication of the same send operation.
 func (obj *ExecRes) send() error {
-	return obj.init.Send(&ExecSends{
+	sends := &ExecSends{
 		Output: obj.output,
 		Stdout: obj.stdout,
 		Stderr: obj.stderr,
-	})
+	}
+	if obj.stdout != nil {
+		sends.Lines = splitLines(*obj.stdout)
+		// The stdout was checked when the cmd ran, but if it's from an
+		// older cache, then it might not be JSON, so we don't send it.
+		if s, err := compactJSON(*obj.stdout); obj.ParseJSON && err == nil {
+			sends.JSON = &s
+		}
+	}
+	return obj.init.Send(sends)
+}
+
+// guardCmd runs one of the commands that guard against running the Cmd, and it
+// returns the exit status. The name is used for logging. Any error scenario is
+// returned as an error, but a non-zero exit status isn't one.
+func (obj *ExecRes) guardCmd(name, command, shell, cwd string) (int, error) {
+	var cmdName string
+	var cmdArgs []string
+	if shell == "" {
+		// call without a shell
+		// FIXME: are there still whitespace splitting issues?
+		split := strings.Fields(command)
+		if len(split) == 0 {
+			return 0, fmt.Errorf("the %s command is empty", name)
+		}
+		cmdName = split[0]
+		cmdArgs = split[1:]
+	} else {
+		cmdName = shell // usually bash, or sh
+		cmdArgs = []string{"-c", command}
+	}
+	cmd := exec.Command(cmdName, cmdArgs...)
+	cmd.Dir = cwd // run program in pwd if ""
+	// ignore signals sent to parent process (we're in our own group)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+		Pgid:    0,
+	}
+
+	// if we have an user and group, use them
+	var err error
+	if cmd.SysProcAttr.Credential, err = obj.getCredential(); err != nil {
+		return 0, errwrap.Wrapf(err, "error while setting credential")
+	}
+
+	var out splitWriter
+	out.Init()
+	cmd.Stdout = out.Stdout
+	cmd.Stderr = out.Stderr
+
+	exitStatus := 0
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError) // embeds an os.ProcessState
+		if !ok {
+			// command failed in some bad way
+			return 0, errwrap.Wrapf(err, "%s failed in some bad way", name)
+		}
+		pStateSys := exitErr.Sys() // (*os.ProcessState) Sys
+		wStatus, ok := pStateSys.(syscall.WaitStatus)
+		if !ok {
+			return 0, errwrap.Wrapf(err, "could not get exit status of %s", name)
+		}
+		exitStatus = wStatus.ExitStatus()
+		if exitStatus == 0 {
+			// i'm not sure if this could happen
+			return 0, errwrap.Wrapf(err, "unexpected %s exit status of zero", name)
+		}
+		obj.init.Logf("%s: %s", name, strings.Join(cmd.Args, " "))
+	}
+	if s := out.String(); s == "" {
+		obj.init.Logf("%s out empty!", name)
+	} else {
+		obj.init.Logf("%s out:", name)
+		obj.init.Logf("%s", s)
+	}
+	return exitStatus, nil
+}
+
+// exitCodeIn returns true if the exit code is in the list of codes. An empty
+// list is the same as a list with only zero in it.
+func exitCodeIn(code int, codes []int) bool {
+	if len(codes) == 0 {
+		return code == 0
+	}
+	for _, x := range codes {
+		if x == code {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLines splits the output of a command into a list of lines. The last one
+// isn't included if it's empty, since output usually ends with a newline.
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// compactJSON validates and returns the compact form of some JSON.
+func compactJSON(s string) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, []byte(s)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 // safety is a helper function that populates the cached "send" values if they
@@ -872,6 +1004,12 @@ func (obj *ExecRes) Cmp(r engine.Res) error {
 	if obj.Timeout != res.Timeout {
 		return fmt.Errorf("the Timeout differs")
 	}
+	if err := engineUtil.IntListCmp(obj.ExitCodes, res.ExitCodes); err != nil {
+		return errwrap.Wrapf(err, "the ExitCodes differ")
+	}
+	if obj.ParseJSON != res.ParseJSON {
+		return fmt.Errorf("the ParseJSON differs")
+	}
 
 	if obj.WatchCmd != res.WatchCmd {
 		return fmt.Errorf("the WatchCmd differs")
@@ -895,6 +1033,19 @@ func (obj *ExecRes) Cmp(r engine.Res) error {
 	if obj.IfShell != res.IfShell {
 		return fmt.Errorf("the IfShell differs")
 	}
+	if err := engineUtil.IntListCmp(obj.IfExitCodes, res.IfExitCodes); err != nil {
+		return errwrap.Wrapf(err, "the IfExitCodes differ")
+	}
+
+	if obj.Unless != res.Unless {
+		return fmt.Errorf("the Unless differs")
+	}
+	if obj.UnlessCwd != res.UnlessCwd {
+		return fmt.Errorf("the UnlessCwd differs")
+	}
+	if obj.UnlessShell != res.UnlessShell {
+		return fmt.Errorf("the UnlessShell differs")
+	}
 
 	if obj.Creates != res.Creates {
 		return fmt.Errorf("the Creates differs")
@@ -1046,6 +1197,11 @@ type ExecSends struct {
 	Stdout *string `lang:"stdout"`
 	// Stderr is the stderr of the command.
 	Stderr *string `lang:"stderr"`
+	// Lines is the stdout of the command split into a list of lines.
+	Lines []string `lang:"lines"`
+	// JSON is the stdout of the command in the compact JSON form. It is
+	// only sent if the ParseJSON parameter is true.
+	JSON *string `lang:"json"`
 }
 
 // Sends represents the default struct of values we can send using Send/Recv.
@@ -1054,6 +1210,8 @@ func (obj *ExecRes) Sends() interface{} {
 		Output: nil,
 		Stdout: nil,
 		Stderr: nil,
+		Lines:  nil,
+		JSON:   nil,
 	}
 }
 
@@ -1126,6 +1284,11 @@ func (obj *ExecRes) cmdFiles() []string {
 	} else if sp := strings.Fields(obj.IfCmd); len(sp) > 0 {
 		paths = append(paths, sp[0])
 	}
+	if obj.UnlessShell != "" {
+		paths = append(paths, obj.UnlessShell)
+	} else if sp := strings.Fields(obj.Unless); len(sp) > 0 {
+		paths = append(paths, sp[0])
+	}
 	if obj.DoneShell != "" {
 		paths = append(paths, obj.DoneShell)
 	} else if sp := strings.Fields(obj.DoneCmd); len(sp) > 0 {
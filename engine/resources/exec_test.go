@@ -441,6 +441,213 @@ func TestExecTimeoutBehaviour(t *testing.T) {
 	t.Errorf("general cmd error")
 }
 
+func TestExecExitCodes1(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		res  *ExecRes
+		fail bool
+		ran  bool   // did the cmd run?
+		out  string // expected stdout if it ran
+	}
+	testCases := []test{
+		{
+			name: "plain",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh"},
+			ran:  true,
+			out:  "hello\n",
+		},
+		{
+			name: "fail",
+			res:  &ExecRes{Cmd: "exit 3", Shell: "/bin/sh"},
+			fail: true,
+		},
+		{
+			name: "nonzero success",
+			res:  &ExecRes{Cmd: "echo hello; exit 3", Shell: "/bin/sh", ExitCodes: []int{0, 3}},
+			ran:  true,
+			out:  "hello\n",
+		},
+		{
+			name: "zero not a success",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", ExitCodes: []int{2}},
+			fail: true,
+		},
+		{
+			name: "ifcmd skip",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", IfCmd: "exit 1", IfShell: "/bin/sh"},
+		},
+		{
+			name: "ifexitcodes run",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", IfCmd: "exit 4", IfShell: "/bin/sh", IfExitCodes: []int{4}},
+			ran:  true,
+			out:  "hello\n",
+		},
+		{
+			name: "ifexitcodes skip",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", IfCmd: "true", IfShell: "/bin/sh", IfExitCodes: []int{4}},
+		},
+		{
+			name: "unless skip",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", Unless: "true", UnlessShell: "/bin/sh"},
+		},
+		{
+			name: "unless run",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", Unless: "exit 1", UnlessShell: "/bin/sh"},
+			ran:  true,
+			out:  "hello\n",
+		},
+		{
+			name: "ifcmd and unless",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", IfCmd: "true", IfShell: "/bin/sh", Unless: "true", UnlessShell: "/bin/sh"},
+		},
+		{
+			name: "invalid json",
+			res:  &ExecRes{Cmd: "echo hello", Shell: "/bin/sh", ParseJSON: true},
+			fail: true,
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, fail, ran, out := tc.name, tc.res, tc.fail, tc.ran, tc.out
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			if err := res.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			init, execSends := fakeExecInit(t)
+			if err := res.Init(init); err != nil {
+				t.Errorf("init failed with: %v", err)
+				return
+			}
+			defer res.Cleanup()
+
+			checkOK, err := res.CheckApply(context.Background(), true)
+			if !fail && err != nil {
+				t.Errorf("checkapply failed with: %v", err)
+				return
+			}
+			if fail {
+				if err == nil {
+					t.Errorf("checkapply expected error, got nil")
+				}
+				return
+			}
+
+			if ran == checkOK {
+				t.Errorf("expected ran: %t, got checkOK: %t", ran, checkOK)
+				return
+			}
+			if !ran {
+				return
+			}
+			if execSends.Stdout == nil {
+				t.Errorf("stdout is nil")
+				return
+			}
+			if s := *execSends.Stdout; s != out {
+				t.Errorf("got wrong stdout: %s", s)
+			}
+		})
+	}
+}
+
+func TestExecValidate1(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		res  *ExecRes
+		fail bool
+	}
+	testCases := []test{
+		{"plain", &ExecRes{Cmd: "echo hello"}, false},
+		{"unless", &ExecRes{Cmd: "echo hello", Unless: "true"}, false},
+		{"unless spaces", &ExecRes{Cmd: "echo hello", Unless: " \t\n"}, true},
+		{"unless spaces shell", &ExecRes{Cmd: "echo hello", Unless: "  ", UnlessShell: "/bin/sh"}, true},
+		{"unlesscwd without unless", &ExecRes{Cmd: "echo hello", UnlessCwd: "/"}, true},
+		{"ifcmd", &ExecRes{Cmd: "echo hello", IfCmd: "true"}, false},
+		{"ifcmd spaces", &ExecRes{Cmd: "echo hello", IfCmd: "   "}, true},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, fail := tc.name, tc.res, tc.fail
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			err := res.Validate()
+			if !fail && err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			if fail && err == nil {
+				t.Errorf("validate passed, expected fail")
+				return
+			}
+		})
+	}
+}
+
+func TestExecGuardCmdEmpty1(t *testing.T) {
+	res := &ExecRes{Cmd: "echo hello"}
+	for _, command := range []string{"", " ", "\t\n"} {
+		if _, err := res.guardCmd("unless", command, "", ""); err == nil {
+			t.Errorf("expected an error for command: %q", command)
+		}
+	}
+}
+
+func TestExecSendRecv4(t *testing.T) {
+	r1 := &ExecRes{
+		Cmd:       `printf '{ "a": [1, 2] }\nfoo\n\nbar\n' | head -1; echo bar`,
+		Shell:     "/bin/bash",
+		ParseJSON: false,
+	}
+	r2 := &ExecRes{
+		Cmd:       `printf '{ "a": [1, 2],\n "b": "c" }\n'`,
+		Shell:     "/bin/bash",
+		ParseJSON: true,
+	}
+
+	for _, res := range []*ExecRes{r1, r2} {
+		if err := res.Validate(); err != nil {
+			t.Errorf("validate failed with: %v", err)
+			return
+		}
+	}
+
+	init, execSends := fakeExecInit(t)
+	if err := r1.Init(init); err != nil {
+		t.Errorf("init failed with: %v", err)
+		return
+	}
+	defer r1.Cleanup()
+	if _, err := r1.CheckApply(context.Background(), true); err != nil {
+		t.Errorf("checkapply failed with: %v", err)
+		return
+	}
+	if l := execSends.Lines; len(l) != 2 || l[0] != `{ "a": [1, 2] }` || l[1] != "bar" {
+		t.Errorf("got wrong lines: %+v", l)
+	}
+	if execSends.JSON != nil {
+		t.Errorf("expected nil json, got: %s", *execSends.JSON)
+	}
+
+	init, execSends = fakeExecInit(t)
+	if err := r2.Init(init); err != nil {
+		t.Errorf("init failed with: %v", err)
+		return
+	}
+	defer r2.Cleanup()
+	if _, err := r2.CheckApply(context.Background(), true); err != nil {
+		t.Errorf("checkapply failed with: %v", err)
+		return
+	}
+	if l := execSends.Lines; len(l) != 2 {
+		t.Errorf("got wrong lines: %+v", l)
+	}
+	if execSends.JSON == nil {
+		t.Errorf("json is nil")
+	} else if s := *execSends.JSON; s != `{"a":[1,2],"b":"c"}` {
+		t.Errorf("got wrong json: %s", s)
+	}
+}
+
 func TestExecAutoEdge1(t *testing.T) {
 	g, err := pgraph.NewGraph("TestGraph")
 	if err != nil {
@@ -49,6 +49,7 @@ import (
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/engine/traits"
 	engineUtil "github.com/purpleidea/mgmt/engine/util"
+	coregolang "github.com/purpleidea/mgmt/lang/core/golang"
 	"github.com/purpleidea/mgmt/lang/funcs/vars"
 	"github.com/purpleidea/mgmt/lang/interfaces"
 	"github.com/purpleidea/mgmt/lang/types"
@@ -175,6 +176,20 @@ type FileRes struct {
 	// searches one level deep at the moment.
 	Fragments []string `lang:"fragments" yaml:"fragments"`
 
+	// Template specifies a golang text/template which is rendered to build
+	// the file contents. It has the same functions available that the
+	// golang.template function does. It cannot be combined with the
+	// Content, Source or Fragments parameters. It is rendered again each
+	// time we run, so if the vars change (eg: through send/recv) then the
+	// file will too. If rendering fails, then the file is left untouched.
+	Template *string `lang:"template" yaml:"template"`
+
+	// TemplateVars is the value that is passed in to the Template. It can
+	// be of any type, but a struct or a map with str keys is the most
+	// useful, since each field or key can then be used in the template as
+	// `{{ .name }}`. This is ignored unless the Template is specified.
+	TemplateVars types.Value `lang:"template_vars" yaml:"-"`
+
 	// Owner specifies the file owner. You can specify either the string
 	// name, or a string representation of the owner integer uid.
 	Owner string `lang:"owner" yaml:"owner"`
@@ -187,6 +202,30 @@ type FileRes struct {
 	// form or symbolic form.
 	Mode string `lang:"mode" yaml:"mode"`
 
+	// SELinuxContext is the SELinux security context of the file. Any part
+	// of it which is left empty keeps its current value. If we Recurse,
+	// then it's set on all of the contents of this directory too.
+	SELinuxContext *FileSELinuxContext `lang:"selinux_context" yaml:"selinux_context"`
+
+	// ACL is a list of POSIX ACL entries in the short text form that the
+	// getfacl and setfacl tools use. Eg: `user:james:rwx`, `g:wheel:r-x`,
+	// or `default:group:admins:rwx`. The access ACL and the default ACL are
+	// each replaced by the entries given for them, if any. The base user,
+	// group and other entries keep their current values if they are left
+	// out, and a mask is added if one is needed. These base entries and the
+	// mask can't be specified along with Mode, since that sets them too.
+	// Default entries can only be used on directories. If we Recurse, then
+	// the ACL is set on all of the contents of this directory too, but
+	// files only get the access ACL.
+	ACL []string `lang:"acl" yaml:"acl"`
+
+	// Xattrs is a map of extended attributes to set on the file. The keys
+	// must include the namespace, eg: `user.origin`. Any other attributes
+	// are left alone. Use the SELinuxContext and ACL parameters to manage
+	// those special attributes. If we Recurse, then these are set on all of
+	// the contents of this directory too.
+	Xattrs map[string]string `lang:"xattrs" yaml:"xattrs"`
+
 	// Recurse specifies if you want to work recursively on the resource. It
 	// is used when copying a source directory, or to determine if a watch
 	// should be recursive or not. When making a directory, this is required
@@ -206,6 +245,28 @@ type FileRes struct {
 	// Recurse to true. This doesn't work with Content or Fragments.
 	Purge bool `lang:"purge" yaml:"purge"`
 
+	// RecurseLimit is the maximum depth that we recurse to when copying,
+	// purging or watching a directory. A value of one means that only the
+	// direct contents of the directory are managed, and any directories in
+	// there are created, but left empty. The default of zero means that
+	// there is no limit. This requires Recurse.
+	RecurseLimit uint64 `lang:"recurse_limit" yaml:"recurse_limit"`
+
+	// Include is a list of glob patterns. If it's not empty, then only the
+	// files which match at least one of them get copied, purged or watched.
+	// Directories are always recursed into. The pattern syntax is the same
+	// as what's used in an ignore file. (See FileIgnoreName for details.)
+	// This requires Recurse.
+	Include []string `lang:"include" yaml:"include"`
+
+	// Exclude is a list of glob patterns for paths that never get copied,
+	// purged or watched. If a directory matches, then so does everything in
+	// it. These get added after any patterns from the ignore files at the
+	// top of the Source directory and of this directory, so they can't be
+	// negated there. (See FileIgnoreName for details.) This requires
+	// Recurse.
+	Exclude []string `lang:"exclude" yaml:"exclude"`
+
 	// Symlink specifies that the file should be a symbolic link to the
 	// source contents. Those do not have to point to an actual file or
 	// directory. The source in that case can be either an absolute or
@@ -213,6 +274,7 @@ type FileRes struct {
 	Symlink bool `lang:"symlink" yaml:"symlink"`
 
 	sha256sum string
+	rendered  *string // the last rendered template
 }
 
 // getPath returns the actual path to use for this resource. It computes this
@@ -300,9 +362,21 @@ func (obj *FileRes) Validate() error {
 	isContent := obj.Content != nil
 	isSrc := obj.Source != ""
 	isFrag := len(obj.Fragments) > 0
-	if (isContent && isSrc) || (isSrc && isFrag) || (isFrag && isContent) {
-		return fmt.Errorf("can only specify one of Content, Source, and Fragments")
+	isTmpl := obj.Template != nil
+	count := 0
+	for _, x := range []bool{isContent, isSrc, isFrag, isTmpl} {
+		if x {
+			count++
+		}
 	}
+	if count > 1 {
+		return fmt.Errorf("can only specify one of Content, Source, Fragments, and Template")
+	}
+	if obj.TemplateVars != nil && !isTmpl {
+		return fmt.Errorf("can't specify TemplateVars without a Template")
+	}
+	// The Template is rendered just like Content is used from now on...
+	isContent = isContent || isTmpl
 
 	if obj.Symlink && !isSrc && obj.State == FileStateExists {
 		return fmt.Errorf("can't use Symlink with an empty Source")
@@ -331,6 +405,28 @@ func (obj *FileRes) Validate() error {
 		return fmt.Errorf("you'll want to Recurse when you have a Purge to do")
 	}
 
+	if (obj.RecurseLimit > 0 || len(obj.Include) > 0 || len(obj.Exclude) > 0) && !obj.Recurse {
+		return fmt.Errorf("you'll want to Recurse when you have a RecurseLimit, Include or Exclude")
+	}
+	for _, x := range obj.Include {
+		pattern, err := parseFilePattern(x)
+		if err != nil {
+			return errwrap.Wrapf(err, "invalid Include pattern")
+		}
+		if pattern.negate {
+			return fmt.Errorf("can't negate an Include pattern: %s", x)
+		}
+	}
+	for _, x := range obj.Exclude {
+		pattern, err := parseFilePattern(x)
+		if err != nil {
+			return errwrap.Wrapf(err, "invalid Exclude pattern")
+		}
+		if pattern.negate {
+			return fmt.Errorf("can't negate an Exclude pattern: %s", x)
+		}
+	}
+
 	if isSrc && !obj.isDir() && !srcIsDir && obj.Recurse && !obj.Symlink {
 		return fmt.Errorf("you can't recurse when copying a single file")
 	}
@@ -385,12 +481,42 @@ func (obj *FileRes) Validate() error {
 		}
 	}
 
+	if obj.SELinuxContext != nil {
+		if err := obj.SELinuxContext.Validate(); err != nil {
+			return errwrap.Wrapf(err, "the SELinuxContext is invalid")
+		}
+	}
+	for _, x := range obj.ACL {
+		entry, err := parseFileACLEntry(x)
+		if err != nil {
+			return err
+		}
+		if entry.def && !obj.isDir() {
+			return fmt.Errorf("can't specify a default ACL entry on a file: %s", x)
+		}
+		isBase := entry.tag != aclUser && entry.tag != aclGroup
+		if !entry.def && isBase && obj.Mode != "" {
+			return fmt.Errorf("can't specify the base ACL entry %s with Mode", x)
+		}
+	}
+	for k := range obj.Xattrs {
+		if k == xattrSELinux || k == xattrACLAccess || k == xattrACLDefault {
+			return fmt.Errorf("use the SELinuxContext or ACL params instead of the %s xattr", k)
+		}
+		if !strings.Contains(k, ".") || strings.HasPrefix(k, ".") {
+			return fmt.Errorf("the xattr %s must start with a namespace", k)
+		}
+	}
+
 	if obj.Symlink && (isContent || isFrag) {
 		return fmt.Errorf("can't specify Content or Fragments with Symlink")
 	}
 	if obj.Symlink && (obj.Recurse || obj.Purge) {
 		return fmt.Errorf("can't specify Recurse or Purge with Symlink")
 	}
+	if obj.Symlink && (len(obj.ACL) > 0 || len(obj.Xattrs) > 0) {
+		return fmt.Errorf("can't specify ACL or Xattrs with Symlink")
+	}
 
 	return nil
 }
@@ -427,7 +553,33 @@ func (obj *FileRes) Watch(ctx context.Context) error {
 	// TODO: should this be after (later in the file) the `defer recWatcher.Close()` ?
 	defer close(exit)
 
-	recWatcher, err := recwatch.NewRecWatcher(obj.getPath(), obj.Recurse)
+	// The filter is rebuilt when an ignore file changes, but the watches on
+	// any directories that it previously skipped only get added back when
+	// the watcher next walks the tree.
+	filter, err := obj.filter()
+	if err != nil {
+		return err
+	}
+	filterMutex := &sync.Mutex{}
+	filterOpt := func(root string) recwatch.Option {
+		return recwatch.Filter(func(p string, isDir bool) bool {
+			if !util.HasPathPrefix(p, root) {
+				return false
+			}
+			rel := strings.TrimPrefix(p, root)
+			if rel == FileIgnoreName {
+				return false // we want to know if this changes
+			}
+			if isDir {
+				rel += "/"
+			}
+			filterMutex.Lock()
+			defer filterMutex.Unlock()
+			return filter.skip(rel)
+		})
+	}
+
+	recWatcher, err := recwatch.NewRecWatcher(obj.getPath(), obj.Recurse, filterOpt(obj.getPath()))
 	if err != nil {
 		return err
 	}
@@ -437,7 +589,7 @@ func (obj *FileRes) Watch(ctx context.Context) error {
 	if obj.Source != "" {
 		// This block is virtually identical to the below one.
 		recurse := strings.HasSuffix(obj.Source, "/") // isDir
-		rw, err := recwatch.NewRecWatcher(obj.Source, recurse)
+		rw, err := recwatch.NewRecWatcher(obj.Source, recurse, filterOpt(obj.Source))
 		if err != nil {
 			return err
 		}
@@ -516,6 +668,21 @@ func (obj *FileRes) Watch(ctx context.Context) error {
 		}()
 	}
 
+	// refilter rebuilds the filter if the event was for an ignore file.
+	refilter := func(name string) error {
+		if path.Base(name) != FileIgnoreName {
+			return nil
+		}
+		f, err := obj.filter()
+		if err != nil {
+			return err
+		}
+		filterMutex.Lock()
+		filter = f
+		filterMutex.Unlock()
+		return nil
+	}
+
 	obj.init.Running() // when started, notify engine that we're running
 
 	for {
@@ -537,6 +704,9 @@ func (obj *FileRes) Watch(ctx context.Context) error {
 			if obj.init.Debug { // don't access event.Body if event.Error isn't nil
 				obj.init.Logf("event(%s): %v", event.Body.Name, event.Body.Op)
 			}
+			if err := refilter(event.Body.Name); err != nil {
+				return err
+			}
 
 		case event, ok := <-inputEvents:
 			if !ok {
@@ -548,6 +718,9 @@ func (obj *FileRes) Watch(ctx context.Context) error {
 			if obj.init.Debug { // don't access event.Body if event.Error isn't nil
 				obj.init.Logf("input event(%s): %v", event.Body.Name, event.Body.Op)
 			}
+			if err := refilter(event.Body.Name); err != nil {
+				return err
+			}
 
 		case <-ctx.Done(): // closed by the engine to signal shutdown
 			return nil
@@ -770,7 +943,8 @@ func (obj *FileRes) dirCheckApply(ctx context.Context, apply bool) (bool, error)
 // fileCheckApply method. It returns checkOK and error as is normally expected.
 // If excludes is specified, none of those files there will be deleted by this,
 // with the exception that a sync *can* convert a file to a dir, or vice-versa.
-func (obj *FileRes) syncCheckApply(ctx context.Context, apply bool, src, dst string, excludes []string) (bool, error) {
+// Any paths that the filter skips are neither copied nor deleted.
+func (obj *FileRes) syncCheckApply(ctx context.Context, apply bool, src, dst string, excludes []string, filter *fileFilter) (bool, error) {
 	if obj.init.Debug {
 		obj.init.Logf("sync: %s -> %s", src, dst)
 	}
@@ -835,6 +1009,19 @@ func (obj *FileRes) syncCheckApply(ctx context.Context, apply bool, src, dst str
 		obj.init.Logf("dstFiles: %v", printFiles(smartDst))
 	}
 
+	// remove anything we skip, so that it's neither copied nor purged
+	root := obj.getPath()
+	for relPath := range smartSrc {
+		if filter.skip(strings.TrimPrefix(dst+relPath, root)) {
+			delete(smartSrc, relPath)
+		}
+	}
+	for relPath, fileInfo := range smartDst {
+		if filter.skip(strings.TrimPrefix(fileInfo.AbsPath, root)) {
+			delete(smartDst, relPath)
+		}
+	}
+
 	for relPath, fileInfo := range smartSrc {
 		absSrc := fileInfo.AbsPath // absolute path
 		absDst := dst + relPath    // absolute dest
@@ -879,7 +1066,7 @@ func (obj *FileRes) syncCheckApply(ctx context.Context, apply bool, src, dst str
 			obj.init.Logf("recurse: %s -> %s", absSrc, absDst)
 		}
 		if obj.Recurse {
-			if c, err := obj.syncCheckApply(ctx, apply, absSrc, absDst, excludes); err != nil { // recurse
+			if c, err := obj.syncCheckApply(ctx, apply, absSrc, absDst, excludes, filter); err != nil { // recurse
 				return false, errwrap.Wrapf(err, "recurse failed")
 			} else if !c { // don't let subsequent passes make this true
 				checkOK = false
@@ -936,7 +1123,7 @@ func (obj *FileRes) syncCheckApply(ctx context.Context, apply bool, src, dst str
 		}
 		_ = absSrc
 		//obj.init.Logf("recurse rm: %s -> %s", absSrc, absDst)
-		//if c, err := obj.syncCheckApply(ctx, apply, absSrc, absDst, excludes); err != nil {
+		//if c, err := obj.syncCheckApply(ctx, apply, absSrc, absDst, excludes, filter); err != nil {
 		//	return false, errwrap.Wrapf(err, "recurse rm failed")
 		//} else if !c { // don't let subsequent passes make this true
 		//	checkOK = false
@@ -1012,7 +1199,7 @@ func (obj *FileRes) stateCheckApply(ctx context.Context, apply bool) (bool, erro
 	// Optimization: we shouldn't even look at obj.Content here, but we can
 	// skip this empty file creation here since we know we're going to be
 	// making it there anyways. This way we save the extra fopen noise.
-	if obj.Content != nil || len(obj.Fragments) > 0 {
+	if obj.Content != nil || obj.Template != nil || len(obj.Fragments) > 0 {
 		return false, nil // pretend we actually made it
 	}
 
@@ -1038,13 +1225,18 @@ func (obj *FileRes) contentCheckApply(ctx context.Context, apply bool) (bool, er
 		obj.init.Logf("contentCheckApply(%t)", apply)
 	}
 
+	content := obj.Content
+	if obj.Template != nil {
+		content = obj.rendered // CheckApply renders it first
+	}
+
 	// content is not defined, leave it alone...
-	if obj.Content == nil {
+	if content == nil {
 		return true, nil
 	}
 
 	// Actually write the file. This is similar to fragmentsCheckApply.
-	bufferSrc := bytes.NewReader([]byte(*obj.Content))
+	bufferSrc := bytes.NewReader([]byte(*content))
 	sha256sum, checkOK, err := obj.fileCheckApply(ctx, apply, bufferSrc, obj.getPath(), obj.sha256sum)
 	if sha256sum != "" { // empty values mean errored or didn't hash
 		// this can be valid even when the whole function errors
@@ -1057,6 +1249,58 @@ func (obj *FileRes) contentCheckApply(ctx context.Context, apply bool) (bool, er
 	return checkOK, nil // success
 }
 
+// render runs the template with the vars and returns the file contents.
+func (obj *FileRes) render(ctx context.Context) (string, error) {
+	s, err := coregolang.Render(ctx, *obj.Template, obj.TemplateVars, obj.init.Debug, obj.init.Logf)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "could not render the template")
+	}
+	return s, nil
+}
+
+// filter builds the filter which decides which paths get skipped when we work
+// recursively on a directory. It reads the ignore files at the top of both the
+// Source directory and of our own directory, if they exist. If we're not going
+// to recurse, then this returns nil, which is a filter that skips nothing.
+func (obj *FileRes) filter() (*fileFilter, error) {
+	if !obj.Recurse || !obj.isDir() {
+		return nil, nil
+	}
+	filter := &fileFilter{
+		limit: obj.RecurseLimit,
+	}
+	for _, x := range obj.Include {
+		pattern, err := parseFilePattern(x)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "invalid Include pattern")
+		}
+		filter.include = append(filter.include, pattern)
+	}
+
+	dirs := []string{}
+	if strings.HasSuffix(obj.Source, "/") && !obj.Symlink {
+		dirs = append(dirs, obj.Source)
+	}
+	dirs = append(dirs, obj.getPath())
+	for _, dir := range dirs {
+		patterns, err := parseFileIgnore(dir + FileIgnoreName)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "could not read the ignore file")
+		}
+		filter.ignore = append(filter.ignore, patterns...)
+	}
+
+	for _, x := range obj.Exclude {
+		pattern, err := parseFilePattern(x)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "invalid Exclude pattern")
+		}
+		filter.ignore = append(filter.ignore, pattern)
+	}
+
+	return filter, nil
+}
+
 // sourceCheckApply performs a CheckApply for the file source.
 func (obj *FileRes) sourceCheckApply(ctx context.Context, apply bool) (bool, error) {
 	if obj.Symlink { // delegate
@@ -1108,8 +1352,13 @@ func (obj *FileRes) sourceCheckApply(ctx context.Context, apply bool) (bool, err
 		obj.init.Logf("excludes: %+v", excludes)
 	}
 
+	filter, err := obj.filter()
+	if err != nil {
+		return false, err
+	}
+
 	// XXX: should this work with obj.Purge && obj.Source != "" or not?
-	checkOK, err := obj.syncCheckApply(ctx, apply, obj.Source, obj.getPath(), excludes)
+	checkOK, err := obj.syncCheckApply(ctx, apply, obj.Source, obj.getPath(), excludes, filter)
 	if err != nil {
 		obj.init.Logf("error: %v", err)
 		return false, err
@@ -1373,6 +1622,20 @@ func (obj *FileRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 		obj.sha256sum = "" // invalidate!!
 	}
 
+	// Render the template before we change anything, so that if it fails,
+	// we refuse to write anything at all. If the output changed, then our
+	// cache is no longer valid.
+	if obj.Template != nil {
+		s, err := obj.render(ctx)
+		if err != nil {
+			return false, err
+		}
+		if obj.rendered == nil || *obj.rendered != s {
+			obj.sha256sum = "" // invalidate!!
+		}
+		obj.rendered = &s
+	}
+
 	checkOK := true
 
 	// Run stateCheckApply before contentCheckApply, sourceCheckApply, and
@@ -1409,10 +1672,119 @@ func (obj *FileRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	} else if !c {
 		checkOK = false
 	}
+	// Run attrCheckApply after chmodCheckApply, since chmod changes the ACL.
+	if c, err := obj.attrCheckApply(ctx, apply); err != nil {
+		return false, err
+	} else if !c {
+		checkOK = false
+	}
 
 	return checkOK, nil // w00t
 }
 
+// Diff returns the list of differences between the file on disk and the one we
+// want. It only looks at the simple properties, so a recursive copy or purge is
+// still only reported as a change without any more details.
+func (obj *FileRes) Diff(ctx context.Context) ([]*engine.PlanDiff, error) {
+	diffs := []*engine.PlanDiff{}
+
+	stat := os.Stat
+	if obj.Symlink {
+		stat = os.Lstat
+	}
+	fileInfo, err := stat(obj.getPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	state := FileStateAbsent
+	if err == nil {
+		state = FileStateExists
+	}
+	if obj.State != FileStateUndefined && obj.State != state {
+		diffs = append(diffs, &engine.PlanDiff{
+			Field: "state",
+			Have:  state,
+			Want:  obj.State,
+		})
+	}
+	if state == FileStateAbsent {
+		return diffs, nil // nothing else to compare against
+	}
+
+	want := obj.Content
+	if obj.Template != nil {
+		s, err := obj.render(ctx)
+		if err != nil {
+			return nil, err
+		}
+		want = &s
+	}
+	if want != nil && !obj.isDir() {
+		content, err := os.ReadFile(obj.getPath())
+		if err != nil {
+			return nil, err
+		}
+		if have, want := content, []byte(*want); !bytes.Equal(have, want) {
+			summary := func(b []byte) string {
+				sum := sha256.Sum256(b)
+				return fmt.Sprintf("%d bytes (sha256:%s)", len(b), hex.EncodeToString(sum[:])[:12])
+			}
+			diffs = append(diffs, &engine.PlanDiff{
+				Field: "content",
+				Have:  summary(have),
+				Want:  summary(want),
+			})
+		}
+	}
+
+	if obj.Mode != "" {
+		mode, err := obj.mode()
+		if err != nil {
+			return nil, err
+		}
+		if fileInfo.Mode() != mode {
+			diffs = append(diffs, &engine.PlanDiff{
+				Field: "mode",
+				Have:  fmt.Sprintf("%#o", fileInfo.Mode().Perm()),
+				Want:  obj.Mode,
+			})
+		}
+	}
+
+	stUnix, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok { // not unix
+		return diffs, nil
+	}
+	if obj.Owner != "" {
+		uid, err := engineUtil.GetUID(obj.Owner)
+		if err != nil {
+			return nil, err
+		}
+		if int(stUnix.Uid) != uid {
+			diffs = append(diffs, &engine.PlanDiff{
+				Field: "owner",
+				Have:  strconv.FormatInt(int64(stUnix.Uid), 10),
+				Want:  obj.Owner,
+			})
+		}
+	}
+	if obj.Group != "" {
+		gid, err := engineUtil.GetGID(obj.Group)
+		if err != nil {
+			return nil, err
+		}
+		if int(stUnix.Gid) != gid {
+			diffs = append(diffs, &engine.PlanDiff{
+				Field: "group",
+				Have:  strconv.FormatInt(int64(stUnix.Gid), 10),
+				Want:  obj.Group,
+			})
+		}
+	}
+
+	return diffs, nil
+}
+
 // Cmp compares two resources and returns an error if they are not equivalent.
 func (obj *FileRes) Cmp(r engine.Res) error {
 	// we can only compare FileRes to others of the same resource kind
@@ -1450,6 +1822,27 @@ func (obj *FileRes) Cmp(r engine.Res) error {
 			return fmt.Errorf("the fragment at index %d differs", i)
 		}
 	}
+	if (obj.Template == nil) != (res.Template == nil) { // xor
+		return fmt.Errorf("the Template differs")
+	}
+	if obj.Template != nil && res.Template != nil {
+		if *obj.Template != *res.Template { // compare the strings
+			return fmt.Errorf("the contents of Template differ")
+		}
+	}
+	if (obj.TemplateVars == nil) != (res.TemplateVars == nil) { // xor
+		return fmt.Errorf("the TemplateVars differ")
+	}
+	if obj.TemplateVars != nil && res.TemplateVars != nil {
+		if err := obj.TemplateVars.Type().Cmp(res.TemplateVars.Type()); err != nil {
+			return errwrap.Wrapf(err, "the type of TemplateVars differs")
+		}
+		// Map values store their keys as pointers, so Cmp won't match
+		// two identical maps. The string form is deterministic though.
+		if obj.TemplateVars.String() != res.TemplateVars.String() {
+			return fmt.Errorf("the contents of TemplateVars differ")
+		}
+	}
 
 	if obj.Owner != res.Owner {
 		return fmt.Errorf("the Owner differs")
@@ -1462,6 +1855,20 @@ func (obj *FileRes) Cmp(r engine.Res) error {
 	if obj.Mode != res.Mode {
 		return fmt.Errorf("the Mode differs")
 	}
+	if err := obj.SELinuxContext.Cmp(res.SELinuxContext); err != nil {
+		return errwrap.Wrapf(err, "the SELinuxContext differs")
+	}
+	if err := util.SortedStrSliceCompare(obj.ACL, res.ACL); err != nil {
+		return errwrap.Wrapf(err, "the ACL differs")
+	}
+	if len(obj.Xattrs) != len(res.Xattrs) {
+		return fmt.Errorf("the number of Xattrs differs")
+	}
+	for k, v := range obj.Xattrs {
+		if x, exists := res.Xattrs[k]; !exists || x != v {
+			return fmt.Errorf("the Xattr %s differs", k)
+		}
+	}
 
 	if obj.Recurse != res.Recurse {
 		return fmt.Errorf("the Recurse option differs")
@@ -1472,6 +1879,15 @@ func (obj *FileRes) Cmp(r engine.Res) error {
 	if obj.Purge != res.Purge {
 		return fmt.Errorf("the Purge option differs")
 	}
+	if obj.RecurseLimit != res.RecurseLimit {
+		return fmt.Errorf("the RecurseLimit differs")
+	}
+	if err := util.SortedStrSliceCompare(obj.Include, res.Include); err != nil {
+		return errwrap.Wrapf(err, "the Include differs")
+	}
+	if err := util.SortedStrSliceCompare(obj.Exclude, res.Exclude); err != nil {
+		return errwrap.Wrapf(err, "the Exclude differs")
+	}
 	if obj.Symlink != res.Symlink {
 		return fmt.Errorf("the Symlink option differs")
 	}
@@ -1647,6 +2063,34 @@ func (obj *FileRes) Copy() engine.CopyableRes {
 	for _, frag := range obj.Fragments {
 		fragments = append(fragments, frag)
 	}
+	var template *string
+	if obj.Template != nil {
+		s := *obj.Template
+		template = &s
+	}
+	include := []string{}
+	for _, x := range obj.Include {
+		include = append(include, x)
+	}
+	exclude := []string{}
+	for _, x := range obj.Exclude {
+		exclude = append(exclude, x)
+	}
+	acl := []string{}
+	for _, x := range obj.ACL {
+		acl = append(acl, x)
+	}
+	var xattrs map[string]string
+	if obj.Xattrs != nil {
+		xattrs = make(map[string]string)
+		for k, v := range obj.Xattrs {
+			xattrs[k] = v
+		}
+	}
+	var templateVars types.Value
+	if obj.TemplateVars != nil {
+		templateVars = obj.TemplateVars.Copy()
+	}
 	return &FileRes{
 		Path:      obj.Path,
 		Dirname:   obj.Dirname,
@@ -1655,12 +2099,21 @@ func (obj *FileRes) Copy() engine.CopyableRes {
 		Content:   content,
 		Source:    obj.Source,
 		Fragments: fragments,
+		Template:  template,
 		Owner:     obj.Owner,
 		Group:     obj.Group,
 		Mode:      obj.Mode,
+		ACL:       acl,
+		Xattrs:    xattrs,
 		Recurse:   obj.Recurse,
 		Force:     obj.Force,
 		Purge:     obj.Purge,
+		Include:   include,
+		Exclude:   exclude,
+
+		TemplateVars:   templateVars,
+		RecurseLimit:   obj.RecurseLimit,
+		SELinuxContext: obj.SELinuxContext.Copy(),
 	}
 }
 
@@ -1709,7 +2162,7 @@ func (obj *FileRes) Reversed() (engine.ReversibleRes, error) {
 	// if we're removing the file with a `state => "absent"`, save it too...
 	// We do this whether we specified content with Content or w/ Fragments.
 	// The `res.State != FileStateAbsent` check is an optional optimization.
-	if ((obj.Content != nil || len(obj.Fragments) > 0) || obj.State == FileStateAbsent) && res.State != FileStateAbsent {
+	if ((obj.Content != nil || obj.Template != nil || len(obj.Fragments) > 0) || obj.State == FileStateAbsent) && res.State != FileStateAbsent {
 		content, err := os.ReadFile(obj.getPath())
 		if err != nil && !os.IsNotExist(err) {
 			return nil, errwrap.Wrapf(err, "could not read file for reversal storage")
@@ -1735,6 +2188,9 @@ func (obj *FileRes) Reversed() (engine.ReversibleRes, error) {
 	if len(obj.Fragments) > 0 {
 		res.Fragments = []string{}
 	}
+	// The same is true for a Template, the rendered file is what we store.
+	res.Template = nil
+	res.TemplateVars = nil
 
 	// There is a race if the operating system is adding/changing/removing
 	// the file between the os.ReadFile at the top and here. If there is a
@@ -0,0 +1,652 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package resources
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	engineUtil "github.com/purpleidea/mgmt/engine/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// xattrSELinux is the extended attribute that stores the SELinux
+	// security context of a file.
+	xattrSELinux = "security.selinux"
+
+	// xattrACLAccess is the extended attribute that stores the POSIX access
+	// ACL of a file.
+	xattrACLAccess = "system.posix_acl_access"
+
+	// xattrACLDefault is the extended attribute that stores the POSIX
+	// default ACL of a directory.
+	xattrACLDefault = "system.posix_acl_default"
+
+	// These are the values used in the kernel's xattr representation of an
+	// ACL. See: include/uapi/linux/posix_acl_xattr.h
+	aclXattrVersion = 0x0002
+	aclUndefinedID  = 0xffffffff
+	aclUserObj      = 0x01
+	aclUser         = 0x02
+	aclGroupObj     = 0x04
+	aclGroup        = 0x08
+	aclMask         = 0x10
+	aclOther        = 0x20
+)
+
+// FileSELinuxContext is the SELinux security context of a file. Any field that
+// is empty is left as it currently is on the file.
+type FileSELinuxContext struct {
+	// User is the SELinux user. Eg: `system_u`.
+	User string `lang:"user" yaml:"user"`
+
+	// Role is the SELinux role. Eg: `object_r`.
+	Role string `lang:"role" yaml:"role"`
+
+	// Type is the SELinux type. Eg: `httpd_sys_content_t`.
+	Type string `lang:"type" yaml:"type"`
+
+	// Range is the SELinux level or range. Eg: `s0` or `s0:c0.c1023`.
+	Range string `lang:"range" yaml:"range"`
+}
+
+// Validate reports any problems with the struct definition.
+func (obj *FileSELinuxContext) Validate() error {
+	for _, x := range []string{obj.User, obj.Role, obj.Type} {
+		if strings.Contains(x, ":") {
+			return fmt.Errorf("the SELinux user, role and type can't contain a colon")
+		}
+	}
+	return nil
+}
+
+// Cmp compares two SELinux contexts. It errors if they are not identical.
+func (obj *FileSELinuxContext) Cmp(ctx *FileSELinuxContext) error {
+	if (obj == nil) != (ctx == nil) { // xor
+		return fmt.Errorf("the FileSELinuxContext differs")
+	}
+	if obj == nil && ctx == nil {
+		return nil
+	}
+	if obj.User != ctx.User {
+		return fmt.Errorf("the User differs")
+	}
+	if obj.Role != ctx.Role {
+		return fmt.Errorf("the Role differs")
+	}
+	if obj.Type != ctx.Type {
+		return fmt.Errorf("the Type differs")
+	}
+	if obj.Range != ctx.Range {
+		return fmt.Errorf("the Range differs")
+	}
+	return nil
+}
+
+// Copy returns a copy of this struct.
+func (obj *FileSELinuxContext) Copy() *FileSELinuxContext {
+	if obj == nil {
+		return nil
+	}
+	return &FileSELinuxContext{
+		User:  obj.User,
+		Role:  obj.Role,
+		Type:  obj.Type,
+		Range: obj.Range,
+	}
+}
+
+// merge returns the context that we want, given the current context string. It
+// errors if the result is incomplete, which can happen if the file doesn't have
+// a context yet, and not every field was specified.
+func (obj *FileSELinuxContext) merge(current string) (string, error) {
+	parts := strings.SplitN(current, ":", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	for i, x := range []string{obj.User, obj.Role, obj.Type, obj.Range} {
+		if x != "" {
+			parts[i] = x
+		}
+		if parts[i] == "" {
+			return "", fmt.Errorf("incomplete SELinux context: %s", strings.Join(parts, ":"))
+		}
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// fileACLEntry is a single parsed POSIX ACL entry.
+type fileACLEntry struct {
+	def       bool   // is this a default ACL entry?
+	tag       uint16 // one of the acl* tag constants
+	qualifier string // the user or group for the named entries
+	perm      uint16 // the rwx bits
+}
+
+// parseFileACLEntry parses an ACL entry in the short text form that getfacl and
+// setfacl use. Eg: `user::rwx`, `g:wheel:r-x`, `default:user:james:rw-` or
+// `mask::rx`. The user or group can be a name or a numeric id.
+func parseFileACLEntry(s string) (*fileACLEntry, error) {
+	obj := &fileACLEntry{}
+	fields := strings.Split(s, ":")
+	if len(fields) == 4 && (fields[0] == "default" || fields[0] == "d") {
+		obj.def = true
+		fields = fields[1:]
+	}
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid ACL entry: %s", s)
+	}
+
+	obj.qualifier = fields[1]
+	named := obj.qualifier != ""
+	switch fields[0] {
+	case "user", "u":
+		obj.tag = aclUserObj
+		if named {
+			obj.tag = aclUser
+		}
+	case "group", "g":
+		obj.tag = aclGroupObj
+		if named {
+			obj.tag = aclGroup
+		}
+	case "mask", "m":
+		obj.tag = aclMask
+	case "other", "o":
+		obj.tag = aclOther
+	default:
+		return nil, fmt.Errorf("invalid ACL entry type: %s", fields[0])
+	}
+	if named && obj.tag != aclUser && obj.tag != aclGroup {
+		return nil, fmt.Errorf("the ACL entry can't be named: %s", s)
+	}
+
+	for _, c := range fields[2] {
+		switch c {
+		case 'r':
+			obj.perm |= 4
+		case 'w':
+			obj.perm |= 2
+		case 'x':
+			obj.perm |= 1
+		case '-':
+		default:
+			return nil, fmt.Errorf("invalid ACL permissions: %s", fields[2])
+		}
+	}
+	return obj, nil
+}
+
+// fileACL is a POSIX ACL in the form the kernel uses. The ids are resolved.
+type fileACL map[[2]uint32]uint16 // {tag, id} -> perm
+
+// String returns the ACL in the stable short text form that getfacl uses.
+func (obj fileACL) String() string {
+	keys := obj.keys()
+	s := []string{}
+	for _, k := range keys {
+		name := map[uint32]string{
+			aclUserObj:  "user",
+			aclUser:     "user",
+			aclGroupObj: "group",
+			aclGroup:    "group",
+			aclMask:     "mask",
+			aclOther:    "other",
+		}[k[0]]
+		id := ""
+		if k[1] != aclUndefinedID {
+			id = strconv.FormatUint(uint64(k[1]), 10)
+		}
+		perm := []byte("---")
+		for i, c := range "rwx" {
+			if obj[k]&(4>>i) != 0 {
+				perm[i] = byte(c)
+			}
+		}
+		s = append(s, fmt.Sprintf("%s:%s:%s", name, id, perm))
+	}
+	return strings.Join(s, ",")
+}
+
+// keys returns the keys in the order that the kernel expects them.
+func (obj fileACL) keys() [][2]uint32 {
+	keys := [][2]uint32{}
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// has returns true if an entry with this tag exists.
+func (obj fileACL) has(tag uint16) bool {
+	_, exists := obj[[2]uint32{uint32(tag), aclUndefinedID}]
+	return exists
+}
+
+// named returns true if any named user or group entries exist.
+func (obj fileACL) named() bool {
+	for k := range obj {
+		if k[0] == aclUser || k[0] == aclGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// fill adds the base entries that are missing from this ACL by copying them
+// from the other one. If we need a mask, and don't have one, then it's computed
+// the same way that setfacl does it, unless keepMask is true. In that case, the
+// mask of the other one is kept, and if it has none, then its group permissions
+// are used, since those are what chmod changes.
+func (obj fileACL) fill(other fileACL, keepMask bool) {
+	for _, tag := range []uint16{aclUserObj, aclGroupObj, aclOther} {
+		k := [2]uint32{uint32(tag), aclUndefinedID}
+		if _, exists := obj[k]; !exists {
+			obj[k] = other[k]
+		}
+	}
+	if obj.has(aclMask) || !obj.named() {
+		return
+	}
+	k := [2]uint32{aclMask, aclUndefinedID}
+	if perm, exists := other[k]; exists && keepMask {
+		obj[k] = perm
+		return
+	}
+	if keepMask {
+		obj[k] = other[[2]uint32{aclGroupObj, aclUndefinedID}]
+		return
+	}
+	var mask uint16
+	for x, perm := range obj {
+		if x[0] == aclUser || x[0] == aclGroupObj || x[0] == aclGroup {
+			mask |= perm
+		}
+	}
+	obj[k] = mask
+}
+
+// encode returns the ACL in the kernel's xattr format.
+func (obj fileACL) encode() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, uint32(aclXattrVersion))
+	for _, k := range obj.keys() {
+		binary.Write(buf, binary.LittleEndian, uint16(k[0]))
+		binary.Write(buf, binary.LittleEndian, obj[k])
+		binary.Write(buf, binary.LittleEndian, k[1])
+	}
+	return buf.Bytes()
+}
+
+// decodeFileACL decodes an ACL from the kernel's xattr format.
+func decodeFileACL(b []byte) (fileACL, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 {
+		return nil, fmt.Errorf("invalid ACL length of %d", len(b))
+	}
+	if v := binary.LittleEndian.Uint32(b); v != aclXattrVersion {
+		return nil, fmt.Errorf("unknown ACL version of %d", v)
+	}
+	acl := make(fileACL)
+	for i := 4; i < len(b); i += 8 {
+		tag := binary.LittleEndian.Uint16(b[i:])
+		perm := binary.LittleEndian.Uint16(b[i+2:])
+		id := binary.LittleEndian.Uint32(b[i+4:])
+		acl[[2]uint32{uint32(tag), id}] = perm
+	}
+	return acl, nil
+}
+
+// modeFileACL returns the minimal ACL which is equivalent to the file mode.
+func modeFileACL(mode os.FileMode) fileACL {
+	perm := uint16(mode.Perm())
+	return fileACL{
+		{aclUserObj, aclUndefinedID}:  (perm >> 6) & 7,
+		{aclGroupObj, aclUndefinedID}: (perm >> 3) & 7,
+		{aclOther, aclUndefinedID}:    perm & 7,
+	}
+}
+
+// lgetxattr returns the value of an extended attribute, without following any
+// symlinks. If the attribute doesn't exist, then it returns nil, and no error.
+func lgetxattr(p, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(p, name, nil)
+		if errors.Is(err, unix.ENODATA) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Lgetxattr(p, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue // it grew in between the two calls
+		}
+		if errors.Is(err, unix.ENODATA) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// getFileACL reads an ACL from a file. If it has none, it returns nil.
+func getFileACL(p, name string) (fileACL, error) {
+	b, err := lgetxattr(p, name)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return decodeFileACL(b)
+}
+
+// acl resolves the ACL entries into the access and the default ACL. Either of
+// these is nil when there are no entries of that kind.
+func (obj *FileRes) acl() (fileACL, fileACL, error) {
+	var access, def fileACL
+	for _, x := range obj.ACL {
+		entry, err := parseFileACLEntry(x)
+		if err != nil {
+			return nil, nil, err
+		}
+		id := uint32(aclUndefinedID)
+		if entry.tag == aclUser || entry.tag == aclGroup {
+			i, err := strconv.ParseUint(entry.qualifier, 10, 32)
+			if err != nil { // not a numeric id, so look it up
+				var n int
+				if entry.tag == aclUser {
+					n, err = engineUtil.GetUID(entry.qualifier)
+				} else {
+					n, err = engineUtil.GetGID(entry.qualifier)
+				}
+				if err != nil {
+					return nil, nil, err
+				}
+				i = uint64(n)
+			}
+			id = uint32(i)
+		}
+
+		acl := &access
+		if entry.def {
+			acl = &def
+		}
+		if *acl == nil {
+			*acl = make(fileACL)
+		}
+		(*acl)[[2]uint32{uint32(entry.tag), id}] = entry.perm
+	}
+	return access, def, nil
+}
+
+// attrPaths returns the list of paths that the SELinuxContext, ACL and Xattrs
+// are managed on. When we Recurse, this includes everything in our directory
+// that isn't skipped by our filter, except for symlinks, since they can't have
+// an ACL or most other attributes.
+func (obj *FileRes) attrPaths() ([]string, error) {
+	paths := []string{obj.getPath()}
+	if !obj.Recurse || !obj.isDir() {
+		return paths, nil
+	}
+
+	filter, err := obj.filter()
+	if err != nil {
+		return nil, err
+	}
+	root := obj.getPath()
+	walkFn := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(p, root)
+		if rel == "" { // the top, which we already have
+			return nil
+		}
+		if info.IsDir() {
+			rel += "/"
+		}
+		if filter.skip(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		paths = append(paths, p)
+		return nil
+	}
+	if err := filepath.Walk(root, walkFn); err != nil {
+		return nil, errwrap.Wrapf(err, "could not walk %s", root)
+	}
+	return paths, nil
+}
+
+// attrCheckApply performs a CheckApply for the SELinuxContext, ACL and Xattrs
+// of the file, and of its contents as well if we Recurse.
+func (obj *FileRes) attrCheckApply(ctx context.Context, apply bool) (bool, error) {
+	if obj.init.Debug {
+		obj.init.Logf("attrCheckApply(%t)", apply)
+	}
+
+	if obj.SELinuxContext == nil && len(obj.ACL) == 0 && len(obj.Xattrs) == 0 {
+		// nothing specified, everything is ok
+		return true, nil
+	}
+
+	access, def, err := obj.acl()
+	if err != nil {
+		return false, err
+	}
+	paths, err := obj.attrPaths()
+	if err != nil {
+		return false, err
+	}
+
+	checkOK := true
+	for _, p := range paths {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		default:
+		}
+
+		if c, err := obj.selinuxCheckApply(apply, p); err != nil {
+			return false, err
+		} else if !c {
+			checkOK = false
+		}
+		if c, err := obj.aclCheckApply(apply, p, access, def); err != nil {
+			return false, err
+		} else if !c {
+			checkOK = false
+		}
+		if c, err := obj.xattrsCheckApply(apply, p); err != nil {
+			return false, err
+		} else if !c {
+			checkOK = false
+		}
+
+		if !apply && !checkOK { // no need to look any further
+			return false, nil
+		}
+	}
+
+	return checkOK, nil
+}
+
+// selinuxCheckApply performs a CheckApply of the SELinux context on one path.
+func (obj *FileRes) selinuxCheckApply(apply bool, p string) (bool, error) {
+	if obj.SELinuxContext == nil {
+		return true, nil
+	}
+	b, err := lgetxattr(p, xattrSELinux)
+	if err != nil {
+		return false, errwrap.Wrapf(err, "could not read the SELinux context of %s", p)
+	}
+	current := strings.TrimRight(string(b), "\x00")
+
+	expected, err := obj.SELinuxContext.merge(current)
+	if err != nil {
+		return false, errwrap.Wrapf(err, "bad SELinux context for %s", p)
+	}
+	if current == expected {
+		return true, nil
+	}
+
+	if !apply {
+		return false, nil
+	}
+
+	obj.init.Logf("chcon %s %s", expected, p)
+	// libselinux includes the trailing null byte, so we do the same
+	if err := unix.Lsetxattr(p, xattrSELinux, append([]byte(expected), 0), 0); err != nil {
+		return false, errwrap.Wrapf(err, "could not set the SELinux context of %s", p)
+	}
+	return false, nil
+}
+
+// aclCheckApply performs a CheckApply of the access and default ACL on one
+// path. Any base entries that weren't specified are kept as they currently are.
+func (obj *FileRes) aclCheckApply(apply bool, p string, access, def fileACL) (bool, error) {
+	if access == nil && def == nil {
+		return true, nil
+	}
+	fileInfo, err := os.Lstat(p)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := getFileACL(p, xattrACLAccess)
+	if err != nil {
+		return false, errwrap.Wrapf(err, "could not read the ACL of %s", p)
+	}
+	if current == nil { // no ACL is the same as the minimal ACL
+		current = modeFileACL(fileInfo.Mode())
+	}
+
+	checkOK := true
+	expected := current
+	if access != nil {
+		expected = make(fileACL)
+		for k, v := range access {
+			expected[k] = v
+		}
+		expected.fill(current, obj.Mode != "") // chmod changes the mask
+
+		if expected.String() != current.String() {
+			checkOK = false
+			if apply {
+				obj.init.Logf("setfacl %s: %s", p, expected)
+				if err := unix.Lsetxattr(p, xattrACLAccess, expected.encode(), 0); err != nil {
+					return false, errwrap.Wrapf(err, "could not set the ACL of %s", p)
+				}
+			}
+		}
+	}
+
+	if def == nil || !fileInfo.IsDir() { // only dirs have default ACL's
+		return checkOK, nil
+	}
+
+	currentDef, err := getFileACL(p, xattrACLDefault)
+	if err != nil {
+		return false, errwrap.Wrapf(err, "could not read the default ACL of %s", p)
+	}
+	expectedDef := make(fileACL)
+	for k, v := range def {
+		expectedDef[k] = v
+	}
+	if currentDef != nil {
+		expectedDef.fill(currentDef, false)
+	}
+	expectedDef.fill(expected, false) // like setfacl, copy from the access ACL
+
+	if currentDef != nil && expectedDef.String() == currentDef.String() {
+		return checkOK, nil
+	}
+	if !apply {
+		return false, nil
+	}
+	obj.init.Logf("setfacl -d %s: %s", p, expectedDef)
+	if err := unix.Lsetxattr(p, xattrACLDefault, expectedDef.encode(), 0); err != nil {
+		return false, errwrap.Wrapf(err, "could not set the default ACL of %s", p)
+	}
+	return false, nil
+}
+
+// xattrsCheckApply performs a CheckApply of the extended attributes on one
+// path. Only the attributes that we specify are managed.
+func (obj *FileRes) xattrsCheckApply(apply bool, p string) (bool, error) {
+	keys := []string{}
+	for k := range obj.Xattrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	checkOK := true
+	for _, k := range keys {
+		b, err := lgetxattr(p, k)
+		if err != nil {
+			return false, errwrap.Wrapf(err, "could not read xattr %s of %s", k, p)
+		}
+		if b != nil && string(b) == obj.Xattrs[k] {
+			continue
+		}
+		checkOK = false
+		if !apply {
+			return false, nil
+		}
+		obj.init.Logf("setxattr %s %s", k, p)
+		if err := unix.Lsetxattr(p, k, []byte(obj.Xattrs[k]), 0); err != nil {
+			return false, errwrap.Wrapf(err, "could not set xattr %s of %s", k, p)
+		}
+	}
+	return checkOK, nil
+}
@@ -0,0 +1,206 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+// FileIgnoreName is the name of the file which can be placed at the top of a
+// source or destination directory to list paths that the file resource should
+// leave alone. It uses a subset of the .gitignore syntax: blank lines and lines
+// starting with a # are skipped, a leading ! negates a pattern, a trailing /
+// only matches directories, and a pattern containing a / is matched from the
+// top of the directory, while one without a slash matches the base name at any
+// depth. A ** path component matches zero or more directories.
+const FileIgnoreName = ".mgmtignore"
+
+// filePattern is a single parsed glob pattern as used by the file resource.
+type filePattern struct {
+	segments []string // the pattern split on slashes
+	negate   bool     // the pattern started with a !
+	dirOnly  bool     // the pattern ended with a /
+	anchored bool     // the pattern must match from the top
+}
+
+// parseFilePattern parses a single pattern. See FileIgnoreName for the syntax.
+func parseFilePattern(s string) (*filePattern, error) {
+	obj := &filePattern{}
+	if strings.HasPrefix(s, "!") {
+		obj.negate = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		obj.dirOnly = true
+		s = strings.TrimSuffix(s, "/")
+	}
+	if strings.Contains(s, "/") {
+		obj.anchored = true
+		s = strings.TrimPrefix(s, "/")
+	}
+	if s == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	obj.segments = strings.Split(s, "/")
+	for _, x := range obj.segments {
+		if _, err := path.Match(x, ""); err != nil {
+			return nil, errwrap.Wrapf(err, "bad pattern: %s", s)
+		}
+	}
+	return obj, nil
+}
+
+// match returns true if this pattern matches the relative path which has been
+// split into segments.
+func (obj *filePattern) match(segments []string, isDir bool) bool {
+	if obj.dirOnly && !isDir {
+		return false
+	}
+	if !obj.anchored && len(obj.segments) == 1 { // match the base name
+		ok, _ := path.Match(obj.segments[0], segments[len(segments)-1])
+		return ok
+	}
+	return matchSegments(obj.segments, segments)
+}
+
+// matchSegments matches a list of glob segments against a list of path
+// segments. The ** segment matches zero or more path segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// parseFileIgnore reads and parses an ignore file. If it doesn't exist, then
+// this returns an empty list.
+func parseFileIgnore(p string) ([]*filePattern, error) {
+	patterns := []*filePattern{}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return patterns, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := parseFilePattern(line)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "invalid line in %s", p)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, scanner.Err()
+}
+
+// fileFilter decides which paths in a recursive file resource are skipped. A
+// skipped path is not copied, purged or watched.
+type fileFilter struct {
+	// limit is the maximum depth, where zero is unlimited.
+	limit uint64
+
+	// include is the list of patterns which files must match if non-empty.
+	include []*filePattern
+
+	// ignore is the list of ignore patterns. As with .gitignore files, the
+	// last pattern to match a path decides whether it's ignored.
+	ignore []*filePattern
+}
+
+// skip returns true if the relative path should be skipped. Directories have a
+// trailing slash. The empty path, which is the top directory, is never skipped.
+func (obj *fileFilter) skip(rel string) bool {
+	if obj == nil || rel == "" {
+		return false
+	}
+	isDir := strings.HasSuffix(rel, "/")
+	segments := strings.Split(strings.Trim(rel, "/"), "/")
+	if rel == FileIgnoreName {
+		return true // the ignore file itself is left alone
+	}
+
+	if obj.limit > 0 && uint64(len(segments)) > obj.limit {
+		return true
+	}
+	// a path is also ignored if any of the parent directories are
+	for i := 1; i <= len(segments); i++ {
+		if obj.ignored(segments[:i], isDir || i < len(segments)) {
+			return true
+		}
+	}
+
+	if isDir || len(obj.include) == 0 { // includes only filter files
+		return false
+	}
+	for _, x := range obj.include {
+		if x.match(segments, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// ignored returns true if the last ignore pattern that matches isn't negated.
+func (obj *fileFilter) ignored(segments []string, isDir bool) bool {
+	ignored := false
+	for _, x := range obj.ignore {
+		if x.match(segments, isDir) {
+			ignored = !x.negate
+		}
+	}
+	return ignored
+}
@@ -33,14 +33,26 @@ package resources
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/engine/graph/autoedge"
 	engineUtil "github.com/purpleidea/mgmt/engine/util"
+	"github.com/purpleidea/mgmt/lang/types"
 	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/util"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestFileAutoEdge1(t *testing.T) {
@@ -275,3 +287,439 @@ func TestFileAbsolute1(t *testing.T) {
 		t.Errorf("file res should have failed validate")
 	}
 }
+
+func TestFileTemplate1(t *testing.T) {
+	p := path.Join(t.TempDir(), "file")
+	tmpl := "hello {{ .name }}, you are {{ .age }}\n"
+	vars := types.NewStruct(types.NewType("struct{name str; age int}"))
+	vars.Set("name", &types.StrValue{V: "purple"})
+	vars.Set("age", &types.IntValue{V: 42})
+
+	res := &FileRes{
+		Path:         p,
+		State:        FileStateExists,
+		Template:     &tmpl,
+		TemplateVars: vars,
+	}
+	if err := res.Validate(); err != nil {
+		t.Fatalf("validate failed with: %+v", err)
+	}
+	init := &engine.Init{
+		Logf: t.Logf,
+		Recv: func() map[string]*engine.Send {
+			return map[string]*engine.Send{}
+		},
+	}
+	if err := res.Init(init); err != nil {
+		t.Fatalf("init failed with: %+v", err)
+	}
+
+	if _, err := res.CheckApply(context.Background(), true); err != nil {
+		t.Fatalf("checkapply failed with: %+v", err)
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatalf("could not read file: %+v", err)
+	}
+	if s, exp := string(b), "hello purple, you are 42\n"; s != exp {
+		t.Errorf("expected: %q, got: %q", exp, s)
+	}
+
+	// the vars changed, as if we received them, so it must render again
+	vars.Set("age", &types.IntValue{V: 43})
+	if checkOK, err := res.CheckApply(context.Background(), true); err != nil {
+		t.Fatalf("checkapply failed with: %+v", err)
+	} else if checkOK {
+		t.Errorf("checkapply should have made a change")
+	}
+	b, _ = os.ReadFile(p)
+	if s, exp := string(b), "hello purple, you are 43\n"; s != exp {
+		t.Errorf("expected: %q, got: %q", exp, s)
+	}
+
+	// a broken template must not change the file at all
+	bad := "hello {{ nosuchfunc .name }}\n"
+	res.Template = &bad
+	if _, err := res.CheckApply(context.Background(), true); err == nil {
+		t.Errorf("checkapply should have failed")
+	}
+	b, _ = os.ReadFile(p)
+	if s, exp := string(b), "hello purple, you are 43\n"; s != exp {
+		t.Errorf("file was changed to: %q", s)
+	}
+
+	content := "hello"
+	res.Content = &content
+	if err := res.Validate(); err == nil {
+		t.Errorf("validate should fail with both Content and Template")
+	}
+}
+
+func TestFileFilter1(t *testing.T) {
+	type test struct { // an individual test
+		name    string
+		limit   uint64
+		include []string
+		ignore  []string
+		skip    []string // relative paths which must be skipped
+		keep    []string // relative paths which must not be skipped
+	}
+	testCases := []test{}
+
+	testCases = append(testCases, test{
+		name: "empty",
+		keep: []string{"a", "a/", "a/b/c", FileIgnoreName + "/"},
+		skip: []string{FileIgnoreName},
+	})
+	testCases = append(testCases, test{
+		name:  "limit",
+		limit: 2,
+		keep:  []string{"a", "a/", "a/b", "a/b/"},
+		skip:  []string{"a/b/c", "a/b/c/"},
+	})
+	testCases = append(testCases, test{
+		name:   "basename",
+		ignore: []string{"*.pyc", ".git/"},
+		keep:   []string{"a.py", "a/b.py", ".git", "x/.gitignore"},
+		skip:   []string{"a.pyc", "a/b/c.pyc", ".git/", ".git/config", "x/.git/HEAD"},
+	})
+	testCases = append(testCases, test{
+		name:   "anchored",
+		ignore: []string{"/build", "docs/*.md", "**/tmp/"},
+		keep:   []string{"x/build", "x/docs/a.md", "docs/x/a.md", "tmp"},
+		skip:   []string{"build", "build/x", "docs/a.md", "tmp/", "a/b/tmp/c"},
+	})
+	testCases = append(testCases, test{
+		name:   "negate",
+		ignore: []string{"*.log", "!keep.log", "logs/", "!logs/x"},
+		keep:   []string{"keep.log", "a/keep.log", "a.txt"},
+		skip:   []string{"a.log", "a/b.log", "logs/x"}, // parent wins
+	})
+	testCases = append(testCases, test{
+		name:    "include",
+		include: []string{"*.conf", "/etc/*"},
+		ignore:  []string{"secret.conf"},
+		keep:    []string{"a.conf", "a/b.conf", "a/", "etc/x", "x/y/"},
+		skip:    []string{"a.txt", "x/etc/y", "secret.conf"},
+	})
+
+	names := []string{}
+	for index, tc := range testCases { // run all the tests
+		if tc.name == "" {
+			t.Errorf("test #%d: not named", index)
+			continue
+		}
+		if util.StrInList(tc.name, names) {
+			t.Errorf("test #%d: duplicate sub test name of: %s", index, tc.name)
+			continue
+		}
+		names = append(names, tc.name)
+
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			filter := &fileFilter{
+				limit: tc.limit,
+			}
+			for _, x := range tc.include {
+				pattern, err := parseFilePattern(x)
+				if err != nil {
+					t.Fatalf("could not parse %s: %+v", x, err)
+				}
+				filter.include = append(filter.include, pattern)
+			}
+			for _, x := range tc.ignore {
+				pattern, err := parseFilePattern(x)
+				if err != nil {
+					t.Fatalf("could not parse %s: %+v", x, err)
+				}
+				filter.ignore = append(filter.ignore, pattern)
+			}
+
+			for _, x := range tc.skip {
+				if !filter.skip(x) {
+					t.Errorf("path %s was not skipped", x)
+				}
+			}
+			for _, x := range tc.keep {
+				if filter.skip(x) {
+					t.Errorf("path %s was skipped", x)
+				}
+			}
+		})
+	}
+}
+
+func TestFileFilter2(t *testing.T) {
+	src := t.TempDir() + "/"
+	dst := t.TempDir() + "/"
+	files := map[string]string{
+		src + "a.py":         "a",
+		src + "a.pyc":        "a",
+		src + "sub/b.py":     "b",
+		src + "sub/deep/c":   "c",
+		dst + "old.pyc":      "leave me",
+		dst + ".git/config":  "leave me",
+		dst + "sub/stale":    "purge me",
+		dst + FileIgnoreName: ".git/\n",
+	}
+	for p, data := range files {
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatalf("mkdir failed with: %+v", err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatalf("write failed with: %+v", err)
+		}
+	}
+
+	res := &FileRes{
+		Path:         dst,
+		State:        FileStateExists,
+		Source:       src,
+		Recurse:      true,
+		Purge:        true,
+		RecurseLimit: 2,
+		Exclude:      []string{"*.pyc"},
+	}
+	if err := res.Validate(); err != nil {
+		t.Fatalf("validate failed with: %+v", err)
+	}
+	init := &engine.Init{
+		Logf: t.Logf,
+		Recv: func() map[string]*engine.Send {
+			return map[string]*engine.Send{}
+		},
+		FilteredGraph: func() (*pgraph.Graph, error) {
+			return pgraph.NewGraph("FilteredGraph")
+		},
+	}
+	if err := res.Init(init); err != nil {
+		t.Fatalf("init failed with: %+v", err)
+	}
+	if _, err := res.CheckApply(context.Background(), true); err != nil {
+		t.Fatalf("checkapply failed with: %+v", err)
+	}
+
+	found := []string{}
+	walkFn := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			found = append(found, strings.TrimPrefix(p, dst))
+		}
+		return nil
+	}
+	if err := filepath.Walk(dst, walkFn); err != nil {
+		t.Fatalf("walk failed with: %+v", err)
+	}
+	sort.Strings(found)
+	expected := []string{".git/config", FileIgnoreName, "a.py", "old.pyc", "sub/b.py"}
+	if err := util.SortedStrSliceCompare(found, expected); err != nil {
+		t.Errorf("unexpected files: %+v", found)
+	}
+	if _, err := os.Stat(dst + "sub/deep/"); err != nil {
+		t.Errorf("dir at the limit was not made: %+v", err)
+	}
+
+	if checkOK, err := res.CheckApply(context.Background(), false); err != nil {
+		t.Fatalf("checkapply failed with: %+v", err)
+	} else if !checkOK {
+		t.Errorf("second checkapply should be a noop")
+	}
+
+	res.Include = []string{"!*.py"}
+	if err := res.Validate(); err == nil {
+		t.Errorf("validate should fail with a negated Include")
+	}
+}
+
+func TestFileACL1(t *testing.T) {
+	type test struct { // an individual test
+		name  string
+		entry string
+		fail  bool
+		def   bool
+		tag   uint16
+		perm  uint16
+	}
+	testCases := []test{}
+
+	testCases = append(testCases, test{
+		name:  "user obj",
+		entry: "user::rwx",
+		tag:   aclUserObj,
+		perm:  7,
+	})
+	testCases = append(testCases, test{
+		name:  "named group",
+		entry: "g:wheel:r-x",
+		tag:   aclGroup,
+		perm:  5,
+	})
+	testCases = append(testCases, test{
+		name:  "default",
+		entry: "default:user:1000:rw",
+		def:   true,
+		tag:   aclUser,
+		perm:  6,
+	})
+	testCases = append(testCases, test{
+		name:  "mask",
+		entry: "m::r",
+		tag:   aclMask,
+		perm:  4,
+	})
+	testCases = append(testCases, test{
+		name:  "named other",
+		entry: "other:james:r--",
+		fail:  true,
+	})
+	testCases = append(testCases, test{
+		name:  "bad perms",
+		entry: "user::rwz",
+		fail:  true,
+	})
+	testCases = append(testCases, test{
+		name:  "bad type",
+		entry: "world::rwx",
+		fail:  true,
+	})
+	testCases = append(testCases, test{
+		name:  "short",
+		entry: "user:rwx",
+		fail:  true,
+	})
+
+	names := []string{}
+	for index, tc := range testCases { // run all the tests
+		if tc.name == "" {
+			t.Errorf("test #%d: not named", index)
+			continue
+		}
+		if util.StrInList(tc.name, names) {
+			t.Errorf("test #%d: duplicate sub test name of: %s", index, tc.name)
+			continue
+		}
+		names = append(names, tc.name)
+
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			entry, err := parseFileACLEntry(tc.entry)
+			if tc.fail {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not parse: %+v", err)
+			}
+			if entry.def != tc.def || entry.tag != tc.tag || entry.perm != tc.perm {
+				t.Errorf("unexpected entry: %+v", entry)
+			}
+		})
+	}
+}
+
+func TestFileACL2(t *testing.T) {
+	acl := fileACL{
+		{aclUser, 1000}:            6,
+		{aclGroup, 10}:             4,
+		{aclOther, aclUndefinedID}: 0,
+	}
+	acl.fill(modeFileACL(0751), false)
+
+	expected := fileACL{
+		{aclUserObj, aclUndefinedID}:  7,
+		{aclUser, 1000}:               6,
+		{aclGroupObj, aclUndefinedID}: 5,
+		{aclGroup, 10}:                4,
+		{aclMask, aclUndefinedID}:     7,
+		{aclOther, aclUndefinedID}:    0,
+	}
+	if s1, s2 := acl.String(), expected.String(); s1 != s2 {
+		t.Errorf("expected: %s, got: %s", s2, s1)
+	}
+
+	decoded, err := decodeFileACL(acl.encode())
+	if err != nil {
+		t.Fatalf("could not decode: %+v", err)
+	}
+	if s1, s2 := decoded.String(), expected.String(); s1 != s2 {
+		t.Errorf("expected: %s, got: %s", s2, s1)
+	}
+
+	if _, err := decodeFileACL([]byte{2, 0, 0, 0, 1}); err == nil {
+		t.Errorf("expected a decode error")
+	}
+}
+
+func TestFileXattrs1(t *testing.T) {
+	dir := t.TempDir() + "/"
+	if err := os.WriteFile(dir+"file", []byte("hello"), 0644); err != nil {
+		t.Fatalf("write failed with: %+v", err)
+	}
+	if err := unix.Lsetxattr(dir, "user.test", []byte("x"), 0); err != nil {
+		t.Skipf("xattrs are not supported here: %+v", err)
+	}
+
+	res := &FileRes{
+		Path:    dir,
+		State:   FileStateExists,
+		Recurse: true,
+		Xattrs: map[string]string{
+			"user.origin": "mgmt",
+		},
+		ACL: []string{
+			"user:0:r-x",
+			"default:user:0:rwx",
+		},
+	}
+	if err := res.Validate(); err != nil {
+		t.Fatalf("validate failed with: %+v", err)
+	}
+	init := &engine.Init{
+		Logf: t.Logf,
+		Recv: func() map[string]*engine.Send {
+			return map[string]*engine.Send{}
+		},
+	}
+	if err := res.Init(init); err != nil {
+		t.Fatalf("init failed with: %+v", err)
+	}
+
+	if checkOK, err := res.CheckApply(context.Background(), true); errors.Is(err, unix.EOPNOTSUPP) {
+		t.Skipf("acl's are not supported here: %+v", err)
+	} else if err != nil {
+		t.Fatalf("checkapply failed with: %+v", err)
+	} else if checkOK {
+		t.Errorf("checkapply should have made a change")
+	}
+
+	for _, p := range []string{dir, dir + "file"} {
+		b, err := lgetxattr(p, "user.origin")
+		if err != nil || string(b) != "mgmt" {
+			t.Errorf("xattr was not set on %s: %s (%+v)", p, b, err)
+		}
+		acl, err := getFileACL(p, xattrACLAccess)
+		if err != nil || acl == nil {
+			t.Errorf("acl was not set on %s: %+v", p, err)
+			continue
+		}
+		if perm := acl[[2]uint32{aclUser, 0}]; perm != 5 {
+			t.Errorf("unexpected acl on %s: %s", p, acl)
+		}
+	}
+	if acl, err := getFileACL(dir+"file", xattrACLDefault); err != nil || acl != nil {
+		t.Errorf("a file got a default acl: %s (%+v)", acl, err)
+	}
+
+	if checkOK, err := res.CheckApply(context.Background(), false); err != nil {
+		t.Fatalf("checkapply failed with: %+v", err)
+	} else if !checkOK {
+		t.Errorf("second checkapply should be a noop")
+	}
+
+	res.Xattrs[xattrSELinux] = "nope"
+	if err := res.Validate(); err == nil {
+		t.Errorf("validate should fail with the selinux xattr")
+	}
+}
@@ -54,6 +54,7 @@ const groupFile = "/etc/group"
 type GroupRes struct {
 	traits.Base // add the base methods without re-implementation
 	traits.Edgeable
+	traits.Reversible
 
 	init *engine.Init
 
@@ -248,6 +249,73 @@ func (obj *GroupRes) Cmp(r engine.Res) error {
 	return nil
 }
 
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *GroupRes) Copy() engine.CopyableRes {
+	var gid *uint32
+	if obj.GID != nil { // copy the value, not the pointer...
+		x := *obj.GID
+		gid = &x
+	}
+	return &GroupRes{
+		State: obj.State,
+		GID:   gid,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. A group that
+// we add gets deleted, and a group that we delete gets restored with the gid it
+// had before we ran. A group that was already there isn't reversed.
+func (obj *GroupRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*GroupRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	// This runs before CheckApply, so we can see if the group is already
+	// there. We never delete a group that existed before we ran.
+	group, err := user.LookupGroup(obj.Name())
+	if _, ok := err.(user.UnknownGroupError); !ok && err != nil {
+		return nil, errwrap.Wrapf(err, "error looking up group for reversal")
+	}
+	exists := err == nil
+
+	if obj.State == "exists" {
+		if exists {
+			return nil, nil // it was already there, so leave it alone
+		}
+		res.State = "absent"
+		res.GID = nil
+		return res, nil
+	}
+
+	// Down here, we're removing a group, so restore what it is right now.
+	if !exists {
+		return nil, nil // it didn't exist, so there's nothing to restore
+	}
+	gid, err := strconv.ParseUint(group.Gid, 10, 32)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error parsing GID")
+	}
+	gid32 := uint32(gid)
+
+	res.State = "exists"
+	res.GID = &gid32
+
+	return res, nil
+}
+
 // GroupUID is the UID struct for GroupRes.
 type GroupUID struct {
 	engine.BaseUID
@@ -60,6 +60,7 @@ const (
 // For more complicated control over the file, use the regular File resource.
 type LineRes struct {
 	traits.Base // add the base methods without re-implementation
+	traits.Reversible
 
 	init *engine.Init
 
@@ -258,6 +259,11 @@ func (obj *LineRes) remove(ctx context.Context) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close() // don't leak
+		return false, err
+	}
 
 	var fileLines []string
 	scanner := bufio.NewScanner(file)
@@ -274,13 +280,20 @@ func (obj *LineRes) remove(ctx context.Context) (bool, error) {
 		file.Close() // don't leak
 		return false, err
 	}
-	file.Close() // close before we eventually write
 
-	// check if the last line ends with a newline
+	// check if the file ends with a newline, the scanner strips them off
 	nl := ""
-	if len(fileLines) > 0 && strings.HasSuffix(fileLines[len(fileLines)-1], "\n") {
-		nl = "\n"
+	if len(fileLines) > 0 {
+		b := make([]byte, 1)
+		if _, err := file.ReadAt(b, fi.Size()-1); err != nil {
+			file.Close() // don't leak
+			return false, err
+		}
+		if b[0] == '\n' {
+			nl = "\n"
+		}
 	}
+	file.Close() // close before we eventually write
 
 	// XXX: add tests to make sure this is correct
 	var newLines []string
@@ -318,6 +331,10 @@ func (obj *LineRes) remove(ctx context.Context) (bool, error) {
 		return true, nil // nothing removed!
 	}
 
+	if len(newLines) == 0 {
+		nl = "" // don't leave a lone newline in an otherwise empty file
+	}
+
 	// write out the updated file
 	output := strings.Join(newLines, "\n") + nl // preserve newline at EOF
 	return false, os.WriteFile(obj.File, []byte(output), 0600)
@@ -367,6 +384,56 @@ func (obj *LineRes) Cmp(r engine.Res) error {
 	return nil
 }
 
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *LineRes) Copy() engine.CopyableRes {
+	return &LineRes{
+		File:    obj.File,
+		State:   obj.State,
+		Content: obj.Content,
+		Trim:    obj.Trim,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. A line that
+// was already in the state we want isn't reversed.
+func (obj *LineRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*LineRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	// This runs before CheckApply, so we can see if the line is already in
+	// the state we want. If so, there's nothing that we'll need to undo.
+	found, err := obj.check(context.TODO())
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not check line for reversal")
+	}
+	if found == (obj.State == LineStateExists) {
+		return nil, nil // we won't change anything
+	}
+
+	if obj.State == LineStateExists {
+		res.State = LineStateAbsent
+	}
+	if obj.State == LineStateAbsent {
+		res.State = LineStateExists
+	}
+
+	return res, nil
+}
+
 // UnmarshalYAML is the custom unmarshal handler for this struct. It is
 // primarily useful for setting the defaults.
 func (obj *LineRes) UnmarshalYAML(unmarshal func(interface{}) error) error {
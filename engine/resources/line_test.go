@@ -0,0 +1,105 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLineRemove1(t *testing.T) {
+	type test struct { // an individual test
+		name    string
+		input   string
+		content string
+		output  string
+	}
+	testCases := []test{
+		{
+			name:    "trailing newline",
+			input:   "one\ntwo\nthree\n",
+			content: "two",
+			output:  "one\nthree\n",
+		},
+		{
+			name:    "no trailing newline",
+			input:   "one\ntwo\nthree",
+			content: "two",
+			output:  "one\nthree",
+		},
+		{
+			name:    "last line",
+			input:   "one\ntwo\n",
+			content: "two",
+			output:  "one\n",
+		},
+		{
+			name:    "only line",
+			input:   "one\n",
+			content: "one",
+			output:  "",
+		},
+		{
+			name:    "multiple lines",
+			input:   "one\ntwo\nthree\nfour\n",
+			content: "two\nthree",
+			output:  "one\nfour\n",
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, input, content, output := tc.name, tc.input, tc.content, tc.output
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(p, []byte(input), 0600); err != nil {
+				t.Errorf("could not write file: %v", err)
+				return
+			}
+			res := &LineRes{File: p, State: LineStateAbsent, Content: content}
+			if checkOK, err := res.remove(context.Background()); err != nil || checkOK {
+				t.Errorf("expected a removal, got: %t, %v", checkOK, err)
+				return
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Errorf("could not read file: %v", err)
+				return
+			}
+			if s := string(b); s != output {
+				t.Errorf("expected: %q, got: %q", output, s)
+			}
+		})
+	}
+}
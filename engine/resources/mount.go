@@ -117,6 +117,7 @@ const (
 // accordingly. The mount point is set according to the resource's name.
 type MountRes struct {
 	traits.Base
+	traits.Reversible
 
 	init *engine.Init
 
@@ -401,6 +402,91 @@ func (obj *MountRes) Cmp(r engine.Res) error {
 	return nil
 }
 
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *MountRes) Copy() engine.CopyableRes {
+	var options map[string]string
+	if obj.Options != nil {
+		options = make(map[string]string)
+		for k, v := range obj.Options {
+			options[k] = v
+		}
+	}
+	return &MountRes{
+		State:   obj.State,
+		Device:  obj.Device,
+		Type:    obj.Type,
+		Options: options,
+		Freq:    obj.Freq,
+		PassNo:  obj.PassNo,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. A mount that
+// we add gets unmounted and removed from fstab, and a mount that we remove gets
+// restored from the fstab entry that was there before we ran. A mount that was
+// already there isn't reversed.
+func (obj *MountRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*MountRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	// This runs before CheckApply, so the fstab file still contains the
+	// entry for our mount point from before we ran, if there was one.
+	mounts, err := fstab.ParseFile(fstabPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errwrap.Wrapf(err, "could not read fstab for reversal storage")
+	}
+	var prev *fstab.Mount
+	for _, mount := range mounts { // nil if the file doesn't exist
+		if mount.File == obj.Name() {
+			prev = mount
+			break
+		}
+	}
+
+	if obj.State == "exists" && prev == nil {
+		res.State = "absent" // we're adding it, so remove it after
+		return res, nil
+	}
+	if prev == nil {
+		return nil, nil // it didn't exist, so there's nothing to restore
+	}
+
+	// Restore the previous entry, even if we're only changing it.
+	res.State = "exists"
+	res.Device = prev.Spec
+	res.Type = prev.VfsType
+	res.Options = prev.MntOps
+	res.Freq = prev.Freq
+	res.PassNo = prev.PassNo
+
+	mount := &fstab.Mount{
+		Spec:    obj.Device,
+		File:    obj.Name(),
+		VfsType: obj.Type,
+		MntOps:  obj.Options,
+		Freq:    obj.Freq,
+		PassNo:  obj.PassNo,
+	}
+	if obj.State == "exists" && prev.Equals(mount) {
+		return nil, nil // it was already there, so leave it alone
+	}
+	return res, nil
+}
+
 // MountUID is a unique resource identifier.
 type MountUID struct {
 	engine.BaseUID
@@ -32,14 +32,17 @@ package resources
 import (
 	"context"
 	"fmt"
+	"os"
 	"path"
 	"strings"
 
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/engine/resources/packagekit"
+	"github.com/purpleidea/mgmt/engine/resources/pkgbackend"
 	"github.com/purpleidea/mgmt/engine/traits"
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
+	"github.com/purpleidea/mgmt/util/recwatch"
 )
 
 func init() {
@@ -58,13 +61,19 @@ const (
 	// PkgStateNewest is the string that represents that the package should
 	// be installed in the newest available version.
 	PkgStateNewest = "newest"
+
+	// PkgBackendPackageKit is the name of the backend which uses PackageKit.
+	// The native backends are in the pkgbackend package.
+	PkgBackendPackageKit = "packagekit"
 )
 
-// PkgRes is a package resource for packagekit.
+// PkgRes is a package resource. It uses either PackageKit, or one of the native
+// package manager backends.
 type PkgRes struct {
 	traits.Base // add the base methods without re-implementation
 	traits.Edgeable
 	traits.Groupable
+	traits.Reversible
 
 	init *engine.Init
 
@@ -74,6 +83,23 @@ type PkgRes struct {
 	// version string desired.
 	State string `lang:"state" yaml:"state"`
 
+	// Version is the version of the package which we want to pin. It must
+	// be in the form that the package manager shows it in. The State must
+	// be installed if this is used. This is the same as putting the version
+	// in the State, which is the older way of doing this.
+	Version string `lang:"version" yaml:"version"`
+
+	// Hold specifies if the package should be held at its version, so that
+	// it won't be changed by upgrades. If this is nil, then holds are left
+	// alone. This isn't supported by the packagekit backend.
+	Hold *bool `lang:"hold" yaml:"hold"`
+
+	// Backend is the name of the package manager backend to use. This can
+	// be packagekit, or one of dnf, apt, apk or pacman for the native
+	// backends. If it is empty, then the native one for the distro is used
+	// if it is installed, and otherwise packagekit.
+	Backend string `lang:"backend" yaml:"backend"`
+
 	// AllowUntrusted specifies if we want to allow untrusted packages to be
 	// installed. Please see the PackageKit documentation for more
 	// information.
@@ -89,6 +115,10 @@ type PkgRes struct {
 
 	//bus              *packagekit.Conn    // pk bus connection
 	fileList []string // FIXME: update if pkg changes
+
+	backendName string             // the backend that was picked
+	backend     pkgbackend.Backend // nil if packagekit is used
+	backendInit *pkgbackend.Init   // so we can set the debug value
 }
 
 // Default returns some sensible defaults for this resource.
@@ -107,6 +137,22 @@ func (obj *PkgRes) Validate() error {
 		return fmt.Errorf("state is invalid, did you mean `newest` ?")
 	}
 
+	if obj.Version != "" && obj.State != PkgStateInstalled {
+		return fmt.Errorf("the Version can only be used with the %s state", PkgStateInstalled)
+	}
+	if obj.Hold != nil && *obj.Hold && obj.State == PkgStateUninstalled {
+		return fmt.Errorf("can't hold a package which is %s", PkgStateUninstalled)
+	}
+
+	if obj.Backend != "" && obj.Backend != PkgBackendPackageKit {
+		if _, err := pkgbackend.Lookup(obj.Backend); err != nil {
+			return errwrap.Wrapf(err, "invalid backend")
+		}
+	}
+	if obj.Backend == PkgBackendPackageKit && obj.Hold != nil {
+		return fmt.Errorf("the %s backend doesn't support holds", PkgBackendPackageKit)
+	}
+
 	return nil
 }
 
@@ -114,6 +160,17 @@ func (obj *PkgRes) Validate() error {
 func (obj *PkgRes) Init(init *engine.Init) error {
 	obj.init = init // save for later
 
+	if _, err := obj.getBackend(context.TODO()); err != nil {
+		return err
+	}
+	obj.init.Logf("using backend: %s", obj.backendName)
+	if obj.backendInit != nil {
+		obj.backendInit.Debug = obj.init.Debug
+	}
+	if obj.backendName == PkgBackendPackageKit && obj.Hold != nil {
+		return fmt.Errorf("the %s backend doesn't support holds", PkgBackendPackageKit)
+	}
+
 	if obj.fileList == nil {
 		if err := obj.populateFileList(); err != nil {
 			return errwrap.Wrapf(err, "error populating file list in init")
@@ -128,11 +185,65 @@ func (obj *PkgRes) Cleanup() error {
 	return nil
 }
 
+// getBackend picks the backend to use, and returns it. It returns nil if it is
+// packagekit, which is handled separately. This can be called before Init, so
+// that the automatic edges can be found.
+func (obj *PkgRes) getBackend(ctx context.Context) (pkgbackend.Backend, error) {
+	if obj.backendName != "" { // already picked
+		return obj.backend, nil
+	}
+
+	name := obj.Backend
+	if name == "" {
+		var err error
+		if name, err = pkgbackend.Detect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if name == "" || name == PkgBackendPackageKit {
+		obj.backendName = PkgBackendPackageKit
+		return nil, nil
+	}
+
+	backend, err := pkgbackend.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	backendInit := &pkgbackend.Init{
+		Logf: func(format string, v ...interface{}) {
+			if obj.init == nil { // not initialized yet
+				return
+			}
+			obj.init.Logf(name+": "+format, v...)
+		},
+	}
+	if err := backend.Init(backendInit); err != nil {
+		return nil, errwrap.Wrapf(err, "could not init the %s backend", name)
+	}
+	obj.backendName = name
+	obj.backend = backend
+	obj.backendInit = backendInit
+	return backend, nil
+}
+
+// state returns the state that we want, which is the pinned version if there is
+// one.
+func (obj *PkgRes) state() string {
+	if obj.Version != "" {
+		return obj.Version
+	}
+	return obj.State
+}
+
 // Watch is the primary listener for this resource and it outputs events. It
 // uses the PackageKit UpdatesChanged signal to watch for changes.
 // TODO: https://github.com/hughsie/PackageKit/issues/109
 // TODO: https://github.com/hughsie/PackageKit/issues/110
 func (obj *PkgRes) Watch(ctx context.Context) error {
+	if obj.backend != nil {
+		return obj.nativeWatch(ctx)
+	}
+
 	bus := packagekit.NewBus()
 	if bus == nil {
 		return fmt.Errorf("can't connect to PackageKit bus")
@@ -176,6 +287,49 @@ func (obj *PkgRes) Watch(ctx context.Context) error {
 	}
 }
 
+// nativeWatch watches the database files of the native package manager backend
+// for changes.
+func (obj *PkgRes) nativeWatch(ctx context.Context) error {
+	chanList := []<-chan recwatch.Event{}
+	for _, p := range obj.backend.WatchPaths() {
+		if _, err := os.Stat(p); err != nil { // not used on this system
+			continue
+		}
+		recWatcher, err := recwatch.NewRecWatcher(p, false)
+		if err != nil {
+			return err
+		}
+		defer recWatcher.Close()
+		chanList = append(chanList, recWatcher.Events())
+	}
+	var events <-chan recwatch.Event // nil chan blocks if unused
+	if len(chanList) > 0 {
+		events = recwatch.MergeChannels(chanList...)
+	}
+
+	obj.init.Running() // when started, notify engine that we're running
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok { // channel shutdown
+				return fmt.Errorf("unexpected close")
+			}
+			if err := event.Error; err != nil {
+				return errwrap.Wrapf(err, "unknown %s watcher error", obj)
+			}
+			if obj.init.Debug { // don't access event.Body if event.Error isn't nil
+				obj.init.Logf("event(%s): %v", event.Body.Name, event.Body.Op)
+			}
+
+		case <-ctx.Done(): // closed by the engine to signal shutdown
+			return nil
+		}
+
+		obj.init.Event() // notify engine of an event (this can block)
+	}
+}
+
 // get list of names when grouped or not
 func (obj *PkgRes) getNames() []string {
 	if g := obj.GetGroup(); len(g) > 0 { // grouped elements
@@ -207,7 +361,7 @@ func (obj *PkgRes) groupMappingHelper() map[string]string {
 			if !ok {
 				panic(fmt.Sprintf("grouped member %v is not a %s", x, obj.Kind()))
 			}
-			result[pkg.Name()] = pkg.State
+			result[pkg.Name()] = pkg.state()
 		}
 	}
 	return result
@@ -215,11 +369,11 @@ func (obj *PkgRes) groupMappingHelper() map[string]string {
 
 func (obj *PkgRes) pkgMappingHelper(bus *packagekit.Conn) (map[string]*packagekit.PkPackageIDActionData, error) {
 	packageMap := obj.groupMappingHelper() // get the grouped values
-	packageMap[obj.Name()] = obj.State     // key is pkg name, value is pkg state
+	packageMap[obj.Name()] = obj.state()   // key is pkg name, value is pkg state
 	var filter uint64                      // initializes at the "zero" value of 0
 	filter += packagekit.PkFilterEnumArch  // always search in our arch (optional!)
 	// we're requesting newest version, or to narrow down install choices!
-	if obj.State == PkgStateNewest || obj.State == PkgStateInstalled {
+	if obj.State == PkgStateNewest || obj.state() == PkgStateInstalled {
 		// if we add this, we'll still see older packages if installed
 		// this is an optimization, and is *optional*, this logic is
 		// handled inside of PackagesToPackageIDs now automatically!
@@ -241,6 +395,18 @@ func (obj *PkgRes) pkgMappingHelper(bus *packagekit.Conn) (map[string]*packageki
 // populateFileList fills in the fileList structure with what is in the package.
 // TODO: should this work properly if pkg has been autogrouped ?
 func (obj *PkgRes) populateFileList() error {
+	backend, err := obj.getBackend(context.TODO())
+	if err != nil {
+		return err
+	}
+	if backend != nil {
+		files, err := backend.Files(context.TODO(), obj.Name())
+		if err != nil {
+			return errwrap.Wrapf(err, "can't get the files of package %s", obj.Name())
+		}
+		obj.fileList = util.DirifyFileList(files, false)
+		return nil
+	}
 
 	bus := packagekit.NewBus()
 	if bus == nil {
@@ -286,6 +452,10 @@ func (obj *PkgRes) populateFileList() error {
 func (obj *PkgRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	obj.init.Logf("Check: %s", obj.fmtNames(obj.getNames()))
 
+	if obj.backend != nil {
+		return obj.nativeCheckApply(ctx, apply)
+	}
+
 	bus := packagekit.NewBus()
 	if bus == nil {
 		return false, fmt.Errorf("can't connect to PackageKit bus")
@@ -309,7 +479,7 @@ func (obj *PkgRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 
 	// TODO: at the moment, all the states are the same, but
 	// eventually we might be able to drop this constraint!
-	states, err := packagekit.FilterState(result, packageList, obj.State)
+	states, err := packagekit.FilterState(result, packageList, obj.state())
 	if err != nil {
 		return false, errwrap.Wrapf(err, "the FilterState method failed")
 	}
@@ -317,7 +487,7 @@ func (obj *PkgRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	validState := util.BoolMapTrue(util.BoolMapValues(states))
 
 	// obj.State == PkgStateInstalled || PkgStateUninstalled || PkgStateNewest || "4.2-1.fc23"
-	switch obj.State {
+	switch obj.state() {
 	case PkgStateInstalled:
 		fallthrough
 	case PkgStateUninstalled:
@@ -327,7 +497,7 @@ func (obj *PkgRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 			return true, nil // state is correct, exit!
 		}
 	default: // version string
-		if obj.State == data.Version && data.Version != "" {
+		if obj.state() == data.Version && data.Version != "" {
 			return true, nil
 		}
 	}
@@ -339,7 +509,7 @@ func (obj *PkgRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 
 	// apply portion
 	obj.init.Logf("Apply: %s", obj.fmtNames(obj.getNames()))
-	readyPackages, err := packagekit.FilterPackageState(result, packageList, obj.State)
+	readyPackages, err := packagekit.FilterPackageState(result, packageList, obj.state())
 	if err != nil {
 		return false, err // fail
 	}
@@ -352,8 +522,8 @@ func (obj *PkgRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 		transactionFlags += packagekit.PkTransactionFlagEnumOnlyTrusted
 	}
 	// apply correct state!
-	obj.init.Logf("Set(%s): %s...", obj.State, obj.fmtNames(util.StrListIntersection(applyPackages, obj.getNames())))
-	switch obj.State {
+	obj.init.Logf("Set(%s): %s...", obj.state(), obj.fmtNames(util.StrListIntersection(applyPackages, obj.getNames())))
+	switch obj.state() {
 	case PkgStateUninstalled: // run remove
 		// NOTE: packageID is different than when installed, because now
 		// it has the "installed" flag added to the data portion of it!!
@@ -370,7 +540,131 @@ func (obj *PkgRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	if err != nil {
 		return false, err // fail
 	}
-	obj.init.Logf("Set(%s) success: %s", obj.State, obj.fmtNames(util.StrListIntersection(applyPackages, obj.getNames())))
+	obj.init.Logf("Set(%s) success: %s", obj.state(), obj.fmtNames(util.StrListIntersection(applyPackages, obj.getNames())))
+	return false, nil // success
+}
+
+// nativeCheckApply is the CheckApply for the native package manager backends.
+// All of the grouped packages have the same state, so they are checked and
+// changed together.
+func (obj *PkgRes) nativeCheckApply(ctx context.Context, apply bool) (bool, error) {
+	names := obj.getNames()
+	state := obj.state()
+
+	installed, err := obj.backend.Installed(ctx, names)
+	if err != nil {
+		return false, errwrap.Wrapf(err, "could not get the installed packages")
+	}
+	var available map[string]string
+	if state == PkgStateNewest {
+		if available, err = obj.backend.Available(ctx, names); err != nil {
+			return false, errwrap.Wrapf(err, "could not get the available packages")
+		}
+	}
+
+	install := make(map[string]string) // name to version
+	upgrade := []string{}
+	remove := []string{}
+	for _, name := range names {
+		version := installed[name]
+		switch state {
+		case PkgStateUninstalled:
+			if version != "" {
+				remove = append(remove, name)
+			}
+
+		case PkgStateInstalled:
+			if version == "" {
+				install[name] = ""
+			}
+
+		case PkgStateNewest:
+			newest := available[name]
+			if newest == "" && version == "" {
+				return false, fmt.Errorf("can't find package named '%s'", name)
+			}
+			if newest != "" && newest != version { // it's only installed if it's not in a repo
+				upgrade = append(upgrade, name)
+			}
+
+		default: // version string
+			if version != state {
+				install[name] = state
+			}
+		}
+	}
+
+	held := make(map[string]bool)
+	holds := []string{} // packages whose hold must change
+	if obj.Hold != nil {
+		if held, err = obj.backend.Held(ctx, names); err != nil {
+			return false, errwrap.Wrapf(err, "could not get the held packages")
+		}
+		for _, name := range names {
+			if state != PkgStateUninstalled && held[name] != *obj.Hold {
+				holds = append(holds, name)
+			}
+		}
+	}
+
+	if len(install) == 0 && len(upgrade) == 0 && len(remove) == 0 && len(holds) == 0 {
+		return true, nil // state is correct, exit!
+	}
+
+	// state is not okay, no work done, exit, but without error
+	if !apply {
+		return false, nil
+	}
+
+	// apply portion
+	obj.init.Logf("Apply: %s", obj.fmtNames(names))
+	changes := append(append(util.StrMapKeys(install), upgrade...), remove...)
+
+	// a held package must be released before it can be changed
+	release := []string{}
+	for _, name := range changes {
+		if held[name] {
+			release = append(release, name)
+		}
+	}
+	if len(release) > 0 {
+		obj.init.Logf("Release: %s", strings.Join(release, ", "))
+		if err := obj.backend.Hold(ctx, release, false); err != nil {
+			return false, errwrap.Wrapf(err, "could not release the held packages")
+		}
+	}
+
+	if len(install) > 0 {
+		obj.init.Logf("Set(%s): %s...", state, strings.Join(util.StrMapKeys(install), ", "))
+		if err := obj.backend.Install(ctx, install); err != nil {
+			return false, errwrap.Wrapf(err, "could not install the packages")
+		}
+	}
+	if len(upgrade) > 0 {
+		obj.init.Logf("Set(%s): %s...", state, strings.Join(upgrade, ", "))
+		if err := obj.backend.Upgrade(ctx, upgrade); err != nil {
+			return false, errwrap.Wrapf(err, "could not upgrade the packages")
+		}
+	}
+	if len(remove) > 0 {
+		obj.init.Logf("Set(%s): %s...", state, strings.Join(remove, ", "))
+		if err := obj.backend.Remove(ctx, remove); err != nil {
+			return false, errwrap.Wrapf(err, "could not remove the packages")
+		}
+	}
+
+	if obj.Hold != nil && *obj.Hold {
+		// anything that was released needs to be held again
+		holds = util.StrRemoveDuplicatesInList(append(holds, release...))
+	}
+	if len(holds) > 0 {
+		obj.init.Logf("Hold(%t): %s", *obj.Hold, strings.Join(holds, ", "))
+		if err := obj.backend.Hold(ctx, holds, *obj.Hold); err != nil {
+			return false, errwrap.Wrapf(err, "could not change the held packages")
+		}
+	}
+
+	obj.init.Logf("Set(%s) success: %s", state, obj.fmtNames(names))
 	return false, nil // success
 }
 
@@ -385,6 +679,9 @@ func (obj *PkgRes) Cmp(r engine.Res) error {
 	if obj.State != res.State {
 		return fmt.Errorf("state differs: %s vs %s", obj.State, res.State)
 	}
+	if obj.Version != res.Version {
+		return fmt.Errorf("version differs: %s vs %s", obj.Version, res.Version)
+	}
 
 	return obj.Adapts(res)
 }
@@ -397,17 +694,27 @@ func (obj *PkgRes) Adapts(r engine.CompatibleRes) error {
 		return fmt.Errorf("res is not the same kind")
 	}
 
-	if obj.State != res.State {
-		e := fmt.Errorf("state differs in an incompatible way: %s vs %s", obj.State, res.State)
+	if obj.state() != res.state() {
+		e := fmt.Errorf("state differs in an incompatible way: %s vs %s", obj.state(), res.state())
 		if obj.State == PkgStateUninstalled || res.State == PkgStateUninstalled {
 			return e
 		}
-		if stateIsVersion(obj.State) || stateIsVersion(res.State) {
+		if stateIsVersion(obj.state()) || stateIsVersion(res.state()) {
 			return e
 		}
 		// one must be installed, and the other must be "newest"
 	}
 
+	if (obj.Hold == nil) != (res.Hold == nil) {
+		return fmt.Errorf("the Hold differs")
+	}
+	if obj.Hold != nil && *obj.Hold != *res.Hold {
+		return fmt.Errorf("the value of Hold differs")
+	}
+	if obj.Backend != res.Backend {
+		return fmt.Errorf("backend differs: %s vs %s", obj.Backend, res.Backend)
+	}
+
 	if obj.AllowUntrusted != res.AllowUntrusted {
 		return fmt.Errorf("allowuntrusted differs: %t vs %t", obj.AllowUntrusted, res.AllowUntrusted)
 	}
@@ -457,14 +764,93 @@ func (obj *PkgRes) Merge(r engine.CompatibleRes) (engine.CompatibleRes, error) {
 // Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
 // TODO: should this copy internal state?
 func (obj *PkgRes) Copy() engine.CopyableRes {
+	var hold *bool
+	if obj.Hold != nil {
+		h := *obj.Hold // copy
+		hold = &h
+	}
 	return &PkgRes{
 		State:            obj.State,
+		Version:          obj.Version,
+		Hold:             hold,
+		Backend:          obj.Backend,
 		AllowUntrusted:   obj.AllowUntrusted,
 		AllowNonFree:     obj.AllowNonFree,
 		AllowUnsupported: obj.AllowUnsupported,
 	}
 }
 
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed.
+func (obj *PkgRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*PkgRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	// This runs before CheckApply, so we can see if the package is already
+	// in the state that we want. We only reverse what we're going to change
+	// so that we never remove a package that was there before we ran.
+	backend, err := obj.getBackend(context.TODO())
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not get the backend for reversal")
+	}
+	var wasInstalled bool
+	var version string // packagekit doesn't tell us the installed version
+	if backend != nil {
+		installed, err := backend.Installed(context.TODO(), []string{obj.Name()})
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "could not get the installed package for reversal")
+		}
+		version = installed[obj.Name()]
+		wasInstalled = version != ""
+	} else {
+		bus := packagekit.NewBus()
+		if bus == nil {
+			return nil, fmt.Errorf("can't connect to PackageKit bus")
+		}
+		defer bus.Close()
+		if wasInstalled, err = bus.IsInstalled(obj.Name()); err != nil {
+			return nil, errwrap.Wrapf(err, "could not get the installed package for reversal")
+		}
+	}
+
+	if obj.State == PkgStateUninstalled {
+		if !wasInstalled {
+			return nil, nil // it wasn't installed, so there's nothing to restore
+		}
+		// We put back the exact version that was installed before, if
+		// we know it, and otherwise whatever version is available.
+		res.State = PkgStateInstalled
+		res.Version = version
+		return res, nil
+	}
+
+	if wasInstalled {
+		// It was installed before, so we leave it installed even if
+		// we're changing the version. We don't downgrade it afterwards.
+		return nil, nil
+	}
+
+	res.State = PkgStateUninstalled
+	res.Version = ""
+	if res.Hold != nil && *res.Hold { // can't hold what's uninstalled
+		res.Hold = nil
+	}
+
+	return res, nil
+}
+
 // PkgUID is the main UID struct for PkgRes.
 type PkgUID struct {
 	engine.BaseUID
@@ -619,7 +1005,7 @@ func (obj *PkgRes) UIDs() []engine.ResUID {
 	x := &PkgUID{
 		BaseUID: engine.BaseUID{Name: obj.Name(), Kind: obj.Kind()},
 		name:    obj.Name(),
-		state:   obj.State,
+		state:   obj.state(),
 	}
 	result := []engine.ResUID{x}
 
@@ -642,7 +1028,7 @@ func (obj *PkgRes) GroupCmp(r engine.GroupableRes) error {
 		return fmt.Errorf("resource is not the same kind")
 	}
 	// TODO: what should we do about the empty string?
-	if stateIsVersion(obj.State) || stateIsVersion(res.State) {
+	if stateIsVersion(obj.state()) || stateIsVersion(res.state()) {
 		// can't merge specific version checks atm
 		return fmt.Errorf("resource uses a version string")
 	}
@@ -650,6 +1036,17 @@ func (obj *PkgRes) GroupCmp(r engine.GroupableRes) error {
 	if obj.State != res.State {
 		return fmt.Errorf("resource is of a different state")
 	}
+	if (obj.Hold == nil) != (res.Hold == nil) || obj.Hold != nil && *obj.Hold != *res.Hold {
+		return fmt.Errorf("resource has a different hold")
+	}
+	if obj.Backend != res.Backend {
+		return fmt.Errorf("resource has a different backend")
+	}
+	// The reversal is only built and stored for the parent of a group, so
+	// the packages which were grouped into it would never be reversed.
+	if !obj.ReversibleMeta().Disabled || !res.ReversibleMeta().Disabled {
+		return fmt.Errorf("resource is reversible")
+	}
 	return nil
 }
 
@@ -0,0 +1,705 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package resources
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/traits"
+	engineUtil "github.com/purpleidea/mgmt/engine/util"
+	"github.com/purpleidea/mgmt/lang/funcs/vars"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util"
+	"github.com/purpleidea/mgmt/util/distro"
+	"github.com/purpleidea/mgmt/util/errwrap"
+	"github.com/purpleidea/mgmt/util/recwatch"
+)
+
+func init() {
+	engine.RegisterResource(KindPkgRepo, func() engine.Res { return &PkgRepoRes{} })
+
+	// const.res.pkg:repo.state.exists = "exists"
+	// const.res.pkg:repo.state.absent = "absent"
+	vars.RegisterResourceParams(KindPkgRepo, map[string]map[string]func() interfaces.Var{
+		ParamPkgRepoState: {
+			PkgRepoStateExists: func() interfaces.Var {
+				return &types.StrValue{
+					V: PkgRepoStateExists,
+				}
+			},
+			PkgRepoStateAbsent: func() interfaces.Var {
+				return &types.StrValue{
+					V: PkgRepoStateAbsent,
+				}
+			},
+		},
+	})
+}
+
+const (
+	// KindPkgRepo is the kind string used to identify this resource.
+	KindPkgRepo = "pkg:repo"
+
+	// ParamPkgRepoState is the name of the state field parameter.
+	ParamPkgRepoState = "state"
+
+	// PkgRepoStateExists is the string that represents that the repository
+	// should be set up.
+	PkgRepoStateExists = "exists"
+
+	// PkgRepoStateAbsent is the string that represents that the repository
+	// should not exist.
+	PkgRepoStateAbsent = "absent"
+
+	// pkgRepoUpdateMarker is the name of the file in the VarDir which exists
+	// while the package lists of the repository still need an update.
+	pkgRepoUpdateMarker = "update"
+
+	// pkgRepoHeader is the first line of the files that we write.
+	pkgRepoHeader = "# This file is managed by mgmt. Changes will be overwritten.\n"
+)
+
+var (
+	// pkgRepoNameRegexp is the set of names that are safe to use in paths.
+	pkgRepoNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+	// pkgRepoReservedOptions are the settings that we manage ourselves, and
+	// which can't be in the Options. They are lower case.
+	pkgRepoReservedOptions = map[string][]string{
+		distro.FamilyRedHat: {"name", "baseurl", "enabled", "gpgcheck", "gpgkey"},
+		distro.FamilyDebian: {"types", "uris", "suites", "components", "enabled", "signed-by"},
+	}
+)
+
+// PkgRepoRes is a package repository resource. It writes the repository
+// definition in the format of the distro family, along with the key that the
+// repository is signed with, so that the pkg resources can install from it. It
+// adds automatic edges to every pkg resource, so that the repositories are
+// always set up before any packages are installed. The name is used for the
+// names of the files, so it must be safe to use in a path. The redhat family
+// uses a file in /etc/yum.repos.d/ and the debian family uses a deb822 style
+// file in /etc/apt/sources.list.d/.
+type PkgRepoRes struct {
+	traits.Base // add the base methods without re-implementation
+	traits.Edgeable
+	traits.Reversible
+
+	init *engine.Init
+
+	// State is either exists or absent. The default is exists.
+	State string `lang:"state" yaml:"state"`
+
+	// Family is the distro family whose repository format is used. It can
+	// be redhat or debian. If it is empty, then it is detected.
+	Family string `lang:"family" yaml:"family"`
+
+	// Description is a human readable name for the repository. It's only
+	// used by the redhat family. If it is empty, then the name is used.
+	Description string `lang:"description" yaml:"description"`
+
+	// URL is the base URL of the repository. It is required unless the
+	// State is absent.
+	URL string `lang:"url" yaml:"url"`
+
+	// Suites is the list of suites, which are usually release codenames.
+	// It's only used by the debian family. If it is empty, then the
+	// codename of the running distro release is used.
+	Suites []string `lang:"suites" yaml:"suites"`
+
+	// Components is the list of components, such as main and contrib. It's
+	// only used by the debian family. If it is empty, then main is used.
+	Components []string `lang:"components" yaml:"components"`
+
+	// Enabled specifies if the repository is enabled. The default is true.
+	Enabled *bool `lang:"enabled" yaml:"enabled"`
+
+	// Key is the ASCII armored public PGP key that the repository is signed
+	// with. If it is specified, then it is written to a file, and the
+	// signatures are checked with only that key. If it is empty, then the
+	// signatures are checked as the package manager does by default, with
+	// any of the keys that it already trusts.
+	Key string `lang:"key" yaml:"key"`
+
+	// Options is a map of extra settings which are added to the repository
+	// definition. For the redhat family these are keys like priority, and
+	// for the debian family these are fields like Architectures. They
+	// can't be any of the settings that this resource already manages.
+	Options map[string]string `lang:"options" yaml:"options"`
+
+	root   string // path prefix for the files, which is used by the tests
+	family string // the family that was picked
+	suites []string
+
+	// update updates the package lists. It's nil if they aren't updated,
+	// and the tests can replace it.
+	update func(context.Context) error
+}
+
+// Default returns some sensible defaults for this resource.
+func (obj *PkgRepoRes) Default() engine.Res {
+	return &PkgRepoRes{
+		State: PkgRepoStateExists,
+	}
+}
+
+// Validate if the params passed in are valid data.
+func (obj *PkgRepoRes) Validate() error {
+	if !pkgRepoNameRegexp.MatchString(obj.Name()) {
+		return fmt.Errorf("the name must only contain letters, numbers, dots, dashes and underscores")
+	}
+	if obj.State != PkgRepoStateExists && obj.State != PkgRepoStateAbsent {
+		return fmt.Errorf("invalid state: %s", obj.State)
+	}
+	if obj.Family != "" {
+		if _, exists := pkgRepoReservedOptions[obj.Family]; !exists {
+			return fmt.Errorf("unsupported family: %s", obj.Family)
+		}
+	}
+	if obj.State == PkgRepoStateAbsent {
+		return nil // nothing else matters
+	}
+
+	if obj.URL == "" {
+		return fmt.Errorf("the URL can't be empty")
+	}
+	for _, x := range append(append([]string{obj.URL, obj.Description}, obj.Suites...), obj.Components...) {
+		if strings.ContainsAny(x, "\n") {
+			return fmt.Errorf("the value `%s` can't contain a newline", x)
+		}
+	}
+	if obj.Key != "" && !strings.Contains(obj.Key, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		return fmt.Errorf("the Key must be an ASCII armored public PGP key")
+	}
+
+	for key, value := range obj.Options {
+		if key == "" || strings.ContainsAny(key, " \t\n=:") {
+			return fmt.Errorf("invalid option name: `%s`", key)
+		}
+		if strings.ContainsAny(value, "\n") {
+			return fmt.Errorf("the option `%s` can't contain a newline", key)
+		}
+		for family, reserved := range pkgRepoReservedOptions {
+			if obj.Family != "" && family != obj.Family {
+				continue
+			}
+			if util.StrInList(strings.ToLower(key), reserved) {
+				return fmt.Errorf("the option `%s` is managed by the resource", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Init runs some startup code for this resource.
+func (obj *PkgRepoRes) Init(init *engine.Init) error {
+	obj.init = init // save for later
+
+	if err := obj.setFamily(context.TODO()); err != nil {
+		return err
+	}
+
+	if obj.family == distro.FamilyDebian && obj.root == "" && obj.update == nil {
+		obj.update = obj.aptUpdate
+	}
+
+	obj.suites = obj.Suites
+	if obj.family == distro.FamilyDebian && len(obj.suites) == 0 && obj.State != PkgRepoStateAbsent {
+		codename, err := distro.Codename(context.TODO())
+		if err != nil {
+			return errwrap.Wrapf(err, "could not get the distro codename")
+		}
+		if codename == "" {
+			return fmt.Errorf("the distro has no codename, so the Suites must be specified")
+		}
+		obj.suites = []string{codename}
+	}
+
+	return nil
+}
+
+// Cleanup is run by the engine to clean up after the resource is done.
+func (obj *PkgRepoRes) Cleanup() error {
+	return nil
+}
+
+// setFamily sets the distro family that we use, detecting it if it wasn't
+// specified. This is needed before we can know the paths of the files.
+func (obj *PkgRepoRes) setFamily(ctx context.Context) error {
+	obj.family = obj.Family
+	if obj.family == "" {
+		family, err := distro.Family(ctx)
+		if err != nil {
+			return errwrap.Wrapf(err, "could not detect the distro family")
+		}
+		obj.family = family
+	}
+	if _, exists := pkgRepoReservedOptions[obj.family]; !exists {
+		return fmt.Errorf("unsupported distro family: `%s`", obj.family)
+	}
+	return nil
+}
+
+// paths returns the path of the repository definition and the path of the key,
+// as they are seen by the package manager, without the root prefix.
+func (obj *PkgRepoRes) paths() (string, string) {
+	if obj.family == distro.FamilyDebian {
+		return "/etc/apt/sources.list.d/" + obj.Name() + ".sources", "/etc/apt/keyrings/" + obj.Name() + ".asc"
+	}
+	return "/etc/yum.repos.d/" + obj.Name() + ".repo", "/etc/pki/rpm-gpg/RPM-GPG-KEY-" + obj.Name()
+}
+
+// files returns a map of the paths of the files that we manage to what their
+// contents should be. A nil value means that the file should not exist. The
+// paths include the root prefix.
+func (obj *PkgRepoRes) files() map[string]*string {
+	repoPath, keyPath := obj.paths()
+	result := map[string]*string{
+		obj.root + repoPath: nil,
+		obj.root + keyPath:  nil,
+	}
+	if obj.State == PkgRepoStateAbsent {
+		return result
+	}
+
+	enabled := obj.Enabled == nil || *obj.Enabled
+	options := []string{}
+	for _, key := range util.StrMapKeys(obj.Options) { // sorted
+		options = append(options, key, obj.Options[key])
+	}
+
+	var b strings.Builder
+	b.WriteString(pkgRepoHeader)
+	if obj.family == distro.FamilyDebian {
+		yes := map[bool]string{true: "yes", false: "no"}
+		components := obj.Components
+		if len(components) == 0 {
+			components = []string{"main"}
+		}
+		fmt.Fprintf(&b, "Types: deb\n")
+		fmt.Fprintf(&b, "URIs: %s\n", obj.URL)
+		fmt.Fprintf(&b, "Suites: %s\n", strings.Join(obj.suites, " "))
+		fmt.Fprintf(&b, "Components: %s\n", strings.Join(components, " "))
+		fmt.Fprintf(&b, "Enabled: %s\n", yes[enabled])
+		if obj.Key != "" {
+			fmt.Fprintf(&b, "Signed-By: %s\n", keyPath)
+		}
+		for i := 0; i < len(options); i += 2 {
+			fmt.Fprintf(&b, "%s: %s\n", options[i], options[i+1])
+		}
+
+	} else { // redhat
+		one := map[bool]int{true: 1, false: 0}
+		description := obj.Description
+		if description == "" {
+			description = obj.Name()
+		}
+		fmt.Fprintf(&b, "[%s]\n", obj.Name())
+		fmt.Fprintf(&b, "name=%s\n", description)
+		fmt.Fprintf(&b, "baseurl=%s\n", obj.URL)
+		fmt.Fprintf(&b, "enabled=%d\n", one[enabled])
+		if obj.Key != "" { // otherwise gpgcheck is the dnf.conf default
+			fmt.Fprintf(&b, "gpgcheck=1\n")
+			fmt.Fprintf(&b, "gpgkey=file://%s\n", keyPath)
+		}
+		for i := 0; i < len(options); i += 2 {
+			fmt.Fprintf(&b, "%s=%s\n", options[i], options[i+1])
+		}
+	}
+	repo := b.String()
+	result[obj.root+repoPath] = &repo
+
+	if obj.Key != "" {
+		key := strings.TrimSpace(obj.Key) + "\n"
+		result[obj.root+keyPath] = &key
+	}
+	return result
+}
+
+// Watch is the primary listener for this resource and it outputs events.
+func (obj *PkgRepoRes) Watch(ctx context.Context) error {
+	chanList := []<-chan recwatch.Event{}
+	for p := range obj.files() {
+		recWatcher, err := recwatch.NewRecWatcher(p, false)
+		if err != nil {
+			return err
+		}
+		defer recWatcher.Close()
+		chanList = append(chanList, recWatcher.Events())
+	}
+	events := recwatch.MergeChannels(chanList...)
+
+	obj.init.Running() // when started, notify engine that we're running
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok { // channel shutdown
+				return fmt.Errorf("unexpected close")
+			}
+			if err := event.Error; err != nil {
+				return errwrap.Wrapf(err, "unknown %s watcher error", obj)
+			}
+			if obj.init.Debug { // don't access event.Body if event.Error isn't nil
+				obj.init.Logf("event(%s): %v", event.Body.Name, event.Body.Op)
+			}
+
+		case <-ctx.Done(): // closed by the engine to signal shutdown
+			return nil
+		}
+
+		obj.init.Event() // notify engine of an event (this can block)
+	}
+}
+
+// CheckApply method for the pkg:repo resource. It writes or removes the files.
+// For the debian family, it also updates the package lists of the repository.
+// A marker file in the VarDir records that the update is still pending, so if
+// it fails, then it's retried the next time, even though the files are correct.
+func (obj *PkgRepoRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
+	files := obj.files()
+	paths := []string{}
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths) // deterministic order
+
+	// apt needs the new lists before anything can be installed from it
+	update := obj.family == distro.FamilyDebian && obj.State == PkgRepoStateExists && obj.update != nil
+	marker := ""
+	pending := false
+	if update {
+		dir, err := obj.init.VarDir("")
+		if err != nil {
+			return false, errwrap.Wrapf(err, "could not get VarDir")
+		}
+		marker = path.Join(dir, pkgRepoUpdateMarker)
+		if _, err := os.Stat(marker); err == nil {
+			pending = true
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+
+	checkOK := !pending
+	if !checkOK && !apply {
+		return false, nil
+	}
+	for _, p := range paths {
+		content := files[p]
+		data, err := os.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		exists := err == nil
+		if content == nil && !exists || content != nil && exists && bytes.Equal(data, []byte(*content)) {
+			continue // the file is correct
+		}
+		checkOK = false
+		if !apply {
+			return false, nil
+		}
+		if update && !pending { // store this before we change anything
+			if err := os.WriteFile(marker, []byte{}, 0600); err != nil {
+				return false, errwrap.Wrapf(err, "could not write the update marker")
+			}
+			pending = true
+		}
+
+		if content == nil {
+			obj.init.Logf("removing: %s", p)
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return false, err
+			}
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			return false, err
+		}
+		obj.init.Logf("writing: %s", p)
+		if err := os.WriteFile(p, []byte(*content), 0644); err != nil {
+			return false, err
+		}
+	}
+	if checkOK {
+		return true, nil
+	}
+	if !pending {
+		return false, nil
+	}
+
+	if err := obj.update(ctx); err != nil {
+		return false, errwrap.Wrapf(err, "could not update the repository")
+	}
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return false, errwrap.Wrapf(err, "could not remove the update marker")
+	}
+
+	return false, nil
+}
+
+// aptUpdate updates the package lists of only this repository, which is much
+// faster than updating all of them.
+func (obj *PkgRepoRes) aptUpdate(ctx context.Context) error {
+	repoPath, _ := obj.paths()
+	args := []string{
+		"update", "-q",
+		"-o", "Dir::Etc::sourcelist=" + repoPath,
+		"-o", "Dir::Etc::sourceparts=-",
+		"-o", "APT::Get::List-Cleanup=0",
+	}
+	obj.init.Logf("running: apt-get %s", strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, "apt-get", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		obj.init.Logf("apt-get output: %s", out)
+		return err
+	}
+	return nil
+}
+
+// Cmp compares two resources and returns an error if they are not equivalent.
+func (obj *PkgRepoRes) Cmp(r engine.Res) error {
+	// we can only compare PkgRepoRes to others of the same resource kind
+	res, ok := r.(*PkgRepoRes)
+	if !ok {
+		return fmt.Errorf("not a %s", obj.Kind())
+	}
+
+	if obj.State != res.State {
+		return fmt.Errorf("the State differs")
+	}
+	if obj.Family != res.Family {
+		return fmt.Errorf("the Family differs")
+	}
+	if obj.Description != res.Description {
+		return fmt.Errorf("the Description differs")
+	}
+	if obj.URL != res.URL {
+		return fmt.Errorf("the URL differs")
+	}
+	if err := engineUtil.StrListCmp(obj.Suites, res.Suites); err != nil {
+		return errwrap.Wrapf(err, "the Suites differ")
+	}
+	if err := engineUtil.StrListCmp(obj.Components, res.Components); err != nil {
+		return errwrap.Wrapf(err, "the Components differ")
+	}
+	if (obj.Enabled == nil) != (res.Enabled == nil) { // xor
+		return fmt.Errorf("the Enabled differs")
+	}
+	if obj.Enabled != nil && *obj.Enabled != *res.Enabled {
+		return fmt.Errorf("the value of Enabled differs")
+	}
+	if obj.Key != res.Key {
+		return fmt.Errorf("the Key differs")
+	}
+	if len(obj.Options) != len(res.Options) {
+		return fmt.Errorf("the number of Options differs")
+	}
+	for key, value := range obj.Options {
+		if x, exists := res.Options[key]; !exists || x != value {
+			return fmt.Errorf("the Options key `%s` differs", key)
+		}
+	}
+
+	return nil
+}
+
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *PkgRepoRes) Copy() engine.CopyableRes {
+	var enabled *bool
+	if obj.Enabled != nil {
+		e := *obj.Enabled // copy
+		enabled = &e
+	}
+	var options map[string]string
+	if obj.Options != nil {
+		options = make(map[string]string)
+		for key, value := range obj.Options {
+			options[key] = value
+		}
+	}
+	return &PkgRepoRes{
+		State:       obj.State,
+		Family:      obj.Family,
+		Description: obj.Description,
+		URL:         obj.URL,
+		Suites:      append([]string(nil), obj.Suites...),
+		Components:  append([]string(nil), obj.Components...),
+		Enabled:     enabled,
+		Key:         obj.Key,
+		Options:     options,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. A repository
+// which we set up gets removed, but one that was already there is left alone.
+// We don't know what a removed one used to look like, so that stays removed.
+func (obj *PkgRepoRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*PkgRepoRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	if obj.State == PkgRepoStateAbsent {
+		return nil, nil // we can't put it back
+	}
+
+	// This runs before CheckApply (and Init) so we can see if the
+	// repository is already there. We only reverse what we're going to
+	// change so that we never remove a repository that was there before we
+	// ran, even if we change what it contains.
+	if err := obj.setFamily(context.TODO()); err != nil {
+		return nil, err
+	}
+	repoPath, _ := obj.paths()
+	if _, err := os.Stat(obj.root + repoPath); err == nil {
+		return nil, nil // it was there before us
+	} else if !os.IsNotExist(err) {
+		return nil, errwrap.Wrapf(err, "could not stat the repository for reversal")
+	}
+	res.State = PkgRepoStateAbsent
+
+	return res, nil
+}
+
+// PkgRepoUID is the UID struct for PkgRepoRes.
+type PkgRepoUID struct {
+	engine.BaseUID
+
+	name string
+}
+
+// pkgRepoPkgUID is a UID which matches any pkg resource. It's a MultiResUID so
+// that it gets an edge to every pkg resource that there is.
+type pkgRepoPkgUID struct {
+	engine.BaseUID
+}
+
+// IFF returns true if the UID is of a pkg resource.
+func (obj *pkgRepoPkgUID) IFF(uid engine.ResUID) bool {
+	_, ok := uid.(*PkgUID)
+	return ok
+}
+
+// Multi returns true, since we want to match every pkg resource.
+func (obj *pkgRepoPkgUID) Multi() bool {
+	return true
+}
+
+// PkgRepoResAutoEdges holds the state of the auto edge generator.
+type PkgRepoResAutoEdges struct {
+	uid *pkgRepoPkgUID
+}
+
+// Next returns the next automatic edge. The single UID matches all of the pkg
+// resources at once, so there's nothing more after that.
+func (obj *PkgRepoResAutoEdges) Next() []engine.ResUID {
+	return []engine.ResUID{obj.uid}
+}
+
+// Test gets results of the earlier Next() call, & returns if we should
+// continue! We're always done after the first one.
+func (obj *PkgRepoResAutoEdges) Test(input []bool) bool {
+	if len(input) != 1 { // in case we get given bad data
+		panic(fmt.Sprintf("Expecting a single value!"))
+	}
+	return false
+}
+
+// AutoEdges returns the AutoEdge interface. It adds an edge to every pkg
+// resource, so that the repositories are set up before any installs happen.
+// There are no edges when the repository is being removed.
+func (obj *PkgRepoRes) AutoEdges() (engine.AutoEdge, error) {
+	if obj.State == PkgRepoStateAbsent {
+		return nil, nil
+	}
+	var reversed = false // edges go from us to the pkg
+	return &PkgRepoResAutoEdges{
+		uid: &pkgRepoPkgUID{
+			BaseUID: engine.BaseUID{
+				Name:     obj.Name(),
+				Kind:     obj.Kind(),
+				Reversed: &reversed,
+			},
+		},
+	}, nil
+}
+
+// UIDs includes all params to make a unique identification of this object. Most
+// resources only return one, although some resources can return multiple.
+func (obj *PkgRepoRes) UIDs() []engine.ResUID {
+	x := &PkgRepoUID{
+		BaseUID: engine.BaseUID{Name: obj.Name(), Kind: obj.Kind()},
+		name:    obj.Name(),
+	}
+	return []engine.ResUID{x}
+}
+
+// UnmarshalYAML is the custom unmarshal handler for this struct. It is
+// primarily useful for setting the defaults.
+func (obj *PkgRepoRes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawRes PkgRepoRes // indirection to avoid infinite recursion
+
+	def := obj.Default()         // get the default
+	res, ok := def.(*PkgRepoRes) // put in the right format
+	if !ok {
+		return fmt.Errorf("could not convert to PkgRepoRes")
+	}
+	raw := rawRes(*res) // convert; the defaults go here
+
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*obj = PkgRepoRes(raw) // restore from indirection with type conversion!
+	return nil
+}
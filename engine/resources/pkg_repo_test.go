@@ -0,0 +1,434 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/graph/autoedge"
+	"github.com/purpleidea/mgmt/pgraph"
+)
+
+func TestPkgRepoFiles1(t *testing.T) {
+	key := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nabc\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	disabled := false
+
+	type test struct { // an individual test
+		name  string
+		res   *PkgRepoRes
+		files map[string]string // relative path -> content, "" is absent
+	}
+	testCases := []test{}
+
+	testCases = append(testCases, test{
+		name: "redhat",
+		res: &PkgRepoRes{
+			State:   PkgRepoStateExists,
+			Family:  "redhat",
+			URL:     "https://example.com/el/9/$basearch/",
+			Key:     key,
+			Options: map[string]string{"priority": "10", "module_hotfixes": "1"},
+		},
+		files: map[string]string{
+			"/etc/yum.repos.d/example.repo": pkgRepoHeader +
+				"[example]\n" +
+				"name=example\n" +
+				"baseurl=https://example.com/el/9/$basearch/\n" +
+				"enabled=1\n" +
+				"gpgcheck=1\n" +
+				"gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example\n" +
+				"module_hotfixes=1\n" +
+				"priority=10\n",
+			"/etc/pki/rpm-gpg/RPM-GPG-KEY-example": key,
+		},
+	})
+	testCases = append(testCases, test{
+		name: "debian",
+		res: &PkgRepoRes{
+			State:      PkgRepoStateExists,
+			Family:     "debian",
+			URL:        "https://example.com/debian",
+			Suites:     []string{"bookworm"},
+			Components: []string{"main", "contrib"},
+			Enabled:    &disabled,
+			Key:        key,
+			Options:    map[string]string{"Architectures": "amd64"},
+		},
+		files: map[string]string{
+			"/etc/apt/sources.list.d/example.sources": pkgRepoHeader +
+				"Types: deb\n" +
+				"URIs: https://example.com/debian\n" +
+				"Suites: bookworm\n" +
+				"Components: main contrib\n" +
+				"Enabled: no\n" +
+				"Signed-By: /etc/apt/keyrings/example.asc\n" +
+				"Architectures: amd64\n",
+			"/etc/apt/keyrings/example.asc": key,
+		},
+	})
+	testCases = append(testCases, test{
+		name: "redhat without key",
+		res: &PkgRepoRes{
+			State:       PkgRepoStateExists,
+			Family:      "redhat",
+			Description: "Example Repository",
+			URL:         "https://example.com/el/9/",
+		},
+		files: map[string]string{
+			"/etc/yum.repos.d/example.repo": pkgRepoHeader +
+				"[example]\n" +
+				"name=Example Repository\n" +
+				"baseurl=https://example.com/el/9/\n" +
+				"enabled=1\n",
+			"/etc/pki/rpm-gpg/RPM-GPG-KEY-example": "",
+		},
+	})
+	testCases = append(testCases, test{
+		name: "debian absent",
+		res: &PkgRepoRes{
+			State:  PkgRepoStateAbsent,
+			Family: "debian",
+		},
+		files: map[string]string{
+			"/etc/apt/sources.list.d/example.sources": "",
+			"/etc/apt/keyrings/example.asc":           "",
+		},
+	})
+
+	for index, tc := range testCases { // run all the tests
+		name, res, files := tc.name, tc.res, tc.files
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			res.SetKind(KindPkgRepo)
+			res.SetName("example")
+			res.root = t.TempDir()
+
+			// start with stale files so that everything gets fixed
+			for p := range files {
+				if err := os.MkdirAll(res.root+p[:strings.LastIndex(p, "/")], 0755); err != nil {
+					t.Errorf("mkdir failed with: %v", err)
+					return
+				}
+				if err := os.WriteFile(res.root+p, []byte("stale\n"), 0644); err != nil {
+					t.Errorf("write failed with: %v", err)
+					return
+				}
+			}
+
+			if err := res.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			if err := res.Init(&engine.Init{Logf: t.Logf}); err != nil {
+				t.Errorf("init failed with: %v", err)
+				return
+			}
+			if checkOK, err := res.CheckApply(context.Background(), true); err != nil || checkOK {
+				t.Errorf("expected a change, got: %t, %v", checkOK, err)
+				return
+			}
+			if checkOK, err := res.CheckApply(context.Background(), false); err != nil || !checkOK {
+				t.Errorf("expected no more changes, got: %t, %v", checkOK, err)
+				return
+			}
+
+			for p, expected := range files {
+				data, err := os.ReadFile(res.root + p)
+				if expected == "" {
+					if !os.IsNotExist(err) {
+						t.Errorf("file %s should not exist", p)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("could not read %s: %v", p, err)
+					continue
+				}
+				if s := string(data); s != expected {
+					t.Errorf("file %s differs", p)
+					t.Logf("actual:\n%s", s)
+					t.Logf("expected:\n%s", expected)
+				}
+			}
+		})
+	}
+}
+
+func TestPkgRepoUpdate1(t *testing.T) {
+	res := &PkgRepoRes{
+		State:  PkgRepoStateExists,
+		Family: "debian",
+		URL:    "https://example.com/debian",
+		Suites: []string{"bookworm"},
+	}
+	res.SetKind(KindPkgRepo)
+	res.SetName("example")
+	res.root = t.TempDir()
+
+	updates := 0
+	fail := true // the first update fails
+	res.update = func(ctx context.Context) error {
+		updates++
+		if fail {
+			return fmt.Errorf("update failed")
+		}
+		return nil
+	}
+
+	varDir := t.TempDir()
+	init := &engine.Init{
+		Logf:   t.Logf,
+		VarDir: func(string) (string, error) { return varDir, nil },
+	}
+	if err := res.Validate(); err != nil {
+		t.Errorf("validate failed with: %v", err)
+		return
+	}
+	if err := res.Init(init); err != nil {
+		t.Errorf("init failed with: %v", err)
+		return
+	}
+
+	if _, err := res.CheckApply(context.Background(), true); err == nil {
+		t.Errorf("expected the update to fail")
+		return
+	}
+	// the files are correct now, but the update must still happen
+	if checkOK, err := res.CheckApply(context.Background(), false); err != nil || checkOK {
+		t.Errorf("expected a pending update, got: %t, %v", checkOK, err)
+		return
+	}
+	fail = false
+	if checkOK, err := res.CheckApply(context.Background(), true); err != nil || checkOK {
+		t.Errorf("expected the update to run, got: %t, %v", checkOK, err)
+		return
+	}
+	if checkOK, err := res.CheckApply(context.Background(), true); err != nil || !checkOK {
+		t.Errorf("expected no more changes, got: %t, %v", checkOK, err)
+		return
+	}
+	if updates != 2 {
+		t.Errorf("expected 2 updates, got: %d", updates)
+	}
+}
+
+func TestPkgRepoValidate1(t *testing.T) {
+	key := "-----BEGIN PGP PUBLIC KEY BLOCK-----\nabc\n-----END PGP PUBLIC KEY BLOCK-----\n"
+
+	type test struct { // an individual test
+		name string
+		res  *PkgRepoRes
+		fail bool
+	}
+	testCases := []test{
+		{"ok", &PkgRepoRes{State: PkgRepoStateExists, URL: "https://example.com/"}, false},
+		{"no url", &PkgRepoRes{State: PkgRepoStateExists}, true},
+		{"absent without url", &PkgRepoRes{State: PkgRepoStateAbsent}, false},
+		{"bad state", &PkgRepoRes{State: "installed", URL: "https://example.com/"}, true},
+		{"bad family", &PkgRepoRes{State: PkgRepoStateExists, Family: "arch", URL: "https://example.com/"}, true},
+		{"ok key", &PkgRepoRes{State: PkgRepoStateExists, URL: "https://example.com/", Key: key}, false},
+		{"bad key", &PkgRepoRes{State: PkgRepoStateExists, URL: "https://example.com/", Key: "abc"}, true},
+		{"newline", &PkgRepoRes{State: PkgRepoStateExists, URL: "https://example.com/\nenabled=0"}, true},
+		{"ok option", &PkgRepoRes{State: PkgRepoStateExists, URL: "https://example.com/", Options: map[string]string{"priority": "10"}}, false},
+		{"managed option", &PkgRepoRes{State: PkgRepoStateExists, URL: "https://example.com/", Options: map[string]string{"GPGCheck": "0"}}, true},
+		{"managed option other family", &PkgRepoRes{State: PkgRepoStateExists, Family: "redhat", URL: "https://example.com/", Options: map[string]string{"Signed-By": "x"}}, false},
+		{"bad option", &PkgRepoRes{State: PkgRepoStateExists, URL: "https://example.com/", Options: map[string]string{"a=b": "c"}}, true},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, fail := tc.name, tc.res, tc.fail
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			res.SetKind(KindPkgRepo)
+			res.SetName("example")
+			err := res.Validate()
+			if !fail && err != nil {
+				t.Errorf("validate failed with: %v", err)
+			}
+			if fail && err == nil {
+				t.Errorf("validate passed, expected fail")
+			}
+		})
+	}
+}
+
+func TestPkgRepoReversal1(t *testing.T) {
+	type test struct { // an individual test
+		name   string
+		family string
+		state  string
+		file   string // the repo file that was there before, if any
+		exp    string // the reversed state, empty if there is nothing to reverse
+	}
+	testCases := []test{
+		{
+			name:   "add new",
+			family: "redhat",
+			state:  PkgRepoStateExists,
+			exp:    PkgRepoStateAbsent,
+		},
+		{
+			name:   "add existing",
+			family: "redhat",
+			state:  PkgRepoStateExists,
+			file:   "/etc/yum.repos.d/example.repo", // it was there before us
+			exp:    "",
+		},
+		{
+			name:   "add existing debian",
+			family: "debian",
+			state:  PkgRepoStateExists,
+			file:   "/etc/apt/sources.list.d/example.sources",
+			exp:    "",
+		},
+		{
+			name:   "add new debian",
+			family: "debian",
+			state:  PkgRepoStateExists,
+			file:   "/etc/yum.repos.d/example.repo", // not ours
+			exp:    PkgRepoStateAbsent,
+		},
+		{
+			name:   "remove existing",
+			family: "redhat",
+			state:  PkgRepoStateAbsent,
+			file:   "/etc/yum.repos.d/example.repo",
+			exp:    "", // we don't know what it looked like
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, family, state, file, exp := tc.name, tc.family, tc.state, tc.file, tc.exp
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			res := &PkgRepoRes{
+				State:  state,
+				Family: family,
+				URL:    "https://example.com/",
+				Suites: []string{"bookworm"},
+			}
+			res.SetKind(KindPkgRepo)
+			res.SetName("example")
+			res.root = t.TempDir()
+			if file != "" {
+				if err := os.MkdirAll(res.root+file[:strings.LastIndex(file, "/")], 0755); err != nil {
+					t.Errorf("mkdir failed with: %v", err)
+					return
+				}
+				if err := os.WriteFile(res.root+file, []byte("[example]\n"), 0644); err != nil {
+					t.Errorf("write failed with: %v", err)
+					return
+				}
+			}
+			if err := res.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			rev, err := res.Reversed()
+			if err != nil {
+				t.Errorf("reversed failed with: %v", err)
+				return
+			}
+			if exp == "" {
+				if rev != nil {
+					t.Errorf("expected no reversal, got: %+v", rev)
+				}
+				return
+			}
+			r, ok := rev.(*PkgRepoRes)
+			if !ok {
+				t.Errorf("expected a reversal, got: %+v", rev)
+				return
+			}
+			if r.State != exp {
+				t.Errorf("expected: %s, got: %s", exp, r.State)
+			}
+		})
+	}
+}
+
+func TestPkgRepoAutoEdge1(t *testing.T) {
+	g, err := pgraph.NewGraph("TestGraph")
+	if err != nil {
+		t.Errorf("error creating graph: %v", err)
+		return
+	}
+
+	resRepo, err := engine.NewNamedResource(KindPkgRepo, "example")
+	if err != nil {
+		t.Errorf("error creating pkg:repo resource: %v", err)
+		return
+	}
+	resRepo.(*PkgRepoRes).URL = "https://example.com/"
+
+	resPkgs := []engine.Res{}
+	for _, name := range []string{"foo", "bar", "baz"} {
+		resPkg, err := engine.NewNamedResource("pkg", name)
+		if err != nil {
+			t.Errorf("error creating pkg resource: %v", err)
+			return
+		}
+		resPkg.(*PkgRes).Backend = "fake"
+		resPkgs = append(resPkgs, resPkg)
+	}
+
+	g.AddVertex(resRepo, resPkgs[0], resPkgs[1], resPkgs[2])
+
+	debug := testing.Verbose() // set via the -test.v flag to `go test`
+	logf := func(format string, v ...interface{}) {
+		t.Logf("test: "+format, v...)
+	}
+	if err := autoedge.AutoEdge(g, debug, logf); err != nil {
+		t.Errorf("error running autoedges: %v", err)
+		return
+	}
+
+	expected, err := pgraph.NewGraph("Expected")
+	if err != nil {
+		t.Errorf("error creating graph: %v", err)
+		return
+	}
+	for _, resPkg := range resPkgs {
+		edge := &engine.Edge{Name: fmt.Sprintf("%s -> %s (expected)", resRepo, resPkg)}
+		expected.AddEdge(resRepo, resPkg, edge)
+	}
+
+	vertexCmp := func(v1, v2 pgraph.Vertex) (bool, error) { return v1 == v2, nil } // pointer compare is sufficient
+	edgeCmp := func(e1, e2 pgraph.Edge) (bool, error) { return true, nil }         // we don't care about edges here
+
+	if err := expected.GraphCmp(g, vertexCmp, edgeCmp); err != nil {
+		t.Errorf("graph doesn't match expected: %s", err)
+		return
+	}
+}
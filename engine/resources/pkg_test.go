@@ -32,7 +32,12 @@
 package resources
 
 import (
+	"context"
+	"fmt"
 	"testing"
+
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/resources/pkgbackend"
 )
 
 func TestNilList1(t *testing.T) {
@@ -45,3 +50,179 @@ func TestNilList1(t *testing.T) {
 		t.Errorf("list should have been empty, was: %+v", x)
 	}
 }
+
+// testFake is the package database of the fake backend. Each pkg resource in
+// the tests that uses the fake backend gets its own clone of it.
+var testFake = pkgbackend.NewFake()
+
+func init() {
+	// It's not registered in production builds, so we do it here.
+	pkgbackend.Register("fake", func() pkgbackend.Backend { return testFake.Clone() })
+}
+
+func TestPkgFake1(t *testing.T) {
+	fake := testFake
+	fake.Add("mgmt-test-foo", &pkgbackend.FakePackage{
+		Versions: []string{"1.0", "1.1", "2.0"},
+		Files:    []string{"/usr/bin/foo", "/usr/lib/systemd/system/foo.service"},
+	})
+
+	held := true
+	unheld := false
+	steps := []*PkgRes{
+		{State: PkgStateInstalled},
+		{State: PkgStateInstalled, Version: "1.1", Hold: &held},
+		{State: "1.0", Hold: &unheld}, // the older way to pin a version
+		{State: PkgStateNewest, Hold: &unheld},
+		{State: PkgStateUninstalled},
+	}
+	expect := []string{"2.0", "1.1", "1.0", "2.0", ""}
+
+	for i, res := range steps {
+		res.SetKind("pkg")
+		res.SetName("mgmt-test-foo")
+		res.Backend = "fake"
+		if err := res.Validate(); err != nil {
+			t.Errorf("step %d: validate failed with: %v", i, err)
+			return
+		}
+		if err := res.Init(&engine.Init{Logf: t.Logf}); err != nil {
+			t.Errorf("step %d: init failed with: %v", i, err)
+			return
+		}
+		if len(res.fileList) == 0 && i > 0 {
+			t.Errorf("step %d: expected a file list", i)
+		}
+
+		checkOK, err := res.CheckApply(context.Background(), true)
+		if err != nil {
+			t.Errorf("step %d: checkapply failed with: %v", i, err)
+			return
+		}
+		if checkOK {
+			t.Errorf("step %d: expected a change", i)
+		}
+		if checkOK, err := res.CheckApply(context.Background(), false); err != nil || !checkOK {
+			t.Errorf("step %d: expected no more changes, got: %t, %v", i, checkOK, err)
+		}
+
+		pkg := fake.Get("mgmt-test-foo")
+		if pkg.Installed != expect[i] {
+			t.Errorf("step %d: expected version: %s, got: %s", i, expect[i], pkg.Installed)
+		}
+		if res.Hold != nil && pkg.Held != *res.Hold {
+			t.Errorf("step %d: expected held: %t, got: %t", i, *res.Hold, pkg.Held)
+		}
+	}
+}
+
+func TestPkgReversal1(t *testing.T) {
+	fake := testFake
+	fake.Add("mgmt-test-new", &pkgbackend.FakePackage{
+		Versions: []string{"1.0", "2.0"},
+	})
+	fake.Add("mgmt-test-old", &pkgbackend.FakePackage{
+		Versions:  []string{"1.0", "2.0"},
+		Installed: "1.0",
+	})
+
+	type test struct { // an individual test
+		name  string
+		pkg   string
+		state string
+		exp   *PkgRes // nil means that there is nothing to reverse
+	}
+	testCases := []test{
+		{
+			name:  "install new",
+			pkg:   "mgmt-test-new",
+			state: PkgStateInstalled,
+			exp:   &PkgRes{State: PkgStateUninstalled},
+		},
+		{
+			name:  "install existing",
+			pkg:   "mgmt-test-old",
+			state: PkgStateInstalled,
+			exp:   nil, // it was there before us
+		},
+		{
+			name:  "upgrade existing",
+			pkg:   "mgmt-test-old",
+			state: PkgStateNewest,
+			exp:   nil, // we don't remove it or downgrade it
+		},
+		{
+			name:  "remove existing",
+			pkg:   "mgmt-test-old",
+			state: PkgStateUninstalled,
+			exp:   &PkgRes{State: PkgStateInstalled, Version: "1.0"},
+		},
+		{
+			name:  "remove missing",
+			pkg:   "mgmt-test-new",
+			state: PkgStateUninstalled,
+			exp:   nil, // it wasn't there before us
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, pkg, state, exp := tc.name, tc.pkg, tc.state, tc.exp
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			res := &PkgRes{State: state, Backend: "fake"}
+			res.SetKind("pkg")
+			res.SetName(pkg)
+			if err := res.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			rev, err := res.Reversed()
+			if err != nil {
+				t.Errorf("reversed failed with: %v", err)
+				return
+			}
+			if exp == nil {
+				if rev != nil {
+					t.Errorf("expected no reversal, got: %+v", rev)
+				}
+				return
+			}
+			r, ok := rev.(*PkgRes)
+			if !ok {
+				t.Errorf("expected a reversal, got: %+v", rev)
+				return
+			}
+			if r.State != exp.State || r.Version != exp.Version {
+				t.Errorf("expected: %s/%s, got: %s/%s", exp.State, exp.Version, r.State, r.Version)
+			}
+		})
+	}
+}
+
+func TestPkgValidate1(t *testing.T) {
+	held := true
+	for i, res := range []*PkgRes{
+		{State: PkgStateNewest, Version: "1.0"},
+		{State: PkgStateUninstalled, Hold: &held},
+		{State: PkgStateInstalled, Backend: "nope"},
+		{State: PkgStateInstalled, Backend: PkgBackendPackageKit, Hold: &held},
+	} {
+		if err := res.Validate(); err == nil {
+			t.Errorf("test #%d: expected validate error, got nil", i)
+		}
+	}
+}
+
+func TestPkgGroupCmp1(t *testing.T) {
+	res1 := &PkgRes{State: PkgStateInstalled}
+	res2 := &PkgRes{State: PkgStateInstalled}
+	if err := res1.GroupCmp(res2); err != nil {
+		t.Errorf("expected the packages to group, got: %v", err)
+	}
+	res2.ReversibleMeta().Disabled = false
+	if err := res1.GroupCmp(res2); err == nil {
+		t.Errorf("expected a reversible package not to group")
+	}
+	if err := res2.GroupCmp(res1); err == nil {
+		t.Errorf("expected nothing to group into a reversible package")
+	}
+}
@@ -0,0 +1,202 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package pkgbackend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+func init() {
+	Register("apk", func() Backend { return &Apk{} })
+}
+
+// ApkWorldFile is the file where apk stores the packages that were asked for,
+// along with any version constraints.
+const ApkWorldFile = "/etc/apk/world"
+
+// Apk is the backend for the apk package manager, as used by Alpine Linux. A
+// hold is a version constraint in the world file. Since apk installs a pinned
+// version by adding this constraint, a pinned version is always held too.
+type Apk struct {
+	base
+}
+
+// Installed returns a map of the installed version of each package.
+func (obj *Apk) Installed(ctx context.Context, names []string) (map[string]string, error) {
+	out, err := obj.run(ctx, nil, "apk", append([]string{"list", "--installed"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseApkList(out, names), nil
+}
+
+// Available returns a map of the available version of each package.
+func (obj *Apk) Available(ctx context.Context, names []string) (map[string]string, error) {
+	out, err := obj.run(ctx, nil, "apk", append([]string{"list", "--available"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseApkList(out, names), nil
+}
+
+// Install installs or changes each package to the version that it maps to.
+func (obj *Apk) Install(ctx context.Context, packages map[string]string) error {
+	args := []string{"add", "--no-progress"}
+	for _, name := range sortedKeys(packages) {
+		if v := packages[name]; v != "" {
+			name = name + "=" + v
+		}
+		args = append(args, name)
+	}
+	_, err := obj.run(ctx, nil, "apk", args...)
+	return err
+}
+
+// Upgrade installs or upgrades the packages to the newest version.
+func (obj *Apk) Upgrade(ctx context.Context, names []string) error {
+	_, err := obj.run(ctx, nil, "apk", append([]string{"add", "--no-progress", "--upgrade"}, names...)...)
+	return err
+}
+
+// Remove uninstalls the packages.
+func (obj *Apk) Remove(ctx context.Context, names []string) error {
+	_, err := obj.run(ctx, nil, "apk", append([]string{"del", "--no-progress"}, names...)...)
+	return err
+}
+
+// Held returns a map of whether each package has a version constraint in the
+// world file.
+func (obj *Apk) Held(ctx context.Context, names []string) (map[string]bool, error) {
+	b, err := os.ReadFile(ApkWorldFile)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not read the world file")
+	}
+	return parseApkWorld(string(b), names), nil
+}
+
+// Hold pins the packages to their installed versions in the world file, or it
+// removes the constraint if hold is false.
+func (obj *Apk) Hold(ctx context.Context, names []string, hold bool) error {
+	args := []string{"add", "--no-progress"}
+	if !hold {
+		_, err := obj.run(ctx, nil, "apk", append(args, names...)...)
+		return err
+	}
+	installed, err := obj.Installed(ctx, names)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		v := installed[name]
+		if v == "" {
+			return fmt.Errorf("can't hold package %s which isn't installed", name)
+		}
+		args = append(args, name+"="+v)
+	}
+	_, err = obj.run(ctx, nil, "apk", args...)
+	return err
+}
+
+// Files returns the list of files in an installed package.
+func (obj *Apk) Files(ctx context.Context, name string) ([]string, error) {
+	out, _, code, err := obj.runCode(ctx, nil, "apk", "info", "--contents", name)
+	if err != nil || code != 0 { // it's not installed
+		return nil, err
+	}
+	result := []string{}
+	for _, line := range lines(out) {
+		if strings.HasSuffix(line, " contains:") { // header
+			continue
+		}
+		result = append(result, "/"+strings.TrimSpace(line)) // relative
+	}
+	return result, nil
+}
+
+// WatchPaths returns the apk installed database and the world file.
+func (obj *Apk) WatchPaths() []string {
+	return []string{"/lib/apk/db/installed", ApkWorldFile}
+}
+
+// splitApkPackage splits an apk package string like foo-bar-1.2.3-r0 into the
+// name and the version, which has the package release at the end.
+func splitApkPackage(s string) (string, string, bool) {
+	i := strings.LastIndex(s, "-r")
+	if i <= 0 {
+		return "", "", false
+	}
+	j := strings.LastIndex(s[:i], "-")
+	if j <= 0 {
+		return "", "", false
+	}
+	return s[:j], s[j+1:], true
+}
+
+// parseApkList parses the output of apk list. The lines look like this:
+// busybox-1.36.1-r15 x86_64 {busybox} (GPL-2.0-only) [installed]
+func parseApkList(out string, names []string) map[string]string {
+	result := mapDefault[string](names)
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !isMyArch(fields[1]) && fields[1] != "noarch" {
+			continue
+		}
+		name, version, ok := splitApkPackage(fields[0])
+		if !ok {
+			continue
+		}
+		if v, exists := result[name]; !exists || v != "" { // keep the first
+			continue
+		}
+		result[name] = version
+	}
+	return result
+}
+
+// parseApkWorld parses the world file, which is a list of package names that
+// might have a version constraint after an operator like `=`, `~` or `<`.
+func parseApkWorld(data string, names []string) map[string]bool {
+	result := mapDefault[bool](names)
+	for _, entry := range strings.Fields(data) {
+		i := strings.IndexAny(entry, "=~<>")
+		if i <= 0 {
+			continue
+		}
+		if _, exists := result[entry[:i]]; exists {
+			result[entry[:i]] = true
+		}
+	}
+	return result
+}
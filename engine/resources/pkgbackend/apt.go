@@ -0,0 +1,202 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package pkgbackend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	Register("apt", func() Backend { return &Apt{} })
+}
+
+// aptEnv is the environment that prevents apt from asking any questions.
+var aptEnv = []string{"DEBIAN_FRONTEND=noninteractive"}
+
+// Apt is the backend for the apt package manager, as used by Debian and the
+// other Debian family distros. It uses dpkg to query the installed packages.
+type Apt struct {
+	base
+}
+
+// Installed returns a map of the installed version of each package.
+func (obj *Apt) Installed(ctx context.Context, names []string) (map[string]string, error) {
+	args := []string{"-W", "-f", "${Package} ${Architecture} ${db:Status-Abbrev} ${Version}\n"}
+	// dpkg-query exits non-zero if any package is unknown
+	out, stderr, code, err := obj.runCode(ctx, nil, "dpkg-query", append(args, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDpkgQuery(code, stderr); err != nil {
+		return nil, err
+	}
+	return parseDpkgQuery(out, names), nil
+}
+
+// checkDpkgQuery returns an error unless dpkg-query succeeded, or it only failed
+// because some packages are unknown. It exits with one for those, and with two
+// for the fatal errors, such as a broken database, which must never look like
+// the packages aren't installed.
+func checkDpkgQuery(code int, stderr string) error {
+	if code == 0 {
+		return nil
+	}
+	msgs := lines(stderr)
+	unknown := code == 1 && len(msgs) > 0
+	for _, line := range msgs {
+		if !strings.Contains(line, "no packages found matching") {
+			unknown = false
+		}
+	}
+	if unknown {
+		return nil
+	}
+	return fmt.Errorf("dpkg-query exited with: %d: %s", code, strings.TrimSpace(stderr))
+}
+
+// Available returns a map of the candidate version of each package.
+func (obj *Apt) Available(ctx context.Context, names []string) (map[string]string, error) {
+	out, err := obj.run(ctx, nil, "apt-cache", append([]string{"policy"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseAptPolicy(out, names), nil
+}
+
+// Install installs or changes each package to the version that it maps to.
+func (obj *Apt) Install(ctx context.Context, packages map[string]string) error {
+	args := []string{"-y", "-q", "--allow-downgrades", "install"}
+	for _, name := range sortedKeys(packages) {
+		if v := packages[name]; v != "" {
+			name = name + "=" + v
+		}
+		args = append(args, name)
+	}
+	_, err := obj.run(ctx, aptEnv, "apt-get", args...)
+	return err
+}
+
+// Upgrade installs or upgrades the packages to the candidate version.
+func (obj *Apt) Upgrade(ctx context.Context, names []string) error {
+	_, err := obj.run(ctx, aptEnv, "apt-get", append([]string{"-y", "-q", "install"}, names...)...)
+	return err
+}
+
+// Remove uninstalls the packages.
+func (obj *Apt) Remove(ctx context.Context, names []string) error {
+	_, err := obj.run(ctx, aptEnv, "apt-get", append([]string{"-y", "-q", "remove"}, names...)...)
+	return err
+}
+
+// Held returns a map of whether each package is held by apt-mark.
+func (obj *Apt) Held(ctx context.Context, names []string) (map[string]bool, error) {
+	out, err := obj.run(ctx, nil, "apt-mark", "showhold")
+	if err != nil {
+		return nil, err
+	}
+	result := mapDefault[bool](names)
+	for _, line := range lines(out) {
+		if _, exists := result[strings.TrimSpace(line)]; exists {
+			result[strings.TrimSpace(line)] = true
+		}
+	}
+	return result, nil
+}
+
+// Hold marks or unmarks the packages as held with apt-mark.
+func (obj *Apt) Hold(ctx context.Context, names []string, hold bool) error {
+	cmd := "unhold"
+	if hold {
+		cmd = "hold"
+	}
+	_, err := obj.run(ctx, nil, "apt-mark", append([]string{cmd}, names...)...)
+	return err
+}
+
+// Files returns the list of files in an installed package.
+func (obj *Apt) Files(ctx context.Context, name string) ([]string, error) {
+	out, _, code, err := obj.runCode(ctx, nil, "dpkg", "-L", name)
+	if err != nil || code != 0 { // it's not installed
+		return nil, err
+	}
+	return parseFileList(out), nil
+}
+
+// WatchPaths returns the dpkg status database.
+func (obj *Apt) WatchPaths() []string {
+	return []string{"/var/lib/dpkg/status"}
+}
+
+// parseDpkgQuery parses the output of dpkg-query with our format. The status
+// abbreviation has the desired action, and then the current status, where "i"
+// means that it is installed.
+func parseDpkgQuery(out string, names []string) map[string]string {
+	result := mapDefault[string](names)
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) != 4 || !isMyArch(fields[1]) {
+			continue
+		}
+		if _, exists := result[fields[0]]; !exists {
+			continue
+		}
+		if status := fields[2]; len(status) < 2 || status[1] != 'i' {
+			continue
+		}
+		result[fields[0]] = fields[3]
+	}
+	return result
+}
+
+// parseAptPolicy parses the output of apt-cache policy, which has a header for
+// each package that was found, followed by the indented details.
+func parseAptPolicy(out string, names []string) map[string]string {
+	result := mapDefault[string](names)
+	name := ""
+	for _, line := range lines(out) {
+		if !strings.HasPrefix(line, " ") { // header
+			name = strings.TrimSuffix(line, ":")
+			continue
+		}
+		s := strings.TrimPrefix(strings.TrimSpace(line), "Candidate:")
+		if s == strings.TrimSpace(line) {
+			continue
+		}
+		if _, exists := result[name]; !exists {
+			continue
+		}
+		if v := strings.TrimSpace(s); v != "(none)" {
+			result[name] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,231 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package pkgbackend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	Register("dnf", func() Backend { return &Dnf{} })
+}
+
+// dnfVersionlockRegexp matches the name in the dnf4 versionlock list output,
+// which looks like: bash-0:5.2.15-5.fc39.*
+var dnfVersionlockRegexp = regexp.MustCompile(`^(.+)-[0-9]+:`)
+
+// rpmNotInstalledRegexp matches the line that rpm prints for each package that
+// isn't installed.
+var rpmNotInstalledRegexp = regexp.MustCompile(`^package \S+ is not installed$`)
+
+// Dnf is the backend for the dnf package manager, as used by Fedora and the
+// other RedHat family distros. It uses rpm to query the installed packages. The
+// versions are in the version-release form, without the epoch. Holds use the
+// versionlock plugin, which must be installed.
+type Dnf struct {
+	base
+}
+
+// Installed returns a map of the installed version of each package.
+func (obj *Dnf) Installed(ctx context.Context, names []string) (map[string]string, error) {
+	args := []string{"-q", "--queryformat", "%{NAME} %{ARCH} %{VERSION}-%{RELEASE}\n"}
+	// rpm exits non-zero if any package isn't installed
+	out, stderr, code, err := obj.runCode(ctx, nil, "rpm", append(args, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRpmQuery(out, code, stderr); err != nil {
+		return nil, err
+	}
+	return parseNameArchVersion(out, names), nil
+}
+
+// checkRpmQuery returns an error unless rpm succeeded, or it only failed because
+// some packages aren't installed. It says so on stdout for each of them, while
+// the other errors, such as a locked or broken database, go to stderr, and they
+// must never look like the packages aren't installed.
+func checkRpmQuery(out string, code int, stderr string) error {
+	if code == 0 {
+		return nil
+	}
+	missing := false
+	for _, line := range lines(out) {
+		if rpmNotInstalledRegexp.MatchString(line) {
+			missing = true
+		}
+	}
+	if missing && strings.TrimSpace(stderr) == "" {
+		return nil
+	}
+	return fmt.Errorf("rpm exited with: %d: %s", code, strings.TrimSpace(stderr))
+}
+
+// Available returns a map of the newest available version of each package.
+func (obj *Dnf) Available(ctx context.Context, names []string) (map[string]string, error) {
+	args := []string{"--quiet", "repoquery", "--latest-limit=1", "--queryformat", "%{name} %{arch} %{version}-%{release}\n"}
+	out, err := obj.run(ctx, nil, "dnf", append(args, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseNameArchVersion(out, names), nil
+}
+
+// Install installs or changes each package to the version that it maps to.
+func (obj *Dnf) Install(ctx context.Context, packages map[string]string) error {
+	args := []string{"-y", "install"}
+	for _, name := range sortedKeys(packages) {
+		if v := packages[name]; v != "" {
+			name = name + "-" + v
+		}
+		args = append(args, name)
+	}
+	_, err := obj.run(ctx, nil, "dnf", args...)
+	return err
+}
+
+// Upgrade installs or upgrades the packages to the newest version. The packages
+// which aren't installed yet need to be installed separately.
+func (obj *Dnf) Upgrade(ctx context.Context, names []string) error {
+	installed, err := obj.Installed(ctx, names)
+	if err != nil {
+		return err
+	}
+	missing, present := []string{}, []string{}
+	for _, name := range names {
+		if installed[name] == "" {
+			missing = append(missing, name)
+		} else {
+			present = append(present, name)
+		}
+	}
+	if len(missing) > 0 {
+		if _, err := obj.run(ctx, nil, "dnf", append([]string{"-y", "install"}, missing...)...); err != nil {
+			return err
+		}
+	}
+	if len(present) > 0 {
+		if _, err := obj.run(ctx, nil, "dnf", append([]string{"-y", "upgrade"}, present...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove uninstalls the packages.
+func (obj *Dnf) Remove(ctx context.Context, names []string) error {
+	_, err := obj.run(ctx, nil, "dnf", append([]string{"-y", "remove"}, names...)...)
+	return err
+}
+
+// Held returns a map of whether each package is held by a versionlock.
+func (obj *Dnf) Held(ctx context.Context, names []string) (map[string]bool, error) {
+	out, err := obj.run(ctx, nil, "dnf", "--quiet", "versionlock", "list")
+	if err != nil {
+		return nil, err
+	}
+	return parseDnfVersionlock(out, names), nil
+}
+
+// Hold adds or deletes a versionlock for the packages.
+func (obj *Dnf) Hold(ctx context.Context, names []string, hold bool) error {
+	cmd := "delete"
+	if hold {
+		cmd = "add"
+	}
+	_, err := obj.run(ctx, nil, "dnf", append([]string{"-y", "versionlock", cmd}, names...)...)
+	return err
+}
+
+// Files returns the list of files in an installed package.
+func (obj *Dnf) Files(ctx context.Context, name string) ([]string, error) {
+	out, _, code, err := obj.runCode(ctx, nil, "rpm", "-ql", name)
+	if err != nil || code != 0 { // it's not installed
+		return nil, err
+	}
+	return parseFileList(out), nil
+}
+
+// WatchPaths returns the rpm database paths, which have moved over time.
+func (obj *Dnf) WatchPaths() []string {
+	return []string{"/var/lib/rpm/", "/usr/lib/sysimage/rpm/"}
+}
+
+// parseNameArchVersion parses lines of name, arch and version as printed by a
+// query format. Lines for other arches or packages are skipped, so that errors
+// which are printed inline are too.
+func parseNameArchVersion(out string, names []string) map[string]string {
+	result := mapDefault[string](names)
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !isMyArch(fields[1]) {
+			continue
+		}
+		if _, exists := result[fields[0]]; !exists {
+			continue
+		}
+		result[fields[0]] = fields[2]
+	}
+	return result
+}
+
+// parseDnfVersionlock parses the output of the versionlock list command. Both
+// the dnf4 and the dnf5 formats are supported.
+func parseDnfVersionlock(out string, names []string) map[string]bool {
+	result := mapDefault[bool](names)
+	for _, line := range lines(out) {
+		line = strings.TrimSpace(line)
+		name := ""
+		if s := strings.TrimPrefix(line, "Package name:"); s != line { // dnf5
+			name = strings.TrimSpace(s)
+		} else if m := dnfVersionlockRegexp.FindStringSubmatch(line); m != nil { // dnf4
+			name = m[1]
+		}
+		if _, exists := result[name]; exists {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// parseFileList parses a list of absolute paths, one per line, and skips other
+// lines, such as the root directory or any warnings.
+func parseFileList(out string) []string {
+	result := []string{}
+	for _, line := range lines(out) {
+		if !strings.HasPrefix(line, "/") || line == "/." {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
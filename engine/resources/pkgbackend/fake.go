@@ -0,0 +1,267 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package pkgbackend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakePackage is the state of a single package in the fake backend.
+type FakePackage struct {
+	// Versions is the list of versions which are available to install,
+	// from oldest to newest.
+	Versions []string
+
+	// Installed is the installed version. It is empty if the package isn't
+	// installed.
+	Installed string
+
+	// Held is true if the package is held.
+	Held bool
+
+	// Files is the list of files in the package.
+	Files []string
+}
+
+// Fake is a backend which only pretends to manage packages. It's useful to test
+// the pkg resource without a package manager. Like the real ones, it refuses to
+// change a package which is held. It isn't registered, so that it can't be used
+// in production. Tests can Register it under a name of their choosing.
+type Fake struct {
+	init *Init
+
+	mutex    *sync.Mutex
+	packages map[string]*FakePackage
+}
+
+// NewFake returns a new fake backend with no packages.
+func NewFake() *Fake {
+	return &Fake{
+		mutex:    &sync.Mutex{},
+		packages: make(map[string]*FakePackage),
+	}
+}
+
+// Clone returns a new fake backend which shares the package database with this
+// one, so that they act like they're on the same system. It has its own Init,
+// so each user of a clone keeps its own logger.
+func (obj *Fake) Clone() *Fake {
+	return &Fake{
+		mutex:    obj.mutex,
+		packages: obj.packages,
+	}
+}
+
+// Add adds a package to the fake backend, replacing any existing one with the
+// same name.
+func (obj *Fake) Add(name string, pkg *FakePackage) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	obj.packages[name] = pkg
+}
+
+// Get returns a copy of the state of the package, or nil if it doesn't exist.
+func (obj *Fake) Get(name string) *FakePackage {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	pkg, exists := obj.packages[name]
+	if !exists {
+		return nil
+	}
+	cp := *pkg
+	return &cp
+}
+
+// Init stores the init values for later.
+func (obj *Fake) Init(init *Init) error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	obj.init = init
+	return nil
+}
+
+// Installed returns a map of the installed version of each package.
+func (obj *Fake) Installed(ctx context.Context, names []string) (map[string]string, error) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	result := mapDefault[string](names)
+	for _, name := range names {
+		if pkg, exists := obj.packages[name]; exists {
+			result[name] = pkg.Installed
+		}
+	}
+	return result, nil
+}
+
+// Available returns a map of the newest version of each package.
+func (obj *Fake) Available(ctx context.Context, names []string) (map[string]string, error) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	result := mapDefault[string](names)
+	for _, name := range names {
+		if pkg, exists := obj.packages[name]; exists && len(pkg.Versions) > 0 {
+			result[name] = pkg.Versions[len(pkg.Versions)-1]
+		}
+	}
+	return result, nil
+}
+
+// Install installs or changes each package to the version that it maps to.
+func (obj *Fake) Install(ctx context.Context, packages map[string]string) error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	// check everything first, so that it's all or nothing
+	for _, name := range sortedKeys(packages) {
+		pkg, exists := obj.packages[name]
+		if !exists || len(pkg.Versions) == 0 {
+			return fmt.Errorf("no package named: %s", name)
+		}
+		v := packages[name]
+		if v == "" {
+			continue
+		}
+		found := false
+		for _, x := range pkg.Versions {
+			found = found || x == v
+		}
+		if !found {
+			return fmt.Errorf("version %s of package %s isn't available", v, name)
+		}
+		if pkg.Held && pkg.Installed != "" && pkg.Installed != v {
+			return fmt.Errorf("package %s is held", name)
+		}
+	}
+	for _, name := range sortedKeys(packages) {
+		pkg := obj.packages[name]
+		if v := packages[name]; v != "" {
+			pkg.Installed = v
+		} else if pkg.Installed == "" {
+			pkg.Installed = pkg.Versions[len(pkg.Versions)-1]
+		}
+		obj.logf("installed: %s %s", name, pkg.Installed)
+	}
+	return nil
+}
+
+// Upgrade installs or upgrades the packages to the newest version.
+func (obj *Fake) Upgrade(ctx context.Context, names []string) error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	for _, name := range names {
+		pkg, exists := obj.packages[name]
+		if !exists || len(pkg.Versions) == 0 {
+			return fmt.Errorf("no package named: %s", name)
+		}
+		newest := pkg.Versions[len(pkg.Versions)-1]
+		if pkg.Held && pkg.Installed != "" && pkg.Installed != newest {
+			return fmt.Errorf("package %s is held", name)
+		}
+	}
+	for _, name := range names {
+		pkg := obj.packages[name]
+		pkg.Installed = pkg.Versions[len(pkg.Versions)-1]
+		obj.logf("upgraded: %s %s", name, pkg.Installed)
+	}
+	return nil
+}
+
+// Remove uninstalls the packages.
+func (obj *Fake) Remove(ctx context.Context, names []string) error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	for _, name := range names {
+		if pkg, exists := obj.packages[name]; exists && pkg.Held {
+			return fmt.Errorf("package %s is held", name)
+		}
+	}
+	for _, name := range names {
+		if pkg, exists := obj.packages[name]; exists {
+			pkg.Installed = ""
+			obj.logf("removed: %s", name)
+		}
+	}
+	return nil
+}
+
+// Held returns a map of whether each package is held.
+func (obj *Fake) Held(ctx context.Context, names []string) (map[string]bool, error) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	result := mapDefault[bool](names)
+	for _, name := range names {
+		if pkg, exists := obj.packages[name]; exists {
+			result[name] = pkg.Held
+		}
+	}
+	return result, nil
+}
+
+// Hold holds or releases the packages.
+func (obj *Fake) Hold(ctx context.Context, names []string, hold bool) error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	for _, name := range names {
+		pkg, exists := obj.packages[name]
+		if !exists || pkg.Installed == "" {
+			return fmt.Errorf("package %s isn't installed", name)
+		}
+	}
+	for _, name := range names {
+		obj.packages[name].Held = hold
+		obj.logf("held(%t): %s", hold, name)
+	}
+	return nil
+}
+
+// Files returns the list of files in an installed package.
+func (obj *Fake) Files(ctx context.Context, name string) ([]string, error) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	pkg, exists := obj.packages[name]
+	if !exists || pkg.Installed == "" {
+		return nil, nil
+	}
+	return append([]string{}, pkg.Files...), nil
+}
+
+// WatchPaths returns nothing, since there are no files to watch.
+func (obj *Fake) WatchPaths() []string {
+	return nil
+}
+
+// logf logs if we've been initialized. The mutex must be held.
+func (obj *Fake) logf(format string, v ...interface{}) {
+	if obj.init == nil || obj.init.Logf == nil {
+		return
+	}
+	obj.init.Logf(format, v...)
+}
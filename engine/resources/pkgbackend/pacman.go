@@ -0,0 +1,187 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package pkgbackend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+func init() {
+	Register("pacman", func() Backend { return &Pacman{} })
+}
+
+// PacmanConfFile is the pacman config file which has the IgnorePkg setting.
+const PacmanConfFile = "/etc/pacman.conf"
+
+// Pacman is the backend for the pacman package manager, as used by ArchLinux.
+// Since the repositories only have the newest version of each package, only
+// that one can be pinned. A hold is an IgnorePkg entry in the config file. They
+// are detected, but they must be managed in that file directly.
+type Pacman struct {
+	base
+}
+
+// Installed returns a map of the installed version of each package.
+func (obj *Pacman) Installed(ctx context.Context, names []string) (map[string]string, error) {
+	// pacman exits non-zero if any package isn't installed
+	out, _, _, err := obj.runCode(ctx, nil, "pacman", append([]string{"-Q"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	result := mapDefault[string](names)
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if _, exists := result[fields[0]]; exists {
+			result[fields[0]] = fields[1]
+		}
+	}
+	return result, nil
+}
+
+// Available returns a map of the version of each package in the repositories.
+func (obj *Pacman) Available(ctx context.Context, names []string) (map[string]string, error) {
+	// pacman exits non-zero if any package isn't found
+	out, _, _, err := obj.runCode(ctx, nil, "pacman", append([]string{"-Si"}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parsePacmanInfo(out, names), nil
+}
+
+// Install installs each package. Since only the newest version is available,
+// a pinned version must be that one.
+func (obj *Pacman) Install(ctx context.Context, packages map[string]string) error {
+	available, err := obj.Available(ctx, sortedKeys(packages))
+	if err != nil {
+		return err
+	}
+	args := []string{"-S", "--noconfirm", "--needed"}
+	for _, name := range sortedKeys(packages) {
+		if v := packages[name]; v != "" && v != available[name] {
+			return fmt.Errorf("version %s of package %s isn't available", v, name)
+		}
+		args = append(args, name)
+	}
+	_, err = obj.run(ctx, nil, "pacman", args...)
+	return err
+}
+
+// Upgrade installs or upgrades the packages to the newest version.
+func (obj *Pacman) Upgrade(ctx context.Context, names []string) error {
+	_, err := obj.run(ctx, nil, "pacman", append([]string{"-S", "--noconfirm", "--needed"}, names...)...)
+	return err
+}
+
+// Remove uninstalls the packages.
+func (obj *Pacman) Remove(ctx context.Context, names []string) error {
+	_, err := obj.run(ctx, nil, "pacman", append([]string{"-R", "--noconfirm"}, names...)...)
+	return err
+}
+
+// Held returns a map of whether each package is in an IgnorePkg setting.
+func (obj *Pacman) Held(ctx context.Context, names []string) (map[string]bool, error) {
+	b, err := os.ReadFile(PacmanConfFile)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not read the pacman config")
+	}
+	return parsePacmanIgnorePkg(string(b), names), nil
+}
+
+// Hold isn't supported, since it needs an edit to the pacman config file.
+func (obj *Pacman) Hold(ctx context.Context, names []string, hold bool) error {
+	return fmt.Errorf("the pacman backend can't change holds, edit IgnorePkg in %s instead", PacmanConfFile)
+}
+
+// Files returns the list of files in an installed package.
+func (obj *Pacman) Files(ctx context.Context, name string) ([]string, error) {
+	out, _, code, err := obj.runCode(ctx, nil, "pacman", "-Ql", name)
+	if err != nil || code != 0 { // it's not installed
+		return nil, err
+	}
+	result := []string{}
+	for _, line := range lines(out) {
+		if s := strings.TrimPrefix(line, name+" "); s != line {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// WatchPaths returns the pacman local database.
+func (obj *Pacman) WatchPaths() []string {
+	return []string{"/var/lib/pacman/local/"}
+}
+
+// parsePacmanInfo parses the output of pacman -Si, which has blocks of lines
+// like `Name : bash` and `Version : 5.2.026-2` for each package.
+func parsePacmanInfo(out string, names []string) map[string]string {
+	result := mapDefault[string](names)
+	name := ""
+	for _, line := range lines(out) {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Name":
+			name = strings.TrimSpace(value)
+		case "Version":
+			if v, exists := result[name]; exists && v == "" { // keep the first
+				result[name] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return result
+}
+
+// parsePacmanIgnorePkg parses the IgnorePkg settings out of the pacman config.
+func parsePacmanIgnorePkg(data string, names []string) map[string]bool {
+	result := mapDefault[bool](names)
+	for _, line := range lines(data) {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "IgnorePkg" {
+			continue
+		}
+		for _, name := range strings.Fields(value) {
+			if _, exists := result[name]; exists {
+				result[name] = true
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,261 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package pkgbackend contains the native package manager backends that are used
+// by the pkg resource when PackageKit isn't available or isn't wanted. Each one
+// runs the command line tools of its package manager and parses their output.
+package pkgbackend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	archUtil "github.com/purpleidea/mgmt/util/arch"
+	"github.com/purpleidea/mgmt/util/distro"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+var (
+	registeredBackends = make(map[string]func() Backend) // must initialize
+	registeredMutex    = &sync.Mutex{}
+)
+
+// Register takes a backend and its name and makes it available for use. There
+// is no matching unregister function.
+func Register(name string, fn func() Backend) {
+	registeredMutex.Lock()
+	defer registeredMutex.Unlock()
+	if _, exists := registeredBackends[name]; exists {
+		panic(fmt.Sprintf("a pkg backend named %s is already registered", name))
+	}
+	registeredBackends[name] = fn
+}
+
+// Lookup returns a new instance of the named backend. It errors if it doesn't
+// exist.
+func Lookup(name string) (Backend, error) {
+	registeredMutex.Lock()
+	defer registeredMutex.Unlock()
+	fn, exists := registeredBackends[name]
+	if !exists {
+		return nil, fmt.Errorf("no pkg backend named: %s", name)
+	}
+	return fn(), nil
+}
+
+// Names returns a sorted list of the registered backend names.
+func Names() []string {
+	registeredMutex.Lock()
+	defer registeredMutex.Unlock()
+	names := []string{}
+	for name := range registeredBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Detect returns the name of the native backend that matches the distro that we
+// are running on, if its tool is installed. If there isn't one, then this
+// returns the empty string and no error.
+func Detect(ctx context.Context) (string, error) {
+	family, err := distro.Family(ctx)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "could not detect the distro family")
+	}
+	name, exists := distro.ToPackageManager(family)
+	if !exists {
+		return "", nil
+	}
+	if _, err := Lookup(name); err != nil {
+		return "", nil // not one of ours
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return "", nil // not installed
+	}
+	return name, nil
+}
+
+// Init is the structure of values and references which is passed into all
+// backends on initialization.
+type Init struct {
+	// Debug is true if we're running in debug mode.
+	Debug bool
+
+	// Logf is a logging function for the backend to use.
+	Logf func(format string, v ...interface{})
+}
+
+// Backend is the interface that a package manager backend must implement. All
+// of the methods that take a list of names should do the work with as few runs
+// of the package manager as possible. Versions are strings in the form that the
+// package manager itself displays them in.
+type Backend interface {
+	// Init passes in the logging and debug values. It's called once before
+	// any of the other methods.
+	Init(*Init) error
+
+	// Installed returns a map of the installed version of each package.
+	// Packages which aren't installed have an empty version.
+	Installed(ctx context.Context, names []string) (map[string]string, error)
+
+	// Available returns a map of the newest version of each package that
+	// is available to install. Packages which can't be found have an empty
+	// version.
+	Available(ctx context.Context, names []string) (map[string]string, error)
+
+	// Install installs or changes each package to the version that it maps
+	// to. If the version is empty, then any version can be installed, and
+	// a package that is already installed is left alone.
+	Install(ctx context.Context, packages map[string]string) error
+
+	// Upgrade installs or upgrades the packages to the newest version.
+	Upgrade(ctx context.Context, names []string) error
+
+	// Remove uninstalls the packages.
+	Remove(ctx context.Context, names []string) error
+
+	// Held returns a map of whether each package is held at its version so
+	// that it isn't changed by upgrades.
+	Held(ctx context.Context, names []string) (map[string]bool, error)
+
+	// Hold holds the packages at their installed versions, or releases the
+	// hold if the bool is false.
+	Hold(ctx context.Context, names []string, hold bool) error
+
+	// Files returns the list of files in an installed package. If it's not
+	// installed, then this might be empty.
+	Files(ctx context.Context, name string) ([]string, error)
+
+	// WatchPaths returns the list of paths to watch to notice changes to
+	// the installed packages. Paths that don't exist are ignored.
+	WatchPaths() []string
+}
+
+// base contains the common parts of the backends that run a command.
+type base struct {
+	init *Init
+}
+
+// Init stores the init values for later.
+func (obj *base) Init(init *Init) error {
+	obj.init = init
+	return nil
+}
+
+// run runs a command and returns its stdout. It errors if the exit status isn't
+// zero, and the error includes the stderr.
+func (obj *base) run(ctx context.Context, env []string, name string, args ...string) (string, error) {
+	stdout, stderr, code, err := obj.runCode(ctx, env, name, args...)
+	if err != nil {
+		return "", err
+	}
+	if code != 0 {
+		return "", fmt.Errorf("%s exited with: %d: %s", name, code, strings.TrimSpace(stderr))
+	}
+	return stdout, nil
+}
+
+// runCode runs a command and returns its stdout, stderr and its exit status. A
+// non-zero exit status isn't an error, since some tools use it to say that a
+// package wasn't found, but they still print the others.
+func (obj *base) runCode(ctx context.Context, env []string, name string, args ...string) (string, string, int, error) {
+	if obj.init.Debug {
+		obj.init.Logf("run: %s %s", name, strings.Join(args, " "))
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "LC_ALL=C") // we parse the output
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() >= 0 {
+		if obj.init.Debug {
+			obj.init.Logf("%s exited with: %d", name, exitErr.ExitCode())
+		}
+		return stdout.String(), stderr.String(), exitErr.ExitCode(), nil
+	}
+	if err != nil { // includes getting killed by a signal
+		return "", "", 0, errwrap.Wrapf(err, "could not run %s", name)
+	}
+	return stdout.String(), stderr.String(), 0, nil
+}
+
+// mapDefault returns a map with an entry for each name which is set to the zero
+// value of the map type.
+func mapDefault[T any](names []string) map[string]T {
+	m := make(map[string]T)
+	var zero T
+	for _, name := range names {
+		m[name] = zero
+	}
+	return m
+}
+
+// sortedKeys returns the sorted keys of a map, which is helpful to keep the
+// order of the arguments to the commands that we run stable.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isMyArch returns true if the package manager arch is the one that we run on,
+// or if it means any arch. Unknown arches return false.
+func isMyArch(arch string) bool {
+	goarch, exists := archUtil.MapPackageKitArchToGoArch[arch]
+	if !exists {
+		return false
+	}
+	return goarch == archUtil.Any || goarch == runtime.GOARCH
+}
+
+// lines splits the output of a command into its non-empty lines.
+func lines(s string) []string {
+	result := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
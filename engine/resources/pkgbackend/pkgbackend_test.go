@@ -0,0 +1,271 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package pkgbackend
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// myArch returns an arch name which matches the one that we run on, so that
+// the tests don't get filtered out by the arch checks.
+func myArch() string {
+	if runtime.GOARCH == "arm64" {
+		return "aarch64"
+	}
+	return "x86_64"
+}
+
+func TestParse1(t *testing.T) {
+	names := []string{"bash", "vim", "nope"}
+	arch := myArch()
+
+	type test struct { // an individual test
+		name   string
+		actual map[string]string
+		expect map[string]string
+	}
+	testCases := []test{
+		{
+			name: "rpm query",
+			actual: parseNameArchVersion(
+				"bash "+arch+" 5.2.15-5.fc39\n"+
+					"package vim is not installed\n"+
+					"package nope is not installed\n",
+				names,
+			),
+			expect: map[string]string{"bash": "5.2.15-5.fc39", "vim": "", "nope": ""},
+		},
+		{
+			name: "repoquery with other arches",
+			actual: parseNameArchVersion(
+				"bash i686 5.2.26-1.fc39\n\n"+
+					"bash "+arch+" 5.2.26-1.fc39\n\n"+
+					"vim noarch 9.1.0-1.fc39\n",
+				names,
+			),
+			expect: map[string]string{"bash": "5.2.26-1.fc39", "vim": "9.1.0-1.fc39", "nope": ""},
+		},
+		{
+			name: "dpkg-query",
+			actual: parseDpkgQuery(
+				"bash all ii  5.2.15-2+b2\n"+
+					"vim amd64 rc  2:9.0.1378-2\n",
+				names,
+			),
+			expect: map[string]string{"bash": "5.2.15-2+b2", "vim": "", "nope": ""},
+		},
+		{
+			name: "apt-cache policy",
+			actual: parseAptPolicy(
+				"bash:\n"+
+					"  Installed: 5.2.15-2+b2\n"+
+					"  Candidate: 5.2.15-2+b7\n"+
+					"  Version table:\n"+
+					"     5.2.15-2+b7 500\n"+
+					"vim:\n"+
+					"  Installed: (none)\n"+
+					"  Candidate: (none)\n",
+				names,
+			),
+			expect: map[string]string{"bash": "5.2.15-2+b7", "vim": "", "nope": ""},
+		},
+		{
+			name: "apk list",
+			actual: parseApkList(
+				"bash-5.2.21-r0 "+arch+" {bash} (GPL-3.0-or-later) [installed]\n"+
+					"vim-9.0.2127-r0 "+arch+" {vim} (Vim)\n"+
+					"vim-common-9.0.2127-r0 noarch {vim} (Vim)\n",
+				names,
+			),
+			expect: map[string]string{"bash": "5.2.21-r0", "vim": "9.0.2127-r0", "nope": ""},
+		},
+		{
+			name: "pacman info",
+			actual: parsePacmanInfo(
+				"Repository      : core\n"+
+					"Name            : bash\n"+
+					"Version         : 5.2.026-2\n"+
+					"Description     : The GNU Bourne Again shell\n"+
+					"\n"+
+					"Repository      : testing\n"+
+					"Name            : bash\n"+
+					"Version         : 5.2.026-3\n",
+				names,
+			),
+			expect: map[string]string{"bash": "5.2.026-2", "vim": "", "nope": ""},
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, actual, expect := tc.name, tc.actual, tc.expect
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			if !reflect.DeepEqual(actual, expect) {
+				t.Errorf("expected: %+v", expect)
+				t.Errorf("actual: %+v", actual)
+			}
+		})
+	}
+}
+
+func TestParseHeld1(t *testing.T) {
+	names := []string{"bash", "vim", "nope"}
+	expect := map[string]bool{"bash": true, "vim": true, "nope": false}
+
+	dnf4 := "bash-0:5.2.15-5.fc39.*\nvim-enhanced-2:9.1.0-1.fc39.*\nvim-2:9.1.0-1.fc39.*\n"
+	if actual := parseDnfVersionlock(dnf4, names); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("dnf4: expected: %+v, actual: %+v", expect, actual)
+	}
+	dnf5 := "# Added by 'versionlock add' command on 2024-01-01 00:00:00\nPackage name: bash\nevr = 5.2.15-5.fc39\nPackage name: vim\n"
+	if actual := parseDnfVersionlock(dnf5, names); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("dnf5: expected: %+v, actual: %+v", expect, actual)
+	}
+	world := "alpine-base\nbash=5.2.21-r0\nvim~9.0\nnope-not\n"
+	if actual := parseApkWorld(world, names); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("apk: expected: %+v, actual: %+v", expect, actual)
+	}
+	conf := "[options]\n#IgnorePkg = nope\nIgnorePkg   = bash vim\n"
+	if actual := parsePacmanIgnorePkg(conf, names); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("pacman: expected: %+v, actual: %+v", expect, actual)
+	}
+}
+
+func TestCheckQuery1(t *testing.T) {
+	type test struct { // an individual test
+		name  string
+		err   error
+		fails bool
+	}
+	testCases := []test{
+		{"dpkg ok", checkDpkgQuery(0, ""), false},
+		{"dpkg unknown", checkDpkgQuery(1, "dpkg-query: no packages found matching nope\n"), false},
+		{"dpkg broken", checkDpkgQuery(2, "dpkg-query: error: parsing file '/var/lib/dpkg/status'\n"), true},
+		{"dpkg other", checkDpkgQuery(1, "dpkg-query: no packages found matching nope\ndpkg-query: error: out of memory\n"), true},
+		{"dpkg silent", checkDpkgQuery(1, ""), true},
+		{"rpm ok", checkRpmQuery("bash x86_64 5.2.15-5.fc39\n", 0, ""), false},
+		{"rpm missing", checkRpmQuery("bash x86_64 5.2.15-5.fc39\npackage nope is not installed\n", 1, ""), false},
+		{"rpm locked", checkRpmQuery("", 1, "error: rpmdb: BDB0113 Thread/process failed\n"), true},
+		{"rpm missing and broken", checkRpmQuery("package nope is not installed\n", 1, "error: cannot open Packages database\n"), true},
+	}
+	for index, tc := range testCases {
+		if tc.fails && tc.err == nil {
+			t.Errorf("test #%d (%s): expected an error", index, tc.name)
+		}
+		if !tc.fails && tc.err != nil {
+			t.Errorf("test #%d (%s): unexpected error: %+v", index, tc.name, tc.err)
+		}
+	}
+}
+
+func TestSplitApkPackage1(t *testing.T) {
+	for s, expect := range map[string][2]string{
+		"bash-5.2.21-r0":         {"bash", "5.2.21-r0"},
+		"py3-foo-bar-1.0_rc1-r2": {"py3-foo-bar", "1.0_rc1-r2"},
+		"nope":                   {"", ""},
+	} {
+		name, version, _ := splitApkPackage(s)
+		if name != expect[0] || version != expect[1] {
+			t.Errorf("%s: expected: %+v, got: %s, %s", s, expect, name, version)
+		}
+	}
+}
+
+func TestFake1(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	if err := fake.Init(&Init{Logf: t.Logf}); err != nil {
+		t.Errorf("init failed: %+v", err)
+		return
+	}
+	fake.Add("foo", &FakePackage{Versions: []string{"1.0", "2.0"}})
+
+	if err := fake.Install(ctx, map[string]string{"nope": ""}); err == nil {
+		t.Errorf("expected error installing a missing package")
+	}
+	if err := fake.Install(ctx, map[string]string{"foo": "1.0"}); err != nil {
+		t.Errorf("install failed: %+v", err)
+	}
+	if err := fake.Hold(ctx, []string{"foo"}, true); err != nil {
+		t.Errorf("hold failed: %+v", err)
+	}
+	if err := fake.Upgrade(ctx, []string{"foo"}); err == nil {
+		t.Errorf("expected error upgrading a held package")
+	}
+	if err := fake.Hold(ctx, []string{"foo"}, false); err != nil {
+		t.Errorf("hold failed: %+v", err)
+	}
+	if err := fake.Upgrade(ctx, []string{"foo"}); err != nil {
+		t.Errorf("upgrade failed: %+v", err)
+	}
+	if pkg := fake.Get("foo"); pkg.Installed != "2.0" {
+		t.Errorf("expected version 2.0, got: %s", pkg.Installed)
+	}
+	if err := fake.Remove(ctx, []string{"foo"}); err != nil {
+		t.Errorf("remove failed: %+v", err)
+	}
+	if installed, _ := fake.Installed(ctx, []string{"foo"}); installed["foo"] != "" {
+		t.Errorf("expected foo to be removed")
+	}
+}
+
+func TestFakeClone1(t *testing.T) {
+	ctx := context.Background()
+	if _, err := Lookup("fake"); err == nil {
+		t.Errorf("the fake backend must not be registered")
+	}
+
+	logs := make(map[string]int)
+	fake := NewFake()
+	fake.Add("foo", &FakePackage{Versions: []string{"1.0"}})
+	clones := []*Fake{fake.Clone(), fake.Clone()}
+	for i, clone := range clones {
+		name := fmt.Sprintf("clone%d", i)
+		logf := func(format string, v ...interface{}) { logs[name]++ }
+		if err := clone.Init(&Init{Logf: logf}); err != nil {
+			t.Errorf("init failed: %+v", err)
+			return
+		}
+	}
+
+	if err := clones[0].Install(ctx, map[string]string{"foo": ""}); err != nil {
+		t.Errorf("install failed: %+v", err)
+	}
+	if pkg := clones[1].Get("foo"); pkg.Installed != "1.0" {
+		t.Errorf("expected the clones to share their packages")
+	}
+	if logs["clone0"] != 1 || logs["clone1"] != 0 {
+		t.Errorf("expected each clone to use its own logger, got: %+v", logs)
+	}
+}
@@ -35,6 +35,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/user"
 	"path"
 	"strconv"
@@ -49,6 +50,9 @@ import (
 	"github.com/purpleidea/mgmt/pgraph"
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
+
+	systemdUtil "github.com/coreos/go-systemd/v22/util"
+	fstab "github.com/deniswernert/go-fstab"
 )
 
 // TODO: consider providing this as a lib so that we can add tests into the
@@ -702,6 +706,7 @@ func TestResources2(t *testing.T) {
 		expect   func() error   // function to check for expected state
 		startup  func() error   // function to run as startup (unused?)
 		cleanup  func() error   // function to run as cleanup
+		skip     func() error   // function which errors if it can't run here
 	}
 
 	type initOptions struct {
@@ -923,6 +928,143 @@ func TestResources2(t *testing.T) {
 			return os.Mkdir(p, 0777)
 		}
 	}
+	// resRoundTrip encodes and decodes the reversed resource the same way
+	// that the engine stores it, so that we only keep what survives that.
+	resRoundTrip := func(rev *engine.Res) func() error {
+		return func() error {
+			if *rev == nil {
+				return fmt.Errorf("no reversed resource")
+			}
+			str, err := engineUtil.ResToB64(*rev)
+			if err != nil {
+				return err
+			}
+			res, err := engineUtil.B64ToRes(str)
+			if err != nil {
+				return err
+			}
+			*rev = res
+			return nil
+		}
+	}
+	// resReversedApply runs the reversed resource through all of the steps
+	// that the engine would run it through. It's wrapped because the rev
+	// variable is nil until the resReversal step runs.
+	resReversedApply := func(rev *engine.Res) func() error {
+		return func() error {
+			if *rev == nil {
+				return fmt.Errorf("no reversed resource")
+			}
+			steps := []func() error{
+				resValidate(*rev),
+				resInit(*rev),
+				resCheckApply(*rev, false), // changed
+				resCheckApply(*rev, true),  // it's already good
+				resCleanup(*rev),
+			}
+			for _, step := range steps {
+				if err := step(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	resNoReversal := func(rev *engine.Res) func() error {
+		return func() error {
+			if *rev != nil { // it was already there
+				return fmt.Errorf("unexpected reversal: %+v", *rev)
+			}
+			return nil
+		}
+	}
+	cmdRun := func(name string, args ...string) func() error {
+		// run a command, usually to set up the state from before we ran
+		return func() error {
+			out, err := exec.Command(name, args...).CombinedOutput()
+			if err != nil {
+				return errwrap.Wrapf(err, "%s failed: %s", name, out)
+			}
+			return nil
+		}
+	}
+	userExpect := func(name, shell, homedir string, groups []string) func() error {
+		// does the user exist with these properties?
+		return func() error {
+			usr, err := user.Lookup(name)
+			if err != nil {
+				return err
+			}
+			state, err := userState(context.TODO(), usr)
+			if err != nil {
+				return err
+			}
+			if *state.Shell != shell {
+				return fmt.Errorf("unexpected shell: %s", *state.Shell)
+			}
+			if *state.HomeDir != homedir {
+				return fmt.Errorf("unexpected homedir: %s", *state.HomeDir)
+			}
+			if err := util.SortedStrSliceCompare(state.Groups, groups); err != nil {
+				return errwrap.Wrapf(err, "unexpected groups")
+			}
+			return nil
+		}
+	}
+	userAbsent := func(name string) func() error {
+		// is the user absent?
+		return func() error {
+			_, err := user.Lookup(name)
+			if _, ok := err.(user.UnknownUserError); ok {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("user exists, expecting absent")
+		}
+	}
+	groupExpect := func(name string, gid *uint32) func() error {
+		// does the group exist, with this gid if it's not nil?
+		return func() error {
+			group, err := user.LookupGroup(name)
+			if err != nil {
+				return err
+			}
+			if gid != nil && group.Gid != strconv.FormatUint(uint64(*gid), 10) {
+				return fmt.Errorf("unexpected gid: %s", group.Gid)
+			}
+			return nil
+		}
+	}
+	groupAbsent := func(name string) func() error {
+		// is the group absent?
+		return func() error {
+			_, err := user.LookupGroup(name)
+			if _, ok := err.(user.UnknownGroupError); ok {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("group exists, expecting absent")
+		}
+	}
+	needRoot := func() error {
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("this needs root")
+		}
+		return nil
+	}
+	needSystemd := func() error {
+		if err := needRoot(); err != nil {
+			return err
+		}
+		if !systemdUtil.IsRunningSystemd() {
+			return fmt.Errorf("systemd is not running")
+		}
+		return nil
+	}
 
 	testCases := []test{}
 	{
@@ -1321,6 +1463,108 @@ func TestResources2(t *testing.T) {
 			cleanup:  func() error { return nil },
 		})
 	}
+	{
+		//line "r1" {
+		//	file => "/tmp/somefile",
+		//	state => $const.res.line.state.exists,
+		//	content => "this is a new line",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("line", "r1")
+		res := r1.(*LineRes) // if this panics, the test will panic
+		p := "/tmp/somefile"
+		res.File = p
+		res.State = LineStateExists
+		res.Content = "this is a new line"
+		original := "this is the original state\n" // original state
+		var r2 engine.Res                          // future reversed resource
+
+		timeline := []func() error{
+			fileWrite(p, original),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			func() error { // random test
+				if st := r2.(*LineRes).State; st != LineStateAbsent {
+					return fmt.Errorf("unexpected state: %s", st)
+				}
+				return nil
+			},
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			fileExpect(p, original+"this is a new line\n"),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			func() error {
+				// wrap it b/c it is currently nil
+				return r2.Validate()
+			},
+			func() error {
+				return resInit(r2)()
+			},
+			func() error {
+				return resCheckApply(r2, false)()
+			},
+			func() error {
+				return resCheckApply(r2, true)()
+			},
+			func() error {
+				return resCleanup(r2)()
+			},
+			fileExpect(p, original), // ensure it's back to original
+			fileRemove(p),           // cleanup
+		}
+
+		testCases = append(testCases, test{
+			name:     "line reversal",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup:  func() error { return nil },
+		})
+	}
+	{
+		//line "r1" {
+		//	file => "/tmp/somefile",
+		//	state => $const.res.line.state.exists,
+		//	content => "this is an old line",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("line", "r1")
+		res := r1.(*LineRes) // if this panics, the test will panic
+		p := "/tmp/somefile"
+		res.File = p
+		res.State = LineStateExists
+		res.Content = "this is an old line"
+		original := "this is an old line\n" // original state
+		var r2 engine.Res                   // future reversed resource
+
+		timeline := []func() error{
+			fileWrite(p, original),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			func() error {
+				if r2 != nil { // the line was already there
+					return fmt.Errorf("unexpected reversal: %+v", r2)
+				}
+				return nil
+			},
+			resInit(r1),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			fileExpect(p, original), // ensure it's unchanged
+			fileRemove(p),           // cleanup
+		}
+
+		testCases = append(testCases, test{
+			name:     "line reversal of existing line",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup:  func() error { return nil },
+		})
+	}
 	{
 		//file "/tmp/somefile" {
 		//	state => $const.res.file.state.exists,
@@ -1703,6 +1947,442 @@ func TestResources2(t *testing.T) {
 			cleanup:  func() error { return os.RemoveAll(p) },
 		})
 	}
+	{
+		//sysctl "kernel.ostype" {
+		//	value => "mgmt",
+		//	runtime => false,
+		//	persist => true,
+		//	path => "/tmp/99-mgmt-test.conf",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("sysctl", "kernel.ostype")
+		res := r1.(*SysctlRes) // if this panics, the test will panic
+		p := "/tmp/99-mgmt-test.conf"
+		res.Value = "mgmt"
+		res.Runtime = false // don't touch the kernel
+		res.Persist = true
+		res.Filename = p
+		var r2 engine.Res // future reversed resource
+
+		timeline := []func() error{
+			fileRemove(p),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			func() error { // random test
+				if rev := r2.(*SysctlRes); rev.Persist || !rev.PersistRemove {
+					return fmt.Errorf("unexpected reversal: %+v", rev)
+				}
+				return nil
+			},
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			fileExpect(p, "kernel.ostype = mgmt\n"),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			fileAbsent(p), // ensure we removed the file we created
+		}
+
+		testCases = append(testCases, test{
+			name:     "sysctl persist reversal",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup:  func() error { return os.RemoveAll(p) },
+		})
+	}
+	{
+		//sysctl "kernel.ostype" {
+		//	value => "mgmt",
+		//	runtime => false,
+		//	persist => true,
+		//	path => "/tmp/99-mgmt-test.conf",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("sysctl", "kernel.ostype")
+		res := r1.(*SysctlRes) // if this panics, the test will panic
+		p := "/tmp/99-mgmt-test.conf"
+		res.Value = "mgmt"
+		res.Runtime = false // don't touch the kernel
+		res.Persist = true
+		res.Filename = p
+		original := "# this was set by hand\nkernel.ostype = Linux\n" // original state
+		var r2 engine.Res                                             // future reversed resource
+
+		timeline := []func() error{
+			fileWrite(p, original),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			fileExpect(p, "kernel.ostype = mgmt\n"),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			fileExpect(p, original), // ensure it's back to original
+			fileRemove(p),           // cleanup
+		}
+
+		testCases = append(testCases, test{
+			name:     "sysctl persist reversal of existing file",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup:  func() error { return os.RemoveAll(p) },
+		})
+	}
+	{
+		//sysctl "kernel.ostype" {
+		//	value => "mgmt",
+		//	runtime => false,
+		//	persist => true,
+		//	path => "/tmp/99-mgmt-test.conf",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("sysctl", "kernel.ostype")
+		res := r1.(*SysctlRes) // if this panics, the test will panic
+		p := "/tmp/99-mgmt-test.conf"
+		res.Value = "mgmt"
+		res.Runtime = false // don't touch the kernel
+		res.Persist = true
+		res.Filename = p
+		original := "kernel.ostype = mgmt\n" // original state
+		var r2 engine.Res                    // future reversed resource
+
+		timeline := []func() error{
+			fileWrite(p, original),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resNoReversal(&r2),
+			resInit(r1),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			fileExpect(p, original), // ensure it's unchanged
+			fileRemove(p),           // cleanup
+		}
+
+		testCases = append(testCases, test{
+			name:     "sysctl persist reversal of unchanged file",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup:  func() error { return os.RemoveAll(p) },
+		})
+	}
+	{
+		//user "mgmttestuser1" {
+		//	state => "exists",
+		//	shell => "/bin/sh",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("user", "mgmttestuser1")
+		res := r1.(*UserRes) // if this panics, the test will panic
+		res.State = "exists"
+		shell := "/bin/sh"
+		res.Shell = &shell
+		var r2 engine.Res // future reversed resource
+
+		timeline := []func() error{
+			userAbsent("mgmttestuser1"),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			func() error { // random test
+				if st := r2.(*UserRes).State; st != "absent" {
+					return fmt.Errorf("unexpected state: %s", st)
+				}
+				return nil
+			},
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			func() error {
+				_, err := user.Lookup("mgmttestuser1")
+				return err
+			},
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			userAbsent("mgmttestuser1"), // ensure we removed it again
+		}
+
+		testCases = append(testCases, test{
+			name:     "user reversal",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup: func() error {
+				exec.Command("userdel", "mgmttestuser1").Run() // ignore errors
+				return nil
+			},
+			skip: needRoot,
+		})
+	}
+	{
+		//user "mgmttestuser2" {
+		//	state => "exists",
+		//	shell => "/bin/bash",
+		//	homedir => "/tmp/mgmttestuser2new",
+		//	groups => [],
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("user", "mgmttestuser2")
+		res := r1.(*UserRes) // if this panics, the test will panic
+		res.State = "exists"
+		shell := "/bin/bash"
+		res.Shell = &shell
+		homedir := "/tmp/mgmttestuser2new"
+		res.HomeDir = &homedir
+		res.Groups = []string{} // remove them all
+		var r2 engine.Res       // future reversed resource
+
+		timeline := []func() error{
+			cmdRun("groupadd", "mgmttestgroup1"),
+			cmdRun("useradd", "--no-create-home", "--shell", "/bin/sh", "--home-dir", "/tmp/mgmttestuser2", "--groups", "mgmttestgroup1", "mgmttestuser2"),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			userExpect("mgmttestuser2", "/bin/bash", "/tmp/mgmttestuser2new", []string{}),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			userExpect("mgmttestuser2", "/bin/sh", "/tmp/mgmttestuser2", []string{"mgmttestgroup1"}),
+		}
+
+		testCases = append(testCases, test{
+			name:     "user reversal of existing user",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup: func() error {
+				exec.Command("userdel", "mgmttestuser2").Run() // ignore errors
+				exec.Command("groupdel", "mgmttestgroup1").Run()
+				return nil
+			},
+			skip: needRoot,
+		})
+	}
+	{
+		//user "mgmttestuser3" {
+		//	state => "exists",
+		//	groups => ["mgmttestgroup2",],
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("user", "mgmttestuser3")
+		res := r1.(*UserRes) // if this panics, the test will panic
+		res.State = "exists"
+		res.Groups = []string{"mgmttestgroup2"}
+		var r2 engine.Res // future reversed resource
+
+		timeline := []func() error{
+			cmdRun("groupadd", "mgmttestgroup2"),
+			cmdRun("useradd", "--no-create-home", "--shell", "/bin/sh", "--home-dir", "/tmp/mgmttestuser3", "mgmttestuser3"),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),    // an empty list of groups is lost here
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			userExpect("mgmttestuser3", "/bin/sh", "/tmp/mgmttestuser3", []string{"mgmttestgroup2"}),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			userExpect("mgmttestuser3", "/bin/sh", "/tmp/mgmttestuser3", []string{}),
+		}
+
+		testCases = append(testCases, test{
+			name:     "user reversal of added groups",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup: func() error {
+				exec.Command("userdel", "mgmttestuser3").Run() // ignore errors
+				exec.Command("groupdel", "mgmttestgroup2").Run()
+				return nil
+			},
+			skip: needRoot,
+		})
+	}
+	{
+		//group "mgmttestgroup3" {
+		//	state => "exists",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("group", "mgmttestgroup3")
+		res := r1.(*GroupRes) // if this panics, the test will panic
+		res.State = "exists"
+		var r2 engine.Res // future reversed resource
+
+		timeline := []func() error{
+			groupAbsent("mgmttestgroup3"),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			groupExpect("mgmttestgroup3", nil),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			groupAbsent("mgmttestgroup3"), // ensure we removed it again
+		}
+
+		testCases = append(testCases, test{
+			name:     "group reversal",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup: func() error {
+				exec.Command("groupdel", "mgmttestgroup3").Run() // ignore errors
+				return nil
+			},
+			skip: needRoot,
+		})
+	}
+	{
+		//group "mgmttestgroup4" {
+		//	state => "absent",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("group", "mgmttestgroup4")
+		res := r1.(*GroupRes) // if this panics, the test will panic
+		res.State = "absent"
+		gid := uint32(4242)
+		var r2 engine.Res // future reversed resource
+
+		timeline := []func() error{
+			cmdRun("groupadd", "--gid", "4242", "mgmttestgroup4"),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			groupAbsent("mgmttestgroup4"),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			groupExpect("mgmttestgroup4", &gid), // ensure it's back
+		}
+
+		testCases = append(testCases, test{
+			name:     "group reversal of removed group",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup: func() error {
+				exec.Command("groupdel", "mgmttestgroup4").Run() // ignore errors
+				return nil
+			},
+			skip: needRoot,
+		})
+	}
+	{
+		//mount "/tmp/mgmt-test-mount" {
+		//	state => "exists",
+		//	device => "/dev/shm",
+		//	type => "tmpfs",
+		//	options => {"defaults" => "",},
+		//
+		//	Meta:reverse => true,
+		//}
+		p := "/tmp/mgmt-test-mount"
+		r1 := makeRes("mount", p)
+		res := r1.(*MountRes) // if this panics, the test will panic
+		res.State = "exists"
+		res.Device = "/dev/shm" // tmpfs ignores it, but it must be readable
+		res.Type = "tmpfs"
+		res.Options = map[string]string{"defaults": ""}
+		var r2 engine.Res // future reversed resource
+
+		timeline := []func() error{
+			fileMkdir(p, true),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			func() error { // random test
+				if st := r2.(*MountRes).State; st != "absent" {
+					return fmt.Errorf("unexpected state: %s", st)
+				}
+				return nil
+			},
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			resCheckApply(r1, true),  // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			func() error {
+				mounts, err := fstab.ParseFile(fstabPath)
+				if err != nil {
+					return err
+				}
+				for _, mount := range mounts {
+					if mount.File == p {
+						return fmt.Errorf("fstab entry still exists")
+					}
+				}
+				return nil
+			},
+		}
+
+		testCases = append(testCases, test{
+			name:     "mount reversal",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup:  func() error { return os.Remove(p) },
+			skip:     needSystemd,
+		})
+	}
+	{
+		//cron "mgmt-test-cron" {
+		//	state => "exists",
+		//	trigger => "OnCalendar",
+		//	time => "daily",
+		//
+		//	Meta:reverse => true,
+		//}
+		r1 := makeRes("cron", "mgmt-test-cron")
+		res := r1.(*CronRes) // if this panics, the test will panic
+		res.State = "exists"
+		res.Startup = "" // we only look at the unit file
+		res.Trigger = OnCalendar
+		res.Time = "daily"
+		p := util.SystemdUnitDirSystem + "mgmt-test-cron.timer"
+		var r2 engine.Res // future reversed resource
+
+		timeline := []func() error{
+			fileAbsent(p),
+			resValidate(r1),
+			resReversal(r1, &r2), // runs in Init to snapshot
+			resRoundTrip(&r2),
+			resInit(r1),
+			resCheckApply(r1, false), // changed
+			fileExists(p, false),
+			resCheckApply(r1, true), // it's already good
+			resCleanup(r1),
+			resReversedApply(&r2),
+			fileAbsent(p), // ensure we removed the timer we created
+		}
+
+		testCases = append(testCases, test{
+			name:     "cron reversal",
+			timeline: timeline,
+			expect:   func() error { return nil },
+			startup:  func() error { return nil },
+			cleanup:  func() error { return fileRemove(p)() },
+			skip:     needSystemd,
+		})
+	}
 	names := []string{}
 	for index, tc := range testCases { // run all the tests
 		if tc.name == "" {
@@ -1715,7 +2395,13 @@ func TestResources2(t *testing.T) {
 		}
 		names = append(names, tc.name)
 		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
-			timeline, expect, startup, cleanup := tc.timeline, tc.expect, tc.startup, tc.cleanup
+			timeline, expect, startup, cleanup, skip := tc.timeline, tc.expect, tc.startup, tc.cleanup, tc.skip
+
+			if skip != nil {
+				if err := skip(); err != nil {
+					t.Skipf("test #%d: skipping: %+v", index, err)
+				}
+			}
 
 			t.Logf("test #%d: starting...\n", index)
 			defer t.Logf("test #%d: done!", index)
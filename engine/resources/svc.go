@@ -32,16 +32,19 @@
 package resources
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os/user"
+	"os"
 	"path"
+	"strings"
 
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/engine/traits"
 	engineUtil "github.com/purpleidea/mgmt/engine/util"
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
+	"github.com/purpleidea/mgmt/util/recwatch"
 
 	systemd "github.com/coreos/go-systemd/v22/dbus" // change namespace
 	systemdUtil "github.com/coreos/go-systemd/v22/util"
@@ -96,6 +99,7 @@ type SvcRes struct {
 	traits.Edgeable
 	traits.Groupable
 	traits.Refreshable
+	traits.Reversible
 
 	init *engine.Init
 
@@ -104,12 +108,45 @@ type SvcRes struct {
 	State string `lang:"state" yaml:"state"`
 
 	// Startup specifies what should happen on startup. Values can be:
-	// enabled, disabled, and undefined (empty string).
+	// enabled, disabled, masked, and undefined (empty string). A masked
+	// service can't be started at all, not even manually, until it gets
+	// unmasked, which happens if this is changed to enabled or disabled.
 	Startup string `lang:"startup" yaml:"startup"`
 
 	// Session specifies if this is for a system service (false) or a user
 	// session specific service (true).
 	Session bool `lang:"session" yaml:"session"` // user session (true) or system?
+
+	// Content is the contents of the unit file. If this is specified, then
+	// the unit file is written to the local unit directory, which is
+	// /etc/systemd/system/ for system services, or the one in the home dir
+	// of the user for session services. If it's left undefined, then the
+	// unit file isn't managed. A daemon-reload happens when it changes.
+	Content *string `lang:"content" yaml:"content"`
+
+	// DropIns is a map of drop-in names to contents. Each one is written
+	// to a file named after the key with a .conf extension, in the drop-in
+	// directory for this unit, and can override any of the unit settings.
+	// Other drop-in files that might exist there are left alone. A
+	// daemon-reload happens when any of these change.
+	DropIns map[string]string `lang:"dropins" yaml:"dropins"`
+
+	// ReloadOnRefresh specifies what happens when we receive a refresh
+	// notification. If true, then the service is reloaded, and if false,
+	// then it's restarted. In both cases, a service which isn't running is
+	// left alone. If left undefined, then the service is reloaded if it
+	// supports that, and restarted if it doesn't.
+	ReloadOnRefresh *bool `lang:"reload_on_refresh" yaml:"reload_on_refresh"`
+
+	// RemoveFiles is only set by the reversal, and it can't be set from the
+	// language. It's the list of unit and drop-in files that we created,
+	// which get removed once the state and startup have been put back. A
+	// daemon-reload happens when any of these are removed.
+	RemoveFiles []string `yaml:"-"`
+
+	// unitDir overrides the local unit directory if it is set. This is only
+	// used by the tests. It must have a trailing slash.
+	unitDir string
 }
 
 // Default returns some sensible defaults for this resource.
@@ -122,8 +159,25 @@ func (obj *SvcRes) Validate() error {
 	if obj.State != "running" && obj.State != "stopped" && obj.State != "" {
 		return fmt.Errorf("state must be either `running` or `stopped` or undefined")
 	}
-	if obj.Startup != "enabled" && obj.Startup != "disabled" && obj.Startup != "" {
-		return fmt.Errorf("startup must be either `enabled` or `disabled` or undefined")
+	if obj.Startup != "enabled" && obj.Startup != "disabled" && obj.Startup != "masked" && obj.Startup != "" {
+		return fmt.Errorf("startup must be either `enabled` or `disabled` or `masked` or undefined")
+	}
+	if obj.Startup == "masked" && obj.State == "running" {
+		return fmt.Errorf("a masked service can't be running")
+	}
+	// Masking works by replacing the unit file with a link to /dev/null.
+	if obj.Startup == "masked" && obj.Content != nil {
+		return fmt.Errorf("can't specify the Content of a masked service")
+	}
+	for name := range obj.DropIns {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid drop-in name: `%s`", name)
+		}
+	}
+	for _, p := range obj.RemoveFiles {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("the file to remove must be absolute: `%s`", p)
+		}
 	}
 	return nil
 }
@@ -179,6 +233,34 @@ func (obj *SvcRes) Watch(ctx context.Context) error {
 	bus.Signal(buschan)
 	defer bus.RemoveSignal(buschan) // not needed here, but nice for symmetry
 
+	// watch the unit files that we manage, if any
+	files, err := obj.unitFiles()
+	if err != nil {
+		return err
+	}
+	chanList := []<-chan recwatch.Event{}
+	dirs := make(map[string]struct{})
+	for _, p := range util.StrMapKeys(files) {
+		dir := path.Dir(p) + "/"
+		if strings.HasSuffix(dir, ".d/") { // watch the drop-in dir once
+			if _, exists := dirs[dir]; exists {
+				continue
+			}
+			dirs[dir] = struct{}{}
+			p = dir
+		}
+		recWatcher, err := recwatch.NewRecWatcher(p, false)
+		if err != nil {
+			return err
+		}
+		defer recWatcher.Close()
+		chanList = append(chanList, recWatcher.Events())
+	}
+	var fileEvents <-chan recwatch.Event // nil chan blocks if unused
+	if len(chanList) > 0 {
+		fileEvents = recwatch.MergeChannels(chanList...)
+	}
+
 	obj.init.Running() // when started, notify engine that we're running
 
 	var svc = fmt.Sprintf("%s.service", obj.Name()) // systemd name
@@ -233,6 +315,15 @@ func (obj *SvcRes) Watch(ctx context.Context) error {
 				// loop so that we can see the changed invalid signal
 				obj.init.Logf("daemon reload")
 
+			case event, ok := <-fileEvents:
+				if !ok { // channel shutdown
+					return fmt.Errorf("unexpected close")
+				}
+				if err := event.Error; err != nil {
+					return errwrap.Wrapf(err, "unknown %s watcher error", obj)
+				}
+				send = true
+
 			case <-ctx.Done(): // closed by the engine to signal shutdown
 				return nil
 			}
@@ -278,6 +369,18 @@ func (obj *SvcRes) Watch(ctx context.Context) error {
 			case err := <-subErrors:
 				return errwrap.Wrapf(err, "unknown %s error", obj)
 
+			case event, ok := <-fileEvents:
+				if !ok { // channel shutdown
+					return fmt.Errorf("unexpected close")
+				}
+				if err := event.Error; err != nil {
+					return errwrap.Wrapf(err, "unknown %s watcher error", obj)
+				}
+				if obj.init.Debug { // don't access event.Body if event.Error isn't nil
+					obj.init.Logf("event(%s): %v", event.Body.Name, event.Body.Op)
+				}
+				send = true
+
 			case <-ctx.Done(): // closed by the engine to signal shutdown
 				return nil
 			}
@@ -310,6 +413,45 @@ func (obj *SvcRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	}
 	defer conn.Close()
 
+	// The unit files must be correct before we look at anything else.
+	filesOK, err := obj.unitFilesCheckApply(ctx, apply)
+	if err != nil {
+		return false, err
+	}
+	if !filesOK && !apply {
+		return false, nil // the unit might not even exist yet
+	}
+	if !filesOK {
+		obj.init.Logf("daemon-reload")
+		if err := conn.ReloadContext(ctx); err != nil {
+			return false, errwrap.Wrapf(err, "failed to daemon-reload")
+		}
+	}
+
+	checkOK, err := obj.stateCheckApply(ctx, conn, apply)
+	if err != nil {
+		return false, err
+	}
+
+	// The files that we created get removed last, since systemd would not
+	// find the unit to stop or disable it anymore once they're gone.
+	removeOK, err := obj.removeFilesCheckApply(ctx, apply)
+	if err != nil {
+		return false, err
+	}
+	if !removeOK && apply {
+		obj.init.Logf("daemon-reload")
+		if err := conn.ReloadContext(ctx); err != nil {
+			return false, errwrap.Wrapf(err, "failed to daemon-reload")
+		}
+	}
+
+	return filesOK && checkOK && removeOK, nil
+}
+
+// stateCheckApply performs a CheckApply on the running state and the startup
+// state of the unit, and it reloads or restarts it if we got a refresh.
+func (obj *SvcRes) stateCheckApply(ctx context.Context, conn *systemd.Conn, apply bool) (bool, error) {
 	var svc = fmt.Sprintf("%s.service", obj.Name()) // systemd name
 
 	loadstate, err := conn.GetUnitPropertyContext(ctx, svc, "LoadState")
@@ -319,6 +461,9 @@ func (obj *SvcRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 
 	// NOTE: we have to compare variants with other variants, they are really strings...
 	var notFound = (loadstate.Value == dbus.MakeVariant("not-found"))
+	if notFound && len(obj.RemoveFiles) > 0 {
+		return true, nil // we removed it already, so it's stopped
+	}
 	if notFound {
 		return false, errwrap.Wrapf(err, "failed to find svc: %s", svc)
 	}
@@ -341,7 +486,8 @@ func (obj *SvcRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 
 	enabled := (startupstate.Value == dbus.MakeVariant("enabled"))
 	disabled := (startupstate.Value == dbus.MakeVariant("disabled"))
-	startupOK := ((obj.Startup == "") || (obj.Startup == "enabled" && enabled) || (obj.Startup == "disabled" && disabled))
+	masked := (startupstate.Value == dbus.MakeVariant("masked"))
+	startupOK := ((obj.Startup == "") || (obj.Startup == "enabled" && enabled) || (obj.Startup == "disabled" && disabled) || (obj.Startup == "masked" && masked))
 
 	// NOTE: if this svc resource is embedded as a composite resource inside
 	// of another resource using a technique such as `makeComposite()`, then
@@ -364,11 +510,20 @@ func (obj *SvcRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	}
 
 	// apply portion
-	files := []string{svc} // the svc represented in a list
+	files := []string{svc}    // the svc represented in a list
+	if masked && !startupOK { // it must be unmasked before anything else
+		obj.init.Logf("unmask")
+		if _, err := conn.UnmaskUnitFilesContext(ctx, files, false); err != nil {
+			return false, errwrap.Wrapf(err, "unable to unmask")
+		}
+	}
 	if obj.Startup == "enabled" {
 		_, _, err = conn.EnableUnitFilesContext(ctx, files, false, true)
 	} else if obj.Startup == "disabled" {
 		_, err = conn.DisableUnitFilesContext(ctx, files, false)
+	} else if obj.Startup == "masked" && !masked {
+		obj.init.Logf("mask")
+		_, err = conn.MaskUnitFilesContext(ctx, files, false, true)
 	}
 	if err != nil {
 		return false, errwrap.Wrapf(err, "unable to change startup status")
@@ -417,14 +572,28 @@ func (obj *SvcRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 		return false, nil // success
 	}
 
-	obj.init.Logf("reloading...")
-
 	// From: https://www.freedesktop.org/software/systemd/man/latest/org.freedesktop.systemd1.html
 	// If a service is restarted that isn't running, it will be started
 	// unless the "Try" flavor is used in which case a service that isn't
 	// running is not affected by the restart. The "ReloadOrRestart" flavors
 	// attempt a reload if the unit supports it and use a restart otherwise.
-	if _, err := conn.ReloadOrTryRestartUnitContext(ctx, svc, SystemdUnitModeFail, result); err != nil {
+	if obj.ReloadOnRefresh == nil {
+		obj.init.Logf("reloading...")
+		_, err = conn.ReloadOrTryRestartUnitContext(ctx, svc, SystemdUnitModeFail, result)
+
+	} else if *obj.ReloadOnRefresh {
+		if !running { // a plain reload would fail if we're not running
+			obj.init.Logf("skipping reload, svc isn't running")
+			return false, nil
+		}
+		obj.init.Logf("reloading...")
+		_, err = conn.ReloadUnitContext(ctx, svc, SystemdUnitModeFail, result)
+
+	} else {
+		obj.init.Logf("restarting...")
+		_, err = conn.TryRestartUnitContext(ctx, svc, SystemdUnitModeFail, result)
+	}
+	if err != nil {
 		return false, errwrap.Wrapf(err, "failed to reload unit")
 	}
 
@@ -446,6 +615,134 @@ func (obj *SvcRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	return false, nil // success
 }
 
+// getUnitDir returns the local unit directory, with a trailing slash.
+func (obj *SvcRes) getUnitDir() (string, error) {
+	if obj.unitDir != "" {
+		return obj.unitDir, nil
+	}
+	return util.SystemdUnitDir(obj.Session)
+}
+
+// unitFile returns the path of the unit file.
+func (obj *SvcRes) unitFile(dir string) string {
+	return dir + fmt.Sprintf("%s.service", obj.Name()) // systemd name
+}
+
+// dropInFile returns the path of the drop-in file with this name.
+func (obj *SvcRes) dropInFile(dir, name string) string {
+	return obj.unitFile(dir) + ".d/" + name + ".conf" // the drop-in dir
+}
+
+// unitFiles returns the paths of the unit file and the drop-in files that we
+// manage, and the contents that each of them should have.
+func (obj *SvcRes) unitFiles() (map[string]string, error) {
+	files := make(map[string]string)
+	if obj.Content == nil && len(obj.DropIns) == 0 {
+		return files, nil
+	}
+	dir, err := obj.getUnitDir()
+	if err != nil {
+		return nil, err
+	}
+	if obj.Content != nil {
+		files[obj.unitFile(dir)] = *obj.Content
+	}
+	for name, content := range obj.DropIns {
+		files[obj.dropInFile(dir, name)] = content
+	}
+	return files, nil
+}
+
+// prevUnitFile returns the contents that the unit or drop-in file at this path
+// had before we ran, if they differ from what we're going to write. If the file
+// doesn't exist, then remove is true, since we're going to create it. A mask is
+// a symlink to /dev/null, which gets put back by the Startup instead.
+func (obj *SvcRes) prevUnitFile(p, content string) (prev *string, remove bool, err error) {
+	fi, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false, err
+	}
+	if s := string(data); s != content {
+		return &s, false, nil
+	}
+	return nil, false, nil // we won't change it
+}
+
+// unitFilesCheckApply performs a CheckApply on the unit file and the drop-ins.
+// It doesn't daemon-reload, the caller must do that if anything changed.
+func (obj *SvcRes) unitFilesCheckApply(ctx context.Context, apply bool) (bool, error) {
+	files, err := obj.unitFiles()
+	if err != nil {
+		return false, err
+	}
+	checkOK := true
+	for _, p := range util.StrMapKeys(files) { // deterministic order
+		// A masked unit is a symlink to /dev/null, which we must replace
+		// instead of reading through or writing through to it.
+		fi, err := os.Lstat(p)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		if err == nil && fi.Mode().IsRegular() {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return false, err
+			}
+			if bytes.Equal(data, []byte(files[p])) {
+				continue // the contents are correct
+			}
+		}
+		checkOK = false
+		if !apply {
+			return false, nil
+		}
+
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			return false, err
+		}
+		obj.init.Logf("writing: %s", p)
+		// Write atomically, since systemd could read it at any time.
+		if err := util.WriteFileAtomic(p, []byte(files[p]), 0644); err != nil {
+			return false, err
+		}
+	}
+	return checkOK, nil
+}
+
+// removeFilesCheckApply removes the unit and drop-in files that the reversal
+// found we created. It doesn't daemon-reload, the caller must do that if
+// anything changed.
+func (obj *SvcRes) removeFilesCheckApply(ctx context.Context, apply bool) (bool, error) {
+	checkOK := true
+	for _, p := range obj.RemoveFiles {
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			continue // it's already gone
+		} else if err != nil {
+			return false, err
+		}
+		checkOK = false
+		if !apply {
+			return false, nil
+		}
+
+		obj.init.Logf("removing: %s", p)
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return checkOK, nil
+}
+
 // Cmp compares two resources and returns an error if they are not equivalent.
 func (obj *SvcRes) Cmp(r engine.Res) error {
 	// we can only compare SvcRes to others of the same resource kind
@@ -464,9 +761,219 @@ func (obj *SvcRes) Cmp(r engine.Res) error {
 		return fmt.Errorf("the Session differs")
 	}
 
+	if (obj.Content == nil) != (res.Content == nil) { // xor
+		return fmt.Errorf("the Content differs")
+	}
+	if obj.Content != nil && res.Content != nil {
+		if *obj.Content != *res.Content { // compare the strings
+			return fmt.Errorf("the contents of Content differ")
+		}
+	}
+	if len(obj.DropIns) != len(res.DropIns) {
+		return fmt.Errorf("the number of DropIns differs")
+	}
+	for name, content := range obj.DropIns {
+		if x, exists := res.DropIns[name]; !exists || x != content {
+			return fmt.Errorf("the DropIn `%s` differs", name)
+		}
+	}
+	if (obj.ReloadOnRefresh == nil) != (res.ReloadOnRefresh == nil) { // xor
+		return fmt.Errorf("the ReloadOnRefresh differs")
+	}
+	if obj.ReloadOnRefresh != nil && res.ReloadOnRefresh != nil {
+		if *obj.ReloadOnRefresh != *res.ReloadOnRefresh {
+			return fmt.Errorf("the ReloadOnRefresh differs")
+		}
+	}
+	if len(obj.RemoveFiles) != len(res.RemoveFiles) {
+		return fmt.Errorf("the number of RemoveFiles differs")
+	}
+	for i, x := range obj.RemoveFiles {
+		if x != res.RemoveFiles[i] {
+			return fmt.Errorf("the RemoveFiles differ")
+		}
+	}
+
 	return nil
 }
 
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *SvcRes) Copy() engine.CopyableRes {
+	var content *string
+	if obj.Content != nil { // copy the string contents, not the pointer...
+		s := *obj.Content
+		content = &s
+	}
+	var dropIns map[string]string
+	if obj.DropIns != nil {
+		dropIns = make(map[string]string)
+		for name, x := range obj.DropIns {
+			dropIns[name] = x
+		}
+	}
+	var reloadOnRefresh *bool
+	if obj.ReloadOnRefresh != nil {
+		b := *obj.ReloadOnRefresh
+		reloadOnRefresh = &b
+	}
+	var removeFiles []string
+	if obj.RemoveFiles != nil {
+		removeFiles = make([]string, len(obj.RemoveFiles))
+		copy(removeFiles, obj.RemoveFiles)
+	}
+	return &SvcRes{
+		State:           obj.State,
+		Startup:         obj.Startup,
+		Session:         obj.Session,
+		Content:         content,
+		DropIns:         dropIns,
+		ReloadOnRefresh: reloadOnRefresh,
+		RemoveFiles:     removeFiles,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. The reverse
+// puts back the state and startup that the service had before we ran, but only
+// for the parts that we're going to change. The unit and drop-in files that we
+// change get their old contents back, and the ones we create get removed.
+func (obj *SvcRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*SvcRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	// This runs before CheckApply, so we can see what state the service is
+	// in. We only reverse what we're going to change so that we never stop
+	// or disable a service that was already running or enabled before.
+	if !systemdUtil.IsRunningSystemd() {
+		return nil, nil // we can't know what was there before
+	}
+	ctx := context.TODO()
+
+	var conn *systemd.Conn
+	if obj.Session {
+		conn, err = systemd.NewUserConnection() // user session
+	} else {
+		// we want NewSystemConnection but New falls back to this
+		conn, err = systemd.New() // needs root access
+	}
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "failed to connect to systemd")
+	}
+	defer conn.Close()
+
+	var svc = fmt.Sprintf("%s.service", obj.Name()) // systemd name
+
+	activestate, err := conn.GetUnitPropertyContext(ctx, svc, "ActiveState")
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "failed to get active state")
+	}
+	startupstate, err := conn.GetUnitPropertyContext(ctx, svc, "UnitFileState")
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "failed to get unit file state")
+	}
+	running := (activestate.Value == dbus.MakeVariant("active"))
+	startup, _ := startupstate.Value.Value().(string) // empty if not-found
+
+	return obj.reversal(res, running, startup)
+}
+
+// reversal builds the reverse of this resource into res from the running and
+// unit file state that the service had before we ran, and from the unit and
+// drop-in files on disk. It is split out of Reversed so that it can be tested
+// without systemd.
+func (obj *SvcRes) reversal(res *SvcRes, running bool, startup string) (engine.ReversibleRes, error) {
+	// A unit file state that we can't set ourselves, such as static, or a
+	// unit that doesn't exist yet, is the closest to disabled that we have.
+	if startup != "enabled" && startup != "masked" {
+		startup = "disabled"
+	}
+
+	// An undefined State or Startup stays undefined, since we never
+	// changed it in the first place. The same goes for one that already
+	// had the value that we want.
+	changed := false
+	res.State = ""
+	if obj.State == "running" && !running {
+		res.State = "stopped"
+		changed = true
+	}
+	if obj.State == "stopped" && running {
+		res.State = "running"
+		changed = true
+	}
+	res.Startup = ""
+	if obj.Startup != "" && obj.Startup != startup {
+		res.Startup = startup // put back exactly what was there before
+		changed = true
+	}
+
+	// The unit would otherwise keep our settings after we're gone, so the
+	// files that we change or create are put back the way they were.
+	res.Content = nil
+	res.DropIns = nil
+	res.RemoveFiles = nil
+	dir := ""
+	if obj.Content != nil || len(obj.DropIns) > 0 {
+		var err error
+		if dir, err = obj.getUnitDir(); err != nil {
+			return nil, err
+		}
+	}
+	if obj.Content != nil {
+		p := obj.unitFile(dir)
+		prev, remove, err := obj.prevUnitFile(p, *obj.Content)
+		if err != nil {
+			return nil, err
+		}
+		if remove {
+			res.RemoveFiles = append(res.RemoveFiles, p)
+		}
+		res.Content = prev
+	}
+	for _, name := range util.StrMapKeys(obj.DropIns) { // deterministic order
+		p := obj.dropInFile(dir, name)
+		prev, remove, err := obj.prevUnitFile(p, obj.DropIns[name])
+		if err != nil {
+			return nil, err
+		}
+		if remove {
+			res.RemoveFiles = append(res.RemoveFiles, p)
+		}
+		if prev == nil {
+			continue
+		}
+		if res.DropIns == nil {
+			res.DropIns = make(map[string]string)
+		}
+		res.DropIns[name] = *prev
+	}
+	if res.Content != nil || len(res.DropIns) > 0 || len(res.RemoveFiles) > 0 {
+		changed = true
+	}
+
+	if !changed {
+		return nil, nil // we won't change anything
+	}
+	if res.Startup == "masked" && res.State == "running" {
+		res.State = "" // it can't be both, so restoring the mask wins
+	}
+
+	return res, nil
+}
+
 // SvcUID is the UID struct for SvcRes.
 type SvcUID struct {
 	// NOTE: there is also a name variable in the BaseUID struct, this is
@@ -572,15 +1079,12 @@ func (obj *SvcRes) AutoEdges() (engine.AutoEdge, error) {
 	}
 	if obj.Session {
 		// user svc
-		u, err := user.Current()
+		dir, err := util.SystemdUnitDir(obj.Session)
 		if err != nil {
-			return nil, errwrap.Wrapf(err, "error getting current user")
-		}
-		if u.HomeDir == "" {
-			return nil, fmt.Errorf("user has no home directory")
+			return nil, err
 		}
 		svcFiles = []string{
-			path.Join(u.HomeDir, "/.config/systemd/user/", fmt.Sprintf("%s.service", obj.Name())),
+			dir + fmt.Sprintf("%s.service", obj.Name()),
 		}
 	}
 	for _, x := range svcFiles {
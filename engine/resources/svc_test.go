@@ -0,0 +1,473 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/purpleidea/mgmt/engine"
+)
+
+func TestSvcValidate1(t *testing.T) {
+	content := "[Unit]\n"
+	type test struct { // an individual test
+		name string
+		res  *SvcRes
+		fail bool
+	}
+	testCases := []test{
+		{"empty", &SvcRes{}, false},
+		{"content", &SvcRes{Startup: "enabled", Content: &content}, false},
+		{"masked", &SvcRes{Startup: "masked", State: "stopped"}, false},
+		{"masked drop-ins", &SvcRes{Startup: "masked", DropIns: map[string]string{"a": "b"}}, false},
+		{"masked running", &SvcRes{Startup: "masked", State: "running"}, true},
+		{"masked content", &SvcRes{Startup: "masked", Content: &content}, true},
+		{"empty drop-in", &SvcRes{DropIns: map[string]string{"": "b"}}, true},
+		{"drop-in path", &SvcRes{DropIns: map[string]string{"../a": "b"}}, true},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, fail := tc.name, tc.res, tc.fail
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			err := res.Validate()
+			if !fail && err != nil {
+				t.Errorf("validate failed with: %+v", err)
+				return
+			}
+			if fail && err == nil {
+				t.Errorf("validate passed, expected fail")
+				return
+			}
+		})
+	}
+}
+
+func TestSvcUnitFiles1(t *testing.T) {
+	content := "[Unit]\n"
+	type test struct { // an individual test
+		name string
+		res  *SvcRes
+		exp  map[string]string // relative to the unit dir
+	}
+	testCases := []test{
+		{
+			name: "unmanaged",
+			res:  &SvcRes{},
+			exp:  map[string]string{},
+		},
+		{
+			name: "content",
+			res:  &SvcRes{Content: &content},
+			exp: map[string]string{
+				"svc1.service": content,
+			},
+		},
+		{
+			name: "drop-ins",
+			res:  &SvcRes{DropIns: map[string]string{"a": "A", "b": "B"}},
+			exp: map[string]string{
+				"svc1.service.d/a.conf": "A",
+				"svc1.service.d/b.conf": "B",
+			},
+		},
+		{
+			name: "both",
+			res:  &SvcRes{Content: &content, DropIns: map[string]string{"a": "A"}},
+			exp: map[string]string{
+				"svc1.service":          content,
+				"svc1.service.d/a.conf": "A",
+			},
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, exp := tc.name, tc.res, tc.exp
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			dir := t.TempDir() + "/"
+			res.SetKind("svc")
+			res.SetName("svc1")
+			res.unitDir = dir
+
+			files, err := res.unitFiles()
+			if err != nil {
+				t.Errorf("unit files failed with: %+v", err)
+				return
+			}
+			out := make(map[string]string)
+			for p, s := range files {
+				out[strings.TrimPrefix(p, dir)] = s
+			}
+			if !reflect.DeepEqual(out, exp) {
+				t.Errorf("expected: %+v, got: %+v", exp, out)
+				return
+			}
+		})
+	}
+}
+
+func TestSvcUnitFilesCheckApply1(t *testing.T) {
+	content := "[Unit]\nDescription=test\n"
+	type test struct { // an individual test
+		name    string
+		res     *SvcRes
+		files   map[string]string // initial files relative to the unit dir
+		masked  []string          // initial symlinks to /dev/null
+		checkOK bool
+	}
+	testCases := []test{
+		{
+			name:    "unmanaged",
+			res:     &SvcRes{},
+			checkOK: true,
+		},
+		{
+			name:    "missing unit",
+			res:     &SvcRes{Content: &content},
+			checkOK: false,
+		},
+		{
+			name:    "correct unit",
+			res:     &SvcRes{Content: &content},
+			files:   map[string]string{"svc1.service": content},
+			checkOK: true,
+		},
+		{
+			name:    "wrong unit",
+			res:     &SvcRes{Content: &content},
+			files:   map[string]string{"svc1.service": "[Unit]\n"},
+			checkOK: false,
+		},
+		{
+			name:    "masked unit",
+			res:     &SvcRes{Content: &content},
+			masked:  []string{"svc1.service"},
+			checkOK: false,
+		},
+		{
+			name:    "masked empty unit",
+			res:     &SvcRes{Content: new(string)}, // reads as empty
+			masked:  []string{"svc1.service"},
+			checkOK: false,
+		},
+		{
+			name:    "missing drop-in",
+			res:     &SvcRes{DropIns: map[string]string{"a": "A", "b": "B"}},
+			files:   map[string]string{"svc1.service.d/a.conf": "A"},
+			checkOK: false,
+		},
+		{
+			name: "correct drop-ins",
+			res:  &SvcRes{DropIns: map[string]string{"a": "A"}},
+			files: map[string]string{
+				"svc1.service.d/a.conf":     "A",
+				"svc1.service.d/other.conf": "left alone",
+			},
+			checkOK: true,
+		},
+		{
+			name:    "wrong drop-in",
+			res:     &SvcRes{DropIns: map[string]string{"a": "A"}},
+			files:   map[string]string{"svc1.service.d/a.conf": "B"},
+			checkOK: false,
+		},
+		{
+			name:    "masked drop-ins",
+			res:     &SvcRes{Startup: "masked", DropIns: map[string]string{"a": "A"}},
+			masked:  []string{"svc1.service"},
+			checkOK: false,
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, files, masked, checkOK := tc.name, tc.res, tc.files, tc.masked, tc.checkOK
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			dir := t.TempDir() + "/"
+			for p, s := range files {
+				if err := os.MkdirAll(filepath.Dir(dir+p), 0755); err != nil {
+					t.Errorf("could not make dir: %+v", err)
+					return
+				}
+				if err := os.WriteFile(dir+p, []byte(s), 0644); err != nil {
+					t.Errorf("could not write file: %+v", err)
+					return
+				}
+			}
+			for _, p := range masked {
+				if err := os.Symlink("/dev/null", dir+p); err != nil {
+					t.Errorf("could not mask file: %+v", err)
+					return
+				}
+			}
+
+			res.SetKind("svc")
+			res.SetName("svc1")
+			res.unitDir = dir
+			if err := res.Validate(); err != nil {
+				t.Errorf("validate failed with: %+v", err)
+				return
+			}
+			if err := res.Init(&engine.Init{Logf: t.Logf}); err != nil {
+				t.Errorf("init failed with: %+v", err)
+				return
+			}
+			ctx := context.Background()
+
+			ok, err := res.unitFilesCheckApply(ctx, false)
+			if err != nil {
+				t.Errorf("check failed with: %+v", err)
+				return
+			}
+			if ok != checkOK {
+				t.Errorf("expected check of %t, got: %t", checkOK, ok)
+				return
+			}
+			if _, err := res.unitFilesCheckApply(ctx, true); err != nil {
+				t.Errorf("apply failed with: %+v", err)
+				return
+			}
+			if ok, err := res.unitFilesCheckApply(ctx, false); err != nil || !ok {
+				t.Errorf("expected converged, got: %t (%+v)", ok, err)
+				return
+			}
+
+			expected, err := res.unitFiles()
+			if err != nil {
+				t.Errorf("unit files failed with: %+v", err)
+				return
+			}
+			for p, s := range expected {
+				fi, err := os.Lstat(p)
+				if err != nil {
+					t.Errorf("could not stat: %+v", err)
+					return
+				}
+				if !fi.Mode().IsRegular() || fi.Mode().Perm() != 0644 {
+					t.Errorf("unexpected mode of %s: %s", p, fi.Mode())
+				}
+				b, err := os.ReadFile(p)
+				if err != nil {
+					t.Errorf("could not read: %+v", err)
+					return
+				}
+				if string(b) != s {
+					t.Errorf("expected %s to contain: %q, got: %q", p, s, string(b))
+				}
+			}
+			// Nothing else got touched, and no temporary files remain.
+			for p, s := range files {
+				if _, exists := expected[dir+p]; exists {
+					continue
+				}
+				if b, err := os.ReadFile(dir + p); err != nil || string(b) != s {
+					t.Errorf("expected %s to be left alone", p)
+				}
+			}
+			for _, p := range masked {
+				if _, exists := expected[dir+p]; exists {
+					continue
+				}
+				if target, err := os.Readlink(dir + p); err != nil || target != "/dev/null" {
+					t.Errorf("expected %s to stay masked", p)
+				}
+			}
+			matches, err := filepath.Glob(dir + "*/.*.tmp*")
+			if err != nil {
+				t.Errorf("glob failed with: %+v", err)
+				return
+			}
+			more, _ := filepath.Glob(dir + ".*.tmp*")
+			if matches = append(matches, more...); len(matches) > 0 {
+				t.Errorf("temporary files remain: %+v", matches)
+			}
+		})
+	}
+}
+
+func TestSvcReversal1(t *testing.T) {
+	type test struct { // an individual test
+		name    string
+		res     *SvcRes
+		running bool              // the state before we ran
+		startup string            // the unit file state before we ran
+		files   map[string]string // the files in the unit dir before we ran
+		exp     *SvcRes           // nil means that there is nothing to reverse
+	}
+	s := func(x string) *string { return &x }
+	testCases := []test{
+		{
+			name:    "start new",
+			res:     &SvcRes{State: "running", Startup: "enabled"},
+			running: false,
+			startup: "disabled",
+			exp:     &SvcRes{State: "stopped", Startup: "disabled"},
+		},
+		{
+			name:    "start existing",
+			res:     &SvcRes{State: "running", Startup: "enabled"},
+			running: true,
+			startup: "enabled",
+			exp:     nil, // it was there before us
+		},
+		{
+			name:    "enable running",
+			res:     &SvcRes{State: "running", Startup: "enabled"},
+			running: true,
+			startup: "disabled",
+			exp:     &SvcRes{Startup: "disabled"}, // we don't stop it
+		},
+		{
+			name:    "stop running",
+			res:     &SvcRes{State: "stopped"},
+			running: true,
+			startup: "enabled",
+			exp:     &SvcRes{State: "running"},
+		},
+		{
+			name:    "unmanaged",
+			res:     &SvcRes{},
+			running: true,
+			startup: "enabled",
+			exp:     nil,
+		},
+		{
+			name:    "mask enabled",
+			res:     &SvcRes{State: "stopped", Startup: "masked"},
+			running: false,
+			startup: "enabled",
+			exp:     &SvcRes{Startup: "enabled"},
+		},
+		{
+			name:    "unmask",
+			res:     &SvcRes{Startup: "disabled"},
+			running: false,
+			startup: "masked",
+			exp:     &SvcRes{Startup: "masked"},
+		},
+		{
+			name:    "enable static",
+			res:     &SvcRes{Startup: "enabled"},
+			running: false,
+			startup: "static",
+			exp:     &SvcRes{Startup: "disabled"},
+		},
+		{
+			name:    "disable missing",
+			res:     &SvcRes{Startup: "disabled"},
+			running: false,
+			startup: "", // not-found
+			exp:     nil,
+		},
+		{
+			name:    "create unit",
+			res:     &SvcRes{State: "running", Content: s("[Service]\n"), DropIns: map[string]string{"a": "x\n"}},
+			running: false,
+			startup: "", // not-found
+			exp:     &SvcRes{State: "stopped", RemoveFiles: []string{"svc1.service", "svc1.service.d/a.conf"}},
+		},
+		{
+			name:    "change unit",
+			res:     &SvcRes{Content: s("new\n"), DropIns: map[string]string{"a": "x\n", "b": "y\n"}},
+			running: true,
+			startup: "enabled",
+			files: map[string]string{
+				"svc1.service":          "old\n",
+				"svc1.service.d/a.conf": "x\n", // unchanged
+				"svc1.service.d/b.conf": "z\n",
+			},
+			exp: &SvcRes{Content: s("old\n"), DropIns: map[string]string{"b": "z\n"}},
+		},
+		{
+			name:    "unchanged unit",
+			res:     &SvcRes{Content: s("same\n")},
+			running: true,
+			startup: "enabled",
+			files: map[string]string{
+				"svc1.service": "same\n",
+			},
+			exp: nil,
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, running, startup, files, exp := tc.name, tc.res, tc.running, tc.startup, tc.files, tc.exp
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			dir := t.TempDir() + "/"
+			for p, content := range files {
+				if err := os.MkdirAll(filepath.Dir(dir+p), 0755); err != nil {
+					t.Errorf("error making dir: %v", err)
+					return
+				}
+				if err := os.WriteFile(dir+p, []byte(content), 0644); err != nil {
+					t.Errorf("error writing file: %v", err)
+					return
+				}
+			}
+			res.SetKind("svc")
+			res.SetName("svc1")
+			res.unitDir = dir
+			rev, err := res.reversal(res.Copy().(*SvcRes), running, startup)
+			if err != nil {
+				t.Errorf("reversal failed with: %v", err)
+				return
+			}
+			if exp == nil {
+				if rev != nil {
+					t.Errorf("expected no reversal, got: %+v", rev)
+				}
+				return
+			}
+			r, ok := rev.(*SvcRes)
+			if !ok {
+				t.Errorf("expected a reversal, got: %+v", rev)
+				return
+			}
+			if r.State != exp.State || r.Startup != exp.Startup {
+				t.Errorf("expected: %s/%s, got: %s/%s", exp.State, exp.Startup, r.State, r.Startup)
+			}
+			for i := range exp.RemoveFiles {
+				exp.RemoveFiles[i] = dir + exp.RemoveFiles[i]
+			}
+			if err := r.Cmp(exp); err != nil {
+				t.Errorf("reversal differs: %v", err)
+			}
+			if err := r.Validate(); err != nil {
+				t.Errorf("reversal is not valid: %v", err)
+			}
+		})
+	}
+}
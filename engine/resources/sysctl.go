@@ -70,6 +70,7 @@ const (
 // /etc/sysctl.d/ and optionally blanks out the stock /etc/sysctl.conf file too.
 type SysctlRes struct {
 	traits.Base // add the base methods without re-implementation
+	traits.Reversible
 
 	init *engine.Init
 
@@ -100,6 +101,16 @@ type SysctlRes struct {
 	// a dash. For example: /etc/sysctl.d/10-dmesg.conf for example. If this
 	// is omitted, the filename will be chosen automatically.
 	Filename string `lang:"path" yaml:"path"`
+
+	// PersistContent is only set by the reversal, and it can't be set from
+	// the language. It's the previous content of the persistence file, which
+	// is put back as is, instead of the line with the value.
+	PersistContent *string `yaml:"-"`
+
+	// PersistRemove is only set by the reversal, and it can't be set from
+	// the language. It removes the persistence file, which we created, and
+	// it can only be used when Persist is false.
+	PersistRemove bool `yaml:"-"`
 }
 
 // toPath converts our name into the magic kernel path. It does not validate
@@ -150,9 +161,15 @@ func (obj *SysctlRes) Validate() error {
 	}
 
 	// TODO: We could probably relax this check I suppose.
-	if !obj.Runtime && !obj.Persist {
+	if !obj.Runtime && !obj.Persist && !obj.PersistRemove {
 		return fmt.Errorf("you must either set the value at runtime or you must persist")
 	}
+	if obj.PersistContent != nil && !obj.Persist {
+		return fmt.Errorf("the PersistContent can only be used with Persist")
+	}
+	if obj.PersistRemove && obj.Persist {
+		return fmt.Errorf("the PersistRemove can't be used with Persist")
+	}
 
 	// Parse the Name() and see if it's a valid path under /proc/sys/ dir.
 	if _, err := os.Stat(obj.toPath()); err != nil && !os.IsNotExist(err) {
@@ -164,10 +181,11 @@ func (obj *SysctlRes) Validate() error {
 		return fmt.Errorf("name is not a valid kernel path: %s", obj.toPath())
 	}
 
-	if obj.Persist && !strings.HasSuffix(obj.getFilename(), ".conf") {
+	persist := obj.Persist || obj.PersistRemove
+	if persist && !strings.HasSuffix(obj.getFilename(), ".conf") {
 		return fmt.Errorf("filename must end with .conf")
 	}
-	if obj.Persist && !strings.HasPrefix(obj.getFilename(), "/") {
+	if persist && !strings.HasPrefix(obj.getFilename(), "/") {
 		return fmt.Errorf("filename must be absolute and start with slash")
 	}
 
@@ -311,6 +329,9 @@ func (obj *SysctlRes) runtimeCheckApply(ctx context.Context, apply bool) (bool,
 // persistCheckApply checks the on-disk value for the kernel, and modifies it if
 // needed.
 func (obj *SysctlRes) persistCheckApply(ctx context.Context, apply bool) (bool, error) {
+	if obj.PersistRemove {
+		return obj.persistRemoveCheckApply(ctx, apply)
+	}
 	if !obj.Persist {
 		return true, nil
 	}
@@ -318,6 +339,9 @@ func (obj *SysctlRes) persistCheckApply(ctx context.Context, apply bool) (bool,
 	// Clean off any whitespace and put it in the standard format.
 	// TODO: Should we add a "last managed by mgmt on $date" line ?
 	s := fmt.Sprintf("%s = %s\n", obj.Name(), obj.Value)
+	if obj.PersistContent != nil { // put back what was there before
+		s = *obj.PersistContent
+	}
 	expected := []byte(s)
 
 	b, err := os.ReadFile(obj.getFilename())
@@ -344,6 +368,53 @@ func (obj *SysctlRes) persistCheckApply(ctx context.Context, apply bool) (bool,
 	return false, nil
 }
 
+// persistRemoveCheckApply removes the persistence file if it exists. It's used
+// by the reversal to remove a file that we created.
+func (obj *SysctlRes) persistRemoveCheckApply(ctx context.Context, apply bool) (bool, error) {
+	_, err := os.Stat(obj.getFilename())
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !apply {
+		return false, nil
+	}
+
+	if err := os.Remove(obj.getFilename()); err != nil {
+		return false, err
+	}
+
+	obj.init.Logf("removed: %s\n", obj.getFilename())
+
+	return false, nil
+}
+
+// Diff returns the list of differences between the current runtime value and
+// the one we want. It is used by the plan mode.
+func (obj *SysctlRes) Diff(ctx context.Context) ([]*engine.PlanDiff, error) {
+	diffs := []*engine.PlanDiff{}
+	if !obj.Runtime {
+		return diffs, nil
+	}
+
+	b, err := os.ReadFile(obj.toPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if have := strings.TrimSpace(string(b)); have != obj.Value {
+		diffs = append(diffs, &engine.PlanDiff{
+			Field: "value",
+			Have:  have,
+			Want:  obj.Value,
+		})
+	}
+
+	return diffs, nil
+}
+
 // Cmp compares two resources and returns an error if they are not equivalent.
 func (obj *SysctlRes) Cmp(r engine.Res) error {
 	// we can only compare SysctlRes to others of the same resource kind
@@ -370,9 +441,101 @@ func (obj *SysctlRes) Cmp(r engine.Res) error {
 		return fmt.Errorf("the contents of Filename differ")
 	}
 
+	if (obj.PersistContent == nil) != (res.PersistContent == nil) { // xor
+		return fmt.Errorf("the PersistContent differs")
+	}
+	if obj.PersistContent != nil && *obj.PersistContent != *res.PersistContent {
+		return fmt.Errorf("the contents of PersistContent differ")
+	}
+	if obj.PersistRemove != res.PersistRemove {
+		return fmt.Errorf("the PersistRemove value differs")
+	}
+
 	return nil
 }
 
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *SysctlRes) Copy() engine.CopyableRes {
+	var persistContent *string
+	if obj.PersistContent != nil { // copy the string contents, not the pointer...
+		s := *obj.PersistContent
+		persistContent = &s
+	}
+	return &SysctlRes{
+		Value:          obj.Value,
+		Runtime:        obj.Runtime,
+		Persist:        obj.Persist,
+		Filename:       obj.Filename,
+		PersistContent: persistContent,
+		PersistRemove:  obj.PersistRemove,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. The reverse
+// sets the value that the kernel had before we ran. If we persisted the value,
+// then the persistence file gets the content it had before, or it is removed if
+// we created it, so that a reboot doesn't bring back the value we're removing.
+// If nothing is going to change, then there is nothing to reverse.
+func (obj *SysctlRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*SysctlRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	// The kernel value is the previous value for both runtime and persist,
+	// since any persisted file was (hopefully) applied when we booted.
+	b, err := os.ReadFile(obj.toPath())
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not read value for reversal storage")
+	}
+	value := strings.TrimSpace(string(b)) // remove the trailing newline
+	res.Value = value
+
+	// This runs before CheckApply, so if something already has our value,
+	// then either it was like this before we ran, or we set it in a past
+	// run, and the reversal from that run is already stored. Either way,
+	// we don't want to store the value we're setting as the previous one.
+	changed := obj.Runtime && value != obj.Value
+
+	res.PersistContent = nil
+	res.PersistRemove = false
+	if obj.Persist {
+		expected := fmt.Sprintf("%s = %s\n", obj.Name(), obj.Value)
+		content, err := os.ReadFile(obj.getFilename())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errwrap.Wrapf(err, "could not read the persistence file for reversal storage")
+		}
+		if os.IsNotExist(err) { // we create it, so we remove it again
+			res.Persist = false
+			res.PersistRemove = true
+			changed = true
+		} else if s := string(content); s != expected {
+			res.PersistContent = &s // put back exactly what was there
+			changed = true
+		} else {
+			res.Persist = false // we won't change it
+		}
+	}
+
+	if !changed {
+		return nil, nil // we won't change anything
+	}
+
+	return res, nil
+}
+
 // UnmarshalYAML is the custom unmarshal handler for this struct. It is
 // primarily useful for setting the defaults.
 func (obj *SysctlRes) UnmarshalYAML(unmarshal func(interface{}) error) error {
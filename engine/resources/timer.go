@@ -32,10 +32,22 @@ package resources
 import (
 	"context"
 	"fmt"
+	"math"
+	"math/rand"
 	"time"
 
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/engine/traits"
+	"github.com/purpleidea/mgmt/util/cron"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// TimerBackoffLinear grows the interval by the Interval each time.
+	TimerBackoffLinear = "linear"
+
+	// TimerBackoffExponential doubles the interval each time.
+	TimerBackoffExponential = "exponential"
 )
 
 func init() {
@@ -43,7 +55,8 @@ func init() {
 }
 
 // TimerRes is a timer resource for time based events. It outputs an event every
-// interval seconds.
+// interval seconds, or whenever the cron schedule matches. This all happens in
+// process, and unlike with the cron resource, systemd isn't used.
 type TimerRes struct {
 	traits.Base // add the base methods without re-implementation
 	traits.Refreshable
@@ -53,7 +66,30 @@ type TimerRes struct {
 	// Interval between runs in seconds.
 	Interval uint32 `lang:"interval" yaml:"interval"`
 
-	ticker *time.Ticker
+	// Cron is a cron style schedule expression, such as "*/5 * * * *". If
+	// this is specified, then the events happen on this schedule instead
+	// of every Interval seconds, and the Interval must be zero. The usual
+	// five fields and the macros like "@hourly" are supported. The times
+	// are in the local timezone.
+	Cron string `lang:"cron" yaml:"cron"`
+
+	// Jitter is the maximum number of seconds of random delay which are
+	// added to each interval. This is useful to avoid a thundering herd,
+	// when many hosts in a cluster all have the same timer.
+	Jitter uint32 `lang:"jitter" yaml:"jitter"`
+
+	// Backoff is the algorithm that grows the interval after each event.
+	// It can be "linear" or "exponential". If it is empty, then the
+	// interval stays the same. A refresh resets it back to the Interval.
+	// It can't be used with Cron.
+	Backoff string `lang:"backoff" yaml:"backoff"`
+
+	// BackoffMax is the maximum interval in seconds that the Backoff can
+	// grow to. If it is zero, then there is no maximum.
+	BackoffMax uint32 `lang:"backoff_max" yaml:"backoff_max"`
+
+	schedule *cron.Schedule
+	reset    chan struct{}
 }
 
 // Default returns some sensible defaults for this resource.
@@ -63,6 +99,33 @@ func (obj *TimerRes) Default() engine.Res {
 
 // Validate the params that are passed to TimerRes.
 func (obj *TimerRes) Validate() error {
+	if obj.Cron == "" && obj.Interval == 0 {
+		return fmt.Errorf("the Interval must be positive")
+	}
+	if obj.Cron != "" {
+		if obj.Interval != 0 {
+			return fmt.Errorf("the Interval can't be used with Cron")
+		}
+		if _, err := cron.Parse(obj.Cron); err != nil {
+			return errwrap.Wrapf(err, "invalid Cron")
+		}
+		if obj.Backoff != "" {
+			return fmt.Errorf("the Backoff can't be used with Cron")
+		}
+	}
+
+	if obj.Backoff != "" && obj.Backoff != TimerBackoffLinear && obj.Backoff != TimerBackoffExponential {
+		return fmt.Errorf("invalid Backoff: %s", obj.Backoff)
+	}
+	if obj.BackoffMax != 0 {
+		if obj.Backoff == "" {
+			return fmt.Errorf("the BackoffMax requires a Backoff")
+		}
+		if obj.BackoffMax < obj.Interval {
+			return fmt.Errorf("the BackoffMax can't be less than the Interval")
+		}
+	}
+
 	return nil
 }
 
@@ -70,6 +133,15 @@ func (obj *TimerRes) Validate() error {
 func (obj *TimerRes) Init(init *engine.Init) error {
 	obj.init = init // save for later
 
+	if obj.Cron != "" {
+		schedule, err := cron.Parse(obj.Cron)
+		if err != nil {
+			return errwrap.Wrapf(err, "invalid Cron")
+		}
+		obj.schedule = schedule
+	}
+	obj.reset = make(chan struct{}, 1) // buffer one reset
+
 	return nil
 }
 
@@ -78,23 +150,96 @@ func (obj *TimerRes) Cleanup() error {
 	return nil
 }
 
-// newTicker creates a new ticker
-func (obj *TimerRes) newTicker() *time.Ticker {
-	return time.NewTicker(time.Duration(obj.Interval) * time.Second)
+// timerMaxDelay is the largest delay that the timer will ever return.
+const timerMaxDelay = time.Duration(math.MaxInt64)
+
+// delay returns how long to wait until the next event. The count is the number
+// of events since the start or the last reset, which the Backoff uses. The now
+// time is what the Cron schedule is based on.
+func (obj *TimerRes) delay(count uint, now time.Time) time.Duration {
+	var d time.Duration
+	if obj.schedule != nil {
+		next := obj.schedule.Next(now)
+		if next.IsZero() { // it never matches
+			return -1
+		}
+		d = next.Sub(now)
+
+	} else {
+		interval := time.Duration(obj.Interval) * time.Second
+		d = interval
+		// saturate at the largest duration instead of overflowing, so a
+		// long running timer never wraps around to a negative delay
+		switch obj.Backoff {
+		case TimerBackoffLinear:
+			if n := time.Duration(count) + 1; n <= 0 || interval > timerMaxDelay/n {
+				d = timerMaxDelay
+			} else {
+				d = interval * n
+			}
+		case TimerBackoffExponential:
+			if count >= 63 || interval > timerMaxDelay>>count {
+				d = timerMaxDelay
+			} else {
+				d = interval << count
+			}
+		}
+		if m := time.Duration(obj.BackoffMax) * time.Second; m > 0 && d > m {
+			d = m
+		}
+	}
+
+	if obj.Jitter > 0 {
+		j := time.Duration(rand.Int63n(int64(obj.Jitter)*int64(time.Second) + 1))
+		if d > timerMaxDelay-j {
+			return timerMaxDelay
+		}
+		d += j
+	}
+	return d
 }
 
 // Watch is the primary listener for this resource and it outputs events.
 func (obj *TimerRes) Watch(ctx context.Context) error {
-	// create a time.Ticker for the given interval
-	obj.ticker = obj.newTicker()
-	defer obj.ticker.Stop()
+	var count uint
+	var timer *time.Timer
+	var ch <-chan time.Time // a nil channel blocks forever
+	start := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		d := obj.delay(count, time.Now())
+		if d < 0 {
+			obj.init.Logf("the schedule never matches")
+			timer, ch = nil, nil
+			return
+		}
+		if obj.init.Debug {
+			obj.init.Logf("next tick in: %s", d)
+		}
+		timer = time.NewTimer(d)
+		ch = timer.C
+	}
+	start()
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
 
 	obj.init.Running() // when started, notify engine that we're running
 
 	for {
 		select {
-		case <-obj.ticker.C: // received the timer event
+		case <-ch: // received the timer event
 			obj.init.Logf("received tick")
+			count++
+			start()
+
+		case <-obj.reset: // a refresh happened
+			count = 0
+			start()
+			continue
 
 		case <-ctx.Done(): // closed by the engine to signal shutdown
 			return nil
@@ -115,8 +260,10 @@ func (obj *TimerRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 	}
 
 	// reset the timer since apply && refresh
-	obj.ticker.Stop()
-	obj.ticker = obj.newTicker()
+	select {
+	case obj.reset <- struct{}{}:
+	default: // a reset is already pending
+	}
 	return false, nil
 }
 
@@ -131,6 +278,18 @@ func (obj *TimerRes) Cmp(r engine.Res) error {
 	if obj.Interval != res.Interval {
 		return fmt.Errorf("the Interval differs")
 	}
+	if obj.Cron != res.Cron {
+		return fmt.Errorf("the Cron differs")
+	}
+	if obj.Jitter != res.Jitter {
+		return fmt.Errorf("the Jitter differs")
+	}
+	if obj.Backoff != res.Backoff {
+		return fmt.Errorf("the Backoff differs")
+	}
+	if obj.BackoffMax != res.BackoffMax {
+		return fmt.Errorf("the BackoffMax differs")
+	}
 
 	return nil
 }
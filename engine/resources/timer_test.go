@@ -0,0 +1,194 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package resources
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/purpleidea/mgmt/engine"
+)
+
+func TestTimerDelay1(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		res  *TimerRes
+		now  time.Time
+		exp  []time.Duration // expected delays for each count
+	}
+	now := time.Date(2024, 3, 4, 10, 20, 30, 0, time.Local)
+	testCases := []test{
+		{
+			name: "plain",
+			res:  &TimerRes{Interval: 10},
+			exp:  []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+		{
+			name: "linear",
+			res:  &TimerRes{Interval: 10, Backoff: TimerBackoffLinear},
+			exp:  []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second},
+		},
+		{
+			name: "exponential",
+			res:  &TimerRes{Interval: 10, Backoff: TimerBackoffExponential},
+			exp:  []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second},
+		},
+		{
+			name: "exponential max",
+			res:  &TimerRes{Interval: 10, Backoff: TimerBackoffExponential, BackoffMax: 30},
+			exp:  []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+		{
+			name: "cron",
+			res:  &TimerRes{Cron: "*/15 * * * *"},
+			now:  now,
+			exp:  []time.Duration{9*time.Minute + 30*time.Second, 9*time.Minute + 30*time.Second},
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, now, exp := tc.name, tc.res, tc.now, tc.exp
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			if err := res.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			if err := res.Init(&engine.Init{Logf: t.Logf}); err != nil {
+				t.Errorf("init failed with: %v", err)
+				return
+			}
+			for i, d := range exp {
+				if got := res.delay(uint(i), now); got != d {
+					t.Errorf("count %d: expected: %s, got: %s", i, d, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTimerDelay2(t *testing.T) {
+	type test struct { // an individual test
+		name   string
+		res    *TimerRes
+		counts []uint        // large counts which would overflow
+		exp    time.Duration // expected delay for each of the counts
+	}
+	exponential := []uint{28, 32, 33, 62, 63, 64, 1000, math.MaxUint32, math.MaxUint}
+	linear := []uint{math.MaxUint32 << 8, math.MaxUint >> 1, math.MaxUint}
+	testCases := []test{
+		{
+			name:   "exponential max",
+			res:    &TimerRes{Interval: 60, Backoff: TimerBackoffExponential, BackoffMax: 3600},
+			counts: exponential,
+			exp:    time.Hour,
+		},
+		{
+			name:   "exponential",
+			res:    &TimerRes{Interval: 60, Backoff: TimerBackoffExponential},
+			counts: exponential,
+			exp:    timerMaxDelay,
+		},
+		{
+			name:   "linear max",
+			res:    &TimerRes{Interval: 60, Backoff: TimerBackoffLinear, BackoffMax: 3600},
+			counts: linear,
+			exp:    time.Hour,
+		},
+		{
+			name:   "linear",
+			res:    &TimerRes{Interval: 60, Backoff: TimerBackoffLinear},
+			counts: linear,
+			exp:    timerMaxDelay,
+		},
+		{
+			name:   "exponential jitter",
+			res:    &TimerRes{Interval: 60, Backoff: TimerBackoffExponential, Jitter: 5},
+			counts: exponential,
+			exp:    timerMaxDelay,
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		name, res, counts, exp := tc.name, tc.res, tc.counts, tc.exp
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			if err := res.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			if err := res.Init(&engine.Init{Logf: t.Logf}); err != nil {
+				t.Errorf("init failed with: %v", err)
+				return
+			}
+			for _, count := range counts {
+				if got := res.delay(count, time.Now()); got != exp {
+					t.Errorf("count %d: expected: %s, got: %s", count, exp, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTimerJitter1(t *testing.T) {
+	res := &TimerRes{Interval: 10, Jitter: 5}
+	if err := res.Validate(); err != nil {
+		t.Errorf("validate failed with: %v", err)
+		return
+	}
+	if err := res.Init(&engine.Init{Logf: t.Logf}); err != nil {
+		t.Errorf("init failed with: %v", err)
+		return
+	}
+	for i := 0; i < 100; i++ {
+		d := res.delay(0, time.Now())
+		if d < 10*time.Second || d > 15*time.Second {
+			t.Errorf("delay out of range: %s", d)
+		}
+	}
+}
+
+func TestTimerValidate1(t *testing.T) {
+	for index, res := range []*TimerRes{
+		{},
+		{Interval: 10, Cron: "@hourly"},
+		{Cron: "* * *"},
+		{Cron: "@hourly", Backoff: TimerBackoffLinear},
+		{Interval: 10, Backoff: "quadratic"},
+		{Interval: 10, BackoffMax: 20},
+		{Interval: 10, Backoff: TimerBackoffLinear, BackoffMax: 5},
+	} {
+		if err := res.Validate(); err == nil {
+			t.Errorf("test #%d: expected validate error, got nil", index)
+		}
+	}
+}
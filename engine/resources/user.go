@@ -55,6 +55,7 @@ func init() {
 type UserRes struct {
 	traits.Base // add the base methods without re-implementation
 	traits.Edgeable
+	traits.Reversible
 
 	init *engine.Init
 
@@ -87,6 +88,11 @@ type UserRes struct {
 	// resources of the same UID. See the --non-unique flag in `useradd`.
 	AllowDuplicateUID bool `lang:"allowduplicateuid" yaml:"allowduplicateuid"`
 
+	// NoGroups is only set by the reversal, and it can't be set from the
+	// language. It removes all of the supplemental groups, since an empty
+	// list of Groups is lost when the reversal gets stored.
+	NoGroups bool `yaml:"-"`
+
 	recWatcher *recwatch.RecWatcher
 }
 
@@ -115,6 +121,9 @@ func (obj *UserRes) Validate() error {
 			}
 		}
 	}
+	if obj.NoGroups && len(obj.Groups) > 0 {
+		return fmt.Errorf("cannot use both Groups and NoGroups")
+	}
 	if obj.Groups != nil {
 		for _, group := range obj.Groups {
 			if group == "" {
@@ -229,6 +238,15 @@ func (obj *UserRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 		if obj.Shell != nil && *obj.Shell != shell {
 			usercheck = false
 		}
+		if obj.Groups != nil || obj.NoGroups {
+			groups, err := userGroups(usr)
+			if err != nil {
+				return false, err
+			}
+			if util.SortedStrSliceCompare(obj.Groups, groups) != nil {
+				usercheck = false
+			}
+		}
 		if usercheck {
 			return true, nil
 		}
@@ -260,7 +278,7 @@ func (obj *UserRes) CheckApply(ctx context.Context, apply bool) (bool, error) {
 		if obj.Group != nil {
 			args = append(args, "--gid", *obj.Group)
 		}
-		if obj.Groups != nil {
+		if obj.Groups != nil || obj.NoGroups {
 			args = append(args, "--groups", strings.Join(obj.Groups, ","))
 		}
 		if obj.HomeDir != nil {
@@ -371,9 +389,226 @@ func (obj *UserRes) Cmp(r engine.Res) error {
 	if obj.AllowDuplicateUID != res.AllowDuplicateUID {
 		return fmt.Errorf("the AllowDuplicateUID differs")
 	}
+	if obj.NoGroups != res.NoGroups {
+		return fmt.Errorf("the NoGroups differs")
+	}
 	return nil
 }
 
+// Copy copies the resource. Don't call it directly, use engine.ResCopy instead.
+// TODO: should this copy internal state?
+func (obj *UserRes) Copy() engine.CopyableRes {
+	var uid, gid *uint32
+	if obj.UID != nil { // copy the values, not the pointers...
+		x := *obj.UID
+		uid = &x
+	}
+	if obj.GID != nil {
+		x := *obj.GID
+		gid = &x
+	}
+	var group, homedir, shell *string
+	if obj.Group != nil {
+		s := *obj.Group
+		group = &s
+	}
+	if obj.HomeDir != nil {
+		s := *obj.HomeDir
+		homedir = &s
+	}
+	if obj.Shell != nil {
+		s := *obj.Shell
+		shell = &s
+	}
+	var groups []string
+	if obj.Groups != nil {
+		groups = []string{}
+		for _, x := range obj.Groups {
+			groups = append(groups, x)
+		}
+	}
+	return &UserRes{
+		State:             obj.State,
+		UID:               uid,
+		GID:               gid,
+		Group:             group,
+		Groups:            groups,
+		HomeDir:           homedir,
+		Shell:             shell,
+		AllowDuplicateUID: obj.AllowDuplicateUID,
+		NoGroups:          obj.NoGroups,
+	}
+}
+
+// Reversed returns the "reverse" or "reciprocal" resource. This is used to
+// "clean" up after a previously defined resource has been removed. A user that
+// we add gets deleted, and a user that we delete gets restored with the uid,
+// gid, supplemental groups, home directory and shell it had before we ran. If
+// we change a user that was already there, then only the properties that we
+// change get put back.
+func (obj *UserRes) Reversed() (engine.ReversibleRes, error) {
+	cp, err := engine.ResCopy(obj)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not copy")
+	}
+	rev, ok := cp.(engine.ReversibleRes)
+	if !ok {
+		return nil, fmt.Errorf("not reversible")
+	}
+	rev.ReversibleMeta().Disabled = true // the reverse shouldn't run again
+
+	res, ok := cp.(*UserRes)
+	if !ok {
+		return nil, fmt.Errorf("copied res was not our kind")
+	}
+
+	// This runs before CheckApply, so we can see if the user is already
+	// there. We never delete a user that existed before we ran, even if we
+	// changed some of its properties.
+	usr, err := user.Lookup(obj.Name())
+	if _, ok := err.(user.UnknownUserError); !ok && err != nil {
+		return nil, errwrap.Wrapf(err, "error looking up user for reversal")
+	}
+	exists := err == nil
+
+	if obj.State == "exists" && !exists {
+		res.State = "absent"
+		res.UID = nil // userdel doesn't take any of these...
+		res.GID = nil
+		res.Group = nil
+		res.Groups = nil
+		res.HomeDir = nil
+		res.Shell = nil
+		res.NoGroups = false
+		return res, nil
+	}
+
+	if !exists {
+		return nil, nil // it didn't exist, so there's nothing to restore
+	}
+
+	// Down here, the user is there, so look up what it is right now.
+	prev, err := userState(context.TODO(), usr)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.State == "absent" {
+		if len(prev.Groups) == 0 {
+			prev.Groups = nil // useradd doesn't need an empty list
+		}
+		res.State = "exists"
+		res.UID = prev.UID
+		res.GID = prev.GID
+		res.Group = nil // we use the GID instead, they can't both be set
+		res.Groups = prev.Groups
+		res.HomeDir = prev.HomeDir
+		res.Shell = prev.Shell
+		res.NoGroups = false
+		return res, nil
+	}
+
+	// We're changing a user that was already there, so we only put back
+	// the properties that are going to change.
+	changed := false
+	res.UID = nil
+	if obj.UID != nil && *obj.UID != *prev.UID {
+		res.UID = prev.UID
+		changed = true
+	}
+	res.GID = nil
+	if obj.GID != nil && *obj.GID != *prev.GID {
+		res.GID = prev.GID
+		changed = true
+	}
+	res.Group = nil // we use the GID instead, they can't both be set
+	if obj.Group != nil && *obj.Group != *prev.Group {
+		res.GID = prev.GID
+		changed = true
+	}
+	res.Groups = nil
+	res.NoGroups = false
+	if obj.Groups != nil && util.SortedStrSliceCompare(obj.Groups, prev.Groups) != nil {
+		res.Groups = prev.Groups
+		res.NoGroups = len(prev.Groups) == 0
+		changed = true
+	}
+	res.HomeDir = nil
+	if obj.HomeDir != nil && *obj.HomeDir != *prev.HomeDir {
+		res.HomeDir = prev.HomeDir
+		changed = true
+	}
+	res.Shell = nil
+	if obj.Shell != nil && *obj.Shell != *prev.Shell {
+		res.Shell = prev.Shell
+		changed = true
+	}
+	if !changed {
+		return nil, nil // we won't change anything
+	}
+
+	return res, nil
+}
+
+// userState returns a resource with all of the properties that the user has
+// right now. The primary group is in both the GID and the Group fields.
+func userState(ctx context.Context, usr *user.User) (*UserRes, error) {
+	uid, err := strconv.ParseUint(usr.Uid, 10, 32)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error parsing UID")
+	}
+	gid, err := strconv.ParseUint(usr.Gid, 10, 32)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error parsing GID")
+	}
+	grp, err := user.LookupGroupId(usr.Gid)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error looking up the primary group")
+	}
+	groups, err := userGroups(usr)
+	if err != nil {
+		return nil, err
+	}
+	shell, err := util.UserShell(ctx, usr.Username)
+	if err != nil {
+		return nil, err
+	}
+	uid32, gid32 := uint32(uid), uint32(gid)
+	group, homedir := grp.Name, usr.HomeDir
+
+	return &UserRes{
+		State:   "exists",
+		UID:     &uid32,
+		GID:     &gid32,
+		Group:   &group,
+		Groups:  groups,
+		HomeDir: &homedir,
+		Shell:   &shell,
+	}, nil
+}
+
+// userGroups returns the sorted names of the supplemental groups of the user.
+// The primary group isn't included.
+func userGroups(usr *user.User) ([]string, error) {
+	gids, err := usr.GroupIds()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "error looking up the groups of the user")
+	}
+	groups := []string{}
+	for _, gid := range gids {
+		if gid == usr.Gid {
+			continue // the primary group
+		}
+		grp, err := user.LookupGroupId(gid)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "error looking up group: %s", gid)
+		}
+		groups = append(groups, grp.Name)
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
 // UserUID is the UID struct for UserRes.
 type UserUID struct {
 	engine.BaseUID
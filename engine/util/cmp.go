@@ -61,3 +61,18 @@ func StrListCmp(x, y []string) error {
 
 	return nil
 }
+
+// IntListCmp compares two lists of ints. If they are not the same length or do
+// not contain identical ints in the same order, then this errors.
+func IntListCmp(x, y []int) error {
+	if len(x) != len(y) {
+		return fmt.Errorf("the length differs")
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return fmt.Errorf("the elements at position %d differ", i)
+		}
+	}
+
+	return nil
+}
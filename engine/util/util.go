@@ -337,6 +337,12 @@ func ResToParamValues(res engine.Res) (map[string]types.Value, error) {
 			continue // skip zero values
 		}
 
+		// Some fields store the mcl value directly, so pass them on.
+		if val, ok := rval.Interface().(types.Value); ok {
+			ret[name] = val
+			continue
+		}
+
 		// TODO: consider turning this into types.ConfigurableValueOf
 		// and allowing the `kind == reflect.Interface` option?
 		val, err := types.ValueOf(rval)
@@ -33,6 +33,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/purpleidea/mgmt/etcd/deployer"
 	"github.com/purpleidea/mgmt/etcd/interfaces"
 	"github.com/purpleidea/mgmt/etcd/scheduler"
 )
@@ -86,15 +87,46 @@ type FsWorld interface {
 type DeployWorld interface {
 	WatchDeploy(context.Context) (chan error, error)
 
-	// TODO: currently unused, but already implemented
-	//GetDeploys(ctx context.Context) (map[uint64]string, error)
+	GetDeploys(ctx context.Context) (map[uint64]string, error)
 
 	GetDeploy(ctx context.Context, id uint64) (string, error)
 
+	// GetDeployHash returns the hash that was stored with the deploy.
+	GetDeployHash(ctx context.Context, id uint64) (string, error)
+
+	// GetDeploySignature returns the signature of a deploy, or empty if it
+	// wasn't signed.
+	GetDeploySignature(ctx context.Context, id uint64) (string, error)
+
 	GetMaxDeployID(ctx context.Context) (uint64, error)
 
+	// AddDeploy adds a new deploy. If the signature is not empty, then it
+	// is stored atomically along with the deploy.
 	// TODO: This could be split out to a sub-interface?
-	AddDeploy(ctx context.Context, id uint64, hash, pHash string, data *string) error
+	AddDeploy(ctx context.Context, id uint64, hash, pHash string, data *string, signature string) error
+
+	// AddDeployRollout adds a new deploy which is gradually rolled out to
+	// the hosts with the rollout policy.
+	AddDeployRollout(ctx context.Context, id uint64, hash, pHash string, data *string, signature string, rollout *deployer.Rollout) error
+
+	// GetRollout returns the rollout of a deploy, or nil if it has none.
+	GetRollout(ctx context.Context, id uint64) (*deployer.Rollout, error)
+
+	// SetRollout updates the state of an existing rollout.
+	SetRollout(ctx context.Context, rollout *deployer.Rollout) error
+
+	// GetDeployIDFor returns the deploy id which the host should run. This
+	// takes into account any rollout of the newest deploy.
+	GetDeployIDFor(ctx context.Context, hostname string) (uint64, error)
+
+	// SetDeployStatus stores the status of a host for a deploy id.
+	SetDeployStatus(ctx context.Context, id uint64, hostname, status string) error
+
+	// GetDeployStatus returns the status of each host for a deploy id.
+	GetDeployStatus(ctx context.Context, id uint64) (map[string]string, error)
+
+	// GetHosts returns the running hosts and whether they are converged.
+	GetHosts(ctx context.Context) (map[string]bool, error)
 }
 
 // StrWorld is a world interface which is useful for reading, writing, and
@@ -231,6 +263,22 @@ type ResDelete struct {
 	Host string // to/for this host
 }
 
+// SemaClusterSuffix is the suffix that is added to a semaphore id to specify
+// that it should be shared across the entire cluster instead of being local to
+// this one process. For example: `db:2@cluster` lets at most two resources run
+// at the same time across all of the hosts.
+const SemaClusterSuffix = "@cluster"
+
+// SemaWorld is a world interface that has to do with distributed semaphores.
+type SemaWorld interface {
+	// SemaLock acquires one of the size slots of the cluster-wide semaphore
+	// with this id. It blocks until the slot is acquired or the context is
+	// cancelled. On success, it returns a function which must be called to
+	// release the slot. If the host holding a slot disappears, then the
+	// slot must eventually be released automatically.
+	SemaLock(ctx context.Context, id string, size int) (func() error, error)
+}
+
 // SchedulerWorld is an interface that has to do with distributed scheduling.
 // XXX: This should be abstracted to remove the etcd specific types if possible.
 type SchedulerWorld interface {
@@ -31,7 +31,9 @@ package deployer
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -39,6 +41,7 @@ import (
 	"github.com/purpleidea/mgmt/etcd/interfaces"
 	"github.com/purpleidea/mgmt/util/errwrap"
 
+	"github.com/spf13/afero"
 	etcd "go.etcd.io/etcd/client/v3"
 	etcdutil "go.etcd.io/etcd/client/v3/clientv3util"
 )
@@ -47,6 +50,7 @@ const (
 	deployPath  = "deploy"
 	payloadPath = "payload"
 	hashPath    = "hash"
+	signPath    = "signature"
 )
 
 // SimpleDeploy is a deploy struct that provides all of the needed deploy
@@ -161,6 +165,67 @@ func (obj *SimpleDeploy) GetDeploy(ctx context.Context, id uint64) (string, erro
 	return str, nil
 }
 
+// GetDeployHash returns the hash which was stored with the deploy with the
+// specified id. It is empty if the deploy was added without one.
+func (obj *SimpleDeploy) GetDeployHash(ctx context.Context, id uint64) (string, error) {
+	// key structure is $NS/deploy/$id/hash = $hash
+	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, deployPath, id, hashPath)
+	keyMap, err := obj.Client.Get(ctx, path)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "could not get deploy hash")
+	}
+	return keyMap[path], nil // empty if missing
+}
+
+// SignedMessage returns the message that is signed for a deploy. It covers the
+// id, the hash that is stored with the deploy, the hash of the previous deploy,
+// the payload, and the hash of the deploy file system from FsHash, so that a
+// signature can't be reused for another deploy, or in another place in the hash
+// chain, and so that none of the deployed files can be changed.
+func SignedMessage(id uint64, hash, pHash string, data string, fsHash string) []byte {
+	sum := sha256.Sum256([]byte(data))
+	return []byte(fmt.Sprintf("id: %d\nhash: %s\nprevious: %s\npayload: %x\nfs: %s\n", id, hash, pHash, sum, fsHash))
+}
+
+// FsHash returns a deterministic hash of every file in the deploy file system.
+// It's the sha256 of the sorted list of each path with the sha256 of its data,
+// so it changes if any file is added, removed, renamed or modified.
+func FsHash(fs afero.Fs) (string, error) {
+	h := sha256.New()
+	fn := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("can't hash irregular file: %s", path)
+		}
+		b, err := afero.ReadFile(fs, path)
+		if err != nil {
+			return errwrap.Wrapf(err, "can't read file: %s", path)
+		}
+		_, err = fmt.Fprintf(h, "%x %q\n", sha256.Sum256(b), path)
+		return err
+	}
+	if err := afero.Walk(fs, "/", fn); err != nil { // walks in lexical order
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// GetDeploySignature returns the signature of the deploy with the specified id.
+// It is empty if the deploy wasn't signed.
+func (obj *SimpleDeploy) GetDeploySignature(ctx context.Context, id uint64) (string, error) {
+	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, deployPath, id, signPath)
+	keyMap, err := obj.Client.Get(ctx, path)
+	if err != nil {
+		return "", errwrap.Wrapf(err, "could not get deploy signature")
+	}
+	return keyMap[path], nil // empty if missing
+}
+
 // GetMaxDeployID returns the maximum deploy id. If none are found, this returns
 // zero. You must increment the returned value by one when you add a deploy. If
 // two or more clients race for this deploy id, then the loser is not committed,
@@ -181,9 +246,28 @@ func (obj *SimpleDeploy) GetMaxDeployID(ctx context.Context) (uint64, error) {
 // previous hash was, and also adds this new hash along side the id. This is
 // useful to make sure you get a linear chain of git patches, and to avoid two
 // contributors pushing conflicting deploys. This isn't git specific, and so any
-// arbitrary string hash can be used.
+// arbitrary string hash can be used. If signature is not empty, then it is
+// stored in the same transaction, so that nobody ever sees the deploy without
+// its signature, or with the signature of another deploy.
 // FIXME: prune old deploys from the store when they aren't needed anymore...
-func (obj *SimpleDeploy) AddDeploy(ctx context.Context, id uint64, hash, pHash string, data *string) error {
+func (obj *SimpleDeploy) AddDeploy(ctx context.Context, id uint64, hash, pHash string, data *string, signature string) error {
+	return obj.addDeploy(ctx, id, hash, pHash, data, obj.signatureOps(id, signature)...)
+}
+
+// signatureOps returns the ops which store the signature of the deploy with the
+// specified id. There are none if the deploy isn't signed.
+func (obj *SimpleDeploy) signatureOps(id uint64, signature string) []etcd.Op {
+	if signature == "" {
+		return nil
+	}
+	// key structure is $NS/deploy/$id/signature = $signature
+	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, deployPath, id, signPath)
+	return []etcd.Op{etcd.OpPut(path, signature)}
+}
+
+// addDeploy is the implementation of AddDeploy. Any extra ops are added to the
+// same transaction.
+func (obj *SimpleDeploy) addDeploy(ctx context.Context, id uint64, hash, pHash string, data *string, extra ...etcd.Op) error {
 	// key structure is $NS/deploy/$id/payload = $data
 	// key structure is $NS/deploy/$id/hash = $hash
 	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, deployPath, id, payloadPath)
@@ -213,6 +297,7 @@ func (obj *SimpleDeploy) AddDeploy(ctx context.Context, id uint64, hash, pHash s
 	if hash != "" {
 		ops = append(ops, etcd.OpPut(tPath, hash)) // store new hash as well
 	}
+	ops = append(ops, extra...)
 
 	// it's important to do this in one transaction, and atomically, because
 	// this way, we only generate one watch event, and only when it's needed
@@ -0,0 +1,302 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/purpleidea/mgmt/etcd/interfaces"
+	"github.com/purpleidea/mgmt/pgp"
+
+	"github.com/spf13/afero"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	etcd "go.etcd.io/etcd/client/v3"
+	"golang.org/x/crypto/openpgp"
+)
+
+// fakeClient is an in memory etcd client. It only supports what the deployer
+// needs, which is get, and transactions of puts with key and value compares.
+type fakeClient struct {
+	mutex *sync.Mutex
+	data  map[string]string
+}
+
+func (obj *fakeClient) GetClient() *etcd.Client { return nil }
+
+func (obj *fakeClient) Set(ctx context.Context, key, value string, opts ...etcd.OpOption) error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	obj.data[key] = value
+	return nil
+}
+
+func (obj *fakeClient) Get(ctx context.Context, path string, opts ...etcd.OpOption) (map[string]string, error) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	prefix := len(etcd.OpGet(path, opts...).RangeBytes()) > 0 // we only use WithPrefix
+	result := make(map[string]string)
+	for key, value := range obj.data {
+		if key == path || prefix && strings.HasPrefix(key, path) {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+func (obj *fakeClient) Del(ctx context.Context, path string, opts ...etcd.OpOption) (int64, error) {
+	return 0, fmt.Errorf("not implemented")
+}
+
+func (obj *fakeClient) Txn(ctx context.Context, ifCmps []etcd.Cmp, thenOps, elseOps []etcd.Op) (*etcd.TxnResponse, error) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	succeeded := true
+	for _, cmp := range ifCmps {
+		value, exists := obj.data[string(cmp.KeyBytes())]
+		var c int // the result of comparing what we have to the cmp
+		switch x := cmp.TargetUnion.(type) {
+		case *pb.Compare_Version: // a fake version that's only 0 or 1
+			var version int64
+			if exists {
+				version = 1
+			}
+			c = int(version - x.Version)
+		case *pb.Compare_Value:
+			c = strings.Compare(value, string(x.Value))
+		default:
+			return nil, fmt.Errorf("unsupported compare: %v", cmp.Target)
+		}
+		ok := map[pb.Compare_CompareResult]bool{
+			pb.Compare_EQUAL:     c == 0,
+			pb.Compare_GREATER:   c > 0,
+			pb.Compare_LESS:      c < 0,
+			pb.Compare_NOT_EQUAL: c != 0,
+		}[cmp.Result]
+		succeeded = succeeded && ok
+	}
+	ops := thenOps
+	if !succeeded {
+		ops = elseOps
+	}
+	for _, op := range ops {
+		if !op.IsPut() {
+			return nil, fmt.Errorf("unsupported op")
+		}
+		obj.data[string(op.KeyBytes())] = string(op.ValueBytes())
+	}
+	return &etcd.TxnResponse{Succeeded: succeeded}, nil
+}
+
+func (obj *fakeClient) Watcher(ctx context.Context, path string, opts ...etcd.OpOption) (chan error, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (obj *fakeClient) ComplexWatcher(ctx context.Context, path string, opts ...etcd.OpOption) (*interfaces.WatcherInfo, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (obj *fakeClient) WatchMembers(context.Context) (<-chan *interfaces.MembersResult, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// verify checks the signature of a stored deploy the same way that the hosts
+// do it before they run it.
+func verify(ctx context.Context, simpleDeploy *SimpleDeploy, keyring openpgp.EntityList, id uint64, fs afero.Fs) error {
+	data, err := simpleDeploy.GetDeploy(ctx, id)
+	if err != nil {
+		return err
+	}
+	signature, err := simpleDeploy.GetDeploySignature(ctx, id)
+	if err != nil {
+		return err
+	}
+	hash, err := simpleDeploy.GetDeployHash(ctx, id)
+	if err != nil {
+		return err
+	}
+	var pHash string
+	if id > 1 {
+		if pHash, err = simpleDeploy.GetDeployHash(ctx, id-1); err != nil {
+			return err
+		}
+	}
+	fsHash, err := FsHash(fs)
+	if err != nil {
+		return err
+	}
+	_, err = pgp.Verify(keyring, SignedMessage(id, hash, pHash, data, fsHash), signature)
+	return err
+}
+
+func TestSignedDeploy1(t *testing.T) {
+	ctx := context.Background()
+	key, err := pgp.Generate("deployer", "test", "deployer@example.com", nil)
+	if err != nil {
+		t.Errorf("could not generate key: %+v", err)
+		return
+	}
+	keyring := openpgp.EntityList{key.Entity}
+
+	client := &fakeClient{
+		mutex: &sync.Mutex{},
+		data:  make(map[string]string),
+	}
+	simpleDeploy := &SimpleDeploy{
+		Client: client,
+		Logf:   t.Logf,
+	}
+	if err := simpleDeploy.Init(); err != nil {
+		t.Errorf("could not init: %+v", err)
+		return
+	}
+	defer simpleDeploy.Close()
+
+	// all the deploys share the same files, which is fine for this test
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "/main.mcl", []byte("print \"hello\" {}\n"), 0644); err != nil {
+		t.Errorf("could not write file: %+v", err)
+		return
+	}
+
+	// sign like the cli does, right before the deploy is added
+	sign := func(id uint64, hash, pHash, data string) string {
+		fsHash, err := FsHash(fs)
+		if err != nil {
+			t.Errorf("could not hash: %+v", err)
+		}
+		signature, err := key.Sign(SignedMessage(id, hash, pHash, data, fsHash))
+		if err != nil {
+			t.Errorf("could not sign: %+v", err)
+		}
+		return signature
+	}
+
+	data1 := "deploy one"
+	if err := simpleDeploy.AddDeploy(ctx, 1, "h1", "", &data1, sign(1, "h1", "", data1)); err != nil {
+		t.Errorf("could not add deploy: %+v", err)
+		return
+	}
+	if err := verify(ctx, simpleDeploy, keyring, 1, fs); err != nil {
+		t.Errorf("deploy 1 should verify: %+v", err)
+	}
+
+	// two deployers race for the same id, and only the winner is stored
+	winner, loser := "deploy two", "deploy two from someone else"
+	winnerSignature := sign(2, "h2", "h1", winner)
+	loserSignature := sign(2, "h2", "h1", loser)
+	rollout := &Rollout{ID: 2, Previous: 1}
+	if err := simpleDeploy.AddDeployRollout(ctx, 2, "h2", "h1", &winner, winnerSignature, rollout); err != nil {
+		t.Errorf("could not add deploy: %+v", err)
+		return
+	}
+	if err := simpleDeploy.AddDeploy(ctx, 2, "h2", "h1", &loser, loserSignature); err == nil {
+		t.Errorf("the loser of the race should not be added")
+	}
+	if err := verify(ctx, simpleDeploy, keyring, 2, fs); err != nil {
+		t.Errorf("deploy 2 should verify: %+v", err)
+	}
+
+	// a deploy that fails to be added doesn't leave a signature behind
+	data4 := "deploy four"
+	if err := simpleDeploy.AddDeploy(ctx, 4, "h4", "h3", &data4, sign(4, "h4", "h3", data4)); err == nil {
+		t.Errorf("deploy 4 should not be added without deploy 3")
+	}
+	if signature, err := simpleDeploy.GetDeploySignature(ctx, 4); err != nil || signature != "" {
+		t.Errorf("deploy 4 should have no signature, got: %q, %v", signature, err)
+	}
+
+	// an unsigned deploy doesn't verify
+	data3 := "deploy three"
+	if err := simpleDeploy.AddDeploy(ctx, 3, "h3", "h2", &data3, ""); err != nil {
+		t.Errorf("could not add deploy: %+v", err)
+		return
+	}
+	if err := verify(ctx, simpleDeploy, keyring, 3, fs); err == nil {
+		t.Errorf("unsigned deploy 3 should not verify")
+	}
+
+	// changing the files under a signed deploy breaks its signature
+	if err := afero.WriteFile(fs, "/main.mcl", []byte("exec \"evil\" {}\n"), 0644); err != nil {
+		t.Errorf("could not write file: %+v", err)
+		return
+	}
+	if err := verify(ctx, simpleDeploy, keyring, 2, fs); err == nil {
+		t.Errorf("deploy 2 should not verify with changed files")
+	}
+}
+
+func TestFsHash1(t *testing.T) {
+	write := func(fs afero.Fs, name, data string) {
+		if err := afero.WriteFile(fs, name, []byte(data), 0644); err != nil {
+			t.Errorf("could not write file: %+v", err)
+		}
+	}
+	hash := func(fs afero.Fs) string {
+		h, err := FsHash(fs)
+		if err != nil {
+			t.Errorf("could not hash: %+v", err)
+		}
+		return h
+	}
+
+	// the order that the files were written in doesn't matter
+	fs1, fs2 := afero.NewMemMapFs(), afero.NewMemMapFs()
+	write(fs1, "/a.mcl", "a")
+	write(fs1, "/dir/b.mcl", "b")
+	write(fs2, "/dir/b.mcl", "b")
+	write(fs2, "/a.mcl", "a")
+	h := hash(fs1)
+	if h2 := hash(fs2); h != h2 {
+		t.Errorf("expected the same hash, got: %s and %s", h, h2)
+	}
+
+	write(fs2, "/dir/b.mcl", "c") // modified
+	if h2 := hash(fs2); h == h2 {
+		t.Errorf("expected a different hash after a change")
+	}
+
+	fs3 := afero.NewMemMapFs()
+	write(fs3, "/a.mcl", "a")
+	write(fs3, "/dir/c.mcl", "b") // renamed
+	if h3 := hash(fs3); h == h3 {
+		t.Errorf("expected a different hash after a rename")
+	}
+
+	write(fs1, "/dir/new.mcl", "") // added
+	if h1 := hash(fs1); h == h1 {
+		t.Errorf("expected a different hash after an addition")
+	}
+}
@@ -0,0 +1,409 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/purpleidea/mgmt/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	etcd "go.etcd.io/etcd/client/v3"
+	etcdutil "go.etcd.io/etcd/client/v3/clientv3util"
+)
+
+const (
+	rolloutPath = "rollout"      // stored beside the payload
+	statusPath  = "deploystatus" // $NS/deploystatus/$id/$hostname = $status
+
+	// convergedPath is where each host stores its converged state. This
+	// must match the ConvergedPath in the etcd package.
+	convergedPath = "converged"
+)
+
+const (
+	// RolloutStageCanary is the stage where only the canary hosts run the
+	// new deploy.
+	RolloutStageCanary = "canary"
+
+	// RolloutStageRolling is the stage where the new deploy is being
+	// rolled out to the rest of the hosts in batches.
+	RolloutStageRolling = "rolling"
+
+	// RolloutStageDone is the stage once every host runs the new deploy.
+	RolloutStageDone = "done"
+
+	// RolloutStageRolledBack is the stage once the rollout failed, and every
+	// host runs the previous deploy again.
+	RolloutStageRolledBack = "rolledback"
+)
+
+const (
+	// DeployStatusApplied is the status of a host which has switched to the
+	// deploy, but which hasn't converged yet.
+	DeployStatusApplied = "applied"
+
+	// DeployStatusConverged is the status of a host which has converged
+	// while running the deploy.
+	DeployStatusConverged = "converged"
+
+	// DeployStatusFailed is the status of a host which couldn't run the
+	// deploy.
+	DeployStatusFailed = "failed"
+)
+
+// Rollout is the policy and the current state of a gradual deploy. While a
+// rollout is in progress, only the admitted Hosts run the new deploy, and every
+// other host keeps running the Previous one. It is stored alongside the deploy
+// that it rolls out, and it is advanced by whoever started it.
+type Rollout struct {
+	// ID is the deploy id which is being rolled out.
+	ID uint64 `json:"id"`
+
+	// Previous is the deploy id which the hosts that aren't admitted yet
+	// run, and which everyone runs after a roll back. Zero is the empty
+	// deploy.
+	Previous uint64 `json:"previous"`
+
+	// Canaries are the hosts which get the new deploy first.
+	Canaries []string `json:"canaries,omitempty"`
+
+	// CanaryPercent is the percentage of the hosts which get the new deploy
+	// first, in addition to the named Canaries.
+	CanaryPercent int `json:"canary_percent,omitempty"`
+
+	// BatchSize is the number of hosts which get the new deploy at a time
+	// after the canaries have converged. Zero means all of them at once.
+	BatchSize int `json:"batch_size,omitempty"`
+
+	// MaxFailures is the number of failed hosts which are tolerated. If
+	// more than this many fail, then the rollout is rolled back.
+	MaxFailures int `json:"max_failures"`
+
+	// Stage is where the rollout is. It's one of the RolloutStage values.
+	Stage string `json:"stage"`
+
+	// Hosts are the hosts which have been admitted to the new deploy.
+	Hosts []string `json:"hosts"`
+
+	// Failed are the admitted hosts which failed.
+	Failed []string `json:"failed,omitempty"`
+
+	// Message is a human readable explanation of the latest change.
+	Message string `json:"message,omitempty"`
+
+	// Signature is the signature of the rest of the rollout, if whoever
+	// drives it has a signing key. It's signed again at every change.
+	Signature string `json:"signature,omitempty"`
+}
+
+// SignedMessage returns the message that is signed for the rollout. It covers
+// every field except the Signature, so that a host can trust which deploy the
+// rollout tells it to run, and not only the deploys themselves.
+func (obj *Rollout) SignedMessage() ([]byte, error) {
+	rollout := *obj // copy
+	rollout.Signature = ""
+	b, err := json.Marshal(&rollout)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not encode the rollout")
+	}
+	return append([]byte("rollout: "), b...), nil
+}
+
+// Validate checks that the rollout policy makes sense.
+func (obj *Rollout) Validate() error {
+	if obj.ID == 0 {
+		return fmt.Errorf("the rollout needs a deploy id")
+	}
+	if obj.Previous >= obj.ID {
+		return fmt.Errorf("the previous deploy id must be older than the rollout")
+	}
+	if obj.CanaryPercent < 0 || obj.CanaryPercent > 100 {
+		return fmt.Errorf("the canary percent must be between 0 and 100")
+	}
+	if obj.BatchSize < 0 {
+		return fmt.Errorf("the batch size can't be negative")
+	}
+	if obj.MaxFailures < 0 {
+		return fmt.Errorf("the max failures can't be negative")
+	}
+	for _, x := range obj.Canaries {
+		if x == "" || strings.Contains(x, "/") {
+			return fmt.Errorf("invalid canary hostname: `%s`", x)
+		}
+	}
+	return nil
+}
+
+// DeployFor returns the deploy id which the host should be running.
+func (obj *Rollout) DeployFor(hostname string) uint64 {
+	switch obj.Stage {
+	case RolloutStageDone:
+		return obj.ID
+	case RolloutStageRolledBack:
+		return obj.Previous
+	}
+	if util.StrInList(hostname, obj.Hosts) {
+		return obj.ID
+	}
+	return obj.Previous
+}
+
+// Start admits the first hosts. These are the named canaries, and then the
+// canary percentage of the remaining hosts. If there are no canaries, then the
+// first batch is admitted instead. The hosts list must be sorted.
+func (obj *Rollout) Start(hosts []string) {
+	obj.Hosts = []string{}
+	for _, x := range obj.Canaries {
+		if !util.StrInList(x, obj.Hosts) {
+			obj.Hosts = append(obj.Hosts, x)
+		}
+	}
+	if n := (len(hosts)*obj.CanaryPercent + 99) / 100; n > 0 { // round up
+		obj.admit(hosts, n)
+	}
+	obj.Stage = RolloutStageCanary
+	obj.Message = fmt.Sprintf("waiting for %d canary host(s)", len(obj.Hosts))
+	if len(obj.Hosts) == 0 {
+		obj.nextBatch(hosts)
+	}
+}
+
+// Step looks at the current status of each host and advances the rollout if
+// the admitted hosts have all converged. If expired is true, then the admitted
+// hosts which haven't converged yet are counted as failed. The hosts list must
+// be sorted, and the status map is what each host reported for our deploy id.
+// It returns true if the rollout changed. Once it's done or rolled back, it
+// never changes again.
+func (obj *Rollout) Step(hosts []string, status map[string]string, expired bool) bool {
+	if obj.Stage == RolloutStageDone || obj.Stage == RolloutStageRolledBack {
+		return false
+	}
+
+	failed := []string{}
+	waiting := []string{}
+	for _, x := range obj.Hosts {
+		switch s := status[x]; {
+		case s == DeployStatusFailed:
+			failed = append(failed, x)
+		case s == DeployStatusConverged:
+			// pass
+		case expired:
+			failed = append(failed, x) // it took too long
+		default:
+			waiting = append(waiting, x)
+		}
+	}
+	changed := strings.Join(failed, ",") != strings.Join(obj.Failed, ",")
+	obj.Failed = failed
+
+	if len(failed) > obj.MaxFailures {
+		obj.Stage = RolloutStageRolledBack
+		obj.Message = fmt.Sprintf("rolled back to %d after %d failure(s): %s", obj.Previous, len(failed), strings.Join(failed, ", "))
+		return true
+	}
+	if len(waiting) > 0 {
+		return changed
+	}
+
+	obj.nextBatch(hosts)
+	return true
+}
+
+// nextBatch admits the next batch of hosts, or finishes the rollout if there
+// are none left.
+func (obj *Rollout) nextBatch(hosts []string) {
+	n := obj.BatchSize
+	if n == 0 {
+		n = len(hosts) // all of them
+	}
+	if obj.admit(hosts, n) == 0 {
+		obj.Stage = RolloutStageDone
+		obj.Message = fmt.Sprintf("all %d host(s) run deploy %d", len(obj.Hosts), obj.ID)
+		return
+	}
+	obj.Stage = RolloutStageRolling
+	obj.Message = fmt.Sprintf("%d of %d host(s) admitted", len(obj.Hosts), len(hosts))
+}
+
+// admit adds up to n of the hosts which weren't admitted yet. It returns how
+// many were added.
+func (obj *Rollout) admit(hosts []string, n int) int {
+	count := 0
+	for _, x := range hosts {
+		if count >= n {
+			break
+		}
+		if util.StrInList(x, obj.Hosts) {
+			continue
+		}
+		obj.Hosts = append(obj.Hosts, x)
+		count++
+	}
+	return count
+}
+
+// AddDeployRollout adds a new deploy like AddDeploy does, and it stores the
+// rollout (and the signature if there is one) alongside it in the same
+// transaction, so that no host ever switches to the new deploy before it was
+// admitted.
+func (obj *SimpleDeploy) AddDeployRollout(ctx context.Context, id uint64, hash, pHash string, data *string, signature string, rollout *Rollout) error {
+	if rollout.ID != id {
+		return fmt.Errorf("the rollout is for id %d, not %d", rollout.ID, id)
+	}
+	b, err := json.Marshal(rollout)
+	if err != nil {
+		return errwrap.Wrapf(err, "could not encode the rollout")
+	}
+	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, deployPath, id, rolloutPath)
+	ops := append(obj.signatureOps(id, signature), etcd.OpPut(path, string(b)))
+	return obj.addDeploy(ctx, id, hash, pHash, data, ops...)
+}
+
+// GetRollout returns the rollout of the deploy with the specified id. It
+// returns nil if that deploy has no rollout.
+func (obj *SimpleDeploy) GetRollout(ctx context.Context, id uint64) (*Rollout, error) {
+	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, deployPath, id, rolloutPath)
+	keyMap, err := obj.Client.Get(ctx, path)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not get rollout")
+	}
+	val, exists := keyMap[path]
+	if !exists {
+		return nil, nil // no rollout
+	}
+	rollout := &Rollout{}
+	if err := json.Unmarshal([]byte(val), rollout); err != nil {
+		return nil, errwrap.Wrapf(err, "could not decode the rollout")
+	}
+	return rollout, nil
+}
+
+// SetRollout updates an existing rollout. Since this is stored beside the
+// deploy, every host is notified via WatchDeploy.
+func (obj *SimpleDeploy) SetRollout(ctx context.Context, rollout *Rollout) error {
+	b, err := json.Marshal(rollout)
+	if err != nil {
+		return errwrap.Wrapf(err, "could not encode the rollout")
+	}
+	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, deployPath, rollout.ID, rolloutPath)
+	ifs := []etcd.Cmp{etcdutil.KeyExists(path)}
+	ops := []etcd.Op{etcd.OpPut(path, string(b))}
+	result, err := obj.Client.Txn(ctx, ifs, ops, nil)
+	if err != nil {
+		return errwrap.Wrapf(err, "error updating rollout of id %d", rollout.ID)
+	}
+	if !result.Succeeded {
+		return fmt.Errorf("no rollout exists for id %d", rollout.ID)
+	}
+	return nil
+}
+
+// GetDeployIDFor returns the deploy id which the host should be running. This
+// is the newest deploy, unless it has a rollout which says otherwise. If none
+// are found, this returns zero.
+func (obj *SimpleDeploy) GetDeployIDFor(ctx context.Context, hostname string) (uint64, error) {
+	max, err := obj.GetMaxDeployID(ctx)
+	if err != nil || max == 0 {
+		return 0, err
+	}
+	rollout, err := obj.GetRollout(ctx, max)
+	if err != nil {
+		return 0, err
+	}
+	if rollout == nil {
+		return max, nil
+	}
+	return rollout.DeployFor(hostname), nil
+}
+
+// SetDeployStatus stores the status of the host for the deploy with the
+// specified id. The status should be one of the DeployStatus values.
+func (obj *SimpleDeploy) SetDeployStatus(ctx context.Context, id uint64, hostname, status string) error {
+	// key structure is $NS/deploystatus/$id/$hostname = $status
+	path := fmt.Sprintf("%s/%s/%d/%s", obj.ns, statusPath, id, hostname)
+	return errwrap.Wrapf(obj.Client.Set(ctx, path, status), "could not set deploy status")
+}
+
+// GetDeployStatus returns a map of hostname to the status that each host has
+// reported for the deploy with the specified id.
+func (obj *SimpleDeploy) GetDeployStatus(ctx context.Context, id uint64) (map[string]string, error) {
+	path := fmt.Sprintf("%s/%s/%d/", obj.ns, statusPath, id)
+	keyMap, err := obj.Client.Get(ctx, path, etcd.WithPrefix())
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not get deploy status")
+	}
+	result := make(map[string]string)
+	for key, val := range keyMap {
+		if !strings.HasPrefix(key, path) { // sanity check
+			continue
+		}
+		result[key[len(path):]] = val
+	}
+	return result, nil
+}
+
+// GetHosts returns a map of the hosts which are currently running, to whether
+// each of them is converged. This uses the converged state that each
+// host keeps in etcd, which disappears when the host goes away.
+func (obj *SimpleDeploy) GetHosts(ctx context.Context) (map[string]bool, error) {
+	path := fmt.Sprintf("%s/%s/", obj.ns, convergedPath)
+	keyMap, err := obj.Client.Get(ctx, path, etcd.WithPrefix())
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not get hosts")
+	}
+	result := make(map[string]bool)
+	for key, val := range keyMap {
+		if !strings.HasPrefix(key, path) { // sanity check
+			continue
+		}
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid converged value of `%s`", val)
+		}
+		result[key[len(path):]] = b
+	}
+	return result, nil
+}
+
+// SortedHosts is a helper which returns the sorted hostnames from GetHosts.
+func SortedHosts(hosts map[string]bool) []string {
+	result := []string{}
+	for x := range hosts {
+		result = append(result, x)
+	}
+	sort.Strings(result)
+	return result
+}
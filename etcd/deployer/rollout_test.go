@@ -0,0 +1,222 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package deployer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRolloutSteps1(t *testing.T) {
+	hosts := []string{"h1", "h2", "h3", "h4", "h5", "h6", "h7", "h8", "h9", "h10"}
+	sort.Strings(hosts) // h10 comes after h1
+
+	type step struct {
+		status  map[string]string
+		expired bool
+
+		stage string // expected
+		hosts int    // expected number of admitted hosts
+	}
+	type test struct { // an individual test
+		name    string
+		rollout *Rollout
+		stage   string // expected after start
+		admit   string // expected admitted hosts after start
+		steps   []step
+	}
+	testCases := []test{}
+
+	converged := func(names ...string) map[string]string {
+		m := make(map[string]string)
+		for _, x := range names {
+			m[x] = DeployStatusConverged
+		}
+		return m
+	}
+
+	testCases = append(testCases, test{
+		name: "canary then batches",
+		rollout: &Rollout{
+			ID:        2,
+			Previous:  1,
+			Canaries:  []string{"h5"},
+			BatchSize: 4,
+		},
+		stage: RolloutStageCanary,
+		admit: "h5",
+		steps: []step{
+			{status: map[string]string{"h5": DeployStatusApplied}, stage: RolloutStageCanary, hosts: 1},
+			{status: converged("h5"), stage: RolloutStageRolling, hosts: 5},
+			{status: converged("h5", "h1", "h10", "h2"), stage: RolloutStageRolling, hosts: 5},
+			{status: converged("h5", "h1", "h10", "h2", "h3"), stage: RolloutStageRolling, hosts: 9},
+			{status: converged("h5", "h1", "h10", "h2", "h3", "h4", "h6", "h7", "h8"), stage: RolloutStageRolling, hosts: 10},
+			{status: converged(hosts...), stage: RolloutStageDone, hosts: 10},
+		},
+	})
+	testCases = append(testCases, test{
+		name: "percent",
+		rollout: &Rollout{
+			ID:            2,
+			Previous:      1,
+			CanaryPercent: 25, // rounds up to 3 hosts
+		},
+		stage: RolloutStageCanary,
+		admit: "h1,h10,h2",
+		steps: []step{
+			{status: converged("h1", "h10", "h2"), stage: RolloutStageRolling, hosts: 10},
+			{status: converged(hosts...), stage: RolloutStageDone, hosts: 10},
+		},
+	})
+	testCases = append(testCases, test{
+		name: "failure rolls back",
+		rollout: &Rollout{
+			ID:            2,
+			Previous:      1,
+			CanaryPercent: 20,
+			MaxFailures:   1,
+		},
+		stage: RolloutStageCanary,
+		admit: "h1,h10",
+		steps: []step{
+			{status: map[string]string{"h1": DeployStatusFailed}, stage: RolloutStageCanary, hosts: 2},
+			{status: map[string]string{"h1": DeployStatusFailed}, expired: true, stage: RolloutStageRolledBack, hosts: 2},
+		},
+	})
+	testCases = append(testCases, test{
+		name: "only batches",
+		rollout: &Rollout{
+			ID:        5,
+			Previous:  3,
+			BatchSize: 6,
+		},
+		stage: RolloutStageRolling,
+		admit: "h1,h10,h2,h3,h4,h5",
+		steps: []step{
+			{status: converged("h1", "h10", "h2", "h3", "h4", "h5"), stage: RolloutStageRolling, hosts: 10},
+			{status: map[string]string{"h6": DeployStatusFailed}, stage: RolloutStageRolledBack, hosts: 10},
+			{status: converged(hosts...), stage: RolloutStageRolledBack, hosts: 10}, // stays
+		},
+	})
+
+	for index, tc := range testCases { // run all the tests
+		name, rollout, stage, admit, steps := tc.name, tc.rollout, tc.stage, tc.admit, tc.steps
+		t.Run(fmt.Sprintf("test #%d (%s)", index, name), func(t *testing.T) {
+			if err := rollout.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			rollout.Start(hosts)
+			if rollout.Stage != stage {
+				t.Errorf("expected stage %s after start, got: %s", stage, rollout.Stage)
+				return
+			}
+			if s := strings.Join(rollout.Hosts, ","); s != admit {
+				t.Errorf("expected hosts %s after start, got: %s", admit, s)
+				return
+			}
+
+			for i, step := range steps {
+				rollout.Step(hosts, step.status, step.expired)
+				if rollout.Stage != step.stage {
+					t.Errorf("step %d: expected stage %s, got: %s", i, step.stage, rollout.Stage)
+					return
+				}
+				if l := len(rollout.Hosts); l != step.hosts {
+					t.Errorf("step %d: expected %d hosts, got: %d", i, step.hosts, l)
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestRolloutDeployFor1(t *testing.T) {
+	rollout := &Rollout{
+		ID:       7,
+		Previous: 4,
+		Stage:    RolloutStageCanary,
+		Hosts:    []string{"h1"},
+	}
+	if id := rollout.DeployFor("h1"); id != 7 {
+		t.Errorf("admitted host should run 7, not: %d", id)
+	}
+	if id := rollout.DeployFor("h2"); id != 4 {
+		t.Errorf("other host should run 4, not: %d", id)
+	}
+	rollout.Stage = RolloutStageDone
+	if id := rollout.DeployFor("h2"); id != 7 {
+		t.Errorf("every host should run 7 when done, not: %d", id)
+	}
+	rollout.Stage = RolloutStageRolledBack
+	if id := rollout.DeployFor("h1"); id != 4 {
+		t.Errorf("every host should run 4 after a roll back, not: %d", id)
+	}
+}
+
+func TestRolloutSignedMessage1(t *testing.T) {
+	rollout := &Rollout{
+		ID:       7,
+		Previous: 4,
+		Stage:    RolloutStageCanary,
+		Hosts:    []string{"h1"},
+	}
+	msg1, err := rollout.SignedMessage()
+	if err != nil {
+		t.Errorf("error: %+v", err)
+		return
+	}
+	rollout.Signature = "sig"
+	msg2, err := rollout.SignedMessage()
+	if err != nil {
+		t.Errorf("error: %+v", err)
+		return
+	}
+	if string(msg1) != string(msg2) {
+		t.Errorf("the signature should not be signed: %s", msg2)
+	}
+	if rollout.Signature != "sig" {
+		t.Errorf("the signature was cleared")
+	}
+
+	rollout.Hosts = []string{}
+	msg3, err := rollout.SignedMessage()
+	if err != nil {
+		t.Errorf("error: %+v", err)
+		return
+	}
+	if string(msg1) == string(msg3) {
+		t.Errorf("the hosts should be signed: %s", msg3)
+	}
+}
@@ -180,6 +180,12 @@ const (
 	SchedulerPath    = "/scheduler/"
 	schedulerPathFmt = SchedulerPath + "%s" // takes a namespace on the end
 
+	// SemaphorePath is the unprefixed path under which the cluster-wide
+	// semaphores store their data. This is public so that other consumers
+	// can know to avoid this key prefix.
+	SemaphorePath    = "/semaphore/"
+	semaphorePathFmt = SemaphorePath + "%s" // takes a semaphore id on the end
+
 	// DefaultClientURL is the default value that is used for client URLs.
 	// It is pulled from the upstream etcd package.
 	DefaultClientURL = embed.DefaultListenClientURLs // 127.0.0.1:2379
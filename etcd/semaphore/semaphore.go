@@ -0,0 +1,274 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package semaphore implements a distributed counting semaphore with etcd.
+package semaphore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	etcd "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	// DefaultSessionTTL is the number of seconds to wait before a dead or
+	// unresponsive host has its claim on the semaphore removed.
+	DefaultSessionTTL = 10 // seconds
+)
+
+// Semaphore is a distributed counting semaphore. Each acquirer stores a key
+// under the semaphore path which is attached to the lease of this semaphore's
+// session. The oldest Size keys, ordered by their creation revision, hold the
+// semaphore, and everyone else waits until one of the keys ahead of them is
+// removed. If a host dies, its lease expires, the keys are deleted, and the next
+// waiters get to proceed. A single session is shared by every Lock, so reuse
+// the same struct for the same semaphore, and Close it when you're done.
+type Semaphore struct {
+	// Client is the etcd client to use.
+	Client *etcd.Client
+
+	// Path is the key prefix under which this semaphore is stored. It
+	// must not end with a slash.
+	Path string
+
+	// Size is the number of concurrent holders that are allowed.
+	Size int
+
+	// Hostname is the name of the host that is acquiring this semaphore.
+	// It is stored as the value of our key to make debugging easier.
+	Hostname string
+
+	// SessionTTL is the number of seconds to wait before a dead host's
+	// claim expires. If this is zero, then DefaultSessionTTL is used.
+	SessionTTL int
+
+	Debug bool
+	Logf  func(format string, v ...interface{})
+
+	mutex   *sync.Mutex
+	session *concurrency.Session
+	count   int64 // makes each key unique within the session
+}
+
+// Validate returns an error if the struct was not populated correctly.
+func (obj *Semaphore) Validate() error {
+	if obj.Client == nil {
+		return fmt.Errorf("the Client is nil")
+	}
+	if obj.Path == "" {
+		return fmt.Errorf("the Path is empty")
+	}
+	if strings.HasSuffix(obj.Path, "/") {
+		return fmt.Errorf("the Path must not end with a slash")
+	}
+	if obj.Size <= 0 {
+		return fmt.Errorf("the Size must be positive")
+	}
+	if obj.Hostname == "" {
+		return fmt.Errorf("the Hostname is empty")
+	}
+	if obj.Logf == nil {
+		return fmt.Errorf("the Logf function is nil")
+	}
+	return nil
+}
+
+// Init validates the struct and must be called before first use.
+func (obj *Semaphore) Init() error {
+	if err := obj.Validate(); err != nil {
+		return err
+	}
+	obj.mutex = &sync.Mutex{}
+	return nil
+}
+
+// Close closes the session, which revokes its lease and releases any slots of
+// the semaphore that are still held.
+func (obj *Semaphore) Close() error {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if obj.session == nil {
+		return nil
+	}
+	err := obj.session.Close()
+	obj.session = nil
+	return errwrap.Wrapf(err, "could not close session")
+}
+
+// getKey returns the session to use and a new unique key for it. A new session
+// is only created if we don't have one yet, or if the last one expired.
+func (obj *Semaphore) getKey() (*concurrency.Session, string, error) {
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	if obj.session != nil {
+		select {
+		case <-obj.session.Done(): // the lease expired or was revoked
+			obj.session.Close() // ignore error, it's gone anyways
+			obj.session = nil
+		default:
+		}
+	}
+	if obj.session == nil {
+		ttl := obj.SessionTTL
+		if ttl <= 0 {
+			ttl = DefaultSessionTTL
+		}
+		// We don't pass in a ctx, because the session must outlive each
+		// Lock call. It's closed when the semaphore is.
+		session, err := concurrency.NewSession(obj.Client, concurrency.WithTTL(ttl))
+		if err != nil {
+			return nil, "", errwrap.Wrapf(err, "could not create session")
+		}
+		obj.session = session
+	}
+
+	obj.count++
+	key := fmt.Sprintf("%s/%x-%d", obj.Path, obj.session.Lease(), obj.count)
+	return obj.session, key, nil
+}
+
+// Lock acquires one slot of the semaphore. It blocks until this succeeds, or
+// until the context is cancelled, in which case it returns an error. On success
+// it returns a function which must be called to release the slot again.
+func (obj *Semaphore) Lock(ctx context.Context) (func() error, error) {
+	session, key, err := obj.getKey()
+	if err != nil {
+		return nil, err
+	}
+	unlock := func() error {
+		// We don't use the Lock ctx, since it might be closed already.
+		_, err := obj.Client.Delete(context.TODO(), key)
+		return errwrap.Wrapf(err, "could not delete key")
+	}
+
+	prefix := obj.Path + "/"
+
+	// Only create the key if it doesn't exist, so that our creation revision
+	// is what sets our position in the queue.
+	cmp := etcd.Compare(etcd.CreateRevision(key), "=", 0)
+	put := etcd.OpPut(key, obj.Hostname, etcd.WithLease(session.Lease()))
+	get := etcd.OpGet(key)
+	txn, err := obj.Client.Txn(ctx).If(cmp).Then(put).Else(get).Commit()
+	if err != nil {
+		return nil, errwrap.Append(errwrap.Wrapf(err, "could not add key"), unlock())
+	}
+	myRev := txn.Header.Revision // the put revision is our create revision
+	if !txn.Succeeded {
+		resp := txn.Responses[0].GetResponseRange()
+		myRev = resp.Kvs[0].CreateRevision
+	}
+
+	for {
+		// Everyone who is waiting or holding the semaphore, oldest first.
+		opts := []etcd.OpOption{
+			etcd.WithPrefix(),
+			etcd.WithSort(etcd.SortByCreateRevision, etcd.SortAscend),
+			etcd.WithKeysOnly(),
+		}
+		resp, err := obj.Client.Get(ctx, prefix, opts...)
+		if err != nil {
+			return nil, errwrap.Append(errwrap.Wrapf(err, "could not list keys"), unlock())
+		}
+
+		position := -1
+		for i, kv := range resp.Kvs {
+			if kv.CreateRevision == myRev {
+				position = i
+				break
+			}
+		}
+		if position == -1 { // our lease must have expired
+			return nil, errwrap.Append(fmt.Errorf("lost our key: %s", key), unlock())
+		}
+		if position < obj.Size {
+			if obj.Debug {
+				obj.Logf("acquired: %s (%d/%d)", obj.Path, position+1, obj.Size)
+			}
+			return unlock, nil // acquired!
+		}
+		if obj.Debug {
+			obj.Logf("waiting: %s (position %d, size %d)", obj.Path, position+1, obj.Size)
+		}
+
+		// Wait for a delete of any key that is ahead of us in the queue.
+		// We start from the revision after our read so that we can't miss
+		// anything that happened in between the read and the watch.
+		if err := obj.waitDelete(ctx, prefix, resp.Header.Revision+1, myRev); err != nil {
+			return nil, errwrap.Append(err, unlock())
+		}
+	}
+}
+
+// waitDelete blocks until a key under the prefix which was created before the
+// revision named by before is deleted.
+func (obj *Semaphore) waitDelete(ctx context.Context, prefix string, rev, before int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	opts := []etcd.OpOption{
+		etcd.WithPrefix(),
+		etcd.WithRev(rev),
+		etcd.WithFilterPut(),
+		etcd.WithPrevKV(),
+	}
+	ch := obj.Client.Watch(wctx, prefix, opts...)
+	for {
+		select {
+		case resp, ok := <-ch:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return fmt.Errorf("watch closed unexpectedly")
+			}
+			if err := resp.Err(); err != nil {
+				return errwrap.Wrapf(err, "watch error")
+			}
+			for _, ev := range resp.Events {
+				if ev.Type != etcd.EventTypeDelete {
+					continue
+				}
+				// Without a PrevKV we can't know, so check again.
+				if ev.PrevKv == nil || ev.PrevKv.CreateRevision < before {
+					return nil
+				}
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
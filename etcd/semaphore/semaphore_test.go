@@ -0,0 +1,253 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package semaphore
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	etcd "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// freeURL returns a local url with a port that is currently unused.
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+// testClient starts an embedded etcd server and returns a client for it. Both
+// get closed when the test ends.
+func testClient(t *testing.T) *etcd.Client {
+	peerURL, clientURL := freeURL(t), freeURL(t)
+
+	cfg := embed.NewConfig()
+	cfg.Name = "sema"
+	cfg.Dir = t.TempDir()
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+	cfg.Logger = "zap"
+	cfg.LogLevel = "error" // keep things quieter
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("could not start etcd: %+v", err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(60 * time.Second):
+		t.Fatalf("etcd took too long to start")
+	}
+
+	client, err := etcd.New(etcd.Config{
+		Endpoints:   []string{clientURL.String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("could not make client: %+v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func testSemaphore(t *testing.T, client *etcd.Client, hostname string, size int) *Semaphore {
+	sema := &Semaphore{
+		Client:   client,
+		Path:     "/sema/test",
+		Size:     size,
+		Hostname: hostname,
+		Debug:    true,
+		Logf: func(format string, v ...interface{}) {
+			t.Logf(hostname+": "+format, v...)
+		},
+	}
+	if err := sema.Init(); err != nil {
+		t.Fatalf("could not init: %+v", err)
+	}
+	return sema
+}
+
+// lockAsync runs Lock in the background and returns a channel with the unlock
+// function once it has been acquired.
+func lockAsync(t *testing.T, ctx context.Context, sema *Semaphore) <-chan func() error {
+	ch := make(chan func() error, 1)
+	go func() {
+		unlock, err := sema.Lock(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				t.Errorf("lock failed: %+v", err)
+			}
+			close(ch)
+			return
+		}
+		ch <- unlock
+	}()
+	return ch
+}
+
+func TestSemaphoreValidate1(t *testing.T) {
+	sema := &Semaphore{Path: "/sema/test/", Size: 1, Hostname: "h1"}
+	if err := sema.Init(); err == nil {
+		t.Errorf("expected validation error")
+	}
+}
+
+func TestSemaphore1(t *testing.T) {
+	client := testClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	sema := testSemaphore(t, client, "h1", 2)
+	defer sema.Close()
+
+	unlock1, err := sema.Lock(ctx)
+	if err != nil {
+		t.Fatalf("lock failed: %+v", err)
+	}
+	unlock2, err := sema.Lock(ctx)
+	if err != nil {
+		t.Fatalf("lock failed: %+v", err)
+	}
+	ch := lockAsync(t, ctx, sema)
+	select {
+	case <-ch:
+		t.Fatalf("expected the third lock to block")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Every lock shares the one session, so there must be only one lease.
+	resp, err := client.Get(ctx, sema.Path+"/", etcd.WithPrefix())
+	if err != nil {
+		t.Fatalf("get failed: %+v", err)
+	}
+	if n := len(resp.Kvs); n != 3 {
+		t.Errorf("expected 3 keys, got: %d", n)
+	}
+	for _, kv := range resp.Kvs {
+		if kv.Lease != resp.Kvs[0].Lease {
+			t.Errorf("expected a single lease, got: %x and %x", kv.Lease, resp.Kvs[0].Lease)
+		}
+	}
+	leases, err := client.Leases(ctx)
+	if err != nil {
+		t.Fatalf("leases failed: %+v", err)
+	}
+	if n := len(leases.Leases); n != 1 {
+		t.Errorf("expected 1 lease, got: %d", n)
+	}
+
+	if err := unlock1(); err != nil {
+		t.Errorf("unlock failed: %+v", err)
+	}
+	var unlock3 func() error
+	select {
+	case unlock3 = <-ch:
+		if unlock3 == nil {
+			t.Fatalf("third lock failed")
+		}
+	case <-ctx.Done():
+		t.Fatalf("expected the third lock to proceed")
+	}
+
+	for _, unlock := range []func() error{unlock2, unlock3} {
+		if err := unlock(); err != nil {
+			t.Errorf("unlock failed: %+v", err)
+		}
+	}
+	resp, err = client.Get(ctx, sema.Path+"/", etcd.WithPrefix())
+	if err != nil {
+		t.Fatalf("get failed: %+v", err)
+	}
+	if n := len(resp.Kvs); n != 0 {
+		t.Errorf("expected no keys, got: %d", n)
+	}
+}
+
+func TestSemaphoreClose1(t *testing.T) {
+	client := testClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	sema1 := testSemaphore(t, client, "h1", 1)
+	sema2 := testSemaphore(t, client, "h2", 1) // another host
+	defer sema2.Close()
+
+	if _, err := sema1.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %+v", err)
+	}
+	ch := lockAsync(t, ctx, sema2)
+	select {
+	case <-ch:
+		t.Fatalf("expected the other host to block")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Closing revokes the lease, which releases the slot we still hold.
+	if err := sema1.Close(); err != nil {
+		t.Errorf("close failed: %+v", err)
+	}
+	select {
+	case unlock := <-ch:
+		if unlock == nil {
+			t.Fatalf("lock failed")
+		}
+		if err := unlock(); err != nil {
+			t.Errorf("unlock failed: %+v", err)
+		}
+	case <-ctx.Done():
+		t.Fatalf("expected the other host to proceed")
+	}
+
+	// A closed semaphore makes a new session when it's used again.
+	unlock, err := sema1.Lock(ctx)
+	if err != nil {
+		t.Fatalf("lock after close failed: %+v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Errorf("unlock failed: %+v", err)
+	}
+	if err := sema1.Close(); err != nil {
+		t.Errorf("close failed: %+v", err)
+	}
+}
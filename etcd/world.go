@@ -34,6 +34,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/etcd/chooser"
@@ -45,6 +46,7 @@ import (
 	etcdfs "github.com/purpleidea/mgmt/etcd/fs"
 	"github.com/purpleidea/mgmt/etcd/interfaces"
 	"github.com/purpleidea/mgmt/etcd/scheduler"
+	"github.com/purpleidea/mgmt/etcd/semaphore"
 	"github.com/purpleidea/mgmt/lang/embedded"
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
@@ -75,6 +77,9 @@ type World struct {
 	client       interfaces.Client
 	simpleDeploy *deployer.SimpleDeploy
 
+	semaMutex *sync.Mutex
+	semas     map[string]*semaphore.Semaphore // keyed by id
+
 	cleanups []func() error
 }
 
@@ -119,6 +124,21 @@ func (obj *World) Init(init *engine.WorldInit) error {
 		return e
 	})
 
+	obj.semaMutex = &sync.Mutex{}
+	obj.semas = make(map[string]*semaphore.Semaphore)
+	obj.cleanups = append(obj.cleanups, func() error {
+		obj.semaMutex.Lock()
+		defer obj.semaMutex.Unlock()
+		var errs error
+		for _, sema := range obj.semas {
+			if err := sema.Close(); err != nil {
+				errs = errwrap.Append(errs, err)
+			}
+		}
+		obj.semas = make(map[string]*semaphore.Semaphore)
+		return errs
+	})
+
 	return nil
 }
 
@@ -150,6 +170,16 @@ func (obj *World) GetDeploys(ctx context.Context) (map[uint64]string, error) {
 	return obj.simpleDeploy.GetDeploys(ctx)
 }
 
+// GetDeployHash returns the hash that was stored with the deploy.
+func (obj *World) GetDeployHash(ctx context.Context, id uint64) (string, error) {
+	return obj.simpleDeploy.GetDeployHash(ctx, id)
+}
+
+// GetDeploySignature returns the signature of a deploy.
+func (obj *World) GetDeploySignature(ctx context.Context, id uint64) (string, error) {
+	return obj.simpleDeploy.GetDeploySignature(ctx, id)
+}
+
 // GetDeploy returns the deploy with the specified id if it exists.
 func (obj *World) GetDeploy(ctx context.Context, id uint64) (string, error) {
 	return obj.simpleDeploy.GetDeploy(ctx, id)
@@ -161,8 +191,43 @@ func (obj *World) GetMaxDeployID(ctx context.Context) (uint64, error) {
 }
 
 // AddDeploy adds a new deploy.
-func (obj *World) AddDeploy(ctx context.Context, id uint64, hash, pHash string, data *string) error {
-	return obj.simpleDeploy.AddDeploy(ctx, id, hash, pHash, data)
+func (obj *World) AddDeploy(ctx context.Context, id uint64, hash, pHash string, data *string, signature string) error {
+	return obj.simpleDeploy.AddDeploy(ctx, id, hash, pHash, data, signature)
+}
+
+// AddDeployRollout adds a new deploy with a rollout policy.
+func (obj *World) AddDeployRollout(ctx context.Context, id uint64, hash, pHash string, data *string, signature string, rollout *deployer.Rollout) error {
+	return obj.simpleDeploy.AddDeployRollout(ctx, id, hash, pHash, data, signature, rollout)
+}
+
+// GetRollout returns the rollout of a deploy, or nil if it has none.
+func (obj *World) GetRollout(ctx context.Context, id uint64) (*deployer.Rollout, error) {
+	return obj.simpleDeploy.GetRollout(ctx, id)
+}
+
+// SetRollout updates the state of an existing rollout.
+func (obj *World) SetRollout(ctx context.Context, rollout *deployer.Rollout) error {
+	return obj.simpleDeploy.SetRollout(ctx, rollout)
+}
+
+// GetDeployIDFor returns the deploy id which the host should run.
+func (obj *World) GetDeployIDFor(ctx context.Context, hostname string) (uint64, error) {
+	return obj.simpleDeploy.GetDeployIDFor(ctx, hostname)
+}
+
+// SetDeployStatus stores the status of a host for a deploy id.
+func (obj *World) SetDeployStatus(ctx context.Context, id uint64, hostname, status string) error {
+	return obj.simpleDeploy.SetDeployStatus(ctx, id, hostname, status)
+}
+
+// GetDeployStatus returns the status of each host for a deploy id.
+func (obj *World) GetDeployStatus(ctx context.Context, id uint64) (map[string]string, error) {
+	return obj.simpleDeploy.GetDeployStatus(ctx, id)
+}
+
+// GetHosts returns the running hosts and whether they are converged.
+func (obj *World) GetHosts(ctx context.Context) (map[string]bool, error) {
+	return obj.simpleDeploy.GetHosts(ctx)
 }
 
 // ResWatch returns a channel which spits out events on possible exported
@@ -304,6 +369,39 @@ func (obj *World) Scheduler(namespace string, opts ...scheduler.Option) (*schedu
 	return scheduler.Schedule(obj.client.GetClient(), path, obj.init.Hostname, modifiedOpts...)
 }
 
+// SemaLock acquires one slot of a cluster-wide semaphore. The slot is released
+// automatically if this host disappears. Each semaphore keeps its session until
+// the world is closed, so that we don't make a new lease for every lock.
+func (obj *World) SemaLock(ctx context.Context, id string, size int) (func() error, error) {
+	obj.semaMutex.Lock()
+	sema, exists := obj.semas[id]
+	if exists && sema.Size != size {
+		obj.semaMutex.Unlock()
+		return nil, fmt.Errorf("semaphore %s has size %d, not %d", id, sema.Size, size)
+	}
+	if !exists {
+		sema = &semaphore.Semaphore{
+			Client:   obj.client.GetClient(),
+			Path:     fmt.Sprintf(semaphorePathFmt, id),
+			Size:     size,
+			Hostname: obj.init.Hostname,
+
+			Debug: obj.init.Debug,
+			Logf: func(format string, v ...interface{}) {
+				obj.init.Logf("sema: "+format, v...)
+			},
+		}
+		if err := sema.Init(); err != nil {
+			obj.semaMutex.Unlock()
+			return nil, err
+		}
+		obj.semas[id] = sema
+	}
+	obj.semaMutex.Unlock()
+
+	return sema.Lock(ctx)
+}
+
 // URI returns the current FS URI.
 // TODO: Can we improve this API or deprecate it entirely?
 func (obj *World) URI() string {
@@ -34,6 +34,7 @@ import (
 	"encoding/base64"
 	"encoding/gob"
 
+	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/util/errwrap"
 )
 
@@ -44,7 +45,6 @@ func init() {
 // Deploy represents a deploy action, include the type of GAPI to deploy, the
 // payload of that GAPI, and any deploy specific parameters that were chosen.
 // TODO: add staged rollout functionality to this struct
-// TODO: add proper authentication with gpg key signing
 type Deploy struct {
 	ID   uint64
 	Name string // lang, puppet, yaml, etc...
@@ -54,7 +54,41 @@ type Deploy struct {
 
 	NoAutoEdges bool
 
+	// FsURI is the URI of the file system that the deploy was copied into.
+	// The files in it are covered by the deploy signature.
+	FsURI string
+
 	GAPI GAPI
+
+	// verifiedFs is the copy of the file system which was checked against
+	// the deploy signature. It's never stored with the deploy.
+	verifiedFs engine.Fs
+}
+
+// SetVerifiedFs stores the copy of the file system which was checked against
+// the deploy signature. The file system at the FsURI could change at any time,
+// so the GAPI must read the files from this copy instead.
+func (obj *Deploy) SetVerifiedFs(fs engine.Fs) {
+	obj.verifiedFs = fs
+}
+
+// VerifiedFs returns the file system that was stored with SetVerifiedFs. It is
+// nil if the deploy wasn't verified.
+func (obj *Deploy) VerifiedFs() engine.Fs {
+	return obj.verifiedFs
+}
+
+// URI returns the URI of the file system that the deploy stored its files in.
+// Older deploys don't have the FsURI, so the URI of the GAPI is used for them.
+// It's empty if the deploy has no files.
+func (obj *Deploy) URI() string {
+	if obj.FsURI != "" {
+		return obj.FsURI
+	}
+	if obj.GAPI == nil {
+		return ""
+	}
+	return obj.GAPI.Info().URI
 }
 
 // ToB64 encodes a deploy struct as a base64 encoded string.
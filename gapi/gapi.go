@@ -37,6 +37,7 @@ import (
 	"github.com/purpleidea/mgmt/engine"
 	"github.com/purpleidea/mgmt/engine/local"
 	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/prometheus"
 )
 
 // RegisteredGAPIs is a global map of all possible GAPIs which can be used. You
@@ -104,6 +105,9 @@ type Data struct {
 	Noop          bool
 	NoStreamWatch bool
 	Prefix        string
+	Prometheus    *prometheus.Prometheus // optional, nil if unused
+	TraceFuncs    string                 // optional function graph trace file
+	TraceListen   string                 // optional function graph trace addr
 	Debug         bool
 	Logf          func(format string, v ...interface{})
 	// NOTE: we can add more fields here if needed by GAPI endpoints
@@ -111,6 +111,11 @@ func (obj *StmtImport) ScopeGraph(g *pgraph.Graph) {
 	g.AddVertex(obj)
 }
 
+// ScopeGraph adds nodes and vertices to the supplied graph.
+func (obj *StmtType) ScopeGraph(g *pgraph.Graph) {
+	g.AddVertex(obj)
+}
+
 // ScopeGraph adds nodes and vertices to the supplied graph.
 func (obj *StmtComment) ScopeGraph(g *pgraph.Graph) {
 	g.AddVertex(obj)
@@ -249,3 +254,8 @@ func (obj *ExprSingleton) ScopeGraph(g *pgraph.Graph) {
 func (obj *ExprIf) ScopeGraph(g *pgraph.Graph) {
 	g.AddVertex(obj)
 }
+
+// ScopeGraph adds nodes and vertices to the supplied graph.
+func (obj *ExprMatch) ScopeGraph(g *pgraph.Graph) {
+	g.AddVertex(obj)
+}
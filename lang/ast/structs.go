@@ -308,6 +308,19 @@ func (obj *StmtBind) Ordering(produces map[string]interfaces.Node) (*pgraph.Grap
 // SetScope stores the scope for later use in this resource and its children,
 // which it propagates this downwards to.
 func (obj *StmtBind) SetScope(scope *interfaces.Scope) error {
+	// The parser doesn't know what a type alias stands for, so it can't set
+	// the type of the value like it does with other types. We do it here.
+	typ, err := resolveType(scope, obj.Type)
+	if err != nil {
+		return errwrap.Wrapf(err, "could not resolve type of `$%s`", obj.Ident)
+	}
+	if typ != obj.Type {
+		if err := obj.Value.SetType(typ); err != nil {
+			return errwrap.Wrapf(err, "could not set type of `$%s` to %s", obj.Ident, typ.Pretty())
+		}
+		obj.Type = typ
+	}
+
 	emptyContext := map[string]interfaces.Expr{}
 	return obj.Value.SetScope(scope, emptyContext)
 }
@@ -4626,6 +4639,7 @@ func (obj *StmtProg) Apply(fn func(interfaces.Node) error) error {
 // validate.
 func (obj *StmtProg) Init(data *interfaces.Data) error {
 	obj.data = data
+	obj.Textarea.Setup(data)
 	obj.importProgs = []*StmtProg{}
 	obj.importFiles = []string{}
 	obj.nodeOrder = []interfaces.Stmt{}
@@ -5473,6 +5487,7 @@ func (obj *StmtProg) SetScope(scope *interfaces.Scope) error {
 	newVariables := make(map[string]string)
 	newFunctions := make(map[string]string)
 	newClasses := make(map[string]string)
+	newTypes := make(map[string]string)
 	// TODO: If we added .Ordering() for *StmtImport, we could combine this
 	// loop with the main nodeOrder sorted topological ordering loop below!
 	for _, x := range obj.Body {
@@ -5555,6 +5570,21 @@ func (obj *StmtProg) SetScope(scope *interfaces.Scope) error {
 			newClasses[newName] = imp.Name
 			newScope.Classes[newName] = x
 		}
+		for name, x := range importedScope.Types {
+			newName := alias + interfaces.ModuleSep + name
+			if alias == interfaces.BareSymbol {
+				if !AllowBareImports {
+					return fmt.Errorf("bare imports disabled at compile time for import of `%s`", imp.Name)
+				}
+				newName = name
+			}
+			if previous, exists := newTypes[newName]; exists && alias != interfaces.BareSymbol {
+				// don't overwrite in same scope
+				return fmt.Errorf("can't squash type `%s` from `%s` by import of `%s`", newName, previous, imp.Name)
+			}
+			newTypes[newName] = imp.Name
+			newScope.Types[newName] = x
+		}
 
 		// everything has been merged, move on to next import...
 		imports[imp.Name] = struct{}{} // mark as found in scope
@@ -5563,6 +5593,12 @@ func (obj *StmtProg) SetScope(scope *interfaces.Scope) error {
 		}
 	}
 
+	// Type aliases can only refer to other types, so they aren't part of
+	// the ordering. They're added first, so that everything can use them.
+	if err := obj.setScopeTypes(newScope); err != nil {
+		return err
+	}
+
 	// TODO: this could be called once at the top-level, and then cached...
 	// TODO: it currently gets called inside child programs, which is slow!
 	orderingGraph, _, err := obj.Ordering(nil) // XXX: pass in globals from scope?
@@ -5930,6 +5966,76 @@ func (obj *StmtProg) SetScope(scope *interfaces.Scope) error {
 	return nil
 }
 
+// setScopeTypes resolves the type alias statements in this program, and adds
+// them to the scope. They may be declared in any order, and they may refer to
+// each other, and to any types which were already in scope, but they can't be
+// recursive. A type alias may shadow one of the same name from a parent scope.
+func (obj *StmtProg) setScopeTypes(scope *interfaces.Scope) error {
+	stmts := make(map[string]*StmtType)
+	names := []string{}
+	for _, x := range obj.Body {
+		stmt, ok := x.(*StmtType)
+		if !ok {
+			continue
+		}
+		// check for duplicates *in this scope*
+		if _, exists := stmts[stmt.Name]; exists {
+			return fmt.Errorf("type `%s` already exists in this scope", stmt.Name)
+		}
+		stmts[stmt.Name] = stmt
+		names = append(names, stmt.Name)
+	}
+	if len(stmts) == 0 {
+		return nil
+	}
+	sort.Strings(names) // for deterministic errors
+
+	parent := scope.Types // read from this before we add to it
+	resolved := make(map[string]*types.Type)
+	visiting := make(map[string]struct{}) // for detecting cycles
+	var lookup func(string) (*types.Type, error)
+	lookup = func(name string) (*types.Type, error) {
+		if typ, exists := resolved[name]; exists {
+			return typ, nil
+		}
+		stmt, exists := stmts[name]
+		if !exists {
+			if typ, exists := parent[name]; exists {
+				return typ, nil
+			}
+			return nil, fmt.Errorf("type `%s` does not exist in this scope", name)
+		}
+		if _, exists := visiting[name]; exists {
+			return nil, fmt.Errorf("type `%s` is recursive", name)
+		}
+		visiting[name] = struct{}{}
+		typ, err := stmt.Type.Resolve(lookup)
+		delete(visiting, name)
+		if err != nil {
+			return nil, err
+		}
+		typ = typ.Copy() // don't rename the original
+		typ.Name = name
+		resolved[name] = typ
+		return typ, nil
+	}
+
+	typs := make(map[string]*types.Type)
+	for k, v := range parent { // copy
+		typs[k] = v
+	}
+	for _, name := range names {
+		typ, err := lookup(name)
+		if err != nil {
+			return errwrap.Wrapf(err, "could not resolve type `%s`", name)
+		}
+		typs[name] = typ // add to scope, (shadowing is ok)
+	}
+	scope.Types = typs
+
+	return nil
+}
+
 // TypeCheck returns the list of invariants that this node produces. It does so
 // recursively on any children elements that exist in the AST, and returns the
 // collection to the caller. It calls TypeCheck for child statements, and
@@ -6156,13 +6262,14 @@ func (obj *StmtProg) Output(table map[interfaces.Func]types.Value) (*interfaces.
 // TODO: technically this could be a method on Stmt, possibly using Apply...
 func (obj *StmtProg) IsModuleUnsafe() error { // TODO: rename this function?
 	for _, x := range obj.Body {
-		// stmt's allowed: import, bind, func, class
+		// stmt's allowed: import, bind, func, class, type
 		// stmt's not-allowed: for, forkv, if, include, res, edge
 		switch x.(type) {
 		case *StmtImport:
 		case *StmtBind:
 		case *StmtFunc:
 		case *StmtClass:
+		case *StmtType:
 		case *StmtComment: // possibly not even parsed
 			// all of these are safe
 		default:
@@ -6320,6 +6427,12 @@ func (obj *StmtFunc) Ordering(produces map[string]interfaces.Node) (*pgraph.Grap
 // necessary in order to reach this, in particular in situations when a bound
 // expression points to a previously bound expression.
 func (obj *StmtFunc) SetScope(scope *interfaces.Scope) error {
+	typ, err := resolveType(scope, obj.Type)
+	if err != nil {
+		return errwrap.Wrapf(err, "could not resolve type of func `%s`", obj.Name)
+	}
+	obj.Type = typ
+
 	return obj.Func.SetScope(scope, map[string]interfaces.Expr{})
 }
 
@@ -6556,6 +6669,19 @@ func (obj *StmtClass) SetScope(scope *interfaces.Scope) error {
 	// site and not the variables which were in scope at the include site.
 	obj.scope = scope // store for later
 
+	for i, arg := range obj.Args {
+		typ, err := resolveType(scope, arg.Type)
+		if err != nil {
+			return errwrap.Wrapf(err, "could not resolve type of class arg `$%s`", arg.Name)
+		}
+		if typ != arg.Type {
+			obj.Args[i] = &interfaces.Arg{
+				Name: arg.Name,
+				Type: typ,
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -7198,11 +7324,113 @@ func (obj *StmtImport) Output(map[interfaces.Func]types.Value) (*interfaces.Outp
 	return interfaces.EmptyOutput(), nil
 }
 
-// StmtComment is a representation of a comment. It is currently unused. It
-// probably makes sense to make a third kind of Node (not a Stmt or an Expr) so
-// that comments can still be part of the AST (for eventual automatic code
-// formatting) but so that they can exist anywhere in the code. Currently these
-// are dropped by the lexer.
+// StmtType is a representation of a type alias declaration. It gives a name to
+// a type, which can then be used anywhere that a type can be written, such as
+// in `type Config = struct{name str; port int}`. Like a bind statement, it is
+// in scope irrespective of the order of definition, and it is exported to the
+// users of an import. These are resolved by StmtProg in SetScope, which keeps
+// the name on the resolved type, so that type errors can show it.
+type StmtType struct {
+	Textarea
+	data *interfaces.Data
+
+	Name string
+	Type *types.Type
+}
+
+// String returns a short representation of this statement.
+func (obj *StmtType) String() string {
+	return fmt.Sprintf("type(%s)", obj.Name)
+}
+
+// Apply is a general purpose iterator method that operates on any AST node. It
+// is not used as the primary AST traversal function because it is less readable
+// and easy to reason about than manually implementing traversal for each node.
+// Nevertheless, it is a useful facility for operations that might only apply to
+// a select number of node types, since they won't need extra noop iterators...
+func (obj *StmtType) Apply(fn func(interfaces.Node) error) error { return fn(obj) }
+
+// Init initializes this branch of the AST, and returns an error if it fails to
+// validate.
+func (obj *StmtType) Init(data *interfaces.Data) error {
+	obj.data = data
+	obj.Textarea.Setup(data)
+
+	if obj.Name == "" {
+		return fmt.Errorf("type name is empty")
+	}
+	if obj.Type == nil {
+		return fmt.Errorf("type `%s` is missing its definition", obj.Name)
+	}
+	return nil
+}
+
+// Interpolate returns a new node (aka a copy) once it has been expanded. This
+// generally increases the size of the AST when it is used. It calls Interpolate
+// on any child elements and builds the new node with those new node contents.
+// Here it simply returns a copy of itself, as no interpolation is possible.
+func (obj *StmtType) Interpolate() (interfaces.Stmt, error) {
+	return &StmtType{
+		Textarea: obj.Textarea,
+		data:     obj.data,
+		Name:     obj.Name,
+		Type:     obj.Type,
+	}, nil
+}
+
+// Copy returns a light copy of this struct. Anything static will not be copied.
+func (obj *StmtType) Copy() (interfaces.Stmt, error) {
+	return obj, nil // always static
+}
+
+// Ordering returns a graph of the scope ordering that represents the data flow.
+// This can be used in SetScope so that it knows the correct order to run it in.
+// Types don't depend on any values, so StmtProg adds all of them to the scope
+// before anything else, and nothing special needs to happen in here.
+func (obj *StmtType) Ordering(produces map[string]interfaces.Node) (*pgraph.Graph, map[interfaces.Node]string, error) {
+	graph, err := pgraph.NewGraph("ordering")
+	if err != nil {
+		return nil, nil, err
+	}
+	graph.AddVertex(obj)
+
+	cons := make(map[interfaces.Node]string)
+	return graph, cons, nil
+}
+
+// SetScope does nothing for this struct, because the type alias is resolved and
+// added to the scope by the StmtProg that contains it.
+func (obj *StmtType) SetScope(*interfaces.Scope) error { return nil }
+
+// TypeCheck returns the list of invariants that this node produces. It does so
+// recursively on any children elements that exist in the AST, and returns the
+// collection to the caller. It calls TypeCheck for child statements, and
+// Infer/Check for child expressions. A type alias doesn't produce any.
+func (obj *StmtType) TypeCheck() ([]*interfaces.UnificationInvariant, error) {
+	return []*interfaces.UnificationInvariant{}, nil
+}
+
+// Graph returns the reactive function graph which is expressed by this node. It
+// includes any vertices produced by this node, and the appropriate edges to any
+// vertices that are produced by its children. Nodes which fulfill the Expr
+// interface directly produce vertices (and possible children) where as nodes
+// that fulfill the Stmt interface do not produces vertices, where as their
+// children might. This particular statement just returns an empty graph.
+func (obj *StmtType) Graph(*interfaces.Env) (*pgraph.Graph, error) {
+	return pgraph.NewGraph("type") // empty graph
+}
+
+// Output for the type statement produces no output.
+func (obj *StmtType) Output(map[interfaces.Func]types.Value) (*interfaces.Output, error) {
+	return interfaces.EmptyOutput(), nil
+}
+
+// StmtComment is a representation of a comment. It probably makes sense to
+// make a third kind of Node (not a Stmt or an Expr) so that comments can still
+// be part of the AST but so that they can exist anywhere in the code. Currently
+// the lexer keeps them out of the AST, and returns them on the side from the
+// LexParseWithComments function, with their positions set, so that the code
+// formatter can put them back in the right place.
 type StmtComment struct {
 	Textarea
 
@@ -7402,7 +7630,7 @@ func (obj *ExprBool) Func() (interfaces.Func, error) {
 // interface directly produce vertices (and possible children) where as nodes
 // that fulfill the Stmt interface do not produces vertices, where as their
 // children might. This returns a graph with a single vertex (itself) in it.
-func (obj *ExprBool) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
+func (obj *ExprBool) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
 	graph, err := pgraph.NewGraph("bool")
 	if err != nil {
 		return nil, nil, err
@@ -7412,6 +7640,7 @@ func (obj *ExprBool) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, err
 		return nil, nil, err
 	}
 	graph.AddVertex(function)
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -7605,7 +7834,7 @@ func (obj *ExprStr) Func() (interfaces.Func, error) {
 // interface directly produce vertices (and possible children) where as nodes
 // that fulfill the Stmt interface do not produces vertices, where as their
 // children might. This returns a graph with a single vertex (itself) in it.
-func (obj *ExprStr) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
+func (obj *ExprStr) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
 	graph, err := pgraph.NewGraph("str")
 	if err != nil {
 		return nil, nil, err
@@ -7615,6 +7844,7 @@ func (obj *ExprStr) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, erro
 		return nil, nil, err
 	}
 	graph.AddVertex(function)
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -7757,7 +7987,7 @@ func (obj *ExprInt) Func() (interfaces.Func, error) {
 // interface directly produce vertices (and possible children) where as nodes
 // that fulfill the Stmt interface do not produces vertices, where as their
 // children might. This returns a graph with a single vertex (itself) in it.
-func (obj *ExprInt) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
+func (obj *ExprInt) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
 	graph, err := pgraph.NewGraph("int")
 	if err != nil {
 		return nil, nil, err
@@ -7767,6 +7997,7 @@ func (obj *ExprInt) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, erro
 		return nil, nil, err
 	}
 	graph.AddVertex(function)
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -7911,7 +8142,7 @@ func (obj *ExprFloat) Func() (interfaces.Func, error) {
 // interface directly produce vertices (and possible children) where as nodes
 // that fulfill the Stmt interface do not produces vertices, where as their
 // children might. This returns a graph with a single vertex (itself) in it.
-func (obj *ExprFloat) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
+func (obj *ExprFloat) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
 	graph, err := pgraph.NewGraph("float")
 	if err != nil {
 		return nil, nil, err
@@ -7921,6 +8152,7 @@ func (obj *ExprFloat) Graph(*interfaces.Env) (*pgraph.Graph, interfaces.Func, er
 		return nil, nil, err
 	}
 	graph.AddVertex(function)
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -8247,6 +8479,7 @@ func (obj *ExprList) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func,
 		graph.AddEdge(f, function, edge) // element -> list
 	}
 
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -8712,6 +8945,7 @@ func (obj *ExprMap) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func,
 		graph.AddEdge(f, function, edge) // val -> map
 	}
 
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -9133,6 +9367,7 @@ func (obj *ExprStruct) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Fun
 		graph.AddEdge(f, function, edge) // field -> struct
 	}
 
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -9561,6 +9796,10 @@ func (obj *ExprFunc) SetScope(scope *interfaces.Scope, sctx map[string]interface
 	}
 	obj.scope = scope // store for later
 
+	if err := obj.resolveTypes(scope); err != nil {
+		return err
+	}
+
 	if obj.Body != nil {
 		sctxBody := make(map[string]interfaces.Expr)
 		for k, v := range sctx {
@@ -9594,6 +9833,58 @@ func (obj *ExprFunc) SetScope(scope *interfaces.Scope, sctx map[string]interface
 	return nil
 }
 
+// resolveTypes resolves any type aliases in the arg and return types using the
+// scope. If they were used, the parser couldn't set the type of this function,
+// so if all of the types are known, we do that here instead.
+func (obj *ExprFunc) resolveTypes(scope *interfaces.Scope) error {
+	changed := false
+	args := []*interfaces.Arg{}
+	for _, arg := range obj.Args {
+		typ, err := resolveType(scope, arg.Type)
+		if err != nil {
+			return errwrap.Wrapf(err, "could not resolve type of arg `$%s`", arg.Name)
+		}
+		if typ != arg.Type {
+			arg = &interfaces.Arg{
+				Name: arg.Name,
+				Type: typ,
+			}
+			changed = true
+		}
+		args = append(args, arg)
+	}
+	out, err := resolveType(scope, obj.Return)
+	if err != nil {
+		return errwrap.Wrapf(err, "could not resolve return type")
+	}
+	if out != obj.Return {
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	obj.Args = args
+	obj.Return = out
+
+	if obj.Return == nil {
+		return nil
+	}
+	typ := &types.Type{
+		Kind: types.KindFunc,
+		Map:  make(map[string]*types.Type),
+		Ord:  []string{},
+		Out:  obj.Return,
+	}
+	for _, arg := range obj.Args {
+		if arg.Type == nil {
+			return nil // at least one is unknown, can't run SetType...
+		}
+		typ.Map[arg.Name] = arg.Type
+		typ.Ord = append(typ.Ord, arg.Name)
+	}
+	return obj.SetType(typ)
+}
+
 // SetType is used to set the type of this expression once it is known. This
 // usually happens during type unification, but it can also happen during
 // parsing if a type is specified explicitly. Since types are static and don't
@@ -9948,6 +10239,7 @@ func (obj *ExprFunc) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func,
 				return nil, errwrap.Wrapf(err, "ExprFunc.Copy() does not produce an ExprFunc")
 			}
 			valueTransformingFunc := funcExprCopy.function
+			locateFunc(env, valueTransformingFunc, obj)
 			txn.AddVertex(valueTransformingFunc)
 			for i, arg := range args {
 				argName := obj.typ.Ord[i]
@@ -9984,6 +10276,7 @@ func (obj *ExprFunc) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func,
 		return nil, nil, err
 	}
 	outerGraph.AddVertex(funcValueFunc)
+	locateFunc(env, funcValueFunc, obj)
 	return outerGraph, funcValueFunc, nil
 }
 
@@ -10937,6 +11230,7 @@ func (obj *ExprCall) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func,
 			return nil, nil, err
 		}
 
+		locateFunc(env, outputFunc, obj)
 		return txn.Graph(), outputFunc, nil
 	} else if err != nil && ok && canSpeculate && err != funcs.ErrCantSpeculate {
 		// This is a permanent error, not a temporary speculation error.
@@ -10963,6 +11257,7 @@ func (obj *ExprCall) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func,
 		Args: []string{edgeName},
 	})
 
+	locateFunc(env, callFunc, obj)
 	return graph, callFunc, nil
 }
 
@@ -10982,6 +11277,9 @@ func (obj *ExprCall) funcValueFunc(env *interfaces.Env) (*pgraph.Graph, interfac
 		}
 		// XXX: AFAICT we can *always* use the real env here. Ask Sam!
 		useEnv := interfaces.EmptyEnv()
+		if env != nil {
+			useEnv.Locator = env.Locator // keep tracking the positions
+		}
 		if (isParam || isIterated) && obj.Var {
 			useEnv = env
 		}
@@ -11134,6 +11432,7 @@ func (obj *ExprVar) Apply(fn func(interfaces.Node) error) error { return fn(obj)
 // validate.
 func (obj *ExprVar) Init(data *interfaces.Data) error {
 	obj.data = data
+	obj.Textarea.Setup(data)
 
 	return langUtil.ValidateVarName(obj.Name)
 }
@@ -12695,6 +12994,7 @@ func (obj *ExprIf) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func, e
 		graph.AddEdge(f, function, edge) // branch -> if
 	}
 
+	locateFunc(env, function, obj)
 	return graph, function, nil
 }
 
@@ -12726,3 +13026,555 @@ func (obj *ExprIf) Value() (types.Value, error) {
 	}
 	return obj.ElseBranch.Value()
 }
+
+// ExprMatch represents a match expression which compares the value of the
+// subject against the pattern of each arm in order, and returns the value of
+// the first arm that is equal. A default arm *must* be present, so that there
+// is always a value to return. As a result, it has a type.
+type ExprMatch struct {
+	Textarea
+	data  *interfaces.Data
+	scope *interfaces.Scope // store for referencing this later
+	typ   *types.Type
+
+	typSubject *types.Type // unification variable for the subject type
+
+	Subject interfaces.Expr
+	Arms    []*ExprMatchArm
+	Default interfaces.Expr
+}
+
+// ExprMatchArm represents a pattern and value pair in a match expression. This
+// does not satisfy the Expr interface.
+type ExprMatchArm struct {
+	Textarea
+
+	Pattern interfaces.Expr // compared against the subject for equality
+	Value   interfaces.Expr
+}
+
+// String returns a short representation of this expression.
+func (obj *ExprMatch) String() string {
+	var s []string
+	for _, x := range obj.Arms {
+		s = append(s, fmt.Sprintf("%s => %s", x.Pattern.String(), x.Value.String()))
+	}
+	if obj.Default != nil {
+		s = append(s, fmt.Sprintf("default => %s", obj.Default.String()))
+	}
+	return fmt.Sprintf("match( %s ) { %s }", obj.Subject.String(), strings.Join(s, ", "))
+}
+
+// Apply is a general purpose iterator method that operates on any AST node. It
+// is not used as the primary AST traversal function because it is less readable
+// and easy to reason about than manually implementing traversal for each node.
+// Nevertheless, it is a useful facility for operations that might only apply to
+// a select number of node types, since they won't need extra noop iterators...
+func (obj *ExprMatch) Apply(fn func(interfaces.Node) error) error {
+	if err := obj.Subject.Apply(fn); err != nil {
+		return err
+	}
+	for _, x := range obj.Arms {
+		if err := x.Pattern.Apply(fn); err != nil {
+			return err
+		}
+		if err := x.Value.Apply(fn); err != nil {
+			return err
+		}
+	}
+	if err := obj.Default.Apply(fn); err != nil {
+		return err
+	}
+	return fn(obj)
+}
+
+// Init initializes this branch of the AST, and returns an error if it fails to
+// validate.
+func (obj *ExprMatch) Init(data *interfaces.Data) error {
+	obj.data = data
+	obj.Textarea.Setup(data)
+
+	if obj.Subject == nil {
+		return fmt.Errorf("match expression is missing a subject")
+	}
+	if obj.Default == nil {
+		return fmt.Errorf("match expression must have a default arm")
+	}
+
+	if err := obj.Subject.Init(data); err != nil {
+		return err
+	}
+	for _, x := range obj.Arms {
+		if x.Pattern == nil || x.Value == nil {
+			return fmt.Errorf("match expression has an empty arm")
+		}
+		x.Textarea.Setup(data)
+		if err := x.Pattern.Init(data); err != nil {
+			return err
+		}
+		if err := x.Value.Init(data); err != nil {
+			return err
+		}
+	}
+	if err := obj.Default.Init(data); err != nil {
+		return err
+	}
+
+	// no errors
+	return nil
+}
+
+// Interpolate returns a new node (aka a copy) once it has been expanded. This
+// generally increases the size of the AST when it is used. It calls Interpolate
+// on any child elements and builds the new node with those new node contents.
+func (obj *ExprMatch) Interpolate() (interfaces.Expr, error) {
+	subject, err := obj.Subject.Interpolate()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not interpolate Subject")
+	}
+	arms := []*ExprMatchArm{}
+	for _, x := range obj.Arms {
+		pattern, err := x.Pattern.Interpolate()
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "could not interpolate Pattern")
+		}
+		value, err := x.Value.Interpolate()
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "could not interpolate Value")
+		}
+		arm := &ExprMatchArm{
+			Textarea: x.Textarea,
+			Pattern:  pattern,
+			Value:    value,
+		}
+		arms = append(arms, arm)
+	}
+	def, err := obj.Default.Interpolate()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not interpolate Default")
+	}
+	return &ExprMatch{
+		Textarea: obj.Textarea,
+		data:     obj.data,
+		scope:    obj.scope,
+		typ:      obj.typ,
+		Subject:  subject,
+		Arms:     arms,
+		Default:  def,
+	}, nil
+}
+
+// Copy returns a light copy of this struct. Anything static will not be copied.
+func (obj *ExprMatch) Copy() (interfaces.Expr, error) {
+	copied := false
+	subject, err := obj.Subject.Copy()
+	if err != nil {
+		return nil, err
+	}
+	// must have been copied, or pointer would be same
+	if subject != obj.Subject {
+		copied = true
+	}
+	arms := []*ExprMatchArm{}
+	for _, x := range obj.Arms {
+		pattern, err := x.Pattern.Copy()
+		if err != nil {
+			return nil, err
+		}
+		if pattern != x.Pattern {
+			copied = true
+		}
+		value, err := x.Value.Copy()
+		if err != nil {
+			return nil, err
+		}
+		if value != x.Value {
+			copied = true
+		}
+		arm := &ExprMatchArm{
+			Textarea: x.Textarea,
+			Pattern:  pattern,
+			Value:    value,
+		}
+		arms = append(arms, arm)
+	}
+	def, err := obj.Default.Copy()
+	if err != nil {
+		return nil, err
+	}
+	if def != obj.Default {
+		copied = true
+	}
+
+	if !copied { // it's static
+		return obj, nil
+	}
+	return &ExprMatch{
+		Textarea: obj.Textarea,
+		data:     obj.data,
+		scope:    obj.scope,
+		typ:      obj.typ,
+		Subject:  subject,
+		Arms:     arms,
+		Default:  def,
+	}, nil
+}
+
+// Ordering returns a graph of the scope ordering that represents the data flow.
+// This can be used in SetScope so that it knows the correct order to run it in.
+func (obj *ExprMatch) Ordering(produces map[string]interfaces.Node) (*pgraph.Graph, map[interfaces.Node]string, error) {
+	graph, err := pgraph.NewGraph("ordering")
+	if err != nil {
+		return nil, nil, err
+	}
+	graph.AddVertex(obj)
+
+	// Additional constraints: We know the subject has to be satisfied
+	// before this match expression itself can be used, since we depend on
+	// that value.
+	edge := &pgraph.SimpleEdge{Name: "exprmatch"}
+	graph.AddEdge(obj.Subject, obj, edge) // prod -> cons
+
+	cons := make(map[interfaces.Node]string)
+
+	g, c, err := obj.Subject.Ordering(produces)
+	if err != nil {
+		return nil, nil, err
+	}
+	graph.AddGraph(g) // add in the child graph
+
+	for k, v := range c { // c is consumes
+		x, exists := cons[k]
+		if exists && v != x {
+			return nil, nil, fmt.Errorf("consumed value is different, got `%+v`, expected `%+v`", x, v)
+		}
+		cons[k] = v // add to map
+
+		n, exists := produces[v]
+		if !exists {
+			continue
+		}
+		edge := &pgraph.SimpleEdge{Name: "exprmatchsubject"}
+		graph.AddEdge(n, k, edge)
+	}
+
+	// don't put obj.Subject here because this adds an extra edge to it!
+	nodes := []interfaces.Expr{}
+	for _, x := range obj.Arms {
+		nodes = append(nodes, x.Pattern, x.Value)
+	}
+	nodes = append(nodes, obj.Default)
+
+	for _, node := range nodes { // "dry"
+		g, c, err := node.Ordering(produces)
+		if err != nil {
+			return nil, nil, err
+		}
+		graph.AddGraph(g) // add in the child graph
+
+		// additional constraints...
+		edge1 := &pgraph.SimpleEdge{Name: "exprmatcharm1"}
+		graph.AddEdge(obj.Subject, node, edge1) // prod -> cons
+		edge2 := &pgraph.SimpleEdge{Name: "exprmatcharmsubject"}
+		graph.AddEdge(node, obj, edge2) // prod -> cons
+
+		for k, v := range c { // c is consumes
+			x, exists := cons[k]
+			if exists && v != x {
+				return nil, nil, fmt.Errorf("consumed value is different, got `%+v`, expected `%+v`", x, v)
+			}
+			cons[k] = v // add to map
+
+			n, exists := produces[v]
+			if !exists {
+				continue
+			}
+			edge := &pgraph.SimpleEdge{Name: "exprmatcharm2"}
+			graph.AddEdge(n, k, edge)
+		}
+	}
+
+	return graph, cons, nil
+}
+
+// SetScope stores the scope for later use in this resource and its children,
+// which it propagates this downwards to.
+func (obj *ExprMatch) SetScope(scope *interfaces.Scope, sctx map[string]interfaces.Expr) error {
+	if scope == nil {
+		scope = interfaces.EmptyScope()
+	}
+	obj.scope = scope
+	for _, x := range obj.Arms {
+		if err := x.Pattern.SetScope(scope, sctx); err != nil {
+			return err
+		}
+		if err := x.Value.SetScope(scope, sctx); err != nil {
+			return err
+		}
+	}
+	if err := obj.Default.SetScope(scope, sctx); err != nil {
+		return err
+	}
+	return obj.Subject.SetScope(scope, sctx)
+}
+
+// SetType is used to set the type of this expression once it is known. This
+// usually happens during type unification, but it can also happen during
+// parsing if a type is specified explicitly. Since types are static and don't
+// change on expressions, if you attempt to set a different type than what has
+// previously been set (when not initially known) this will error.
+func (obj *ExprMatch) SetType(typ *types.Type) error {
+	// This runs after unification, so we can now check that the subject
+	// is of a kind that we can match on, which unification can't express.
+	if obj.typSubject != nil {
+		if t := unificationUtil.Extract(obj.typSubject); t.Uni == nil {
+			if err := structs.MatchSubjectKind(t); err != nil {
+				return err
+			}
+		}
+	}
+	if obj.typ != nil {
+		return obj.typ.Cmp(typ) // if not set, ensure it doesn't change
+	}
+	obj.typ = typ // set
+	return nil
+}
+
+// Type returns the type of this expression.
+func (obj *ExprMatch) Type() (*types.Type, error) {
+	if obj.typ != nil {
+		return obj.typ, nil
+	}
+
+	var typ *types.Type
+	testAndSet := func(t *types.Type) error {
+		if t == nil {
+			return nil // skip
+		}
+		if typ == nil {
+			typ = t // save
+			return nil
+		}
+		if err := typ.Cmp(t); err != nil {
+			return errwrap.Wrapf(err, "inconsistent arm")
+		}
+		return nil
+	}
+
+	nodes := []interfaces.Expr{}
+	for _, x := range obj.Arms {
+		nodes = append(nodes, x.Value)
+	}
+	nodes = append(nodes, obj.Default)
+
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		t, err := node.Type()
+		if err != nil {
+			continue // not known yet
+		}
+		if err := testAndSet(t); err != nil {
+			return nil, err
+		}
+	}
+
+	if typ != nil {
+		return typ, nil
+	}
+	return nil, errwrap.Wrapf(interfaces.ErrTypeCurrentlyUnknown, obj.String())
+}
+
+// Infer returns the type of itself and a collection of invariants. The returned
+// type may contain unification variables. It collects the invariants by calling
+// Check on its children expressions. In making those calls, it passes in the
+// known type for that child to get it to "Check" it. When the type is not
+// known, it should create a new unification variable to pass in to the child
+// Check calls. Infer usually only calls Check on things inside of it, and often
+// does not call another Infer.
+func (obj *ExprMatch) Infer() (*types.Type, []*interfaces.UnificationInvariant, error) {
+	invariants := []*interfaces.UnificationInvariant{}
+
+	// Fail early if we already know that we can't match on the subject.
+	if t, err := obj.Subject.Type(); err == nil && t.Kind != types.KindUnification {
+		if err := structs.MatchSubjectKind(t); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Same unification var because every pattern must match the subject.
+	typSubject := &types.Type{
+		Kind: types.KindUnification,
+		Uni:  types.NewElem(), // unification variable, eg: ?1
+	}
+	obj.typSubject = typSubject // check it once it has been unified
+
+	subjectInvars, err := obj.Subject.Check(typSubject)
+	if err != nil {
+		return nil, nil, err
+	}
+	invariants = append(invariants, subjectInvars...)
+
+	// Same unification var because all arms must have the same type.
+	typExpr := &types.Type{
+		Kind: types.KindUnification,
+		Uni:  types.NewElem(), // unification variable, eg: ?1
+	}
+
+	for _, x := range obj.Arms {
+		patternInvars, err := x.Pattern.Check(typSubject)
+		if err != nil {
+			return nil, nil, err
+		}
+		invariants = append(invariants, patternInvars...)
+
+		valueInvars, err := x.Value.Check(typExpr)
+		if err != nil {
+			return nil, nil, err
+		}
+		invariants = append(invariants, valueInvars...)
+	}
+
+	defaultInvars, err := obj.Default.Check(typExpr)
+	if err != nil {
+		return nil, nil, err
+	}
+	invariants = append(invariants, defaultInvars...)
+
+	// Every infer call must have this section, because expr var needs this.
+	typType := typExpr
+	if obj.typ != nil {
+		typType = obj.typ
+	}
+	// This must be added even if redundant, so that we collect the obj ptr.
+	invar := &interfaces.UnificationInvariant{
+		Node:   obj,
+		Expr:   obj,
+		Expect: typExpr, // This is the type that we return.
+		Actual: typType,
+	}
+	invariants = append(invariants, invar)
+
+	return typExpr, invariants, nil
+}
+
+// Check is checking that the input type is equal to the object that Check is
+// running on. In doing so, it adds any invariants that are necessary. Check
+// must always call Infer to produce the invariant. The implementation can be
+// generic for all expressions.
+func (obj *ExprMatch) Check(typ *types.Type) ([]*interfaces.UnificationInvariant, error) {
+	return interfaces.GenericCheck(obj, typ)
+}
+
+// Func returns a function which returns the value of the matching arm based on
+// the ever changing subject input.
+func (obj *ExprMatch) Func() (interfaces.Func, error) {
+	typ, err := obj.Type()
+	if err != nil {
+		return nil, err
+	}
+	subject, err := obj.Subject.Type()
+	if err != nil {
+		return nil, err
+	}
+
+	return &structs.MatchFunc{
+		Type:    typ,     // this is the output type of the expression
+		Subject: subject, // this is the type of the subject and patterns
+		Len:     len(obj.Arms),
+	}, nil
+}
+
+// Graph returns the reactive function graph which is expressed by this node. It
+// includes any vertices produced by this node, and the appropriate edges to any
+// vertices that are produced by its children. Nodes which fulfill the Expr
+// interface directly produce vertices (and possible children) where as nodes
+// that fulfill the Stmt interface do not produces vertices, where as their
+// children might. Only the subject and the patterns are added here. The match
+// function adds the selected arm to the running graph, and swaps it out for
+// another one when the subject changes which arm gets selected.
+func (obj *ExprMatch) Graph(env *interfaces.Env) (*pgraph.Graph, interfaces.Func, error) {
+	graph, err := pgraph.NewGraph("match")
+	if err != nil {
+		return nil, nil, err
+	}
+	function, err := obj.Func()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	argNames := []string{structs.MatchFuncArgNameSubject}
+	exprs := map[string]interfaces.Expr{
+		structs.MatchFuncArgNameSubject: obj.Subject,
+	}
+	for i, x := range obj.Arms {
+		pattern := structs.MatchFuncArgNamePattern(i)
+		argNames = append(argNames, pattern)
+		exprs[pattern] = x.Pattern
+	}
+
+	for _, argName := range argNames { // deterministic order
+		x := exprs[argName]
+		g, f, err := x.Graph(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		graph.AddGraph(g)
+
+		edge := &interfaces.FuncEdge{Args: []string{argName}}
+		graph.AddEdge(f, function, edge) // pattern -> match
+	}
+
+	// The arms aren't in the graph. The match function adds the selected
+	// one when it knows which one it is, and swaps it out if that changes.
+	function.(*structs.MatchFunc).ArmGraph = func(txn interfaces.Txn, index int) (interfaces.Func, error) {
+		expr := obj.Default
+		if index < len(obj.Arms) {
+			expr = obj.Arms[index].Value
+		}
+		g, f, err := expr.Graph(env)
+		if err != nil {
+			return nil, err
+		}
+		txn.AddGraph(g)
+		return f, nil
+	}
+
+	locateFunc(env, function, obj)
+	return graph, function, nil
+}
+
+// SetValue here is a no-op, because algorithmically when this is called from
+// the func engine, the child fields (the arm expr's) will have had this done to
+// them first, and as such when we try and retrieve the set value from this
+// expression by calling `Value`, it will build it from scratch!
+func (obj *ExprMatch) SetValue(value types.Value) error {
+	if err := obj.typ.Cmp(value.Type()); err != nil {
+		return err
+	}
+	// noop!
+	return nil
+}
+
+// Value returns the value of this expression in our type system. This will
+// usually only be valid once the engine has run and values have been produced.
+// This might get called speculatively (early) during unification to learn more.
+// This particular expression evaluates the subject and returns the value of the
+// first arm whose pattern is equal to it, or the default arm otherwise.
+func (obj *ExprMatch) Value() (types.Value, error) {
+	subject, err := obj.Subject.Value()
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range obj.Arms {
+		pattern, err := x.Pattern.Value()
+		if err != nil {
+			return nil, err
+		}
+		if pattern.Cmp(subject) == nil {
+			return x.Value.Value()
+		}
+	}
+	return obj.Default.Value()
+}
@@ -328,6 +328,8 @@ func getScope(node interfaces.Expr) (*interfaces.Scope, error) {
 	//case *ExprSingleton:
 	case *ExprIf:
 		return expr.scope, nil
+	case *ExprMatch:
+		return expr.scope, nil
 
 	default:
 		return nil, fmt.Errorf("unexpected: %+v", node)
@@ -447,11 +449,38 @@ func checkParamScope(node interfaces.Expr, freeVars map[interfaces.Expr]struct{}
 		}
 		return nil
 
+	case *ExprMatch:
+		if err := checkParamScope(obj.Subject, freeVars); err != nil {
+			return err
+		}
+		for _, x := range obj.Arms {
+			if err := checkParamScope(x.Pattern, freeVars); err != nil {
+				return err
+			}
+			if err := checkParamScope(x.Value, freeVars); err != nil {
+				return err
+			}
+		}
+		if err := checkParamScope(obj.Default, freeVars); err != nil {
+			return err
+		}
+		return nil
+
 	default:
 		return fmt.Errorf("unexpected: %+v", node)
 	}
 }
 
+// locateFunc tells the locator in the env (if there is one) that this function
+// was built by this expression. It is called from the Graph methods of the
+// expressions that build a new function rather than passing one through.
+func locateFunc(env *interfaces.Env, f interfaces.Func, expr interfaces.Expr) {
+	if env == nil || env.Locator == nil || f == nil {
+		return
+	}
+	env.Locator(f, expr)
+}
+
 // trueCallee is a helper function because ExprTopLevel and ExprSingleton are
 // sometimes added around builtins. This makes it difficult for the type checker
 // to check if a particular builtin is the callee or not. This function removes
@@ -510,6 +539,22 @@ func newExprIterated(name string, definition interfaces.Expr) *ExprIterated {
 	return expr
 }
 
+// resolveType replaces any references to type aliases in the type with the
+// types that they stand for in the scope. A nil type stays nil, and a type that
+// doesn't use any aliases is returned as is.
+func resolveType(scope *interfaces.Scope, typ *types.Type) (*types.Type, error) {
+	return typ.Resolve(func(name string) (*types.Type, error) {
+		if scope == nil {
+			return nil, fmt.Errorf("type `%s` does not exist in an empty scope", name)
+		}
+		t, exists := scope.Types[name]
+		if !exists {
+			return nil, fmt.Errorf("type `%s` does not exist in this scope", name)
+		}
+		return t, nil
+	})
+}
+
 // variableScopeFeedback logs some messages about what is actually in scope so
 // that the user gets a hint about what's going on. This is useful for catching
 // bugs in our programming or in user code!
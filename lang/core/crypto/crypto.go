@@ -0,0 +1,62 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package corecrypto contains functions which compute hashes and other related
+// cryptographic values.
+package corecrypto
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+)
+
+const (
+	// ModuleName is the prefix given to all the functions in this module.
+	ModuleName = "crypto"
+)
+
+// hashes is a lookup table of the hash algorithms that we support. The names
+// are used as the function names, or as the prefix of them.
+var hashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// newHash returns a new hash of the named algorithm.
+func newHash(name string) (hash.Hash, error) {
+	fn, exists := hashes[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown hash: %s", name)
+	}
+	return fn(), nil
+}
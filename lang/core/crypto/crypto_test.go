@@ -0,0 +1,116 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package corecrypto
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/purpleidea/mgmt/lang/types"
+)
+
+func TestHash0(t *testing.T) {
+	values := []struct {
+		hash     string
+		s        string
+		expected string
+	}{
+		{
+			"md5",
+			"",
+			"d41d8cd98f00b204e9800998ecf8427e",
+		},
+		{
+			"md5",
+			"mgmt",
+			"d724f231a9a7b89757c4394d6622bc47",
+		},
+		{
+			"sha256",
+			"hello",
+			"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		},
+		{
+			"sha512",
+			"",
+			"cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+		},
+	}
+
+	for i, x := range values {
+		val, err := Hash(x.hash)(context.Background(), []types.Value{&types.StrValue{V: x.s}})
+		if err != nil {
+			t.Errorf("test #%d: hash failed with: %+v", i, err)
+			continue
+		}
+		if s := val.Str(); s != x.expected {
+			t.Errorf("test #%d: %s(%q) expected: %s, got: %s", i, x.hash, x.s, x.expected, s)
+		}
+	}
+}
+
+func TestHMAC0(t *testing.T) {
+	// this is test case #2 from RFC 4231
+	key := &types.StrValue{V: "Jefe"}
+	msg := &types.StrValue{V: "what do ya want for nothing?"}
+	val, err := HMAC("sha256")(context.Background(), []types.Value{key, msg})
+	if err != nil {
+		t.Fatalf("hmac failed with: %+v", err)
+	}
+	if s, exp := val.Str(), "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"; s != exp {
+		t.Errorf("expected: %s, got: %s", exp, s)
+	}
+}
+
+func TestFileHash0(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(filename, []byte("hello"), 0644); err != nil {
+		t.Fatalf("could not write file: %+v", err)
+	}
+	fn := &FileHashFunc{Hash: "sha256"}
+	if err := fn.Validate(); err != nil {
+		t.Fatalf("could not validate: %+v", err)
+	}
+	val, err := fn.Call(context.Background(), []types.Value{&types.StrValue{V: filename}})
+	if err != nil {
+		t.Fatalf("call failed with: %+v", err)
+	}
+	exp, err := Hash("sha256")(context.Background(), []types.Value{&types.StrValue{V: "hello"}})
+	if err != nil {
+		t.Fatalf("hash failed with: %+v", err)
+	}
+	if s := val.Str(); s != exp.Str() {
+		t.Errorf("expected: %s, got: %s", exp.Str(), s)
+	}
+}
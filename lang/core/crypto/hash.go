@@ -0,0 +1,106 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package corecrypto
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/purpleidea/mgmt/lang/funcs/simple"
+	"github.com/purpleidea/mgmt/lang/types"
+)
+
+func init() {
+	for name := range hashes {
+		simple.ModuleRegister(ModuleName, name, &simple.Scaffold{
+			I: &simple.Info{
+				Pure: true,
+				Memo: true,
+				Fast: true,
+				Spec: true,
+			},
+			T: types.NewType("func(s str) str"),
+			F: Hash(name),
+		})
+	}
+	simple.ModuleRegister(ModuleName, "hmac_sha256", &simple.Scaffold{
+		I: &simple.Info{
+			Pure: true,
+			Memo: true,
+			Fast: true,
+			Spec: true,
+		},
+		T: types.NewType("func(key str, message str) str"),
+		F: HMAC("sha256"),
+	})
+	simple.ModuleRegister(ModuleName, "hmac_sha512", &simple.Scaffold{
+		I: &simple.Info{
+			Pure: true,
+			Memo: true,
+			Fast: true,
+			Spec: true,
+		},
+		T: types.NewType("func(key str, message str) str"),
+		F: HMAC("sha512"),
+	})
+}
+
+// Hash returns a function which computes the hex encoded digest of a string
+// using the named hash algorithm.
+func Hash(name string) func(context.Context, []types.Value) (types.Value, error) {
+	return func(ctx context.Context, input []types.Value) (types.Value, error) {
+		h, err := newHash(name)
+		if err != nil {
+			return nil, err
+		}
+		h.Write([]byte(input[0].Str())) // never returns an error
+		return &types.StrValue{
+			V: hex.EncodeToString(h.Sum(nil)),
+		}, nil
+	}
+}
+
+// HMAC returns a function which computes the hex encoded HMAC of a message with
+// a key, using the named hash algorithm.
+func HMAC(name string) func(context.Context, []types.Value) (types.Value, error) {
+	return func(ctx context.Context, input []types.Value) (types.Value, error) {
+		fn, exists := hashes[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown hash: %s", name)
+		}
+		h := hmac.New(fn, []byte(input[0].Str()))
+		h.Write([]byte(input[1].Str())) // never returns an error
+		return &types.StrValue{
+			V: hex.EncodeToString(h.Sum(nil)),
+		}, nil
+	}
+}
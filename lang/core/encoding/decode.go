@@ -0,0 +1,349 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package coreencoding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/purpleidea/mgmt/lang/funcs"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// JSONDecodeFuncName is the name this function is registered as.
+	JSONDecodeFuncName = "json_decode"
+
+	// YAMLDecodeFuncName is the name this function is registered as.
+	YAMLDecodeFuncName = "yaml_decode"
+
+	// arg names...
+	decodeArgNameData = "data"
+	decodeArgNameType = "type"
+)
+
+func init() {
+	funcs.ModuleRegister(ModuleName, JSONDecodeFuncName, func() interfaces.Func { return &DecodeFunc{Format: formatJSON} })
+	funcs.ModuleRegister(ModuleName, YAMLDecodeFuncName, func() interfaces.Func { return &DecodeFunc{Format: formatYAML} })
+}
+
+var _ interfaces.InferableFunc = &DecodeFunc{} // ensure it meets this expectation
+
+// DecodeFunc parses a serialized document into a value of the type that is
+// named by the second arg. For example, `json_decode($data, "[]str")` returns a
+// list of strings. The type arg should be a static string so that the return
+// type is known during type unification. If it isn't, then the return type must
+// be determined by how the result is used. It errors if the document doesn't
+// match the type. Struct fields must all be present and no other keys may be.
+type DecodeFunc struct {
+	// Format is the serialization format that we decode.
+	Format string
+
+	// Type is the type of the value that we return. (When known.)
+	Type *types.Type
+
+	built bool // was this function built yet?
+
+	init *interfaces.Init
+	last types.Value // last value received to use for diff
+
+	result types.Value // last calculated output
+}
+
+// String returns a simple name for this function. This is needed so this struct
+// can satisfy the pgraph.Vertex interface.
+func (obj *DecodeFunc) String() string {
+	return fmt.Sprintf("%s_decode", obj.Format)
+}
+
+// ArgGen returns the Nth arg name for this function.
+func (obj *DecodeFunc) ArgGen(index int) (string, error) {
+	seq := []string{decodeArgNameData, decodeArgNameType}
+	if l := len(seq); index >= l {
+		return "", fmt.Errorf("index %d exceeds arg length of %d", index, l)
+	}
+	return seq[index], nil
+}
+
+// helper
+func (obj *DecodeFunc) sig() *types.Type {
+	out := "?1"
+	if obj.Type != nil {
+		out = obj.Type.String()
+	}
+	return types.NewType(fmt.Sprintf(
+		"func(%s str, %s str) %s",
+		decodeArgNameData,
+		decodeArgNameType,
+		out,
+	))
+}
+
+// FuncInfer takes partial type and value information from the call site of this
+// function so that it can build an appropriate type signature for it. The type
+// signature may include unification variables.
+func (obj *DecodeFunc) FuncInfer(partialType *types.Type, partialValues []types.Value) (*types.Type, []*interfaces.UnificationInvariant, error) {
+	// func(data str, type str) ?1
+
+	if l := 2; len(partialValues) != l {
+		return nil, nil, fmt.Errorf("function must have %d args", l)
+	}
+
+	// If the type arg is known statically, then we are solved!
+	if partialValues[1] != nil {
+		if err := partialValues[1].Type().Cmp(types.TypeStr); err != nil {
+			return nil, nil, errwrap.Wrapf(err, "function type arg must be a str")
+		}
+		typ, err := parseType(partialValues[1].Str())
+		if err != nil {
+			return nil, nil, err
+		}
+		obj.Type = typ
+	}
+
+	return obj.sig(), []*interfaces.UnificationInvariant{}, nil
+}
+
+// Build is run to turn the polymorphic, undetermined function, into the
+// specific statically typed version. It is usually run after Unify completes,
+// and must be run before Info() and any of the other Func interface methods are
+// used. This function is idempotent, as long as the arg isn't changed between
+// runs.
+func (obj *DecodeFunc) Build(typ *types.Type) (*types.Type, error) {
+	// typ is the KindFunc signature we're trying to build...
+	if typ.Kind != types.KindFunc {
+		return nil, fmt.Errorf("input type must be of kind func")
+	}
+
+	if len(typ.Ord) != 2 {
+		return nil, fmt.Errorf("the decode function needs exactly two args")
+	}
+	if typ.Out == nil {
+		return nil, fmt.Errorf("return type of function must be specified")
+	}
+	if typ.Map == nil {
+		return nil, fmt.Errorf("invalid input type")
+	}
+
+	for i, name := range typ.Ord {
+		t, exists := typ.Map[name]
+		if !exists || t == nil {
+			return nil, fmt.Errorf("arg #%d must be specified", i)
+		}
+		if err := t.Cmp(types.TypeStr); err != nil {
+			return nil, errwrap.Wrapf(err, "arg #%d must be a str", i)
+		}
+	}
+
+	if err := check(typ.Out); err != nil {
+		return nil, errwrap.Wrapf(err, "can't decode into type %s", typ.Out)
+	}
+	if obj.Type != nil {
+		if err := obj.Type.Cmp(typ.Out); err != nil {
+			return nil, errwrap.Wrapf(err, "return type doesn't match the type arg")
+		}
+	}
+
+	obj.Type = typ.Out
+	obj.built = true
+
+	return obj.sig(), nil
+}
+
+// Copy is implemented so that the obj.Type value is not lost if we copy this
+// function. That value is learned during FuncInfer, and previously would have
+// been lost by the time we used it in Build.
+func (obj *DecodeFunc) Copy() interfaces.Func {
+	return &DecodeFunc{
+		Format: obj.Format,
+		Type:   obj.Type, // don't copy because we use this after unification
+
+		built: obj.built,
+		init:  obj.init, // likely gets overwritten anyways
+	}
+}
+
+// Validate tells us if the input struct takes a valid form.
+func (obj *DecodeFunc) Validate() error {
+	if obj.Format != formatJSON && obj.Format != formatYAML {
+		return fmt.Errorf("unknown format: %s", obj.Format)
+	}
+	if !obj.built {
+		return fmt.Errorf("function wasn't built yet")
+	}
+	if obj.Type == nil {
+		return fmt.Errorf("type is still unspecified")
+	}
+	return check(obj.Type)
+}
+
+// Info returns some static info about itself. Build must be called before this
+// will return correct data.
+func (obj *DecodeFunc) Info() *interfaces.Info {
+	// Since this function implements FuncInfer we want sig to return nil to
+	// avoid an accidental return of unification variables when we should be
+	// getting them from FuncInfer, and not from here. (During unification!)
+	var sig *types.Type
+	if obj.built {
+		sig = obj.sig() // helper
+	}
+	return &interfaces.Info{
+		Pure: true,
+		Memo: true,
+		Fast: true,
+		Spec: true,
+		Sig:  sig,
+		Err:  obj.Validate(),
+	}
+}
+
+// Init runs some startup code for this function.
+func (obj *DecodeFunc) Init(init *interfaces.Init) error {
+	obj.init = init
+	return nil
+}
+
+// Stream returns the changing values that this func has over time.
+func (obj *DecodeFunc) Stream(ctx context.Context) error {
+	defer close(obj.init.Output) // the sender closes
+	for {
+		select {
+		case input, ok := <-obj.init.Input:
+			if !ok {
+				return nil // can't output any more
+			}
+
+			if obj.last != nil && input.Cmp(obj.last) == nil {
+				continue // value didn't change, skip it
+			}
+			obj.last = input // store for next
+
+			args, err := interfaces.StructToCallableArgs(input) // []types.Value, error)
+			if err != nil {
+				return err
+			}
+
+			result, err := obj.Call(ctx, args)
+			if err != nil {
+				return err
+			}
+
+			if obj.result != nil && result.Cmp(obj.result) == nil {
+				continue // result didn't change
+			}
+			obj.result = result // store new result
+
+		case <-ctx.Done():
+			return nil
+		}
+
+		select {
+		case obj.init.Output <- obj.result: // send
+			// pass
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Call this function with the input args and return the value if it is possible
+// to do so at this time.
+func (obj *DecodeFunc) Call(ctx context.Context, args []types.Value) (types.Value, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("not enough args")
+	}
+	data := args[0].Str()
+
+	typ, err := parseType(args[1].Str())
+	if err != nil {
+		return nil, err
+	}
+	if obj.Type == nil {
+		// programming error
+		return nil, fmt.Errorf("function wasn't built yet")
+	}
+	if err := obj.Type.Cmp(typ); err != nil {
+		return nil, fmt.Errorf("type arg changed from: `%s`, to: `%s`", obj.Type, typ)
+	}
+
+	return decode(obj.Format, []byte(data), obj.Type)
+}
+
+// parseType parses the type arg and makes sure we can decode into it.
+func parseType(s string) (*types.Type, error) {
+	typ := types.NewType(s)
+	if typ == nil {
+		return nil, fmt.Errorf("invalid type: `%s`", s)
+	}
+	if typ.HasUni() {
+		return nil, fmt.Errorf("type must be fully specified: `%s`", s)
+	}
+	if err := check(typ); err != nil {
+		return nil, errwrap.Wrapf(err, "can't decode into type %s", typ)
+	}
+	return typ, nil
+}
+
+// decode parses the data in the given format into a value of the given type.
+func decode(format string, data []byte, typ *types.Type) (types.Value, error) {
+	var x interface{}
+	switch format {
+	case formatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber() // so that we don't lose integer precision
+		if err := decoder.Decode(&x); err != nil {
+			return nil, errwrap.Wrapf(err, "invalid json")
+		}
+		if err := decoder.Decode(&struct{}{}); err != io.EOF {
+			return nil, fmt.Errorf("invalid json: unexpected data after the document")
+		}
+
+	case formatYAML:
+		if err := yaml.Unmarshal(data, &x); err != nil {
+			return nil, errwrap.Wrapf(err, "invalid yaml")
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+
+	val, err := toValue(typ, x)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "%s does not match type %s", format, typ)
+	}
+	return val, nil
+}
@@ -0,0 +1,149 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package coreencoding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/purpleidea/mgmt/lang/funcs/simple"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	simple.ModuleRegister(ModuleName, "json_encode", &simple.Scaffold{
+		I: &simple.Info{
+			Pure: true,
+			Memo: true,
+			Fast: true,
+			Spec: true,
+		},
+		T: types.NewType("func(a ?1) str"),
+		C: encodeCheck,
+		F: JSONEncode,
+	})
+	simple.ModuleRegister(ModuleName, "yaml_encode", &simple.Scaffold{
+		I: &simple.Info{
+			Pure: true,
+			Memo: true,
+			Fast: true,
+			Spec: true,
+		},
+		T: types.NewType("func(a ?1) str"),
+		C: encodeCheck,
+		F: YAMLEncode,
+	})
+	simple.ModuleRegister(ModuleName, "toml_encode", &simple.Scaffold{
+		I: &simple.Info{
+			Pure: true,
+			Memo: true,
+			Fast: true,
+			Spec: true,
+		},
+		T: types.NewType("func(a ?1) str"),
+		C: func(typ *types.Type) error {
+			if err := encodeCheck(typ); err != nil {
+				return err
+			}
+			// a toml document is always a table at the top-level
+			if k := typ.Map[typ.Ord[0]].Kind; k != types.KindStruct && k != types.KindMap {
+				return fmt.Errorf("toml can only encode a struct or a map, got: %s", k)
+			}
+			return nil
+		},
+		F: TOMLEncode,
+	})
+}
+
+// encodeCheck makes sure that the arg of the encode functions can be encoded.
+func encodeCheck(typ *types.Type) error {
+	if typ == nil {
+		return fmt.Errorf("nil type")
+	}
+	if typ.Kind != types.KindFunc {
+		return fmt.Errorf("not a func")
+	}
+	if len(typ.Map) != 1 || len(typ.Ord) != 1 {
+		return fmt.Errorf("arg count wrong")
+	}
+	return check(typ.Map[typ.Ord[0]])
+}
+
+// JSONEncode returns the compact json encoding of a value. Map keys are always
+// strings in json, so other kinds of keys are converted to strings.
+func JSONEncode(ctx context.Context, input []types.Value) (types.Value, error) {
+	x, err := fromValue(input[0], true)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "can't encode json")
+	}
+	return &types.StrValue{
+		V: string(b),
+	}, nil
+}
+
+// YAMLEncode returns the yaml encoding of a value.
+func YAMLEncode(ctx context.Context, input []types.Value) (types.Value, error) {
+	x, err := fromValue(input[0], false)
+	if err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(x)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "can't encode yaml")
+	}
+	return &types.StrValue{
+		V: string(b),
+	}, nil
+}
+
+// TOMLEncode returns the toml encoding of a struct or a map. Map keys are
+// always strings in toml, so other kinds of keys are converted to strings.
+func TOMLEncode(ctx context.Context, input []types.Value) (types.Value, error) {
+	x, err := fromValue(input[0], true)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toml.Marshal(x)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "can't encode toml")
+	}
+	return &types.StrValue{
+		V: string(b),
+	}, nil
+}
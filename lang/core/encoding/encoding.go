@@ -0,0 +1,387 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package coreencoding contains functions which convert between mcl values and
+// common serialization formats such as json, yaml and toml. It also contains the
+// simpler string encodings such as base64 and hex.
+package coreencoding
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// ModuleName is the prefix given to all the functions in this module.
+	ModuleName = "encoding"
+
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// check returns an error if the type can't be represented in the serialization
+// formats that we support. Functions and variants can't be encoded, and map
+// keys must be one of the basic kinds so that they can be written as strings.
+func check(typ *types.Type) error {
+	if typ == nil {
+		return fmt.Errorf("nil type")
+	}
+	switch typ.Kind {
+	case types.KindBool, types.KindStr, types.KindInt, types.KindFloat:
+		return nil
+
+	case types.KindList:
+		return check(typ.Val)
+
+	case types.KindMap:
+		switch typ.Key.Kind {
+		case types.KindBool, types.KindStr, types.KindInt, types.KindFloat:
+		default:
+			return fmt.Errorf("map key must be a basic kind, got: %s", typ.Key)
+		}
+		return check(typ.Val)
+
+	case types.KindStruct:
+		for _, k := range typ.Ord {
+			if err := check(typ.Map[k]); err != nil {
+				return errwrap.Wrapf(err, "field %s", k)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported kind: %s", typ.Kind)
+}
+
+// toValue converts a decoded golang value into a value of the given type. The
+// input is what the json (with UseNumber) and yaml decoders produce when they
+// decode into an empty interface. It errors if the input doesn't match the
+// type.
+func toValue(typ *types.Type, x interface{}) (types.Value, error) {
+	if x == nil {
+		return nil, fmt.Errorf("got null, expected: %s", typ)
+	}
+
+	switch typ.Kind {
+	case types.KindBool:
+		if b, ok := x.(bool); ok {
+			return &types.BoolValue{V: b}, nil
+		}
+
+	case types.KindStr:
+		if s, ok := x.(string); ok {
+			return &types.StrValue{V: s}, nil
+		}
+
+	case types.KindInt:
+		switch v := x.(type) {
+		case json.Number:
+			i, err := v.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("got %s, expected: %s", v, typ)
+			}
+			return &types.IntValue{V: i}, nil
+		case int:
+			return &types.IntValue{V: int64(v)}, nil
+		case int64:
+			return &types.IntValue{V: v}, nil
+		case uint64:
+			if v > math.MaxInt64 {
+				return nil, fmt.Errorf("integer overflow: %d", v)
+			}
+			return &types.IntValue{V: int64(v)}, nil
+		}
+
+	case types.KindFloat:
+		switch v := x.(type) {
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("got %s, expected: %s", v, typ)
+			}
+			return &types.FloatValue{V: f}, nil
+		case float64:
+			return &types.FloatValue{V: v}, nil
+		case int:
+			return &types.FloatValue{V: float64(v)}, nil
+		case int64:
+			return &types.FloatValue{V: float64(v)}, nil
+		case uint64:
+			return &types.FloatValue{V: float64(v)}, nil
+		}
+
+	case types.KindList:
+		l, ok := x.([]interface{})
+		if !ok {
+			break
+		}
+		list := types.NewList(typ)
+		for i, v := range l {
+			val, err := toValue(typ.Val, v)
+			if err != nil {
+				return nil, errwrap.Wrapf(err, "index %d", i)
+			}
+			if err := list.Add(val); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case types.KindMap:
+		m, ok := toMap(x)
+		if !ok {
+			break
+		}
+		mapping := types.NewMap(typ)
+		for k, v := range m {
+			key, err := toKey(typ.Key, k)
+			if err != nil {
+				return nil, errwrap.Wrapf(err, "key %v", k)
+			}
+			val, err := toValue(typ.Val, v)
+			if err != nil {
+				return nil, errwrap.Wrapf(err, "key %v", k)
+			}
+			if err := mapping.Add(key, val); err != nil {
+				return nil, err
+			}
+		}
+		return mapping, nil
+
+	case types.KindStruct:
+		m, ok := toMap(x)
+		if !ok {
+			break
+		}
+		st := types.NewStruct(typ)
+		for _, k := range typ.Ord {
+			v, exists := m[k]
+			if !exists {
+				return nil, fmt.Errorf("missing field: %s", k)
+			}
+			val, err := toValue(typ.Map[k], v)
+			if err != nil {
+				return nil, errwrap.Wrapf(err, "field %s", k)
+			}
+			if err := st.Set(k, val); err != nil {
+				return nil, err
+			}
+		}
+		if len(m) > len(typ.Ord) {
+			keys := []string{}
+			for k := range m {
+				if s, ok := k.(string); ok {
+					if _, exists := typ.Map[s]; exists {
+						continue
+					}
+				}
+				keys = append(keys, fmt.Sprintf("%v", k))
+			}
+			sort.Strings(keys) // deterministic errors
+			return nil, fmt.Errorf("unexpected field: %s", keys[0])
+		}
+		return st, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported kind: %s", typ.Kind)
+	}
+
+	return nil, fmt.Errorf("got %s, expected: %s", describe(x), typ)
+}
+
+// toMap returns the input as a map if it is one. The json decoder gives us
+// string keys, and the yaml decoder gives us keys of any type.
+func toMap(x interface{}) (map[interface{}]interface{}, bool) {
+	switch m := x.(type) {
+	case map[interface{}]interface{}:
+		return m, true
+	case map[string]interface{}:
+		out := make(map[interface{}]interface{}, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// toKey converts a decoded map key into a value of the given type. Some formats
+// only allow string keys, so those are parsed if we expect a different kind.
+func toKey(typ *types.Type, x interface{}) (types.Value, error) {
+	s, ok := x.(string)
+	if !ok || typ.Kind == types.KindStr {
+		return toValue(typ, x)
+	}
+	switch typ.Kind {
+	case types.KindBool:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return &types.BoolValue{V: b}, nil
+		}
+	case types.KindInt:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return &types.IntValue{V: i}, nil
+		}
+	case types.KindFloat:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return &types.FloatValue{V: f}, nil
+		}
+	}
+	return nil, fmt.Errorf("got %s, expected: %s", describe(x), typ)
+}
+
+// describe returns a short description of the kind of decoded value for errors.
+func describe(x interface{}) string {
+	switch x.(type) {
+	case bool:
+		return "bool"
+	case string:
+		return "str"
+	case json.Number, int, int64, uint64, float64:
+		return "number"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", x)
+}
+
+// fromValue converts a value into a golang value that the json, yaml and toml
+// encoders can all serialize. Structs become golang structs with tagged fields,
+// so that the field order is kept. If stringKeys is true, then all map keys are
+// converted to strings, since some formats don't support any other kind of key.
+func fromValue(val types.Value, stringKeys bool) (interface{}, error) {
+	switch typ := val.Type(); typ.Kind {
+	case types.KindBool:
+		return val.Bool(), nil
+
+	case types.KindStr:
+		return val.Str(), nil
+
+	case types.KindInt:
+		return val.Int(), nil
+
+	case types.KindFloat:
+		return val.Float(), nil
+
+	case types.KindList:
+		out := []interface{}{}
+		for _, v := range val.List() {
+			x, err := fromValue(v, stringKeys)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, x)
+		}
+		return out, nil
+
+	case types.KindMap:
+		if !stringKeys {
+			out := make(map[interface{}]interface{})
+			for k, v := range val.Map() {
+				key, err := fromValue(k, stringKeys)
+				if err != nil {
+					return nil, err
+				}
+				x, err := fromValue(v, stringKeys)
+				if err != nil {
+					return nil, err
+				}
+				out[key] = x
+			}
+			return out, nil
+		}
+		out := make(map[string]interface{})
+		for k, v := range val.Map() {
+			key, err := keyString(k)
+			if err != nil {
+				return nil, err
+			}
+			x, err := fromValue(v, stringKeys)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = x
+		}
+		return out, nil
+
+	case types.KindStruct:
+		fields := []reflect.StructField{}
+		values := []interface{}{}
+		for i, k := range typ.Ord {
+			v, exists := val.Struct()[k]
+			if !exists {
+				return nil, fmt.Errorf("missing struct field: %s", k)
+			}
+			x, err := fromValue(v, stringKeys)
+			if err != nil {
+				return nil, errwrap.Wrapf(err, "field %s", k)
+			}
+			fields = append(fields, reflect.StructField{
+				Name: fmt.Sprintf("F%d", i), // must be exported
+				Type: reflect.TypeOf((*interface{})(nil)).Elem(),
+				Tag:  reflect.StructTag(fmt.Sprintf(`json:"%s" yaml:"%s" toml:"%s"`, k, k, k)),
+			})
+			values = append(values, x)
+		}
+		st := reflect.New(reflect.StructOf(fields)).Elem()
+		for i, x := range values {
+			if x == nil {
+				continue
+			}
+			st.Field(i).Set(reflect.ValueOf(x))
+		}
+		return st.Interface(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported kind: %s", val.Type().Kind)
+}
+
+// keyString returns the string form of a map key.
+func keyString(val types.Value) (string, error) {
+	switch val.Type().Kind {
+	case types.KindBool:
+		return strconv.FormatBool(val.Bool()), nil
+	case types.KindStr:
+		return val.Str(), nil
+	case types.KindInt:
+		return strconv.FormatInt(val.Int(), 10), nil
+	case types.KindFloat:
+		return strconv.FormatFloat(val.Float(), 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported map key kind: %s", val.Type().Kind)
+}
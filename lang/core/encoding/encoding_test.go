@@ -0,0 +1,300 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package coreencoding
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util"
+)
+
+func TestDecode0(t *testing.T) {
+	type test struct { // an individual test
+		name   string
+		format string
+		data   string
+		typ    string
+		fail   bool
+		expect string // the String() of the value
+	}
+	testCases := []test{}
+
+	testCases = append(testCases, test{
+		name:   "json str",
+		format: formatJSON,
+		data:   `"hello"`,
+		typ:    "str",
+		expect: `"hello"`,
+	})
+	testCases = append(testCases, test{
+		name:   "json list of int",
+		format: formatJSON,
+		data:   `[1, 2, 3]`,
+		typ:    "[]int",
+		expect: `[1, 2, 3]`,
+	})
+	testCases = append(testCases, test{
+		name:   "json int is a float",
+		format: formatJSON,
+		data:   `[1, 2.5]`,
+		typ:    "[]float",
+		expect: `[1, 2.5]`,
+	})
+	testCases = append(testCases, test{
+		name:   "json float is not an int",
+		format: formatJSON,
+		data:   `2.5`,
+		typ:    "int",
+		fail:   true,
+	})
+	testCases = append(testCases, test{
+		name:   "json struct",
+		format: formatJSON,
+		data:   `{"name": "mgmt", "port": 8080, "tls": true}`,
+		typ:    "struct{name str; port int; tls bool}",
+		expect: `struct{name: "mgmt"; port: 8080; tls: true}`,
+	})
+	testCases = append(testCases, test{
+		name:   "json struct missing field",
+		format: formatJSON,
+		data:   `{"name": "mgmt"}`,
+		typ:    "struct{name str; port int}",
+		fail:   true,
+	})
+	testCases = append(testCases, test{
+		name:   "json struct extra field",
+		format: formatJSON,
+		data:   `{"name": "mgmt", "port": 8080}`,
+		typ:    "struct{name str}",
+		fail:   true,
+	})
+	testCases = append(testCases, test{
+		name:   "json map with int keys",
+		format: formatJSON,
+		data:   `{"1": "a", "2": "b"}`,
+		typ:    "map{int: str}",
+		expect: `{1: "a", 2: "b"}`,
+	})
+	testCases = append(testCases, test{
+		name:   "json wrong element",
+		format: formatJSON,
+		data:   `["a", 2]`,
+		typ:    "[]str",
+		fail:   true,
+	})
+	testCases = append(testCases, test{
+		name:   "json null",
+		format: formatJSON,
+		data:   `null`,
+		typ:    "str",
+		fail:   true,
+	})
+	testCases = append(testCases, test{
+		name:   "json trailing data",
+		format: formatJSON,
+		data:   `"a" "b"`,
+		typ:    "str",
+		fail:   true,
+	})
+	testCases = append(testCases, test{
+		name:   "yaml map",
+		format: formatYAML,
+		data:   "a: [1, 2]\nb: []\n",
+		typ:    "map{str: []int}",
+		expect: `{"a": [1, 2], "b": []}`,
+	})
+	testCases = append(testCases, test{
+		name:   "yaml nested struct",
+		format: formatYAML,
+		data:   "server:\n  host: example.com\n  weight: 0.5\n",
+		typ:    "struct{server struct{host str; weight float}}",
+		expect: `struct{server: struct{host: "example.com"; weight: 0.5}}`,
+	})
+	testCases = append(testCases, test{
+		name:   "yaml wrong kind",
+		format: formatYAML,
+		data:   "- a\n- b\n",
+		typ:    "map{str: str}",
+		fail:   true,
+	})
+
+	names := []string{}
+	for index, tc := range testCases { // run all the tests
+		if tc.name == "" {
+			t.Errorf("test #%d: not named", index)
+			continue
+		}
+		if util.StrInList(tc.name, names) {
+			t.Errorf("test #%d: duplicate sub test name of: %s", index, tc.name)
+			continue
+		}
+		names = append(names, tc.name)
+
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			typ, err := parseType(tc.typ)
+			if err != nil {
+				t.Errorf("test #%d: invalid type: %+v", index, err)
+				return
+			}
+			val, err := decode(tc.format, []byte(tc.data), typ)
+			if tc.fail {
+				if err == nil {
+					t.Errorf("test #%d: expected error, got: %s", index, val)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("test #%d: decode failed with: %+v", index, err)
+				return
+			}
+			if s := val.String(); s != tc.expect {
+				t.Errorf("test #%d: expected: %s", index, tc.expect)
+				t.Errorf("test #%d: actual:   %s", index, s)
+			}
+		})
+	}
+}
+
+func TestEncode0(t *testing.T) {
+	st := types.NewStruct(types.NewType("struct{name str; ports []int; opts map{str: bool}}"))
+	if err := st.Set("name", &types.StrValue{V: "mgmt"}); err != nil {
+		t.Fatalf("could not set: %+v", err)
+	}
+	ports := types.NewList(types.NewType("[]int"))
+	ports.Add(&types.IntValue{V: 80})
+	ports.Add(&types.IntValue{V: 443})
+	if err := st.Set("ports", ports); err != nil {
+		t.Fatalf("could not set: %+v", err)
+	}
+	opts := types.NewMap(types.NewType("map{str: bool}"))
+	opts.Add(&types.StrValue{V: "tls"}, &types.BoolValue{V: true})
+	if err := st.Set("opts", opts); err != nil {
+		t.Fatalf("could not set: %+v", err)
+	}
+
+	type test struct { // an individual test
+		name   string
+		fn     func(context.Context, []types.Value) (types.Value, error)
+		format string
+		expect string
+	}
+	testCases := []test{
+		{
+			name:   "json",
+			fn:     JSONEncode,
+			format: formatJSON,
+			expect: `{"name":"mgmt","ports":[80,443],"opts":{"tls":true}}`,
+		},
+		{
+			name:   "yaml",
+			fn:     YAMLEncode,
+			format: formatYAML,
+			expect: "name: mgmt\nports:\n- 80\n- 443\nopts:\n  tls: true\n",
+		},
+		{
+			name:   "toml",
+			fn:     TOMLEncode,
+			expect: "name = 'mgmt'\nports = [80, 443]\n\n[opts]\ntls = true\n",
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			val, err := tc.fn(context.Background(), []types.Value{st})
+			if err != nil {
+				t.Errorf("test #%d: encode failed with: %+v", index, err)
+				return
+			}
+			if s := val.Str(); s != tc.expect {
+				t.Errorf("test #%d: expected: %q", index, tc.expect)
+				t.Errorf("test #%d: actual:   %q", index, s)
+			}
+			if tc.format == "" {
+				return
+			}
+			// it must decode back to what we started with
+			out, err := decode(tc.format, []byte(val.Str()), st.Type())
+			if err != nil {
+				t.Errorf("test #%d: decode failed with: %+v", index, err)
+				return
+			}
+			// map keys are compared by pointer, so use the strings
+			if s1, s2 := st.String(), out.String(); s1 != s2 {
+				t.Errorf("test #%d: round trip failed: %s != %s", index, s1, s2)
+			}
+		})
+	}
+}
+
+func TestBinary0(t *testing.T) {
+	values := []struct {
+		encode  func(context.Context, []types.Value) (types.Value, error)
+		decode  func(context.Context, []types.Value) (types.Value, error)
+		s       string
+		encoded string
+	}{
+		{Base64Encode, Base64Decode, "", ""},
+		{Base64Encode, Base64Decode, "mgmt", "bWdtdA=="},
+		{Base64Encode, Base64Decode, "\x00\xff", "AP8="},
+		{HexEncode, HexDecode, "mgmt", "6d676d74"},
+		{HexEncode, HexDecode, "\x00\xff", "00ff"},
+	}
+
+	for i, x := range values {
+		val, err := x.encode(context.Background(), []types.Value{&types.StrValue{V: x.s}})
+		if err != nil {
+			t.Errorf("test #%d: encode failed with: %+v", i, err)
+			continue
+		}
+		if s := val.Str(); s != x.encoded {
+			t.Errorf("test #%d: expected: %s, got: %s", i, x.encoded, s)
+		}
+		val, err = x.decode(context.Background(), []types.Value{val})
+		if err != nil {
+			t.Errorf("test #%d: decode failed with: %+v", i, err)
+			continue
+		}
+		if s := val.Str(); s != x.s {
+			t.Errorf("test #%d: expected: %q, got: %q", i, x.s, s)
+		}
+	}
+
+	if _, err := Base64Decode(context.Background(), []types.Value{&types.StrValue{V: "!"}}); err == nil {
+		t.Errorf("invalid base64 was decoded")
+	}
+	if _, err := HexDecode(context.Background(), []types.Value{&types.StrValue{V: "0"}}); err == nil {
+		t.Errorf("invalid hex was decoded")
+	}
+}
@@ -219,6 +219,14 @@ func (obj *TemplateFunc) Init(init *interfaces.Init) error {
 
 // run runs a template and returns the result.
 func (obj *TemplateFunc) run(ctx context.Context, templateText string, vars types.Value) (string, error) {
+	return Render(ctx, templateText, vars, obj.init.Debug, obj.init.Logf)
+}
+
+// Render runs a template with the vars and returns the result. The template can
+// use all of the simple functions that are registered, which is the same set
+// of functions that the template function in mcl has. This is also used by the
+// file resource, which is why it's exported. The vars may be nil.
+func Render(ctx context.Context, templateText string, vars types.Value, debug bool, logf func(format string, v ...interface{})) (string, error) {
 	// see: https://golang.org/pkg/text/template/#FuncMap for more info
 	// note: we can override any other functions by adding them here...
 	funcMap := map[string]interface{}{
@@ -243,14 +251,14 @@ func (obj *TemplateFunc) run(ctx context.Context, templateText string, vars type
 	// XXX: should this use the scope instead (so imports are used properly) ?
 	for name, scaffold := range simple.RegisteredFuncs {
 		if scaffold.T == nil || scaffold.T.HasUni() {
-			if obj.init.Debug {
-				obj.init.Logf("warning, function named: `%s` is not unified", name)
+			if debug {
+				logf("warning, function named: `%s` is not unified", name)
 			}
 			continue
 		}
 		name = safename(name) // TODO: rename since we can't include dot
 		if _, exists := funcMap[name]; exists {
-			obj.init.Logf("warning, existing function named: `%s` exists", name)
+			logf("warning, existing function named: `%s` exists", name)
 			continue
 		}
 
@@ -261,8 +269,8 @@ func (obj *TemplateFunc) run(ctx context.Context, templateText string, vars type
 		// type reflect.Value.
 		f, err := wrap(ctx, name, scaffold) // wrap it so that it meets API expectations
 		if err != nil {
-			if obj.init.Debug {
-				obj.init.Logf("warning, skipping function named: `%s`, err: %v", name, err)
+			if debug {
+				logf("warning, skipping function named: `%s`, err: %v", name, err)
 			}
 			continue
 		}
@@ -312,7 +320,7 @@ Loop:
 
 		case types.KindMap:
 			if v.Type().Key.Cmp(types.TypeStr) != nil {
-				return "", errwrap.Wrapf(err, "template: map keys must be str")
+				return "", fmt.Errorf("template: map keys must be str")
 			}
 			m := make(map[string]interface{})
 			for k, v := range v.Map() { // map[Value]Value
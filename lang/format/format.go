@@ -0,0 +1,919 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package format prints mcl code in the canonical style. This is the style that
+// `mgmt fmt` enforces, in the same spirit as `gofmt`, so that nobody needs to
+// argue about whitespace.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/purpleidea/mgmt/lang/ast"
+	"github.com/purpleidea/mgmt/lang/funcs"
+	"github.com/purpleidea/mgmt/lang/funcs/operators"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/parser"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util"
+)
+
+// These are the binding strengths of the different expressions. They match the
+// precedence declarations in the parser, and they are used to decide where the
+// parentheses need to go. A higher value binds more tightly.
+const (
+	precBlock   = iota // if and func expressions, always wrapped as operands
+	precOr             // and, or
+	precCmp            // ==, !=, <, >, <=, >=
+	precAdd            // +, -
+	precMul            // *, /
+	precNot            // not
+	precArrow          // $struct->field
+	precDefault        // $list[$index] || $default, $struct->field || $default
+	precLookup         // $list[$index]
+	precIn             // $x in $list
+	precAtom           // anything else
+)
+
+// Source formats the mcl code and returns the result. The comments are kept. It
+// errors if the code can't be parsed.
+func Source(src []byte) ([]byte, error) {
+	return source(src, false)
+}
+
+// source is the implementation of Source. If parens is true, every operator is
+// wrapped in parentheses, which is only useful to test the precedence logic.
+func source(src []byte, parens bool) ([]byte, error) {
+	stmt, comments, err := parser.LexParseWithComments(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &printer{
+		buf:      &bytes.Buffer{},
+		lines:    strings.Split(string(src), "\n"),
+		comments: comments,
+		lastRow:  -1,
+		fresh:    true,
+		bol:      true,
+		parens:   parens,
+	}
+	prog, ok := stmt.(*ast.StmtProg)
+	if !ok {
+		return nil, fmt.Errorf("unexpected top-level statement: %T", stmt)
+	}
+	p.body(prog.Body)
+	p.commentsBefore(math.MaxInt) // whatever is left is at the end
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	out := p.buf.Bytes()
+	if len(out) == 0 {
+		return out, nil
+	}
+	return append(bytes.TrimRight(out, "\n"), '\n'), nil
+}
+
+// printer holds the state while printing the code. Comments are not part of the
+// AST, so each time that we're about to print something that starts on a new
+// line, we first print the comments which were above it in the original code.
+type printer struct {
+	buf   *bytes.Buffer
+	lines []string // the original code, used to find the closing of a block
+
+	comments []*ast.StmtComment // sorted by position
+	next     int                // index of the next comment to print
+
+	indent  int
+	bol     bool // are we at the beginning of a line?
+	lastRow int  // last row of the original code that we printed, or -1
+	fresh   bool // did a block just open, so we don't want a blank line?
+	parens  bool // wrap every operator in parentheses
+
+	err error // the first error that we found
+}
+
+// write adds some text to the current line, indenting it if needed.
+func (obj *printer) write(s string) {
+	if s == "" {
+		return
+	}
+	if obj.bol {
+		obj.buf.WriteString(strings.Repeat("\t", obj.indent))
+		obj.bol = false
+	}
+	obj.buf.WriteString(s)
+}
+
+// newline ends the current line.
+func (obj *printer) newline() {
+	obj.buf.WriteString("\n")
+	obj.bol = true
+}
+
+// errorf stores the first error. We keep going, but the output is discarded.
+func (obj *printer) errorf(format string, v ...interface{}) {
+	if obj.err == nil {
+		obj.err = fmt.Errorf(format, v...)
+	}
+}
+
+// blank adds a blank line if there was at least one in the original code before
+// this row. Many blank lines become one, and none are added after an opening.
+func (obj *printer) blank(row int) {
+	if !obj.fresh && obj.lastRow >= 0 && row > obj.lastRow+1 {
+		obj.newline()
+	}
+}
+
+// hasCommentsBefore returns true if there's a comment to print before this row.
+func (obj *printer) hasCommentsBefore(row int) bool {
+	return obj.next < len(obj.comments) && commentRow(obj.comments[obj.next]) < row
+}
+
+// commentsBefore prints every remaining comment that is above this row on its
+// own line.
+func (obj *printer) commentsBefore(row int) {
+	for obj.hasCommentsBefore(row) {
+		comment := obj.comments[obj.next]
+		obj.next++
+		r := commentRow(comment)
+		obj.blank(r)
+		obj.write(commentText(comment))
+		obj.newline()
+		obj.lastRow = r
+		obj.fresh = false
+	}
+}
+
+// trailing prints the next comment at the end of the current line if it was on
+// this row or above in the original code.
+func (obj *printer) trailing(row int) {
+	if obj.hasCommentsBefore(row + 1) {
+		comment := obj.comments[obj.next]
+		obj.next++
+		obj.write(" " + commentText(comment))
+	}
+}
+
+// item prints something that goes on its own line, such as a statement or a
+// resource field. Any comments go above it or at the end of its last line.
+func (obj *printer) item(node interfaces.Node, fn func()) {
+	row := startRow(node)
+	if row >= 0 {
+		obj.commentsBefore(row)
+		obj.blank(row)
+	}
+	fn()
+	end := endRow(node)
+	obj.trailing(end)
+	obj.newline()
+	obj.commentsBefore(end + 1) // if there was more than one on those lines
+	if end >= 0 {
+		obj.lastRow = end
+	}
+	obj.fresh = false
+}
+
+// block prints a curly brace block. The header should already be written. The
+// open row is where the opening brace is, and the close row is where the
+// closing brace is. An empty block with no comments stays on one line.
+func (obj *printer) block(open, close int, empty bool, fn func()) {
+	if empty && (close < 0 || !obj.hasCommentsBefore(close)) {
+		obj.write(" {}")
+		return
+	}
+	obj.write(" {")
+	obj.open(open, close, fn)
+	obj.write("}")
+}
+
+// open prints the indented contents of a block after the opening and leaves us
+// at the start of the line where the closing should be written.
+func (obj *printer) open(open, close int, fn func()) {
+	if open >= 0 {
+		obj.trailing(open)
+	}
+	obj.newline()
+	obj.indent++
+	obj.fresh = true
+	if open >= 0 {
+		obj.lastRow = open
+	}
+	fn()
+	if close >= 0 {
+		obj.commentsBefore(close)
+	}
+	obj.indent--
+	if close >= 0 {
+		obj.lastRow = close
+	}
+	obj.fresh = false
+}
+
+// closeRow returns the first row after this one which has some code on it, so
+// it's not blank and not only a comment. This is used to find a closing brace
+// that isn't stored in the AST.
+func (obj *printer) closeRow(after int) int {
+	if after < 0 {
+		return -1
+	}
+	for i := after + 1; i < len(obj.lines); i++ {
+		s := strings.TrimSpace(obj.lines[i])
+		if s != "" && !strings.HasPrefix(s, "#") {
+			return i
+		}
+	}
+	return -1
+}
+
+// body prints a list of statements.
+func (obj *printer) body(stmts []interfaces.Stmt) {
+	for _, x := range stmts {
+		stmt := x // copy
+		obj.item(stmt, func() { obj.stmt(stmt) })
+	}
+}
+
+// prog prints the contents of a block which holds a program.
+func (obj *printer) prog(stmt interfaces.Stmt) {
+	prog, ok := stmt.(*ast.StmtProg)
+	if !ok {
+		obj.errorf("unexpected block statement: %T", stmt)
+		return
+	}
+	obj.body(prog.Body)
+}
+
+// progEmpty returns true if the block which holds a program is empty.
+func progEmpty(stmt interfaces.Stmt) bool {
+	prog, ok := stmt.(*ast.StmtProg)
+	return ok && len(prog.Body) == 0
+}
+
+// stmt prints a statement, without the newline at the end.
+func (obj *printer) stmt(stmt interfaces.Stmt) {
+	switch x := stmt.(type) {
+	case *ast.StmtBind:
+		obj.write("$" + x.Ident)
+		if x.Type != nil {
+			obj.write(" " + typeString(x.Type))
+		}
+		obj.write(" = ")
+		obj.expr(x.Value)
+
+	case *ast.StmtRes:
+		if x.Collect {
+			obj.write("collect ")
+		}
+		obj.write(x.Kind + " ")
+		obj.expr(x.Name)
+		contents := []ast.StmtResContents{}
+		for _, c := range x.Contents {
+			if _, ok := c.(*ast.StmtResCollect); ok {
+				continue // added by the parser
+			}
+			contents = append(contents, c)
+		}
+		obj.block(endRow(x.Name), lastRow(x), len(contents) == 0, func() {
+			for _, c := range contents {
+				content := c // copy
+				obj.item(content, func() { obj.resContents(content) })
+			}
+		})
+
+	case *ast.StmtEdge:
+		for i, half := range x.EdgeHalfList {
+			if i > 0 {
+				obj.write(" -> ")
+			}
+			obj.edgeHalf(half)
+		}
+
+	case *ast.StmtIf:
+		if call, ok := isPanic(x); ok {
+			obj.write("panic")
+			obj.args(call.Args)
+			return
+		}
+		obj.write("if ")
+		obj.expr(x.Condition)
+		if x.ElseBranch == nil {
+			obj.block(endRow(x.Condition), lastRow(x), progEmpty(x.ThenBranch), func() {
+				obj.prog(x.ThenBranch)
+			})
+			return
+		}
+		// The closing of the then branch isn't stored anywhere, but it's
+		// on the same line as the else, so we look for it in the code.
+		thenRow := endRow(x.Condition)
+		if r := endRow(x.ThenBranch); r > thenRow {
+			thenRow = r
+		}
+		elseRow := obj.closeRow(thenRow)
+		obj.block(endRow(x.Condition), elseRow, false, func() {
+			obj.prog(x.ThenBranch)
+		})
+		obj.write(" else")
+		obj.block(elseRow, lastRow(x), false, func() {
+			obj.prog(x.ElseBranch)
+		})
+
+	case *ast.StmtFor:
+		obj.write(fmt.Sprintf("for $%s, $%s in ", x.Index, x.Value))
+		obj.expr(x.Expr)
+		obj.block(endRow(x.Expr), lastRow(x), progEmpty(x.Body), func() {
+			obj.prog(x.Body)
+		})
+
+	case *ast.StmtForKV:
+		obj.write(fmt.Sprintf("forkv $%s, $%s in ", x.Key, x.Val))
+		obj.expr(x.Expr)
+		obj.block(endRow(x.Expr), lastRow(x), progEmpty(x.Body), func() {
+			obj.prog(x.Body)
+		})
+
+	case *ast.StmtFunc:
+		fn, ok := x.Func.(*ast.ExprFunc)
+		if !ok {
+			obj.errorf("unexpected func statement: %T", x.Func)
+			return
+		}
+		obj.write("func " + x.Name)
+		obj.funcBody(fn, startRow(x), lastRow(x))
+
+	case *ast.StmtClass:
+		obj.write("class " + x.Name)
+		if x.Args != nil {
+			obj.params(x.Args)
+		}
+		obj.block(startRow(x), lastRow(x), progEmpty(x.Body), func() {
+			obj.prog(x.Body)
+		})
+
+	case *ast.StmtInclude:
+		obj.write("include " + x.Name)
+		if x.Args != nil {
+			obj.args(x.Args)
+		}
+		if x.Alias != "" {
+			obj.write(" as " + x.Alias)
+		}
+
+	case *ast.StmtImport:
+		obj.write("import " + strconv.Quote(x.Name))
+		if x.Alias != "" {
+			obj.write(" as " + x.Alias)
+		}
+
+	case *ast.StmtType:
+		obj.write(fmt.Sprintf("type %s = %s", x.Name, typeString(x.Type)))
+
+	case *ast.StmtComment:
+		obj.write(commentText(x))
+
+	default:
+		obj.errorf("unhandled statement: %T", stmt)
+	}
+}
+
+// resContents prints a field, edge or meta param of a resource.
+func (obj *printer) resContents(content ast.StmtResContents) {
+	switch x := content.(type) {
+	case *ast.StmtResField:
+		obj.write(x.Field + " => ")
+		if x.Condition != nil {
+			obj.expr(x.Condition)
+			obj.write(" ?: ")
+		}
+		obj.expr(x.Value)
+
+	case *ast.StmtResEdge:
+		obj.write(capitalize(x.Property) + " => ")
+		if x.Condition != nil {
+			obj.expr(x.Condition)
+			obj.write(" ?: ")
+		}
+		obj.edgeHalf(x.EdgeHalf)
+
+	case *ast.StmtResMeta:
+		if strings.ToLower(x.Property) == ast.MetaField {
+			obj.write(capitalize(ast.MetaField))
+		} else {
+			obj.write(capitalize(ast.MetaField) + interfaces.ClassSep + x.Property)
+		}
+		obj.write(" => ")
+		if x.Condition != nil {
+			obj.expr(x.Condition)
+			obj.write(" ?: ")
+		}
+		obj.expr(x.MetaExpr)
+
+	default:
+		obj.errorf("unhandled resource contents: %T", content)
+	}
+	obj.write(",")
+}
+
+// edgeHalf prints one side of an edge, eg: `File["/tmp/foo"]`.
+func (obj *printer) edgeHalf(half *ast.StmtEdgeHalf) {
+	obj.write(capitalize(half.Kind) + "[")
+	obj.expr(half.Name)
+	obj.write("]")
+	if half.SendRecv != "" {
+		obj.write(interfaces.ModuleSep + half.SendRecv)
+	}
+}
+
+// funcBody prints the signature and body of a function, after the name. The
+// open and close rows are where the braces are.
+func (obj *printer) funcBody(fn *ast.ExprFunc, open, close int) {
+	obj.params(fn.Args)
+	if fn.Return != nil {
+		obj.write(" " + typeString(fn.Return))
+	}
+	if fn.Body == nil {
+		obj.errorf("func has no body")
+		return
+	}
+	if open == close && !obj.hasCommentsBefore(close) { // one line
+		obj.write(" { ")
+		obj.expr(fn.Body)
+		obj.write(" }")
+		return
+	}
+	obj.write(" {")
+	obj.open(open, close, func() {
+		obj.item(fn.Body, func() { obj.expr(fn.Body) })
+	})
+	obj.write("}")
+}
+
+// params prints the parameters of a function or class, eg: `($a, $b str)`.
+func (obj *printer) params(args []*interfaces.Arg) {
+	obj.write("(")
+	for i, arg := range args {
+		if i > 0 {
+			obj.write(", ")
+		}
+		obj.write("$" + arg.Name)
+		if arg.Type != nil {
+			obj.write(" " + typeString(arg.Type))
+		}
+	}
+	obj.write(")")
+}
+
+// args prints the arguments of a call, eg: `($a, 42)`.
+func (obj *printer) args(args []interfaces.Expr) {
+	obj.write("(")
+	for i, arg := range args {
+		if i > 0 {
+			obj.write(", ")
+		}
+		obj.expr(arg)
+	}
+	obj.write(")")
+}
+
+// operand prints an expression which is part of an operator, and wraps it in
+// parentheses if it wouldn't bind tightly enough without them.
+func (obj *printer) operand(expr interfaces.Expr, wrap bool) {
+	if !wrap || obj.parens { // in parens mode it wraps itself
+		obj.expr(expr)
+		return
+	}
+	obj.write("(")
+	obj.expr(expr)
+	obj.write(")")
+}
+
+// expr prints an expression.
+func (obj *printer) expr(expr interfaces.Expr) {
+	if obj.parens && obj.precedence(expr) < precAtom {
+		obj.write("(")
+		defer obj.write(")")
+	}
+
+	switch x := expr.(type) {
+	case *ast.ExprBool:
+		obj.write(strconv.FormatBool(x.V))
+
+	case *ast.ExprStr:
+		obj.write(`"` + x.V + `"`) // the lexer keeps the escapes as is
+
+	case *ast.ExprInt:
+		obj.write(strconv.FormatInt(x.V, 10))
+
+	case *ast.ExprFloat:
+		s := strconv.FormatFloat(x.V, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0" // the lexer needs the dot to know it's a float
+		}
+		obj.write(s)
+
+	case *ast.ExprList:
+		obj.write("[")
+		obj.elements(x, len(x.Elements), func(i int) interfaces.Node {
+			return x.Elements[i]
+		}, func(i int) {
+			obj.expr(x.Elements[i])
+		})
+		obj.write("]")
+
+	case *ast.ExprMap:
+		obj.write("{")
+		obj.elements(x, len(x.KVs), func(i int) interfaces.Node {
+			return x.KVs[i].Key
+		}, func(i int) {
+			obj.expr(x.KVs[i].Key)
+			obj.write(" => ")
+			obj.expr(x.KVs[i].Val)
+		})
+		obj.write("}")
+
+	case *ast.ExprStruct:
+		obj.write("struct{")
+		obj.elements(x, len(x.Fields), func(i int) interfaces.Node {
+			return x.Fields[i].Value
+		}, func(i int) {
+			obj.write(x.Fields[i].Name + " => ")
+			obj.expr(x.Fields[i].Value)
+		})
+		obj.write("}")
+
+	case *ast.ExprFunc:
+		obj.write("func")
+		obj.funcBody(x, startRow(x), lastRow(x))
+
+	case *ast.ExprCall:
+		obj.call(x)
+
+	case *ast.ExprVar:
+		obj.write("$" + x.Name)
+
+	case *ast.ExprIf:
+		obj.write("if ")
+		obj.expr(x.Condition)
+		if startRow(x) == lastRow(x) && !obj.hasCommentsBefore(lastRow(x)) { // one line
+			obj.write(" { ")
+			obj.expr(x.ThenBranch)
+			obj.write(" } else { ")
+			obj.expr(x.ElseBranch)
+			obj.write(" }")
+			return
+		}
+		elseRow := obj.closeRow(endRow(x.ThenBranch))
+		obj.write(" {")
+		obj.open(endRow(x.Condition), elseRow, func() {
+			obj.item(x.ThenBranch, func() { obj.expr(x.ThenBranch) })
+		})
+		obj.write("} else {")
+		obj.open(elseRow, lastRow(x), func() {
+			obj.item(x.ElseBranch, func() { obj.expr(x.ElseBranch) })
+		})
+		obj.write("}")
+
+	case *ast.ExprMatch:
+		obj.write("match ")
+		obj.expr(x.Subject)
+		obj.write(" {")
+		n := len(x.Arms)
+		obj.elements(x, n+1, func(i int) interfaces.Node {
+			if i == n {
+				return x.Default
+			}
+			return x.Arms[i].Pattern
+		}, func(i int) {
+			if i == n {
+				obj.write("default => ")
+				obj.expr(x.Default)
+				return
+			}
+			obj.expr(x.Arms[i].Pattern)
+			obj.write(" => ")
+			obj.expr(x.Arms[i].Value)
+		})
+		obj.write("}")
+
+	default:
+		obj.errorf("unhandled expression: %T", expr)
+	}
+}
+
+// elements prints the contents of a list, map or struct. They stay on one line
+// unless they were on more than one line in the original code. Either way, the
+// trailing comma is always there, since the grammar needs it.
+func (obj *printer) elements(node interfaces.Node, n int, get func(int) interfaces.Node, fn func(int)) {
+	if n == 0 {
+		return
+	}
+	multi := false
+	row := startRow(node)
+	for i := 0; i < n; i++ {
+		r := startRow(get(i))
+		if r < 0 {
+			continue
+		}
+		if row >= 0 && r != row {
+			multi = true
+			break
+		}
+		row = r
+	}
+	if !multi {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				obj.write(" ")
+			}
+			fn(i)
+			obj.write(",")
+		}
+		return
+	}
+
+	obj.open(startRow(node), lastRow(node), func() {
+		for i := 0; i < n; i++ {
+			i := i // copy
+			obj.item(get(i), func() {
+				fn(i)
+				obj.write(",")
+			})
+		}
+	})
+}
+
+// call prints a function call. Many of the operators are calls once they are
+// parsed, so we put them back the way they were written.
+func (obj *printer) call(call *ast.ExprCall) {
+	prec := obj.precedence(call)
+	args := call.Args
+
+	switch {
+	case call.Name == operators.OperatorFuncName && prec < precAtom:
+		// precedence already checked that this is a str
+		op := args[0].(*ast.ExprStr).V
+		if len(args) == 2 { // not
+			obj.write(op + " ")
+			obj.operand(args[1], obj.precedence(args[1]) < prec)
+			return
+		}
+		// comparisons can't be chained, the others are left associative
+		left := obj.precedence(args[1]) < prec || (prec == precCmp && obj.precedence(args[1]) == prec)
+		obj.operand(args[1], left)
+		obj.write(" " + op + " ")
+		obj.operand(args[2], obj.precedence(args[2]) <= prec)
+
+	case call.Name == funcs.LookupFuncName, call.Name == funcs.LookupDefaultFuncName:
+		obj.operand(args[0], obj.precedence(args[0]) < precLookup)
+		obj.write("[")
+		obj.expr(args[1])
+		obj.write("]")
+		if len(args) == 3 {
+			obj.write(" || ")
+			obj.operand(args[2], obj.precedence(args[2]) <= precDefault)
+		}
+
+	case call.Name == funcs.StructLookupFuncName, call.Name == funcs.StructLookupOptionalFuncName:
+		obj.operand(args[0], obj.precedence(args[0]) < precArrow)
+		obj.write("->" + args[1].(*ast.ExprStr).V)
+		if len(args) == 3 {
+			obj.write(" || ")
+			obj.operand(args[2], obj.precedence(args[2]) <= precDefault)
+		}
+
+	case prec == precIn:
+		obj.operand(args[0], obj.precedence(args[0]) <= prec)
+		obj.write(" in ")
+		obj.operand(args[1], obj.precedence(args[1]) <= prec)
+
+	default:
+		if call.Anon != nil {
+			obj.expr(call.Anon)
+		} else if call.Var {
+			obj.write("$" + call.Name)
+		} else {
+			obj.write(call.Name)
+		}
+		obj.args(args)
+	}
+}
+
+// precedence returns how tightly this expression binds. See the prec constants.
+func (obj *printer) precedence(expr interfaces.Expr) int {
+	switch x := expr.(type) {
+	case *ast.ExprIf, *ast.ExprMatch, *ast.ExprFunc:
+		return precBlock
+
+	case *ast.ExprCall:
+		switch x.Name {
+		case operators.OperatorFuncName:
+			if len(x.Args) == 0 {
+				break
+			}
+			op, ok := x.Args[0].(*ast.ExprStr)
+			if !ok {
+				break
+			}
+			switch op.V {
+			case "and", "or":
+				return precOr
+			case "==", "!=", "<", ">", "<=", ">=":
+				return precCmp
+			case "+", "-":
+				return precAdd
+			case "*", "/":
+				return precMul
+			case "not":
+				return precNot
+			}
+
+		case funcs.LookupFuncName:
+			return precLookup
+
+		case funcs.LookupDefaultFuncName, funcs.StructLookupOptionalFuncName:
+			return precDefault
+
+		case funcs.StructLookupFuncName:
+			return precArrow
+
+		case funcs.ContainsFuncName:
+			if obj.isInfix(x) {
+				return precIn
+			}
+		}
+	}
+	return precAtom
+}
+
+// isInfix returns true if this call was written as an operator between its two
+// args. The `in` operator is a call to the contains function, which could also
+// be called by name, so we look at how it was written. The infix version starts
+// with the first arg, or with a parenthesis that wraps it.
+func (obj *printer) isInfix(call *ast.ExprCall) bool {
+	if call.Var || call.Anon != nil || len(call.Args) != 2 {
+		return false
+	}
+	if !call.IsSet() {
+		return false
+	}
+	pn, ok := call.Args[0].(interfaces.PositionableNode)
+	if !ok || !pn.IsSet() {
+		return false
+	}
+	l1, c1 := call.Pos()
+	l2, c2 := pn.Pos()
+	if l1 == l2 && c1 == c2 { // the call starts with the first arg
+		return true
+	}
+	if l1 < len(obj.lines) && c1 < len(obj.lines[l1]) {
+		return obj.lines[l1][c1] == '('
+	}
+	return false
+}
+
+// isPanic returns the call if this if statement is what the parser builds from
+// the panic statement.
+func isPanic(stmt *ast.StmtIf) (*ast.ExprCall, bool) {
+	if stmt.ElseBranch != nil {
+		return nil, false
+	}
+	call, ok := stmt.Condition.(*ast.ExprCall)
+	if !ok || call.Name != "panic" {
+		return nil, false
+	}
+	res, ok := stmt.ThenBranch.(*ast.StmtRes)
+	if !ok || res.Kind != interfaces.PanicResKind {
+		return nil, false
+	}
+	return call, true
+}
+
+// typeString prints a type the way it's written in the code. The String method
+// of the type is close, but the args of a func are written differently.
+func typeString(typ *types.Type) string {
+	switch typ.Kind {
+	case types.KindList:
+		return "[]" + typeString(typ.Val)
+
+	case types.KindMap:
+		return fmt.Sprintf("map{%s: %s}", typeString(typ.Key), typeString(typ.Val))
+
+	case types.KindStruct:
+		fields := []string{}
+		for _, k := range typ.Ord {
+			fields = append(fields, k+" "+typeString(typ.Map[k]))
+		}
+		return fmt.Sprintf("struct{%s}", strings.Join(fields, "; "))
+
+	case types.KindFunc:
+		args := []string{}
+		for i, k := range typ.Ord {
+			s := typeString(typ.Map[k])
+			if k != util.NumToAlpha(i) { // the parser makes up these names
+				s = "$" + k + " " + s
+			}
+			args = append(args, s)
+		}
+		s := fmt.Sprintf("func(%s)", strings.Join(args, ", "))
+		if typ.Out != nil {
+			s += " " + typeString(typ.Out)
+		}
+		return s
+	}
+	return typ.String()
+}
+
+// capitalize returns the string with each part that's separated by a colon
+// starting with an upper case letter. The lexer lower cases these for us.
+func capitalize(s string) string {
+	parts := strings.Split(s, interfaces.ClassSep)
+	for i, x := range parts {
+		if x != "" {
+			parts[i] = strings.ToUpper(x[:1]) + x[1:]
+		}
+	}
+	return strings.Join(parts, interfaces.ClassSep)
+}
+
+// commentText returns the comment the way it should be printed.
+func commentText(comment *ast.StmtComment) string {
+	return strings.TrimRight("#"+comment.Value, " \t\r")
+}
+
+// commentRow returns the row that the comment was on.
+func commentRow(comment *ast.StmtComment) int {
+	row, _ := comment.Pos()
+	return row
+}
+
+// startRow returns the row that the node starts on, or -1 if it doesn't know.
+func startRow(node interfaces.Node) int {
+	pn, ok := node.(interfaces.PositionableNode)
+	if !ok || !pn.IsSet() {
+		return -1
+	}
+	row, _ := pn.Pos()
+	return row
+}
+
+// lastRow returns the row that the last token of the node is on, or -1 if it
+// doesn't know. For a node that ends with a closing brace, that's where it is.
+func lastRow(node interfaces.Node) int {
+	pn, ok := node.(interfaces.PositionableNode)
+	if !ok || !pn.IsSet() {
+		return -1
+	}
+	row, _ := pn.End()
+	return row
+}
+
+// endRow returns the last row that the node or any of its children are on, or
+// -1 if none of them know where they are.
+func endRow(node interfaces.Node) int {
+	row := -1
+	if node == nil {
+		return row
+	}
+	node.Apply(func(n interfaces.Node) error {
+		if r := lastRow(n); r > row {
+			row = r
+		}
+		if r := startRow(n); r > row {
+			row = r
+		}
+		return nil
+	})
+	return row
+}
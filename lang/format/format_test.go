@@ -0,0 +1,259 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package format
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/lang/parser"
+)
+
+func TestSource0(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		code string
+		exp  string
+	}
+	testCases := []test{
+		{
+			name: "empty",
+			code: "",
+			exp:  "",
+		},
+		{
+			name: "spacing",
+			code: "$x   =42\n$y int=$x+1*2\n",
+			exp:  "$x = 42\n$y int = $x + 1 * 2\n",
+		},
+		{
+			name: "blank lines",
+			code: "\n\n$x = 1\n\n\n\n$y = 2\n$z = 3\n\n",
+			exp:  "$x = 1\n\n$y = 2\n$z = 3\n",
+		},
+		{
+			name: "comments",
+			code: "# header\n\n$x = 1\t\t# trailing\n  # own line\n$y = 2\n# footer",
+			exp:  "# header\n\n$x = 1 # trailing\n# own line\n$y = 2\n# footer\n",
+		},
+		{
+			name: "resource",
+			code: "file \"/tmp/foo\" { # header\ncontent=>\"hello\\n\",\n  mode => $b ?: \"0644\",\n\nMeta:noop=>true,\nBefore=>File[\"/tmp/bar\"],\n# end\n}\ntest \"t1\" {\n}\n",
+			exp:  "file \"/tmp/foo\" { # header\n\tcontent => \"hello\\n\",\n\tmode => $b ?: \"0644\",\n\n\tMeta:noop => true,\n\tBefore => File[\"/tmp/bar\"],\n\t# end\n}\ntest \"t1\" {}\n",
+		},
+		{
+			name: "lists",
+			code: "$a = [1,2,3,]\n$b = [\n\"x\", # first\n\"y\",]\n$c = {\"k\"=>1,}\n$d = struct{a=>1,b=>2,}\n",
+			exp:  "$a = [1, 2, 3,]\n$b = [\n\t\"x\", # first\n\t\"y\",\n]\n$c = {\"k\" => 1,}\n$d = struct{a => 1, b => 2,}\n",
+		},
+		{
+			name: "parens",
+			code: "$a = ($x+1)*2\n$b = $x+(1*2)\n$c = (not $x) == true\n$d = not ($x == true)\n$e = ($x - 1) - 2\n$f = $x - (1 - 2)\n",
+			exp:  "$a = ($x + 1) * 2\n$b = $x + 1 * 2\n$c = not $x == true\n$d = not ($x == true)\n$e = $x - 1 - 2\n$f = $x - (1 - 2)\n",
+		},
+		{
+			name: "lookups",
+			code: "$a = $l[0]||13\n$b = $s->a\n$c = $s->a||\"x\"\n$d = 3 in $l\n$e = contains(3, $l)\n$f = ($x+1) in $l\n",
+			exp:  "$a = $l[0] || 13\n$b = $s->a\n$c = $s->a || \"x\"\n$d = 3 in $l\n$e = contains(3, $l)\n$f = ($x + 1) in $l\n",
+		},
+		{
+			name: "funcs",
+			code: "func double($a) { $a*2 }\nfunc add($a int, $b int) int {\n$a+$b\n}\n$f = func($x) { $x }\n$g = $f(3)\n",
+			exp:  "func double($a) { $a * 2 }\nfunc add($a int, $b int) int {\n\t$a + $b\n}\n$f = func($x) { $x }\n$g = $f(3)\n",
+		},
+		{
+			name: "blocks",
+			code: "if $b {\ntest \"t1\" {}\n} else {\n# nothing\n}\nfor $i,$v in $l {\ntest \"t-${v}\" {}\n}\nclass c($a, $b str) {\n}\ninclude c(1, \"x\") as d\npanic(false)\n",
+			exp:  "if $b {\n\ttest \"t1\" {}\n} else {\n\t# nothing\n}\nfor $i, $v in $l {\n\ttest \"t-${v}\" {}\n}\nclass c($a, $b str) {}\ninclude c(1, \"x\") as d\npanic(false)\n",
+		},
+		{
+			name: "imports and edges",
+			code: "import \"fmt\"\nimport \"math\"   as   m\nimport \"os\" as *\nFile[\"/tmp/a\"]->Test[\"t1\"]\nTest[\"t1\"].foo->Test[\"t2\"].bar\n",
+			exp:  "import \"fmt\"\nimport \"math\" as m\nimport \"os\" as *\nFile[\"/tmp/a\"] -> Test[\"t1\"]\nTest[\"t1\"].foo -> Test[\"t2\"].bar\n",
+		},
+		{
+			name: "types and literals",
+			code: "$a []str = []\n$b map{str: int} = {}\n$c struct{a int; b str} = struct{a => 1, b => \"x\",}\n$d = 1.0\n$e = -7\n$f func(int) str = func($x int) str { \"x\" }\n",
+			exp:  "$a []str = []\n$b map{str: int} = {}\n$c struct{a int; b str} = struct{a => 1, b => \"x\",}\n$d = 1.0\n$e = -7\n$f func(int) str = func($x int) str { \"x\" }\n",
+		},
+		{
+			name: "type aliases",
+			code: "type Config=struct{a Port;b []mod.Thing}\ntype Port = int\n$c Config = $x\nfunc f($p Port) map{str: Port} { {} }\n",
+			exp:  "type Config = struct{a Port; b []mod.Thing}\ntype Port = int\n$c Config = $x\nfunc f($p Port) map{str: Port} { {} }\n",
+		},
+		{
+			name: "match",
+			code: "$a = match $x {\n\"a\"=>1,\n  # two\n\t2=>   2,\ndefault=>0,\n}\n$b = match $y {true => \"t\", default => \"f\",}\n",
+			exp:  "$a = match $x {\n\t\"a\" => 1,\n\t# two\n\t2 => 2,\n\tdefault => 0,\n}\n$b = match $y {true => \"t\", default => \"f\",}\n",
+		},
+	}
+
+	names := []string{}
+	for index, tc := range testCases { // run all the tests
+		if tc.name == "" {
+			t.Errorf("test #%d: not named", index)
+			continue
+		}
+		if util := strings.Join(names, ","); strings.Contains(","+util+",", ","+tc.name+",") {
+			t.Errorf("test #%d: duplicate sub test name of: %s", index, tc.name)
+			continue
+		}
+		names = append(names, tc.name)
+
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Source([]byte(tc.code))
+			if err != nil {
+				t.Errorf("test #%d: format failed with: %+v", index, err)
+				return
+			}
+			if s := string(out); s != tc.exp {
+				t.Errorf("test #%d: unexpected output", index)
+				t.Logf("test #%d: got:\n%s", index, s)
+				t.Logf("test #%d: exp:\n%s", index, tc.exp)
+			}
+		})
+	}
+}
+
+func TestSource1(t *testing.T) {
+	if _, err := Source([]byte("$x = \n")); err == nil {
+		t.Errorf("expected a parse error")
+	}
+}
+
+// TestSource2 formats all of the examples, and checks that doing it again
+// doesn't change anything, that the meaning of the code stays the same, and
+// that no comments are lost.
+func TestSource2(t *testing.T) {
+	files, err := filepath.Glob("../../examples/lang/*.mcl")
+	if err != nil {
+		t.Fatalf("glob failed with: %+v", err)
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Errorf("file: %s: read failed with: %+v", file, err)
+			continue
+		}
+		if _, err := parser.LexParse(bytes.NewReader(b)); err != nil {
+			continue // some examples are broken on purpose
+		}
+
+		out, err := Source(b)
+		if err != nil {
+			t.Errorf("file: %s: format failed with: %+v", file, err)
+			continue
+		}
+		again, err := Source(out)
+		if err != nil {
+			t.Errorf("file: %s: format of the output failed with: %+v", file, err)
+			continue
+		}
+		if string(out) != string(again) {
+			t.Errorf("file: %s: format is not stable", file)
+		}
+
+		// wrapping every operator in parens shows the tree's structure
+		p1, err1 := source(b, true)
+		p2, err2 := source(out, true)
+		if err1 != nil || err2 != nil || string(p1) != string(p2) {
+			t.Errorf("file: %s: the meaning of the code changed", file)
+		}
+
+		_, c1, _ := parser.LexParseWithComments(bytes.NewReader(b))
+		_, c2, _ := parser.LexParseWithComments(bytes.NewReader(out))
+		if l1, l2 := len(c1), len(c2); l1 != l2 {
+			t.Errorf("file: %s: had %d comments, now has %d", file, l1, l2)
+		}
+	}
+}
+
+func TestTool0(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.mcl")
+	bad := filepath.Join(dir, "bad.mcl")
+	if err := os.WriteFile(good, []byte("$x = 1\n"), 0644); err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+	if err := os.WriteFile(bad, []byte("$x=1\n"), 0600); err != nil {
+		t.Fatalf("could not write: %+v", err)
+	}
+
+	run := func(args *cliUtil.FmtArgs) (string, error) {
+		stdout := &bytes.Buffer{}
+		tool := &Tool{
+			Stdin:  strings.NewReader(""),
+			Stdout: stdout,
+		}
+		info := &cliUtil.ToolInfo{
+			Args: args,
+			Logf: t.Logf,
+		}
+		err := tool.Main(context.Background(), info)
+		return stdout.String(), err
+	}
+
+	out, err := run(&cliUtil.FmtArgs{Check: true, Paths: []string{dir}})
+	if err == nil {
+		t.Errorf("check passed with an unformatted file")
+	}
+	if out != bad+"\n" {
+		t.Errorf("check listed: %q", out)
+	}
+
+	if _, err := run(&cliUtil.FmtArgs{Write: true, Paths: []string{dir}}); err != nil {
+		t.Errorf("write failed with: %+v", err)
+	}
+	b, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatalf("could not read: %+v", err)
+	}
+	if s := string(b); s != "$x = 1\n" {
+		t.Errorf("write produced: %q", s)
+	}
+	if fi, err := os.Stat(bad); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("write didn't keep the file mode")
+	}
+
+	if out, err := run(&cliUtil.FmtArgs{Check: true, Paths: []string{dir}}); err != nil || out != "" {
+		t.Errorf("check failed after write with: %+v: %q", err, out)
+	}
+
+	if _, err := run(&cliUtil.FmtArgs{Write: true}); err == nil {
+		t.Errorf("write to stdin didn't fail")
+	}
+}
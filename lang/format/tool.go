@@ -0,0 +1,199 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package format
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// Name is the name of this tool.
+	Name = "fmt"
+
+	// stdinName is what we call the input when it comes from stdin.
+	stdinName = "<stdin>"
+)
+
+func init() {
+	cliUtil.RegisterTool(Name, func() cliUtil.Tool { return &Tool{} })
+}
+
+// Tool formats mcl code for the `fmt` command. With no flags it prints the
+// formatted code to stdout. With --check it lists the files which would change,
+// and errors if there are any, which is useful in a pre-commit hook. With -w it
+// writes the changes back to the files.
+type Tool struct {
+	// Stdin is where the code is read from when there are no paths. It's
+	// os.Stdin if nil.
+	Stdin io.Reader
+
+	// Stdout is where the output goes. It's os.Stdout if nil.
+	Stdout io.Writer
+}
+
+// Main formats all of the requested files.
+func (obj *Tool) Main(ctx context.Context, info *cliUtil.ToolInfo) error {
+	args, ok := info.Args.(*cliUtil.FmtArgs)
+	if !ok {
+		// programming error
+		return fmt.Errorf("could not convert to our struct")
+	}
+	stdin, stdout := obj.Stdin, obj.Stdout
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+
+	if len(args.Paths) == 0 {
+		if args.Write {
+			return fmt.Errorf("can't write the result back to %s", stdinName)
+		}
+		src, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		out, err := Source(src)
+		if err != nil {
+			return errwrap.Wrapf(err, "%s", stdinName)
+		}
+		if !args.Check {
+			_, err := stdout.Write(out)
+			return err
+		}
+		if !bytes.Equal(src, out) {
+			fmt.Fprintln(stdout, stdinName)
+			return fmt.Errorf("%s is not formatted", stdinName)
+		}
+		return nil
+	}
+
+	files := []string{}
+	for _, path := range args.Paths {
+		found, err := mclFiles(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+
+	var reterr error
+	count := 0 // number of files which aren't formatted
+	for _, file := range files {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		changed, err := obj.file(file, args, stdout)
+		if err != nil {
+			reterr = errwrap.Append(reterr, errwrap.Wrapf(err, "%s", file))
+			continue
+		}
+		if changed {
+			count++
+		}
+	}
+	if reterr != nil {
+		return reterr
+	}
+	if args.Check && count > 0 {
+		return fmt.Errorf("%d file(s) are not formatted", count)
+	}
+	return nil
+}
+
+// file formats a single file. It returns true if the formatting changed it.
+func (obj *Tool) file(file string, args *cliUtil.FmtArgs, stdout io.Writer) (bool, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	out, err := Source(src)
+	if err != nil {
+		return false, err
+	}
+	changed := !bytes.Equal(src, out)
+
+	if args.Check && changed {
+		fmt.Fprintln(stdout, file)
+	}
+	if args.Write && changed {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(file, out, fi.Mode().Perm()); err != nil {
+			return false, err
+		}
+	}
+	if !args.Check && !args.Write {
+		if _, err := stdout.Write(out); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+// mclFiles returns the path if it's a file, or all the mcl files inside of it in
+// lexical order if it's a directory.
+func mclFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	files := []string{}
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(p, interfaces.DotFileNameExtension) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
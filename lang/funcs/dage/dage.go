@@ -44,10 +44,12 @@ import (
 	"github.com/purpleidea/mgmt/engine/local"
 	"github.com/purpleidea/mgmt/lang/funcs/ref"
 	"github.com/purpleidea/mgmt/lang/funcs/structs"
+	"github.com/purpleidea/mgmt/lang/funcs/trace"
 	"github.com/purpleidea/mgmt/lang/funcs/txn"
 	"github.com/purpleidea/mgmt/lang/interfaces"
 	"github.com/purpleidea/mgmt/lang/types"
 	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/prometheus"
 	"github.com/purpleidea/mgmt/util/errwrap"
 )
 
@@ -62,6 +64,14 @@ type Engine struct {
 	Local    *local.API
 	World    engine.World
 
+	// Prometheus is an optional handle to the metrics that we update. If
+	// it is nil, then no metrics are collected.
+	Prometheus *prometheus.Prometheus
+
+	// Tracer is an optional handle to the tracer that records each change
+	// to the graph and each new value. If it is nil, nothing is traced.
+	Tracer *trace.Tracer
+
 	Debug bool
 	Logf  func(format string, v ...interface{})
 
@@ -243,12 +253,17 @@ func (obj *Engine) Txn() interfaces.Txn {
 			obj.wgTxn.Done()
 		}
 	}
+	var traceFunc func(int)
+	if obj.Tracer != nil {
+		traceFunc = obj.Tracer.Txn
+	}
 	return (&txn.GraphTxn{
 		Lock:     obj.Lock,
 		Unlock:   obj.Unlock,
 		GraphAPI: obj,
 		RefCount: obj.refCount, // reference counting
 		FreeFunc: free,
+		Trace:    traceFunc,
 	}).Init()
 }
 
@@ -321,6 +336,8 @@ func (obj *Engine) addVertex(f interfaces.Func) error {
 	// only now, do we modify the graph
 	obj.state[f] = node
 	obj.graph.AddVertex(f)
+	obj.Prometheus.UpdateFunctionNodes(len(obj.state)) // ignore error
+	obj.Tracer.AddVertex(f)
 	return nil
 }
 
@@ -381,6 +398,7 @@ func (obj *Engine) AddEdge(f1, f2 interfaces.Func, fe *interfaces.FuncEdge) erro
 	}
 
 	obj.graph.AddEdge(f1, f2, fe) // replaces any existing edge here
+	obj.Tracer.AddEdge(f1, f2, fe)
 
 	// This shouldn't error, since the test graph didn't find a cycle.
 	if _, err := obj.graph.TopologicalSort(); err != nil {
@@ -425,6 +443,8 @@ func (obj *Engine) deleteVertex(f interfaces.Func) error {
 	// lookup when we're in an unlocked state.
 	delete(obj.state, f)
 	obj.graph.DeleteVertex(f)
+	obj.Prometheus.UpdateFunctionNodes(len(obj.state)) // ignore error
+	obj.Tracer.DeleteVertex(f)
 	return nil
 }
 
@@ -457,6 +477,7 @@ func (obj *Engine) DeleteEdge(fe *interfaces.FuncEdge) error {
 	// Don't bother checking if edge exists first and don't error if it
 	// doesn't because it might have gotten deleted when a vertex did, and
 	// so there's no need to complain for nothing.
+	obj.Tracer.DeleteEdge(fe)
 	obj.graph.DeleteEdge(fe)
 
 	return nil
@@ -1213,6 +1234,7 @@ func (obj *Engine) Run(ctx context.Context) (reterr error) {
 			}
 			obj.loaded = false // reset this
 			node.running = true
+			node.started = time.Now()
 
 			obj.statsMutex.Lock()
 			val, _ := obj.stats.inputList[node] // val is # or zero
@@ -1294,6 +1316,7 @@ func (obj *Engine) Run(ctx context.Context) (reterr error) {
 						obj.Logf("func `%s` got nil value", node)
 						panic("got nil value")
 					}
+					obj.Prometheus.UpdateFunctionStreamEventsTotal(node.metricName()) // ignore error
 
 					obj.tableMutex.RLock()
 					cached, exists := obj.table[f]
@@ -1301,6 +1324,7 @@ func (obj *Engine) Run(ctx context.Context) (reterr error) {
 					if !exists { // first value received
 						// RACE: do this AFTER value is present!
 						//node.loaded = true // not yet please
+						obj.Prometheus.ObserveFunctionFirstValue(node.metricName(), time.Since(node.started)) // ignore error
 						if obj.Debug {
 							obj.Logf("func `%s` started", node)
 						}
@@ -1318,6 +1342,7 @@ func (obj *Engine) Run(ctx context.Context) (reterr error) {
 					obj.tableMutex.Lock()
 					obj.table[f] = value // save the latest
 					obj.tableMutex.Unlock()
+					obj.Tracer.Value(f, value)
 					node.rwmutex.Lock()
 					node.loaded = true // set *after* value is in :)
 					//obj.Logf("func `%s` changed", node)
@@ -1552,6 +1577,7 @@ type state struct {
 	isLeaf bool // is my out degree zero?
 
 	running bool
+	started time.Time // when we started running it
 	wg      *sync.WaitGroup
 	ctx     context.Context // per state ctx (inner ctx)
 	cancel  func()          // cancel above inner ctx
@@ -1568,6 +1594,14 @@ func (obj *state) String() string {
 	return obj.Func.String()
 }
 
+// metricName returns the name of this function to use in the metrics. Anything
+// after the first colon is removed, so that functions like constants, which
+// include their value in their name, don't each get their own metric.
+func (obj *state) metricName() string {
+	name, _, _ := strings.Cut(obj.String(), ":")
+	return strings.TrimSpace(name)
+}
+
 // stats holds some statistics and other debugging information.
 type stats struct {
 
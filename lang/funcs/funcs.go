@@ -35,6 +35,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 
 	docsUtil "github.com/purpleidea/mgmt/docs/util"
 	"github.com/purpleidea/mgmt/lang/interfaces"
@@ -131,6 +132,10 @@ const (
 // as well.
 var registeredFuncs = make(map[string]func() interfaces.Func) // must initialize
 
+// registeredFuncsMutex guards registeredFuncs, since Mock can change it while
+// other goroutines are looking up functions.
+var registeredFuncsMutex = &sync.Mutex{}
+
 // Register takes a func and its name and makes it available for use. It is
 // commonly called in the init() method of the func at program startup. There is
 // no matching Unregister function. You may also register functions which
@@ -139,7 +144,10 @@ var registeredFuncs = make(map[string]func() interfaces.Func) // must initialize
 // function name with the ModuleSep character. It is defined as a const and is
 // probably the period character.
 func Register(name string, fn func() interfaces.Func) {
-	if _, exists := registeredFuncs[name]; exists {
+	registeredFuncsMutex.Lock()
+	_, exists := registeredFuncs[name]
+	registeredFuncsMutex.Unlock()
+	if exists {
 		panic(fmt.Sprintf("a func named %s is already registered", name))
 	}
 
@@ -158,7 +166,9 @@ func Register(name string, fn func() interfaces.Func) {
 	//}
 
 	//gob.Register(fn())
+	registeredFuncsMutex.Lock()
 	registeredFuncs[name] = fn
+	registeredFuncsMutex.Unlock()
 
 	f := fn() // Remember: If we modify this copy, it gets thrown away!
 
@@ -183,11 +193,33 @@ func ModuleRegister(module, name string, fn func() interfaces.Func) {
 	Register(module+ModuleSep+name, fn)
 }
 
+// Mock replaces the registered func of this name with a different one until the
+// returned restore function is called. This is used by test harnesses such as
+// the `mgmt test` runner to stub out facts and functions. It's safe to call this
+// concurrently with the lookup functions, but the mock is seen by every user of
+// the registered funcs in this process until it's restored.
+func Mock(name string, fn func() interfaces.Func) (func(), error) {
+	registeredFuncsMutex.Lock()
+	defer registeredFuncsMutex.Unlock()
+	orig, exists := registeredFuncs[name]
+	if !exists {
+		return nil, fmt.Errorf("a func named %s is not registered", name)
+	}
+	registeredFuncs[name] = fn
+	return func() {
+		registeredFuncsMutex.Lock()
+		defer registeredFuncsMutex.Unlock()
+		registeredFuncs[name] = orig
+	}, nil
+}
+
 // Lookup returns a pointer to the function's struct. It may be convertible to a
 // BuildableFunc or InferableFunc if the particular function implements those
 // additional methods.
 func Lookup(name string) (interfaces.Func, error) {
+	registeredFuncsMutex.Lock()
 	f, exists := registeredFuncs[name]
+	registeredFuncsMutex.Unlock() // don't hold it while we build the func
 	if !exists {
 		return nil, fmt.Errorf("not found")
 	}
@@ -201,6 +233,8 @@ func Lookup(name string) (interfaces.Func, error) {
 // result in the map keys that it returns. If you search for an empty prefix,
 // then this will return all the top-level functions that aren't in a module.
 func LookupPrefix(prefix string) map[string]func() interfaces.Func {
+	registeredFuncsMutex.Lock()
+	defer registeredFuncsMutex.Unlock()
 	result := make(map[string]func() interfaces.Func)
 	for name, f := range registeredFuncs {
 		// requested top-level functions, and no module separators...
@@ -227,6 +261,8 @@ func LookupPrefix(prefix string) map[string]func() interfaces.Func {
 // functions, and each one must have its own unique memory address to work
 // properly.
 func Map() map[string]func() interfaces.Func {
+	registeredFuncsMutex.Lock()
+	defer registeredFuncsMutex.Unlock()
 	m := make(map[string]func() interfaces.Func)
 	for name, fn := range registeredFuncs { // copy
 		m[name] = fn
@@ -33,3 +33,58 @@ package funcs
 
 // most of the testing of this package is inside of the adjacent `facts` package
 // because it imports this package and thus lets us use those constructs to test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/purpleidea/mgmt/lang/interfaces"
+)
+
+func TestMockConcurrent1(t *testing.T) {
+	const name = "_test_mock_concurrent"
+	registeredFuncsMutex.Lock()
+	registeredFuncs[name] = func() interfaces.Func { return nil }
+	registeredFuncsMutex.Unlock()
+	defer func() {
+		registeredFuncsMutex.Lock()
+		delete(registeredFuncs, name)
+		registeredFuncsMutex.Unlock()
+	}()
+
+	if _, err := Mock("_test_mock_missing", nil); err == nil {
+		t.Errorf("expected an error mocking a missing func")
+	}
+
+	// Run with -race to check that this is safe.
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				restore, err := Mock(name, func() interfaces.Func { return nil })
+				if err != nil {
+					t.Errorf("mock failed: %+v", err)
+					return
+				}
+				restore()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := Lookup(name); err != nil {
+					t.Errorf("lookup failed: %+v", err)
+					return
+				}
+				if _, exists := Map()[name]; !exists {
+					t.Errorf("expected the func in the map")
+					return
+				}
+				LookupPrefix("")
+			}
+		}()
+	}
+}
@@ -0,0 +1,280 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+
+package structs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// MatchFuncName is the unique name identifier for this function.
+	MatchFuncName = "match"
+
+	// MatchFuncArgNameSubject is the name for the edge which connects the
+	// value being matched on to the match function.
+	MatchFuncArgNameSubject = "subject"
+)
+
+// MatchFunc is a function that passes through the value of the first arm whose
+// pattern is equal to the subject value that it gets. If no pattern matches,
+// then the value of the default arm is passed through instead. Only the subject
+// and the patterns are inputs. The selected arm is added to the graph with the
+// Txn, and it's swapped out whenever a different arm gets selected, so that only
+// one of them is ever active.
+type MatchFunc struct {
+	Type    *types.Type // this is the type of the match expression output
+	Subject *types.Type // this is the type of the subject and each pattern
+	Len     int         // number of arms, not including the default arm
+
+	// ArmGraph adds the subgraph of the arm at this index to the Txn, and
+	// returns the func which outputs its value. The default arm is at the
+	// index which is equal to Len.
+	ArmGraph func(txn interfaces.Txn, index int) (interfaces.Func, error)
+
+	init *interfaces.Init
+	last types.Value // last value received to use for diff
+	arm  int         // index of the active arm, or -1 if there is none
+
+	// outputChan is an initially-nil channel from which we receive output
+	// values from the active arm. This channel is reset when the arm is
+	// swapped.
+	outputChan chan types.Value
+}
+
+// MatchFuncArgNamePattern returns the name for the edge which connects the
+// pattern of the arm at this index to the match function.
+func MatchFuncArgNamePattern(index int) string {
+	return fmt.Sprintf("pattern:%d", index)
+}
+
+// MatchSubjectKind returns an error if values of this type can't be matched on.
+// The subject is compared for equality with each pattern, so only the simple
+// comparable kinds are allowed.
+func MatchSubjectKind(typ *types.Type) error {
+	if typ == nil {
+		return fmt.Errorf("the subject type is unknown")
+	}
+	switch typ.Kind {
+	case types.KindBool, types.KindStr, types.KindInt, types.KindVariant:
+		return nil
+	}
+	return fmt.Errorf("can't match on a value of type: %s", typ)
+}
+
+// String returns a simple name for this function. This is needed so this struct
+// can satisfy the pgraph.Vertex interface.
+func (obj *MatchFunc) String() string {
+	return MatchFuncName
+}
+
+// Validate tells us if the input struct takes a valid form.
+func (obj *MatchFunc) Validate() error {
+	if obj.Type == nil {
+		return fmt.Errorf("must specify a type")
+	}
+	if obj.Subject == nil {
+		return fmt.Errorf("must specify a subject type")
+	}
+	if err := MatchSubjectKind(obj.Subject); err != nil {
+		return err
+	}
+	if obj.Len < 0 {
+		return fmt.Errorf("invalid number of arms")
+	}
+	if obj.ArmGraph == nil {
+		return fmt.Errorf("must specify an arm graph function")
+	}
+	return nil
+}
+
+// Info returns some static info about itself.
+func (obj *MatchFunc) Info() *interfaces.Info {
+	var typ *types.Type
+	if obj.Type != nil && obj.Subject != nil { // don't panic if called speculatively
+		typ = &types.Type{
+			Kind: types.KindFunc, // function type
+			Map: map[string]*types.Type{
+				MatchFuncArgNameSubject: obj.Subject,
+			},
+			Ord: []string{MatchFuncArgNameSubject},
+			Out: obj.Type, // result type must match
+		}
+		for i := 0; i < obj.Len; i++ {
+			pattern := MatchFuncArgNamePattern(i)
+			typ.Map[pattern] = obj.Subject // patterns match the subject
+			typ.Ord = append(typ.Ord, pattern)
+		}
+	}
+
+	return &interfaces.Info{
+		Pure: true,
+		Memo: false, // TODO: ???
+		Sig:  typ,
+		Err:  obj.Validate(),
+	}
+}
+
+// Init runs some startup code for this match expression function.
+func (obj *MatchFunc) Init(init *interfaces.Init) error {
+	obj.init = init
+	obj.last = nil
+	obj.arm = -1
+	return nil
+}
+
+// Stream takes an input struct in the format as described in the Func and Graph
+// methods of the Expr, and returns the actual expected value as a stream based
+// on the changing inputs to that value.
+func (obj *MatchFunc) Stream(ctx context.Context) error {
+	defer close(obj.init.Output) // the sender closes
+
+	obj.outputChan = nil
+
+	defer func() {
+		obj.init.Txn.Reverse() // remove the active arm
+	}()
+
+	canReceiveMoreInputs := true
+	canReceiveMoreOutputValues := false // until we have an active arm
+	for {
+		if !canReceiveMoreInputs && !canReceiveMoreOutputValues {
+			return nil
+		}
+
+		select {
+		case input, ok := <-obj.init.Input:
+			if !ok {
+				obj.init.Input = nil // block looping back here
+				canReceiveMoreInputs = false
+				continue
+			}
+			if obj.last != nil && input.Cmp(obj.last) == nil {
+				continue // value didn't change, skip it
+			}
+			obj.last = input // store for next
+
+			args, err := interfaces.StructToCallableArgs(input) // []types.Value, error)
+			if err != nil {
+				return err
+			}
+
+			arm, err := obj.selectArm(args)
+			if err != nil {
+				return err
+			}
+
+			// It's important to avoid redundant graph replacements,
+			// since they're slow, and the arm would start again.
+			if arm == obj.arm {
+				continue // the same arm is still selected
+			}
+			obj.arm = arm
+
+			if err := obj.replaceSubGraph(arm); err != nil {
+				return errwrap.Wrapf(err, "could not replace subgraph")
+			}
+			canReceiveMoreOutputValues = true
+			continue
+
+		case outputValue, ok := <-obj.outputChan:
+			// send the new output value downstream
+			if !ok {
+				obj.outputChan = nil
+				canReceiveMoreOutputValues = false
+				continue
+			}
+
+			select {
+			case obj.init.Output <- outputValue: // send
+			case <-ctx.Done():
+				return nil
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// selectArm returns the index of the arm which is selected by these args. The
+// arms are tried in order, so the first pattern that is equal to the subject
+// wins. If none of them are, then this is the index of the default arm.
+func (obj *MatchFunc) selectArm(args []types.Value) (int, error) {
+	if i, j := len(args), 1+obj.Len; i != j {
+		return 0, fmt.Errorf("arg length doesn't match, got %d, exp: %d", i, j)
+	}
+
+	subject := args[0]
+	for i := 0; i < obj.Len; i++ {
+		if args[1+i].Cmp(subject) == nil {
+			return i, nil // this arm matched
+		}
+	}
+	return obj.Len, nil // default arm
+}
+
+// replaceSubGraph removes the previously active arm, and adds the one at this
+// index instead.
+func (obj *MatchFunc) replaceSubGraph(index int) error {
+	// Create a subgraph which looks as follows. Most of the nodes are
+	// elided because we don't know which nodes the arm will create.
+	//
+	// digraph {
+	//   armFunc -> "subgraphOutput"
+	// }
+
+	// delete the old subgraph
+	if err := obj.init.Txn.Reverse(); err != nil {
+		return errwrap.Wrapf(err, "could not Reverse")
+	}
+
+	armFunc, err := obj.ArmGraph(obj.init.Txn, index)
+	if err != nil {
+		return errwrap.Wrapf(err, "could not build the arm")
+	}
+
+	obj.outputChan = make(chan types.Value)
+	edgeName := ChannelBasedSinkFuncArgName
+	subgraphOutput := &ChannelBasedSinkFunc{
+		Name:     "subgraphOutput",
+		Target:   obj,
+		EdgeName: edgeName,
+		Chan:     obj.outputChan,
+		Type:     obj.Type,
+	}
+	edge := &interfaces.FuncEdge{Args: []string{edgeName}}
+	obj.init.Txn.AddVertex(subgraphOutput)
+	obj.init.Txn.AddEdge(armFunc, subgraphOutput, edge)
+	return obj.init.Txn.Commit()
+}
@@ -0,0 +1,121 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package structs
+
+import (
+	"testing"
+
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/types"
+)
+
+func TestMatchSelectArm1(t *testing.T) {
+	variant := func(v types.Value) types.Value {
+		return &types.VariantValue{V: v, T: v.Type()}
+	}
+	type test struct { // an individual test
+		name string
+		typ  *types.Type
+		args []types.Value // subject and then each pattern
+		exp  int
+	}
+	testCases := []test{
+		{
+			name: "str",
+			typ:  types.TypeStr,
+			args: []types.Value{&types.StrValue{V: "b"}, &types.StrValue{V: "a"}, &types.StrValue{V: "b"}},
+			exp:  1,
+		},
+		{
+			name: "first wins",
+			typ:  types.TypeInt,
+			args: []types.Value{&types.IntValue{V: 3}, &types.IntValue{V: 3}, &types.IntValue{V: 3}},
+			exp:  0,
+		},
+		{
+			name: "bool default",
+			typ:  types.TypeBool,
+			args: []types.Value{&types.BoolValue{V: false}, &types.BoolValue{V: true}},
+			exp:  1, // default
+		},
+		{
+			name: "variant",
+			typ:  types.TypeVariant,
+			args: []types.Value{variant(&types.StrValue{V: "b"}), variant(&types.StrValue{V: "a"}), variant(&types.StrValue{V: "b"})},
+			exp:  1,
+		},
+		{
+			name: "variant default",
+			typ:  types.TypeVariant,
+			args: []types.Value{variant(&types.IntValue{V: 42}), variant(&types.IntValue{V: 13})},
+			exp:  1, // default
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &MatchFunc{
+				Type:    types.TypeStr,
+				Subject: tc.typ,
+				Len:     len(tc.args) - 1,
+				ArmGraph: func(interfaces.Txn, int) (interfaces.Func, error) {
+					return nil, nil // unused
+				},
+			}
+			if err := obj.Validate(); err != nil {
+				t.Errorf("validate failed with: %v", err)
+				return
+			}
+			arm, err := obj.selectArm(tc.args)
+			if err != nil {
+				t.Errorf("select failed with: %v", err)
+				return
+			}
+			if arm != tc.exp {
+				t.Errorf("expected arm: %d, got: %d", tc.exp, arm)
+			}
+		})
+	}
+}
+
+func TestMatchSubjectKind1(t *testing.T) {
+	for _, s := range []string{"str", "int", "bool", "variant"} {
+		if err := MatchSubjectKind(types.NewType(s)); err != nil {
+			t.Errorf("type %s should be allowed, got: %v", s, err)
+		}
+	}
+	for _, s := range []string{"float", "[]str", "map{str: int}", "struct{a str}", "func(str) str"} {
+		if err := MatchSubjectKind(types.NewType(s)); err == nil {
+			t.Errorf("type %s should not be allowed", s)
+		}
+	}
+}
@@ -0,0 +1,692 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package trace records the changes that happen in a running function graph.
+// This is useful to find out which functions keep emitting values when a
+// program never converges. The trace includes every value that each function
+// sends, which may contain secrets, so it should be kept private.
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// DefaultLimit is the number of events that are kept in memory if no
+	// limit is specified. The oldest events are discarded first.
+	DefaultLimit = 10000
+
+	// DefaultWindow is the window of time used to find the hot nodes if
+	// none is specified.
+	DefaultWindow = 30 * time.Second
+
+	// DefaultMaxSize is the default maximum size in bytes of each of the
+	// two files which make up the trace file.
+	DefaultMaxSize = 8 * 1024 * 1024 // 8MiB
+
+	// RotatedSuffix is added to the end of the trace file path to get the
+	// path of the older file that it gets rotated into.
+	RotatedSuffix = ".1"
+
+	// KindAddVertex is the kind of event for a function added to the graph.
+	KindAddVertex = "add-vertex"
+
+	// KindDeleteVertex is the kind of event for a function removed from the
+	// graph.
+	KindDeleteVertex = "delete-vertex"
+
+	// KindAddEdge is the kind of event for an edge added to the graph.
+	KindAddEdge = "add-edge"
+
+	// KindDeleteEdge is the kind of event for an edge removed from the
+	// graph.
+	KindDeleteEdge = "delete-edge"
+
+	// KindValue is the kind of event for a new value sent by a function.
+	KindValue = "value"
+
+	// KindTxn is the kind of event for a graph transaction that committed.
+	KindTxn = "txn"
+
+	// graphvizColors is the number of colours in the graphviz colour scheme
+	// that we use to show how hot each node is.
+	graphvizColors = 9
+)
+
+// Event is a single change which happened in the function graph.
+type Event struct {
+	// Time is when this event happened.
+	Time time.Time `json:"time"`
+
+	// Kind is what sort of event this is. It is one of the Kind constants.
+	Kind string `json:"kind"`
+
+	// ID is a unique identifier for the function this event is about. For
+	// edge events this is the identifier of the source function.
+	ID string `json:"id,omitempty"`
+
+	// Name is the name of the function this event is about. For edge
+	// events this describes both ends of the edge.
+	Name string `json:"name,omitempty"`
+
+	// Position is the location in the source code which produced this
+	// function, if it is known.
+	Position string `json:"position,omitempty"`
+
+	// Value is the new value for value events, the edge args for edge
+	// events, and a short summary for transaction events.
+	Value string `json:"value,omitempty"`
+}
+
+// Node is the traced state of a single function in the graph.
+type Node struct {
+	// ID is a unique identifier for this function.
+	ID string `json:"id"`
+
+	// Name is the name of this function.
+	Name string `json:"name"`
+
+	// Position is the location in the source code which produced this
+	// function, if it is known.
+	Position string `json:"position,omitempty"`
+
+	// Count is the total number of values this function has sent.
+	Count int64 `json:"count"`
+
+	// Hot is the number of values this function sent inside the window. At
+	// most the last Limit values of each function are counted.
+	Hot int64 `json:"hot"`
+
+	// Last is when this function last sent a value.
+	Last time.Time `json:"last"`
+
+	// Value is the last value this function sent.
+	Value string `json:"value,omitempty"`
+}
+
+// Edge is an edge between two functions in the graph.
+type Edge struct {
+	// From is the identifier of the function that sends the value.
+	From string `json:"from"`
+
+	// To is the identifier of the function that receives the value.
+	To string `json:"to"`
+
+	// Args are the names of the arguments this edge fills.
+	Args []string `json:"args"`
+}
+
+// Snapshot is the state of the function graph at some moment in time.
+type Snapshot struct {
+	// Time is when this snapshot was taken.
+	Time time.Time `json:"time"`
+
+	// Window is the window of time used to count the hot values.
+	Window string `json:"window"`
+
+	// Nodes are the functions in the graph in a deterministic order.
+	Nodes []*Node `json:"nodes"`
+
+	// Edges are the edges in the graph in a deterministic order.
+	Edges []*Edge `json:"edges"`
+}
+
+// stats holds what we know about each function.
+type stats struct {
+	count int64
+	last  time.Time
+	value string
+	times []time.Time // when the latest values were sent, oldest first
+}
+
+// Tracer records the changes that happen in a running function graph, such as
+// functions being added or removed, and each new value that a function sends.
+// All of the methods are safe to call on a nil tracer, in which case they do
+// nothing. This lets the function engine call them unconditionally.
+type Tracer struct {
+	// Filename is an optional path to a file that each event gets appended
+	// to as a line of JSON. When it gets too big, it gets rotated into a
+	// single older file of the same name with the RotatedSuffix.
+	Filename string
+
+	// MaxSize is the maximum size in bytes of each of the two trace files.
+	// If it is zero, then DefaultMaxSize is used.
+	MaxSize int64
+
+	// Limit is the number of events to keep in memory. It is also the
+	// number of value times kept for each function to count the hot ones.
+	// If it is zero, then the DefaultLimit is used.
+	Limit int
+
+	Logf func(format string, v ...interface{})
+
+	mutex     *sync.Mutex
+	graph     *pgraph.Graph
+	stats     map[interfaces.Func]*stats
+	positions map[interfaces.Func]string
+	events    []*Event
+
+	file *os.File
+	size int64
+}
+
+// Init must be called to initialize the struct before first use.
+func (obj *Tracer) Init() error {
+	if obj.Logf == nil {
+		return fmt.Errorf("the Logf function must be specified")
+	}
+	if obj.Limit < 0 {
+		return fmt.Errorf("invalid limit of %d", obj.Limit)
+	}
+	if obj.Limit == 0 {
+		obj.Limit = DefaultLimit
+	}
+	if obj.MaxSize < 0 {
+		return fmt.Errorf("invalid max size of %d", obj.MaxSize)
+	}
+	if obj.MaxSize == 0 {
+		obj.MaxSize = DefaultMaxSize
+	}
+
+	graph, err := pgraph.NewGraph("trace")
+	if err != nil {
+		return err
+	}
+	obj.mutex = &sync.Mutex{}
+	obj.graph = graph
+	obj.stats = make(map[interfaces.Func]*stats)
+	obj.positions = make(map[interfaces.Func]string)
+	obj.events = []*Event{}
+
+	if obj.Filename != "" {
+		if err := obj.open(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// open opens the trace file for appending, and looks up its current size.
+func (obj *Tracer) open() error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	file, err := os.OpenFile(obj.Filename, flags, 0600) // private
+	if err != nil {
+		return errwrap.Wrapf(err, "could not open trace file")
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close() // ignore error
+		return errwrap.Wrapf(err, "could not stat trace file")
+	}
+	obj.file = file
+	obj.size = fileInfo.Size()
+	return nil
+}
+
+// rotate moves the current trace file into the rotated one, which replaces the
+// older events, and then opens a new, empty file.
+func (obj *Tracer) rotate() error {
+	if err := obj.file.Close(); err != nil {
+		return errwrap.Wrapf(err, "could not close trace file")
+	}
+	obj.file = nil
+	if err := os.Rename(obj.Filename, obj.Filename+RotatedSuffix); err != nil {
+		return errwrap.Wrapf(err, "could not rotate trace file")
+	}
+	return obj.open()
+}
+
+// write appends an event to the trace file. If the event would make the file
+// too big, then the file gets rotated first. It must be called with the mutex
+// held, and with an open file.
+func (obj *Tracer) write(event *Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if obj.size > 0 && obj.size+int64(len(b)) > obj.MaxSize {
+		if err := obj.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := obj.file.Write(b)
+	obj.size += int64(n)
+	return err
+}
+
+// Close closes the trace file if one is open.
+func (obj *Tracer) Close() error {
+	if obj == nil {
+		return nil
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	if obj.file == nil {
+		return nil
+	}
+	err := obj.file.Close()
+	obj.file = nil
+	return err
+}
+
+// record adds an event to the list, and to the trace file if we have one. It
+// must be called with the mutex held.
+func (obj *Tracer) record(event *Event) {
+	obj.events = append(obj.events, event)
+	if n := len(obj.events); n > obj.Limit {
+		obj.events = obj.events[n-obj.Limit:] // discard the oldest
+	}
+
+	if obj.file == nil {
+		return
+	}
+	if err := obj.write(event); err != nil {
+		// Don't spam the logs on each event, just stop writing.
+		obj.Logf("could not write to trace file: %+v", err)
+		if obj.file != nil {
+			obj.file.Close() // ignore error
+			obj.file = nil
+		}
+	}
+}
+
+// event builds a new event about this function. It must be called with the
+// mutex held.
+func (obj *Tracer) event(kind string, f interfaces.Func) *Event {
+	return &Event{
+		Time:     time.Now(),
+		Kind:     kind,
+		ID:       id(f),
+		Name:     f.String(),
+		Position: obj.positions[f],
+	}
+}
+
+// Locate stores the source position of the AST node which built this function.
+// Only the first known position is kept, since the innermost expression which
+// built a function is the most precise one. This has the right signature for
+// the Locator field of the interfaces.Env struct.
+func (obj *Tracer) Locate(f interfaces.Func, node interfaces.Node) {
+	if obj == nil || f == nil {
+		return
+	}
+	if pn, ok := node.(interfaces.PositionableNode); !ok || !pn.IsSet() {
+		return // we don't know where it is
+	}
+	displayer, ok := node.(interfaces.TextDisplayer)
+	if !ok {
+		return
+	}
+
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if _, exists := obj.positions[f]; exists {
+		return
+	}
+	obj.positions[f] = displayer.Byline()
+}
+
+// AddVertex records that this function was added to the graph.
+func (obj *Tracer) AddVertex(f interfaces.Func) {
+	if obj == nil {
+		return
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	obj.graph.AddVertex(f)
+	if _, exists := obj.stats[f]; !exists {
+		obj.stats[f] = &stats{}
+	}
+	obj.record(obj.event(KindAddVertex, f))
+}
+
+// DeleteVertex records that this function was removed from the graph.
+func (obj *Tracer) DeleteVertex(f interfaces.Func) {
+	if obj == nil {
+		return
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	obj.record(obj.event(KindDeleteVertex, f))
+	obj.graph.DeleteVertex(f)
+	delete(obj.stats, f)
+	delete(obj.positions, f)
+}
+
+// AddEdge records that this edge was added to the graph.
+func (obj *Tracer) AddEdge(f1, f2 interfaces.Func, fe *interfaces.FuncEdge) {
+	if obj == nil {
+		return
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	obj.graph.AddEdge(f1, f2, fe)
+	event := obj.event(KindAddEdge, f1)
+	event.Name = fmt.Sprintf("%s -> %s", f1, f2)
+	event.Value = strings.Join(fe.Args, ", ")
+	obj.record(event)
+}
+
+// DeleteEdge records that this edge was removed from the graph.
+func (obj *Tracer) DeleteEdge(fe *interfaces.FuncEdge) {
+	if obj == nil {
+		return
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	v1, v2, found := obj.graph.LookupEdge(fe)
+	if !found {
+		return // it was probably removed along with a vertex
+	}
+	f1, ok1 := v1.(interfaces.Func)
+	f2, ok2 := v2.(interfaces.Func)
+	if !ok1 || !ok2 {
+		return
+	}
+	obj.graph.DeleteEdge(fe)
+	event := obj.event(KindDeleteEdge, f1)
+	event.Name = fmt.Sprintf("%s -> %s", f1, f2)
+	event.Value = strings.Join(fe.Args, ", ")
+	obj.record(event)
+}
+
+// Value records that this function sent a new value.
+func (obj *Tracer) Value(f interfaces.Func, value types.Value) {
+	if obj == nil || value == nil {
+		return
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	event := obj.event(KindValue, f)
+	event.Value = value.String()
+
+	// The engine might still send a value for a func that was deleted.
+	if s, exists := obj.stats[f]; exists {
+		s.count++
+		s.last = event.Time
+		s.value = event.Value
+		s.times = append(s.times, event.Time)
+		if n := len(s.times); n > obj.Limit {
+			s.times = s.times[n-obj.Limit:] // discard the oldest
+		}
+	}
+	obj.record(event)
+}
+
+// Txn records that a graph transaction committed this many operations. This
+// has the right signature for the Trace field of the txn.GraphTxn struct.
+func (obj *Tracer) Txn(ops int) {
+	if obj == nil {
+		return
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	obj.record(&Event{
+		Time:  time.Now(),
+		Kind:  KindTxn,
+		Value: fmt.Sprintf("committed %d operations", ops),
+	})
+}
+
+// Events returns a copy of the events which happened at or after this time.
+// Pass in the zero time to get every event that is still kept in memory.
+func (obj *Tracer) Events(since time.Time) []*Event {
+	if obj == nil {
+		return []*Event{}
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	// The events are in order, so find the first one we want.
+	i := sort.Search(len(obj.events), func(i int) bool {
+		return !obj.events[i].Time.Before(since)
+	})
+	events := []*Event{}
+	for _, x := range obj.events[i:] {
+		event := *x // copy
+		events = append(events, &event)
+	}
+	return events
+}
+
+// Snapshot returns the current state of the function graph. Each node includes
+// the number of values it sent during the window of time that ended now. If the
+// window is zero, the DefaultWindow is used.
+func (obj *Tracer) Snapshot(window time.Duration) *Snapshot {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	now := time.Now()
+	snapshot := &Snapshot{
+		Time:   now,
+		Window: window.String(),
+		Nodes:  []*Node{},
+		Edges:  []*Edge{},
+	}
+	if obj == nil {
+		return snapshot
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+
+	start := now.Add(-window)
+	for _, v := range obj.graph.VerticesSorted() { // deterministic
+		f, ok := v.(interfaces.Func)
+		if !ok {
+			continue
+		}
+		node := &Node{
+			ID:       id(f),
+			Name:     f.String(),
+			Position: obj.positions[f],
+		}
+		if s, exists := obj.stats[f]; exists {
+			node.Count = s.count
+			node.Last = s.last
+			node.Value = s.value
+			node.Hot = hot(s.times, start)
+		}
+		snapshot.Nodes = append(snapshot.Nodes, node)
+
+		vs := []pgraph.Vertex{}
+		for v2 := range obj.graph.Adjacency()[v] {
+			vs = append(vs, v2)
+		}
+		sort.Sort(pgraph.VertexSlice(vs)) // deterministic
+		for _, v2 := range vs {
+			fe, ok := obj.graph.Adjacency()[v][v2].(*interfaces.FuncEdge)
+			if !ok {
+				continue
+			}
+			snapshot.Edges = append(snapshot.Edges, &Edge{
+				From: id(f),
+				To:   fmt.Sprintf("%p", v2),
+				Args: fe.Args,
+			})
+		}
+	}
+
+	return snapshot
+}
+
+// hot returns the number of these times which are at or after the start. The
+// times must be in order.
+func hot(times []time.Time, start time.Time) int64 {
+	i := sort.Search(len(times), func(i int) bool {
+		return !times[i].Before(start)
+	})
+	return int64(len(times) - i)
+}
+
+// IsLoopback returns true if this listen address only accepts connections from
+// the local machine. An address without a host listens on every interface.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Graphviz returns the current state of the function graph in graphviz format.
+// Nodes which sent more values during the window of time that ended now are
+// drawn in a hotter colour, and each node shows its source position if known.
+func (obj *Tracer) Graphviz(window time.Duration) string {
+	snapshot := obj.Snapshot(window)
+
+	max := int64(0)
+	for _, node := range snapshot.Nodes {
+		if node.Hot > max {
+			max = node.Hot
+		}
+	}
+
+	name := fmt.Sprintf("trace: %s", snapshot.Window)
+	str := ""
+	str += fmt.Sprintf("digraph \"%s\" {\n", name)
+	str += fmt.Sprintf("\tlabel=\"%s\";\n", name)
+	str += fmt.Sprintf("\tnode [colorscheme=reds%d,style=filled];\n", graphvizColors)
+	for _, node := range snapshot.Nodes {
+		color := 1 // coldest
+		if max > 0 && node.Hot > 0 {
+			color += int((node.Hot*(graphvizColors-1) + max - 1) / max)
+		}
+		label := html.EscapeString(node.Name)
+		if node.Position != "" {
+			label += "<BR />" + html.EscapeString(node.Position)
+		}
+		label += fmt.Sprintf("<BR />%d in window, %d total", node.Hot, node.Count)
+		str += fmt.Sprintf("\t\"%s\" [label=<%s>,fillcolor=%d];\n", node.ID, label, color)
+	}
+	for _, edge := range snapshot.Edges {
+		label := html.EscapeString(strings.Join(edge.Args, ", "))
+		str += fmt.Sprintf("\t\"%s\" -> \"%s\" [label=<%s>];\n", edge.From, edge.To, label)
+	}
+	str += "}\n"
+
+	return str
+}
+
+// Handler returns an http handler which serves the trace. The /graph path has
+// a JSON snapshot of the graph, the /events path has the JSON list of events,
+// and the /graphviz path has a graphviz snapshot. The snapshots take a window
+// parameter such as `?window=1m` and the events take a since parameter with an
+// RFC3339 time such as `?since=2006-01-02T15:04:05Z`.
+func (obj *Tracer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/graph", func(w http.ResponseWriter, r *http.Request) {
+		window, err := parseWindow(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, obj.Snapshot(window))
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		since := time.Time{}
+		if s := r.URL.Query().Get("since"); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid since: %s", s), http.StatusBadRequest)
+				return
+			}
+			since = t
+		}
+		writeJSON(w, obj.Events(since))
+	})
+
+	mux.HandleFunc("/graphviz", func(w http.ResponseWriter, r *http.Request) {
+		window, err := parseWindow(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write([]byte(obj.Graphviz(window))) // ignore error
+	})
+
+	return mux
+}
+
+// id returns a unique identifier for this function.
+func id(f interfaces.Func) string {
+	return fmt.Sprintf("%p", f)
+}
+
+// parseWindow pulls the optional window parameter out of the request.
+func parseWindow(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("window")
+	if s == "" {
+		return DefaultWindow, nil
+	}
+	window, err := time.ParseDuration(s)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window: %s", s)
+	}
+	return window, nil
+}
+
+// writeJSON sends this data as JSON.
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(data) // ignore error
+}
@@ -0,0 +1,330 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purpleidea/mgmt/lang/ast"
+	"github.com/purpleidea/mgmt/lang/funcs/structs"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/types"
+)
+
+func testTracer(t *testing.T, filename string) *Tracer {
+	tracer := &Tracer{
+		Filename: filename,
+		Logf: func(format string, v ...interface{}) {
+			t.Logf("trace: "+format, v...)
+		},
+	}
+	if err := tracer.Init(); err != nil {
+		t.Fatalf("could not init tracer: %+v", err)
+	}
+	return tracer
+}
+
+func TestTracerNil0(t *testing.T) {
+	var tracer *Tracer // nil tracers must be safe to use
+	f := &structs.ConstFunc{Value: &types.IntValue{V: 42}}
+	tracer.Locate(f, &ast.ExprInt{V: 42})
+	tracer.AddVertex(f)
+	tracer.Value(f, &types.IntValue{V: 42})
+	tracer.Txn(1)
+	tracer.DeleteVertex(f)
+	if err := tracer.Close(); err != nil {
+		t.Errorf("close failed: %+v", err)
+	}
+	if n := len(tracer.Snapshot(0).Nodes); n != 0 {
+		t.Errorf("expected no nodes, got: %d", n)
+	}
+}
+
+func TestTracer1(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "trace.json")
+	tracer := testTracer(t, filename)
+
+	expr := &ast.ExprInt{V: 42}
+	expr.Locate(2, 4, 2, 6)
+	f1 := &structs.ConstFunc{Value: &types.IntValue{V: 42}}
+	f2 := &structs.ConstFunc{Value: &types.IntValue{V: 13}}
+	f3 := &structs.ConstFunc{Value: &types.IntValue{V: 0}}
+
+	tracer.Locate(f1, expr)
+	tracer.Locate(f1, &ast.ExprInt{V: 42}) // first position wins
+	tracer.Locate(f2, &ast.ExprInt{V: 13}) // not located, so skipped
+	tracer.AddVertex(f1)
+	tracer.AddVertex(f2)
+	tracer.AddVertex(f3)
+	tracer.AddEdge(f1, f2, &interfaces.FuncEdge{Args: []string{"a"}})
+	tracer.Txn(4)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		tracer.Value(f1, &types.IntValue{V: int64(i)})
+	}
+	tracer.Value(f2, &types.IntValue{V: 13})
+
+	snapshot := tracer.Snapshot(time.Minute)
+	if n := len(snapshot.Nodes); n != 3 {
+		t.Fatalf("expected 3 nodes, got: %d", n)
+	}
+	if n := len(snapshot.Edges); n != 1 {
+		t.Fatalf("expected 1 edge, got: %d", n)
+	}
+	nodes := make(map[string]*Node)
+	for _, node := range snapshot.Nodes {
+		nodes[node.ID] = node
+	}
+	if node := nodes[id(f1)]; node.Hot != 3 || node.Count != 3 || node.Value != "2" {
+		t.Errorf("unexpected node: %+v", node)
+	}
+	if node := nodes[id(f1)]; node.Position != "<unknown> @ 3:5-3:7" {
+		t.Errorf("unexpected position: %s", node.Position)
+	}
+	if node := nodes[id(f2)]; node.Hot != 1 || node.Position != "" {
+		t.Errorf("unexpected node: %+v", node)
+	}
+	if node := nodes[id(f3)]; node.Hot != 0 || node.Count != 0 {
+		t.Errorf("unexpected node: %+v", node)
+	}
+
+	if n := len(tracer.Events(start)); n != 4 {
+		t.Errorf("expected 4 value events, got: %d", n)
+	}
+	if n := len(tracer.Events(time.Time{})); n != 9 {
+		t.Errorf("expected 9 events, got: %d", n)
+	}
+
+	gv := tracer.Graphviz(time.Minute)
+	if !strings.Contains(gv, "fillcolor=9") {
+		t.Errorf("expected a hot node in:\n%s", gv)
+	}
+	if !strings.Contains(gv, "fillcolor=1") {
+		t.Errorf("expected a cold node in:\n%s", gv)
+	}
+
+	tracer.DeleteVertex(f2)
+	if n := len(tracer.Snapshot(0).Edges); n != 0 {
+		t.Errorf("expected no edges, got: %d", n)
+	}
+
+	if err := tracer.Close(); err != nil {
+		t.Errorf("close failed: %+v", err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("could not stat trace file: %+v", err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("expected a private trace file, got mode: %o", mode)
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("could not read trace file: %+v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if n := len(lines); n != 10 {
+		t.Errorf("expected 10 lines, got: %d", n)
+	}
+	event := &Event{}
+	if err := json.Unmarshal([]byte(lines[0]), event); err != nil {
+		t.Errorf("could not decode event: %+v", err)
+	}
+	if event.Kind != KindAddVertex || event.ID != id(f1) {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestTracerLimit0(t *testing.T) {
+	tracer := testTracer(t, "")
+	tracer.Limit = 2
+	f := &structs.ConstFunc{Value: &types.IntValue{V: 42}}
+	tracer.AddVertex(f)
+	for i := 0; i < 5; i++ {
+		tracer.Value(f, &types.IntValue{V: int64(i)})
+	}
+	events := tracer.Events(time.Time{})
+	if n := len(events); n != 2 {
+		t.Fatalf("expected 2 events, got: %d", n)
+	}
+	if v := events[1].Value; v != "4" {
+		t.Errorf("expected the newest event, got: %s", v)
+	}
+}
+
+func TestTracerRotate0(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "trace.json")
+	tracer := &Tracer{
+		Filename: filename,
+		MaxSize:  1024,
+		Logf: func(format string, v ...interface{}) {
+			t.Logf("trace: "+format, v...)
+		},
+	}
+	if err := tracer.Init(); err != nil {
+		t.Fatalf("could not init tracer: %+v", err)
+	}
+	f := &structs.ConstFunc{Value: &types.IntValue{V: 42}}
+	tracer.AddVertex(f)
+	count := 100
+	for i := 0; i < count; i++ {
+		tracer.Value(f, &types.IntValue{V: int64(i)})
+	}
+	if err := tracer.Close(); err != nil {
+		t.Errorf("close failed: %+v", err)
+	}
+
+	for _, x := range []string{filename, filename + RotatedSuffix} {
+		fi, err := os.Stat(x)
+		if err != nil {
+			t.Fatalf("could not stat trace file: %+v", err)
+		}
+		if size := fi.Size(); size > tracer.MaxSize {
+			t.Errorf("file %s is too big: %d", x, size)
+		}
+		if mode := fi.Mode().Perm(); mode != 0600 {
+			t.Errorf("expected a private trace file, got mode: %o", mode)
+		}
+	}
+
+	// the newest event must be the last line of the current file
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("could not read trace file: %+v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	event := &Event{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), event); err != nil {
+		t.Fatalf("could not decode event: %+v", err)
+	}
+	if expected := fmt.Sprintf("%d", count-1); event.Value != expected {
+		t.Errorf("expected the newest event, got: %s", event.Value)
+	}
+}
+
+func TestTracerHot0(t *testing.T) {
+	tracer := testTracer(t, "")
+	tracer.Limit = 3
+	f1 := &structs.ConstFunc{Value: &types.IntValue{V: 1}}
+	f2 := &structs.ConstFunc{Value: &types.IntValue{V: 2}}
+	tracer.AddVertex(f1)
+	tracer.AddVertex(f2)
+	for i := 0; i < 3; i++ {
+		tracer.Value(f1, &types.IntValue{V: int64(i)})
+	}
+	// These push every value event of f1 out of the list of events.
+	for i := 0; i < 5; i++ {
+		tracer.Value(f2, &types.IntValue{V: int64(i)})
+	}
+
+	nodes := make(map[string]*Node)
+	for _, node := range tracer.Snapshot(time.Minute).Nodes {
+		nodes[node.ID] = node
+	}
+	if node := nodes[id(f1)]; node.Hot != 3 || node.Count != 3 {
+		t.Errorf("unexpected f1 node: %+v", node)
+	}
+	if node := nodes[id(f2)]; node.Hot != 3 || node.Count != 5 { // limited
+		t.Errorf("unexpected f2 node: %+v", node)
+	}
+}
+
+func TestIsLoopback0(t *testing.T) {
+	testCases := map[string]bool{
+		"127.0.0.1:9234": true,
+		"127.1.2.3:9234": true,
+		"[::1]:9234":     true,
+		"localhost:9234": true,
+		":9234":          false,
+		"0.0.0.0:9234":   false,
+		"[::]:9234":      false,
+		"192.0.2.1:9234": false,
+		"example.com:80": false,
+		"nope":           false,
+	}
+	for addr, expected := range testCases {
+		if b := IsLoopback(addr); b != expected {
+			t.Errorf("address %s: expected %t, got: %t", addr, expected, b)
+		}
+	}
+}
+
+func TestTracerHandler0(t *testing.T) {
+	tracer := testTracer(t, "")
+	f := &structs.ConstFunc{Value: &types.IntValue{V: 42}}
+	tracer.AddVertex(f)
+	tracer.Value(f, &types.IntValue{V: 42})
+
+	server := httptest.NewServer(tracer.Handler())
+	defer server.Close()
+
+	type test struct { // an individual test
+		path   string
+		status int
+		prefix string
+	}
+	testCases := []test{
+		{"/graph?window=1m", http.StatusOK, "{"},
+		{"/graph?window=nope", http.StatusBadRequest, "invalid window"},
+		{"/events", http.StatusOK, "["},
+		{"/events?since=yesterday", http.StatusBadRequest, "invalid since"},
+		{"/graphviz", http.StatusOK, "digraph"},
+	}
+	for index, tc := range testCases {
+		resp, err := http.Get(server.URL + tc.path)
+		if err != nil {
+			t.Errorf("test #%d: request failed: %+v", index, err)
+			continue
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("test #%d: could not read body: %+v", index, err)
+			continue
+		}
+		if resp.StatusCode != tc.status {
+			t.Errorf("test #%d: expected status %d, got: %d", index, tc.status, resp.StatusCode)
+		}
+		if !strings.HasPrefix(string(b), tc.prefix) {
+			t.Errorf("test #%d: unexpected body: %s", index, string(b))
+		}
+	}
+}
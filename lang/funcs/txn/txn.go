@@ -296,6 +296,10 @@ type GraphTxn struct {
 	// when we're done with this Txn.
 	FreeFunc func()
 
+	// Trace is an optional function that will get called with the number
+	// of operations each time a commit succeeds. It is used for tracing.
+	Trace func(int)
+
 	// ops is a list of operations to run on a graph
 	ops []opfn
 
@@ -327,6 +331,7 @@ func (obj *GraphTxn) Copy() interfaces.Txn {
 		GraphAPI: obj.GraphAPI,
 		RefCount: obj.RefCount, // this is shared across all txn's
 		// FreeFunc is shared with the parent.
+		Trace: obj.Trace,
 	}
 	return txn.Init()
 }
@@ -505,6 +510,9 @@ func (obj *GraphTxn) commit() error {
 			//obj.rev = append([]opfn{op}, obj.rev...) // add to front
 		}
 	}
+	if obj.Trace != nil {
+		obj.Trace(len(obj.ops))
+	}
 	obj.ops = []opfn{} // clear it
 
 	// garbage collect anything that hit zero!
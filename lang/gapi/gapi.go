@@ -539,10 +539,15 @@ func (obj *GAPI) LangInit(ctx context.Context) error {
 		Input: input,
 		Data:  obj.Data,
 
-		Hostname: obj.data.Hostname,
-		Local:    obj.data.Local,
-		World:    obj.data.World,
-		Debug:    obj.data.Debug,
+		Hostname:   obj.data.Hostname,
+		Local:      obj.data.Local,
+		World:      obj.data.World,
+		Prometheus: obj.data.Prometheus,
+
+		TraceFuncs:  obj.data.TraceFuncs,
+		TraceListen: obj.data.TraceListen,
+
+		Debug: obj.data.Debug,
 		Logf: func(format string, v ...interface{}) {
 			// TODO: add the Name prefix in parent logger
 			obj.data.Logf(Name+": "+format, v...)
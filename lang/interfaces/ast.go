@@ -292,6 +292,10 @@ type Scope struct {
 	// Classes map the name of the class to the class.
 	Classes map[string]Stmt
 
+	// Types map the name of a type alias to the type it stands for. The
+	// stored types have their Name set to the alias.
+	Types map[string]*types.Type
+
 	// Iterated is a flag that is true if this scope is inside of a for
 	// loop.
 	Iterated bool
@@ -306,6 +310,7 @@ func EmptyScope() *Scope {
 		Variables: make(map[string]Expr),
 		Functions: make(map[string]Expr),
 		Classes:   make(map[string]Stmt),
+		Types:     make(map[string]*types.Type),
 		Iterated:  false,
 		Chain:     []Node{},
 	}
@@ -323,6 +328,7 @@ func (obj *Scope) Copy() *Scope {
 	variables := make(map[string]Expr)
 	functions := make(map[string]Expr)
 	classes := make(map[string]Stmt)
+	typs := make(map[string]*types.Type)
 	iterated := obj.Iterated
 	chain := []Node{}
 
@@ -335,6 +341,9 @@ func (obj *Scope) Copy() *Scope {
 	for k, v := range obj.Classes { // copy
 		classes[k] = v // we don't copy the StmtClass!
 	}
+	for k, v := range obj.Types { // copy
+		typs[k] = v // types are static, we don't copy them
+	}
 	for _, x := range obj.Chain { // copy
 		chain = append(chain, x) // we don't copy the Stmt pointer!
 	}
@@ -343,6 +352,7 @@ func (obj *Scope) Copy() *Scope {
 		Variables: variables,
 		Functions: functions,
 		Classes:   classes,
+		Types:     typs,
 		Iterated:  iterated,
 		Chain:     chain,
 	}
@@ -360,6 +370,7 @@ func (obj *Scope) Merge(scope *Scope) error {
 	namedVariables := []string{}
 	namedFunctions := []string{}
 	namedClasses := []string{}
+	namedTypes := []string{}
 	for name := range scope.Variables {
 		namedVariables = append(namedVariables, name)
 	}
@@ -371,7 +382,11 @@ func (obj *Scope) Merge(scope *Scope) error {
 	}
 	sort.Strings(namedVariables)
 	sort.Strings(namedFunctions)
+	for name := range scope.Types {
+		namedTypes = append(namedTypes, name)
+	}
 	sort.Strings(namedClasses)
+	sort.Strings(namedTypes)
 
 	for _, name := range namedVariables {
 		if _, exists := obj.Variables[name]; exists {
@@ -394,6 +409,16 @@ func (obj *Scope) Merge(scope *Scope) error {
 		}
 		obj.Classes[name] = scope.Classes[name]
 	}
+	if obj.Types == nil && len(namedTypes) > 0 {
+		obj.Types = make(map[string]*types.Type)
+	}
+	for _, name := range namedTypes {
+		if _, exists := obj.Types[name]; exists {
+			e := fmt.Errorf("type `%s` was overwritten", name)
+			err = errwrap.Append(err, e)
+		}
+		obj.Types[name] = scope.Types[name]
+	}
 
 	if scope.Iterated { // XXX: how should we merge this?
 		obj.Iterated = scope.Iterated
@@ -417,6 +442,9 @@ func (obj *Scope) IsEmpty() bool {
 	if len(obj.Classes) > 0 {
 		return false
 	}
+	if len(obj.Types) > 0 {
+		return false
+	}
 	return true
 }
 
@@ -434,6 +462,12 @@ type Env struct {
 	// in obj.expr of ExprCall. (Functions map[string]*Env) But actually,
 	// our new version is now this:
 	Functions map[Expr]*Env
+
+	// Locator is an optional function which is called with each function
+	// that gets built by the Graph method of an expression, along with that
+	// expression. This is used to map functions back to their position in
+	// the source code, for example when tracing the running function graph.
+	Locator func(Func, Node)
 }
 
 // EmptyEnv returns the zero, empty value for the env, with all the internal
@@ -467,6 +501,7 @@ func (obj *Env) Copy() *Env {
 	return &Env{
 		Variables: variables,
 		Functions: functions,
+		Locator:   obj.Locator,
 	}
 }
 
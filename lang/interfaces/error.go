@@ -30,6 +30,8 @@
 package interfaces
 
 import (
+	"fmt"
+
 	"github.com/purpleidea/mgmt/util"
 )
 
@@ -51,3 +53,29 @@ const (
 	// signal a permanent error.
 	ErrExpectedFileMissing = util.Error("file is currently missing")
 )
+
+// NodeError is an error which is associated with a particular AST node. It lets
+// tools such as the language server find out where in the source the error
+// occurred, without having to parse the error message.
+type NodeError struct {
+	// Err is the underlying error.
+	Err error
+
+	// Node is the node that caused the error. If it implements the
+	// PositionableNode interface, then the position is known.
+	Node Node
+}
+
+// Error returns the error message. If the node knows where it is, the location
+// is added onto the end.
+func (obj *NodeError) Error() string {
+	if displayer, ok := obj.Node.(TextDisplayer); ok {
+		return fmt.Sprintf("%s: %s", obj.Err.Error(), displayer.Byline())
+	}
+	return obj.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (obj *NodeError) Unwrap() error {
+	return obj.Err
+}
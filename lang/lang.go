@@ -35,6 +35,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
@@ -43,6 +44,7 @@ import (
 	"github.com/purpleidea/mgmt/lang/ast"
 	_ "github.com/purpleidea/mgmt/lang/core" // import so the funcs register
 	"github.com/purpleidea/mgmt/lang/funcs/dage"
+	"github.com/purpleidea/mgmt/lang/funcs/trace"
 	"github.com/purpleidea/mgmt/lang/funcs/vars"
 	"github.com/purpleidea/mgmt/lang/inputs"
 	"github.com/purpleidea/mgmt/lang/interfaces"
@@ -53,6 +55,7 @@ import (
 	"github.com/purpleidea/mgmt/lang/unification"
 	_ "github.com/purpleidea/mgmt/lang/unification/solvers" // import so the solvers register
 	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/prometheus"
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
 )
@@ -99,12 +102,26 @@ type Lang struct {
 	Local    *local.API
 	World    engine.World
 	Prefix   string
-	Debug    bool
-	Logf     func(format string, v ...interface{})
 
-	ast   interfaces.Stmt // store main prog AST here
-	funcs *dage.Engine    // function event engine
-	graph *pgraph.Graph   // function graph
+	// Prometheus is an optional handle to the metrics that the function
+	// engine updates.
+	Prometheus *prometheus.Prometheus
+
+	// TraceFuncs is an optional path to a file where a trace of every
+	// change to the running function graph is appended as JSON lines.
+	TraceFuncs string
+
+	// TraceListen is an optional address to serve the live function graph
+	// trace on over http. See the trace.Tracer Handler method for the API.
+	TraceListen string
+
+	Debug bool
+	Logf  func(format string, v ...interface{})
+
+	ast    interfaces.Stmt // store main prog AST here
+	funcs  *dage.Engine    // function event engine
+	graph  *pgraph.Graph   // function graph
+	tracer *trace.Tracer   // function graph tracer (if used)
 
 	streamChan <-chan error // signals a new graph can be created or problem
 	//streamBurst bool // should we try and be bursty with the stream events?
@@ -291,6 +308,18 @@ func (obj *Lang) Init(ctx context.Context) error {
 	// iow, we don't support variable, variables or absurd things like that
 	obj.graph = &pgraph.Graph{Name: "functionGraph"}
 	env := interfaces.EmptyEnv()
+	if obj.TraceFuncs != "" || obj.TraceListen != "" {
+		obj.tracer = &trace.Tracer{
+			Filename: obj.TraceFuncs,
+			Logf: func(format string, v ...interface{}) {
+				obj.Logf("trace: "+format, v...)
+			},
+		}
+		if err := obj.tracer.Init(); err != nil {
+			return errwrap.Wrapf(err, "could not start the function tracer")
+		}
+		env.Locator = obj.tracer.Locate // learn the source positions
+	}
 	// XXX: Do we need to do something like this?
 	//for k, v := range scope.Variables {
 	//	g, builtinFunc, err := v.Graph(nil)
@@ -326,7 +355,9 @@ func (obj *Lang) Init(ctx context.Context) error {
 		Local:    obj.Local,
 		World:    obj.World,
 		//Prefix:   fmt.Sprintf("%s/", path.Join(obj.Prefix, "funcs")),
-		Debug: obj.Debug,
+		Prometheus: obj.Prometheus,
+		Tracer:     obj.tracer,
+		Debug:      obj.Debug,
 		Logf: func(format string, v ...interface{}) {
 			obj.Logf("funcs: "+format, v...)
 		},
@@ -368,6 +399,33 @@ func (obj *Lang) Run(ctx context.Context) (reterr error) {
 
 	<-obj.funcs.Started() // wait for startup (will not block forever)
 
+	if obj.tracer != nil && obj.TraceListen != "" {
+		server := &http.Server{
+			Addr:    obj.TraceListen,
+			Handler: obj.tracer.Handler(),
+		}
+		obj.Logf("serving the function trace on: %s", server.Addr)
+		if !trace.IsLoopback(server.Addr) {
+			// The trace has every value, and it has no authentication.
+			obj.Logf("warning: the function trace is not only on loopback, any values such as secrets are exposed")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				// Tracing is for debugging, so don't fail the run.
+				obj.Logf("could not serve the function trace: %+v", err)
+			}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-runCtx.Done()
+			server.Close() // ignore error
+		}()
+	}
+
 	// Sanity checks for graph size.
 	if count := obj.funcs.NumVertices(); count != 0 {
 		return fmt.Errorf("expected empty graph on start, got %d vertices", count)
@@ -457,5 +515,12 @@ func (obj *Lang) Interpret() (*pgraph.Graph, error) {
 
 // Cleanup cleans up and frees memory and resources after everything is done.
 func (obj *Lang) Cleanup() error {
-	return obj.funcs.Cleanup()
+	var reterr error
+	if err := obj.funcs.Cleanup(); err != nil {
+		reterr = errwrap.Append(reterr, err)
+	}
+	if err := obj.tracer.Close(); err != nil { // safe if nil
+		reterr = errwrap.Append(reterr, err)
+	}
+	return reterr
 }
@@ -0,0 +1,631 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package lsp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/purpleidea/mgmt/engine"
+	engineUtil "github.com/purpleidea/mgmt/engine/util"
+	"github.com/purpleidea/mgmt/lang/ast"
+	"github.com/purpleidea/mgmt/lang/funcs/vars"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/interpolate"
+	"github.com/purpleidea/mgmt/lang/parser"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/lang/unification"
+	langUtil "github.com/purpleidea/mgmt/lang/util"
+	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// UnificationTimeout is the maximum amount of time in seconds that we
+	// spend on type unification for a single document change.
+	UnificationTimeout = 10
+
+	// definitionKindVar is used for bind statements.
+	definitionKindVar = "var"
+
+	// definitionKindFunc is used for func statements.
+	definitionKindFunc = "func"
+
+	// definitionKindClass is used for class statements.
+	definitionKindClass = "class"
+
+	// definitionKindImport is used for import statements.
+	definitionKindImport = "import"
+)
+
+var (
+	// resHeaderRegexp matches the start of a resource definition, eg:
+	// `file "/tmp/foo" {` and captures the kind.
+	resHeaderRegexp = regexp.MustCompile(`^\s*([a-z][a-z0-9_:]*)\s+[^=]*\{\s*$`)
+
+	// stmtStartRegexp matches a line where a new statement is being typed.
+	stmtStartRegexp = regexp.MustCompile(`^\s*[a-z][a-z0-9_:]*$`)
+)
+
+// Analysis is the result of checking a single mcl document. It keeps the AST
+// around so that the hover, definition and completion requests can be answered
+// without checking the document again.
+type Analysis struct {
+	// URI is the document identifier that the client uses.
+	URI string
+
+	// Path is the absolute filename of the document.
+	Path string
+
+	// Diagnostics is the list of problems that were found. It is empty if
+	// the document lexed, parsed and unified successfully.
+	Diagnostics []*Diagnostic
+
+	lines []string        // document text split into lines
+	ast   interfaces.Stmt // nil if we couldn't get this far
+}
+
+// analyze lexes, parses, builds the scope of, and unifies the document. Any
+// error is stored as a diagnostic. Whatever stage we reach, we keep the AST so
+// that we can still answer questions about it.
+func (obj *Server) analyze(ctx context.Context, uri, path, text string) *Analysis {
+	analysis := &Analysis{
+		URI:         uri,
+		Path:        path,
+		Diagnostics: []*Diagnostic{},
+		lines:       strings.Split(text, "\n"),
+	}
+
+	xast, err := parser.LexParse(strings.NewReader(text))
+	if err != nil {
+		analysis.addError(errwrap.Wrapf(err, "could not generate AST"))
+		return analysis
+	}
+
+	importGraph, err := pgraph.NewGraph("importGraph")
+	if err != nil {
+		analysis.addError(err)
+		return analysis
+	}
+	importVertex := &pgraph.SelfVertex{
+		Name:  "",          // first node is the empty string
+		Graph: importGraph, // store a reference to ourself
+	}
+	importGraph.AddVertex(importVertex)
+
+	osFs := afero.NewReadOnlyFs(afero.NewOsFs()) // we never write!
+	localFs := &util.AferoFs{Afero: &afero.Afero{Fs: osFs}}
+
+	data := &interfaces.Data{
+		Fs:    localFs,
+		FsURI: localFs.URI(),
+		Base:  filepath.Dir(path) + "/", // base path with trailing slash
+		Files: []string{path},
+		Metadata: &interfaces.Metadata{
+			Main: filepath.Base(path), // use the name of the input
+		},
+		Imports: importVertex,
+		Modules: obj.ModulePath,
+
+		LexParser:       parser.LexParse,
+		Downloader:      nil, // we never download in the editor
+		StrInterpolater: interpolate.StrInterpolate,
+		SourceFinder: func(p string) ([]byte, error) {
+			if p == path { // use the unsaved version
+				return []byte(text), nil
+			}
+			return os.ReadFile(p)
+		},
+
+		Debug: obj.Debug,
+		Logf: func(format string, v ...interface{}) {
+			obj.Logf("ast: "+format, v...)
+		},
+	}
+	if err := xast.Init(data); err != nil {
+		analysis.addError(errwrap.Wrapf(err, "could not init and validate AST"))
+		return analysis
+	}
+
+	iast, err := xast.Interpolate()
+	if err != nil {
+		analysis.addError(errwrap.Wrapf(err, "could not interpolate AST"))
+		return analysis
+	}
+	analysis.ast = iast
+
+	variables := map[string]interfaces.Expr{
+		"purpleidea": &ast.ExprStr{V: "hello world!"}, // james says hi
+		"hostname":   &ast.ExprStr{V: ""},             // not used here
+	}
+	consts := ast.VarPrefixToVariablesScope(vars.ConstNamespace) // strips prefix!
+	addback := vars.ConstNamespace + interfaces.ModuleSep        // add it back...
+	variables, err = ast.MergeExprMaps(variables, consts, addback)
+	if err != nil {
+		analysis.addError(errwrap.Wrapf(err, "couldn't merge in consts"))
+		return analysis
+	}
+
+	// top-level, built-in, initial global scope
+	scope := &interfaces.Scope{
+		Variables: variables,
+		// all the built-in top-level, core functions enter here...
+		Functions: ast.FuncPrefixToFunctionsScope(""), // runs funcs.LookupPrefix
+	}
+	if err := iast.SetScope(scope); err != nil {
+		analysis.addError(errwrap.Wrapf(err, "could not set scope"))
+		return analysis
+	}
+
+	solver, err := unification.LookupDefault()
+	if err != nil {
+		analysis.addError(errwrap.Wrapf(err, "could not get default solver"))
+		return analysis
+	}
+	unifier := &unification.Unifier{
+		AST:          iast,
+		Solver:       solver,
+		Strategy:     make(map[string]string),
+		UnifiedState: types.NewUnifiedState(),
+		Debug:        obj.Debug,
+		Logf: func(format string, v ...interface{}) {
+			obj.Logf("unification: "+format, v...)
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, UnificationTimeout*time.Second)
+	defer cancel()
+	if err := unifier.Unify(ctx); err != nil {
+		analysis.addError(errwrap.Wrapf(err, "could not unify types"))
+		return analysis
+	}
+
+	return analysis
+}
+
+// addError stores an error as a diagnostic. If we can tell where the error
+// happened, the diagnostic points there, otherwise it goes on the first line.
+func (obj *Analysis) addError(err error) {
+	rng := Range{
+		Start: Position{Line: 0, Character: 0},
+		End:   Position{Line: 0, Character: len(obj.line(0))},
+	}
+
+	var lpErr *parser.LexParseErr
+	var nodeErr *interfaces.NodeError
+	if errors.As(err, &lpErr) && (lpErr.Filename == "" || lpErr.Filename == obj.Path) {
+		rng = Range{
+			Start: Position{Line: lpErr.Row, Character: lpErr.Col},
+			End:   Position{Line: lpErr.Row, Character: obj.tokenEnd(lpErr.Row, lpErr.Col)},
+		}
+	} else if errors.As(err, &nodeErr) {
+		if r, ok := obj.nodeRange(nodeErr.Node); ok {
+			rng = r
+		}
+	}
+
+	obj.Diagnostics = append(obj.Diagnostics, &Diagnostic{
+		Range:    rng,
+		Severity: severityError,
+		Source:   "mgmt",
+		Message:  err.Error(),
+	})
+}
+
+// line returns the text of the line, or the empty string if it's out of range.
+func (obj *Analysis) line(i int) string {
+	if i < 0 || i >= len(obj.lines) {
+		return ""
+	}
+	return obj.lines[i]
+}
+
+// tokenEnd returns the column just past the token which starts at this line
+// and column. The parser only stores the start of the last token of a node, so
+// this is needed to know where the node really ends.
+func (obj *Analysis) tokenEnd(line, col int) int {
+	s := obj.line(line)
+	if col >= len(s) {
+		return col
+	}
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '.' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	i := col
+	switch {
+	case s[i] == '"':
+		for i++; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++ // skip the escaped char
+				continue
+			}
+			if s[i] == '"' {
+				return i + 1
+			}
+		}
+		return len(s)
+
+	case s[i] == '$' || isIdent(s[i]):
+		i++ // the first char is already matched
+		for i < len(s) && isIdent(s[i]) {
+			i++
+		}
+		return i
+	}
+	return col + 1
+}
+
+// nodeRange returns the range of the node in this document. It returns false if
+// the node doesn't know its position, or if it comes from another file.
+func (obj *Analysis) nodeRange(node interfaces.Node) (Range, bool) {
+	pn, ok := node.(interfaces.PositionableNode)
+	if !ok || !pn.IsSet() {
+		return Range{}, false
+	}
+	if p, ok := node.(interface{ Path() string }); ok && p.Path() != obj.Path {
+		return Range{}, false
+	}
+	startLine, startCol := pn.Pos()
+	endLine, endCol := pn.End()
+	return Range{
+		Start: Position{Line: startLine, Character: startCol},
+		End:   Position{Line: endLine, Character: obj.tokenEnd(endLine, endCol)},
+	}, true
+}
+
+// located is a node along with its range in the document.
+type located struct {
+	node interfaces.Node
+	rng  Range
+}
+
+// contains returns true if the position is inside of the range. The end is
+// included so that a cursor right after a token still matches it.
+func contains(rng Range, pos Position) bool {
+	if pos.Line < rng.Start.Line || pos.Line > rng.End.Line {
+		return false
+	}
+	if pos.Line == rng.Start.Line && pos.Character < rng.Start.Character {
+		return false
+	}
+	if pos.Line == rng.End.Line && pos.Character > rng.End.Character {
+		return false
+	}
+	return true
+}
+
+// size returns a number which can be used to compare how big ranges are.
+func size(rng Range) int {
+	return (rng.End.Line-rng.Start.Line)*1000000 + rng.End.Character - rng.Start.Character
+}
+
+// nodesAt returns every node which contains the position, with the innermost
+// ones first.
+func (obj *Analysis) nodesAt(pos Position) []*located {
+	result := []*located{}
+	if obj.ast == nil {
+		return result
+	}
+	obj.ast.Apply(func(node interfaces.Node) error {
+		if rng, ok := obj.nodeRange(node); ok && contains(rng, pos) {
+			result = append(result, &located{node: node, rng: rng})
+		}
+		return nil
+	})
+	sort.SliceStable(result, func(i, j int) bool {
+		return size(result[i].rng) < size(result[j].rng)
+	})
+	return result
+}
+
+// Hover returns the inferred type of whatever is at this position. It returns
+// nil if there is nothing to show.
+func (obj *Analysis) Hover(pos Position) *Hover {
+	for _, x := range obj.nodesAt(pos) {
+		s := hoverText(x.node)
+		if s == "" {
+			continue
+		}
+		rng := x.rng
+		return &Hover{
+			Contents: MarkupContent{
+				Kind:  "markdown",
+				Value: "```mcl\n" + s + "\n```",
+			},
+			Range: &rng,
+		}
+	}
+	return nil
+}
+
+// hoverText returns the text to display for a node, or the empty string if we
+// don't know anything useful about it.
+func hoverText(node interfaces.Node) string {
+	switch x := node.(type) {
+	case *ast.StmtBind:
+		if typ, err := x.Value.Type(); err == nil {
+			return fmt.Sprintf("$%s %s", x.Ident, typ)
+		}
+	case *ast.StmtFunc:
+		if typ, err := x.Func.Type(); err == nil {
+			return fmt.Sprintf("func %s: %s", x.Name, typ)
+		}
+	case *ast.StmtClass:
+		return fmt.Sprintf("class %s", x.Name)
+	case *ast.StmtType:
+		return fmt.Sprintf("type %s = %s", x.Name, x.Type)
+	case *ast.StmtImport:
+		return fmt.Sprintf("import %q", x.Name)
+	case *ast.StmtRes:
+		return fmt.Sprintf("resource %s", x.Kind)
+	case *ast.StmtResField:
+		if typ, err := x.Value.Type(); err == nil {
+			return fmt.Sprintf("%s => %s", x.Field, typ)
+		}
+	case *ast.ExprVar:
+		if typ, err := x.Type(); err == nil {
+			return fmt.Sprintf("$%s %s", x.Name, typ)
+		}
+	case *ast.ExprCall:
+		if typ, err := x.Type(); err == nil && x.Name != "" {
+			return fmt.Sprintf("%s(): %s", x.Name, typ)
+		}
+	case interfaces.Expr:
+		if typ, err := x.Type(); err == nil {
+			return typ.String()
+		}
+	}
+	return ""
+}
+
+// definition is something in the document which a name can refer to.
+type definition struct {
+	name  string
+	kind  string
+	rng   Range // where the definition is
+	scope Range // where the definition is visible
+}
+
+// definitions returns all of the definitions in the document.
+func (obj *Analysis) definitions() []*definition {
+	result := []*definition{}
+	if obj.ast == nil {
+		return result
+	}
+
+	// Anything defined at the top-level is visible throughout the file.
+	last := len(obj.lines) - 1
+	everywhere := Range{
+		End: Position{Line: last, Character: len(obj.line(last))},
+	}
+
+	obj.ast.Apply(func(node interfaces.Node) error {
+		prog, ok := node.(*ast.StmtProg)
+		if !ok {
+			return nil
+		}
+		scope, ok := obj.nodeRange(prog)
+		if !ok && prog != obj.ast {
+			return nil // some other file or unknown position
+		}
+		if prog == obj.ast {
+			scope = everywhere
+		}
+
+		for _, stmt := range prog.Body {
+			rng, ok := obj.nodeRange(stmt)
+			if !ok {
+				continue
+			}
+			d := &definition{
+				rng:   rng,
+				scope: scope,
+			}
+			switch x := stmt.(type) {
+			case *ast.StmtBind:
+				d.name, d.kind = x.Ident, definitionKindVar
+			case *ast.StmtFunc:
+				d.name, d.kind = x.Name, definitionKindFunc
+			case *ast.StmtClass:
+				d.name, d.kind = x.Name, definitionKindClass
+			case *ast.StmtImport:
+				d.name, d.kind = x.Alias, definitionKindImport
+				if x.Alias == "" {
+					result, err := langUtil.ParseImportName(x.Name)
+					if err != nil {
+						continue
+					}
+					d.name = result.Alias
+				}
+			default:
+				continue
+			}
+			result = append(result, d)
+		}
+		return nil
+	})
+
+	return result
+}
+
+// Definition returns the location where the name at this position is defined.
+// Names which come from an import point to the import statement. It returns
+// nil if no definition was found.
+func (obj *Analysis) Definition(pos Position) *Location {
+	for _, x := range obj.nodesAt(pos) {
+		var name, kind string
+		switch n := x.node.(type) {
+		case *ast.ExprVar:
+			name, kind = n.Name, definitionKindVar
+		case *ast.ExprCall:
+			name, kind = n.Name, definitionKindFunc
+			if n.Var {
+				kind = definitionKindVar
+			}
+		case *ast.StmtInclude:
+			name, kind = n.Name, definitionKindClass
+		default:
+			continue
+		}
+		if name == "" {
+			continue
+		}
+
+		// If this came from an import, then point to the import.
+		if i := strings.Index(name, interfaces.ModuleSep); i > -1 {
+			name, kind = name[:i], definitionKindImport
+		}
+
+		var found *definition
+		for _, d := range obj.definitions() {
+			if d.name != name || d.kind != kind || !contains(d.scope, pos) {
+				continue
+			}
+			if found == nil || size(d.scope) < size(found.scope) {
+				found = d // innermost scope wins
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		return &Location{
+			URI:   obj.URI,
+			Range: found.rng,
+		}
+	}
+	return nil
+}
+
+// Completion returns the list of resource kinds when a new statement is being
+// typed, or the list of fields when inside of a resource. This only looks at
+// the text and not the AST, because the document usually doesn't parse while
+// someone is in the middle of typing.
+func (obj *Analysis) Completion(pos Position) []*CompletionItem {
+	result := []*CompletionItem{}
+
+	prefix := obj.line(pos.Line)
+	if pos.Character < len(prefix) {
+		prefix = prefix[:pos.Character]
+	}
+
+	if kind := obj.enclosingKind(pos); kind != "" {
+		fields, err := engineUtil.LangFieldNameToStructType(kind)
+		if err != nil {
+			return result
+		}
+		names := []string{}
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			result = append(result, &CompletionItem{
+				Label:      name,
+				Kind:       completionKindField,
+				Detail:     fields[name].String(),
+				InsertText: name + " => ",
+			})
+		}
+		return result
+	}
+
+	if prefix != "" && !stmtStartRegexp.MatchString(prefix) {
+		return result
+	}
+	kinds := engine.RegisteredResourcesNames()
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if strings.HasPrefix(kind, "_") { // built-in resource
+			continue
+		}
+		result = append(result, &CompletionItem{
+			Label:  kind,
+			Kind:   completionKindClass,
+			Detail: "resource",
+		})
+	}
+	return result
+}
+
+// enclosingKind returns the resource kind whose body contains this position,
+// or the empty string if we're not inside of a known resource.
+func (obj *Analysis) enclosingKind(pos Position) string {
+	headers := []string{} // the text leading up to each open brace
+	inString := false     // strings can span multiple lines
+	for i := 0; i <= pos.Line; i++ {
+		s := obj.line(i)
+		if i == pos.Line && pos.Character < len(s) {
+			s = s[:pos.Character]
+		}
+		for j := 0; j < len(s); j++ {
+			if inString {
+				if s[j] == '\\' {
+					j++ // skip the escaped char
+				} else if s[j] == '"' {
+					inString = false
+				}
+				continue
+			}
+			switch s[j] {
+			case '"':
+				inString = true
+			case '#':
+				j = len(s) // skip the comment
+			case '{':
+				headers = append(headers, s[:j+1])
+			case '}':
+				if len(headers) > 0 {
+					headers = headers[:len(headers)-1]
+				}
+			}
+		}
+	}
+	if len(headers) == 0 {
+		return ""
+	}
+
+	matches := resHeaderRegexp.FindStringSubmatch(headers[len(headers)-1])
+	if len(matches) < 2 {
+		return ""
+	}
+	if !util.StrInList(matches[1], engine.RegisteredResourcesNames()) {
+		return ""
+	}
+	return matches[1]
+}
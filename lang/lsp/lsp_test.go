@@ -0,0 +1,269 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	_ "github.com/purpleidea/mgmt/engine/resources" // let register's run
+)
+
+const testURI = "file:///tmp/lsp/main.mcl"
+
+func testAnalyze(t *testing.T, code string) *Analysis {
+	server := &Server{
+		In:  &bytes.Buffer{},
+		Out: &bytes.Buffer{},
+		Logf: func(format string, v ...interface{}) {
+			t.Logf("lsp: "+format, v...)
+		},
+	}
+	if err := server.Init(); err != nil {
+		t.Fatalf("init failed: %+v", err)
+	}
+	return server.analyze(context.Background(), testURI, uriToPath(testURI), code)
+}
+
+func TestDiagnostics0(t *testing.T) {
+	code := strings.Join([]string{
+		`$x = 42`,
+		`$y = 13 ^ 42`,
+	}, "\n")
+	analysis := testAnalyze(t, code)
+	if l := len(analysis.Diagnostics); l != 1 {
+		t.Fatalf("expected one diagnostic, got: %d", l)
+	}
+	d := analysis.Diagnostics[0]
+	t.Logf("diagnostic: %+v", d)
+	if d.Range.Start.Line != 1 || d.Range.Start.Character != 8 {
+		t.Errorf("unexpected start: %+v", d.Range.Start)
+	}
+	if d.Severity != severityError {
+		t.Errorf("unexpected severity: %d", d.Severity)
+	}
+}
+
+func TestDiagnostics1(t *testing.T) {
+	code := strings.Join([]string{
+		`$x = 42`,
+		`test "t1" {`,
+		`	stringptr => $x,`,
+		`}`,
+	}, "\n")
+	analysis := testAnalyze(t, code)
+	if l := len(analysis.Diagnostics); l != 1 {
+		t.Fatalf("expected one diagnostic, got: %d", l)
+	}
+	d := analysis.Diagnostics[0]
+	t.Logf("diagnostic: %+v", d)
+	if d.Range.Start.Line != 2 || d.Range.Start.Character != 1 {
+		t.Errorf("unexpected start: %+v", d.Range.Start)
+	}
+	if !strings.Contains(d.Message, "str != int") {
+		t.Errorf("unexpected message: %s", d.Message)
+	}
+}
+
+func TestDiagnostics2(t *testing.T) {
+	code := strings.Join([]string{
+		`$x = 42`,
+		`$y = $x + 1`,
+	}, "\n")
+	analysis := testAnalyze(t, code)
+	if l := len(analysis.Diagnostics); l != 0 {
+		t.Errorf("expected no diagnostics, got: %+v", analysis.Diagnostics[0])
+	}
+}
+
+func TestHover0(t *testing.T) {
+	code := strings.Join([]string{
+		`$x = 42`,
+		`$y = $x + 1`,
+	}, "\n")
+	analysis := testAnalyze(t, code)
+	for _, tc := range []struct {
+		pos    Position
+		expect string
+	}{
+		{Position{Line: 0, Character: 1}, "$x int"},
+		{Position{Line: 1, Character: 6}, "$x int"},
+	} {
+		hover := analysis.Hover(tc.pos)
+		if hover == nil {
+			t.Errorf("no hover at: %+v", tc.pos)
+			continue
+		}
+		if !strings.Contains(hover.Contents.Value, tc.expect) {
+			t.Errorf("hover at %+v was: %s", tc.pos, hover.Contents.Value)
+		}
+	}
+}
+
+func TestDefinition0(t *testing.T) {
+	code := strings.Join([]string{
+		`import "fmt"`,
+		`$x = 42`,
+		`func double($a) {`,
+		`	$a * 2`,
+		`}`,
+		`$y = double($x)`,
+		`$s = fmt.printf("%d", $y)`,
+	}, "\n")
+	analysis := testAnalyze(t, code)
+	if l := len(analysis.Diagnostics); l != 0 {
+		t.Fatalf("unexpected diagnostic: %+v", analysis.Diagnostics[0])
+	}
+	for _, tc := range []struct {
+		pos  Position
+		line int
+	}{
+		{Position{Line: 5, Character: 13}, 1}, // $x
+		{Position{Line: 5, Character: 6}, 2},  // double
+		{Position{Line: 6, Character: 23}, 5}, // $y
+		{Position{Line: 6, Character: 6}, 0},  // fmt.printf
+	} {
+		location := analysis.Definition(tc.pos)
+		if location == nil {
+			t.Errorf("no definition at: %+v", tc.pos)
+			continue
+		}
+		if location.URI != testURI {
+			t.Errorf("unexpected uri: %s", location.URI)
+		}
+		if l := location.Range.Start.Line; l != tc.line {
+			t.Errorf("definition at %+v was on line: %d", tc.pos, l)
+		}
+	}
+}
+
+func TestCompletion0(t *testing.T) {
+	code := strings.Join([]string{
+		`file "/tmp/foo" {`,
+		`	content => "hello",`,
+		`	`,
+		`}`,
+		``,
+	}, "\n")
+	analysis := testAnalyze(t, code)
+
+	labels := func(items []*CompletionItem) map[string]struct{} {
+		m := make(map[string]struct{})
+		for _, x := range items {
+			m[x.Label] = struct{}{}
+		}
+		return m
+	}
+
+	fields := labels(analysis.Completion(Position{Line: 2, Character: 1}))
+	for _, x := range []string{"content", "mode", "owner", "state"} {
+		if _, exists := fields[x]; !exists {
+			t.Errorf("missing field: %s", x)
+		}
+	}
+	if _, exists := fields["file"]; exists {
+		t.Errorf("got a kind inside of a resource")
+	}
+
+	kinds := labels(analysis.Completion(Position{Line: 4, Character: 0}))
+	for _, x := range []string{"file", "exec", "test"} {
+		if _, exists := kinds[x]; !exists {
+			t.Errorf("missing kind: %s", x)
+		}
+	}
+}
+
+func TestServer0(t *testing.T) {
+	in := &bytes.Buffer{}
+	send := func(msg string) {
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	send(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + testURI + `","text":"$x = 42\n"}}}`)
+	send(`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"` + testURI + `"},"position":{"line":0,"character":1}}}`)
+	send(`{"jsonrpc":"2.0","id":3,"method":"unknown/method"}`)
+	send(`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`)
+	send(`{"jsonrpc":"2.0","method":"exit"}`)
+
+	out := &bytes.Buffer{}
+	server := &Server{
+		In:  in,
+		Out: out,
+		Logf: func(format string, v ...interface{}) {
+			t.Logf("lsp: "+format, v...)
+		},
+	}
+	if err := server.Init(); err != nil {
+		t.Fatalf("init failed: %+v", err)
+	}
+	if err := server.Run(context.Background()); err != nil {
+		t.Fatalf("run failed: %+v", err)
+	}
+
+	reader := bufio.NewReader(out)
+	responses := []*message{}
+	for {
+		msg, err := readMessage(reader)
+		if err != nil {
+			break
+		}
+		responses = append(responses, msg)
+	}
+	if l := len(responses); l != 5 { // 4 replies and 1 notification
+		t.Fatalf("expected 5 messages, got: %d", l)
+	}
+
+	if s := string(*responses[0].ID); s != "1" {
+		t.Errorf("unexpected id: %s", s)
+	}
+	if m := responses[1].Method; m != "textDocument/publishDiagnostics" {
+		t.Errorf("unexpected method: %s", m)
+	}
+	b, err := json.Marshal(responses[2].Result)
+	if err != nil {
+		t.Fatalf("could not encode: %+v", err)
+	}
+	if !strings.Contains(string(b), "$x int") {
+		t.Errorf("unexpected hover: %s", string(b))
+	}
+	if e := responses[3].Error; e == nil || e.Code != errCodeMethodNotFound {
+		t.Errorf("expected a method not found error, got: %+v", e)
+	}
+	if responses[4].Error != nil {
+		t.Errorf("unexpected shutdown error: %+v", responses[4].Error)
+	}
+}
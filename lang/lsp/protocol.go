@@ -0,0 +1,177 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package lsp
+
+import (
+	"encoding/json"
+)
+
+// This file contains the small subset of the language server protocol types
+// that we implement. The field names and json tags follow the specification so
+// that these can be marshalled directly onto the wire.
+
+const (
+	// jsonrpcVersion is the only version of JSON-RPC that is supported.
+	jsonrpcVersion = "2.0"
+
+	// errCodeParseError is the JSON-RPC code for invalid JSON.
+	errCodeParseError = -32700
+
+	// errCodeMethodNotFound is the JSON-RPC code for an unknown method.
+	errCodeMethodNotFound = -32601
+
+	// errCodeInvalidParams is the JSON-RPC code for invalid parameters.
+	errCodeInvalidParams = -32602
+
+	// errCodeInternalError is the JSON-RPC code for an internal error.
+	errCodeInternalError = -32603
+
+	// textDocumentSyncFull means the client sends the full document text on
+	// every change.
+	textDocumentSyncFull = 1
+
+	// severityError is the diagnostic severity used for errors.
+	severityError = 1
+
+	// completionKindField is the completion item kind for a field.
+	completionKindField = 5
+
+	// completionKindClass is the completion item kind for a class, which we
+	// use for resource kinds.
+	completionKindClass = 7
+)
+
+// message is a JSON-RPC request, response or notification. Requests have both
+// an ID and a Method, notifications only have a Method, and responses only have
+// an ID.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+// responseError is the error object of a JSON-RPC response.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Position is a zero-based line and character offset in a document.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// Range is a span in a document. The end position is exclusive.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location is a range inside of a particular document.
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// Diagnostic is a problem found in a document.
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// MarkupContent is some formatted text that the client displays.
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// Hover is the result of a hover request.
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+// CompletionItem is a single completion suggestion.
+type CompletionItem struct {
+	Label      string `json:"label"`
+	Kind       int    `json:"kind"`
+	Detail     string `json:"detail,omitempty"`
+	InsertText string `json:"insertText,omitempty"`
+}
+
+// textDocumentItem is the full document sent by didOpen.
+type textDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+// textDocumentIdentifier identifies a document.
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// didOpenParams are the params of textDocument/didOpen.
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+// didChangeParams are the params of textDocument/didChange. Since we ask for
+// full document sync, only the text of the last change is used.
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// didCloseParams are the params of textDocument/didClose.
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+// textDocumentPositionParams are the params of the hover, definition and
+// completion requests.
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+// publishDiagnosticsParams are the params of the diagnostics notification.
+type publishDiagnosticsParams struct {
+	URI         string        `json:"uri"`
+	Diagnostics []*Diagnostic `json:"diagnostics"`
+}
@@ -0,0 +1,402 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package lsp implements a language server for mcl. It speaks the language
+// server protocol (LSP) so that editors can show diagnostics, the inferred
+// types, jump to definitions, and complete resource kinds and their fields.
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "github.com/purpleidea/mgmt/lang/core"                // import so the funcs register
+	_ "github.com/purpleidea/mgmt/lang/unification/solvers" // import so the solvers register
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// Name is the name of this tool.
+	Name = "lsp"
+
+	// contentLengthHeader is the only header that we need to read.
+	contentLengthHeader = "Content-Length"
+)
+
+// Server is a language server for mcl. It reads requests from In and writes
+// responses and notifications to Out, which are usually stdin and stdout.
+type Server struct {
+	// In is where the client messages are read from.
+	In io.Reader
+
+	// Out is where the server messages are written to.
+	Out io.Writer
+
+	// ModulePath is the absolute path to the modules directory. It may be
+	// empty if modules are not used.
+	ModulePath string
+
+	// Debug represents if we're running in debug mode or not.
+	Debug bool
+
+	// Logf is a logger which should be used. It must not write to Out!
+	Logf func(format string, v ...interface{})
+
+	documents map[string]*Analysis // keyed by uri
+	mutex     *sync.Mutex          // for writing to Out
+	shutdown  bool                 // did we get the shutdown request?
+}
+
+// Init validates and initializes the server.
+func (obj *Server) Init() error {
+	if obj.In == nil || obj.Out == nil {
+		return fmt.Errorf("the In and Out streams must be specified")
+	}
+	if obj.ModulePath != "" && !strings.HasSuffix(obj.ModulePath, "/") {
+		return fmt.Errorf("module path does not end with a slash")
+	}
+	if obj.Logf == nil {
+		return fmt.Errorf("the Logf function is missing")
+	}
+
+	obj.documents = make(map[string]*Analysis)
+	obj.mutex = &sync.Mutex{}
+	return nil
+}
+
+// Run reads and handles messages until the client asks us to exit, the input
+// stream closes, or the context is cancelled.
+func (obj *Server) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		msg *message
+		err error
+	}
+	ch := make(chan *result)
+	// We don't wait for this reader, because it may be blocked reading from
+	// stdin forever. It exits on its own once we stop receiving from it.
+	go func() {
+		defer close(ch)
+		reader := bufio.NewReader(obj.In)
+		for {
+			msg, err := readMessage(reader)
+			select {
+			case ch <- &result{msg: msg, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if _, ok := err.(*json.SyntaxError); err != nil && !ok {
+				return // can't recover from a broken stream
+			}
+		}
+	}()
+
+	for {
+		var r *result
+		var ok bool
+		select {
+		case r, ok = <-ch:
+			if !ok {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if r.err == io.EOF {
+			return nil // client went away
+		}
+		if _, ok := r.err.(*json.SyntaxError); ok {
+			obj.Logf("invalid message: %+v", r.err)
+			obj.reply(nil, nil, &responseError{
+				Code:    errCodeParseError,
+				Message: r.err.Error(),
+			})
+			continue
+		}
+		if r.err != nil {
+			return errwrap.Wrapf(r.err, "could not read message")
+		}
+
+		if exit := obj.handle(ctx, r.msg); exit {
+			return nil
+		}
+	}
+}
+
+// handle runs a single message. It returns true if we should exit.
+func (obj *Server) handle(ctx context.Context, msg *message) bool {
+	if obj.Debug {
+		obj.Logf("method: %s", msg.Method)
+	}
+
+	var result interface{}
+	var err error
+	switch msg.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   textDocumentSyncFull,
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"completionProvider": map[string]interface{}{},
+			},
+			"serverInfo": map[string]interface{}{
+				"name": "mgmt",
+			},
+		}
+
+	case "initialized":
+		return false // notification
+
+	case "shutdown":
+		obj.shutdown = true
+
+	case "exit":
+		if !obj.shutdown {
+			obj.Logf("exit without shutdown")
+		}
+		return true
+
+	case "textDocument/didOpen":
+		params := &didOpenParams{}
+		if err := json.Unmarshal(msg.Params, params); err != nil {
+			obj.Logf("invalid params: %+v", err)
+			return false
+		}
+		obj.update(ctx, params.TextDocument.URI, params.TextDocument.Text)
+		return false // notification
+
+	case "textDocument/didChange":
+		params := &didChangeParams{}
+		if err := json.Unmarshal(msg.Params, params); err != nil {
+			obj.Logf("invalid params: %+v", err)
+			return false
+		}
+		if l := len(params.ContentChanges); l > 0 { // full sync
+			obj.update(ctx, params.TextDocument.URI, params.ContentChanges[l-1].Text)
+		}
+		return false // notification
+
+	case "textDocument/didClose":
+		params := &didCloseParams{}
+		if err := json.Unmarshal(msg.Params, params); err != nil {
+			obj.Logf("invalid params: %+v", err)
+			return false
+		}
+		delete(obj.documents, params.TextDocument.URI)
+		obj.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []*Diagnostic{}, // clear them
+		})
+		return false // notification
+
+	case "textDocument/hover":
+		var analysis *Analysis
+		var params *textDocumentPositionParams
+		if analysis, params, err = obj.position(msg); err == nil {
+			if hover := analysis.Hover(params.Position); hover != nil {
+				result = hover
+			}
+		}
+
+	case "textDocument/definition":
+		var analysis *Analysis
+		var params *textDocumentPositionParams
+		if analysis, params, err = obj.position(msg); err == nil {
+			if location := analysis.Definition(params.Position); location != nil {
+				result = location
+			}
+		}
+
+	case "textDocument/completion":
+		var analysis *Analysis
+		var params *textDocumentPositionParams
+		if analysis, params, err = obj.position(msg); err == nil {
+			result = analysis.Completion(params.Position)
+		}
+
+	default:
+		if msg.ID == nil {
+			return false // ignore unknown notifications
+		}
+		obj.reply(msg.ID, nil, &responseError{
+			Code:    errCodeMethodNotFound,
+			Message: fmt.Sprintf("method not found: %s", msg.Method),
+		})
+		return false
+	}
+
+	if err != nil {
+		obj.reply(msg.ID, nil, &responseError{
+			Code:    errCodeInvalidParams,
+			Message: err.Error(),
+		})
+		return false
+	}
+	obj.reply(msg.ID, result, nil)
+	return false
+}
+
+// update checks the new version of the document and publishes the diagnostics.
+func (obj *Server) update(ctx context.Context, uri, text string) {
+	analysis := obj.analyze(ctx, uri, uriToPath(uri), text)
+	obj.documents[uri] = analysis
+	obj.notify("textDocument/publishDiagnostics", &publishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: analysis.Diagnostics,
+	})
+}
+
+// position decodes the params of a request which points to a position in a
+// document, and returns the analysis of that document.
+func (obj *Server) position(msg *message) (*Analysis, *textDocumentPositionParams, error) {
+	params := &textDocumentPositionParams{}
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return nil, nil, err
+	}
+	analysis, exists := obj.documents[params.TextDocument.URI]
+	if !exists {
+		return nil, nil, fmt.Errorf("document is not open: %s", params.TextDocument.URI)
+	}
+	return analysis, params, nil
+}
+
+// reply sends a response to a request. Notifications don't get replies.
+func (obj *Server) reply(id *json.RawMessage, result interface{}, e *responseError) {
+	if id == nil && e == nil {
+		return // notification
+	}
+	var msg interface{}
+	if e != nil {
+		msg = &struct {
+			JSONRPC string           `json:"jsonrpc"`
+			ID      *json.RawMessage `json:"id"` // null if unknown
+			Error   *responseError   `json:"error"`
+		}{jsonrpcVersion, id, e}
+	} else {
+		msg = &struct {
+			JSONRPC string           `json:"jsonrpc"`
+			ID      *json.RawMessage `json:"id"`
+			Result  interface{}      `json:"result"` // must be present
+		}{jsonrpcVersion, id, result}
+	}
+	if err := obj.write(msg); err != nil {
+		obj.Logf("could not reply: %+v", err)
+	}
+}
+
+// notify sends a notification to the client.
+func (obj *Server) notify(method string, params interface{}) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		obj.Logf("could not encode notification: %+v", err)
+		return
+	}
+	msg := &message{
+		JSONRPC: jsonrpcVersion,
+		Method:  method,
+		Params:  b,
+	}
+	if err := obj.write(msg); err != nil {
+		obj.Logf("could not notify: %+v", err)
+	}
+}
+
+// write sends a message with the required header.
+func (obj *Server) write(msg interface{}) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	obj.mutex.Lock()
+	defer obj.mutex.Unlock()
+	if _, err := fmt.Fprintf(obj.Out, "%s: %d\r\n\r\n", contentLengthHeader, len(b)); err != nil {
+		return err
+	}
+	_, err = obj.Out.Write(b)
+	return err
+}
+
+// readMessage reads a single message with its headers from the stream.
+func readMessage(reader *bufio.Reader) (*message, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" { // end of headers
+			break
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), contentLengthHeader) {
+			continue // ignore other headers like Content-Type
+		}
+		if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+			return nil, errwrap.Wrapf(err, "invalid %s", contentLengthHeader)
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing %s header", contentLengthHeader)
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(reader, b); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// uriToPath converts a file:// uri into a path. Anything else is returned as
+// is, so that it can at least be used as a unique name.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
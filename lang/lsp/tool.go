@@ -0,0 +1,81 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package lsp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+)
+
+func init() {
+	cliUtil.RegisterTool(Name, func() cliUtil.Tool { return &Tool{} })
+}
+
+// Tool runs the language server over stdin and stdout for the `lsp` command.
+type Tool struct{}
+
+// Main runs the language server until the client tells it to exit.
+func (obj *Tool) Main(ctx context.Context, info *cliUtil.ToolInfo) error {
+	args, ok := info.Args.(*cliUtil.LspArgs)
+	if !ok {
+		// programming error
+		return fmt.Errorf("could not convert to our struct")
+	}
+
+	// empty by default
+	modules := args.ModulePath
+	if modules != "" && !strings.HasSuffix(modules, "/") {
+		return fmt.Errorf("module path does not end with a slash")
+	}
+	if modules != "" && !strings.HasPrefix(modules, "/") {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		modules = filepath.Join(wd, modules) + "/"
+	}
+
+	server := &Server{
+		In:         os.Stdin,
+		Out:        os.Stdout,
+		ModulePath: modules,
+		Debug:      info.Debug,
+		Logf:       info.Logf,
+	}
+	if err := server.Init(); err != nil {
+		return err
+	}
+	return server.Run(ctx)
+}
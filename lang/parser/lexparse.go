@@ -39,6 +39,7 @@ import (
 	"strings"
 
 	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/lang/ast"
 	"github.com/purpleidea/mgmt/lang/interfaces"
 	"github.com/purpleidea/mgmt/util"
 	"github.com/purpleidea/mgmt/util/errwrap"
@@ -86,10 +87,51 @@ type lexParseAST struct {
 
 	lexerErr error // from lexer
 	parseErr error // from Error(e string)
+
+	comments []*lexComment // from lexer, in the order they were seen
+}
+
+// lexComment is a comment as seen by the lexer. Comments aren't part of the
+// grammar, so the lexer stores them here instead of returning a token.
+type lexComment struct {
+	value string // without the leading #
+	row   int
+	col   int
 }
 
 // LexParse runs the lexer/parser machinery and returns the AST.
 func LexParse(input io.Reader) (interfaces.Stmt, error) {
+	lp, err := lexParse(input)
+	if err != nil {
+		return nil, err
+	}
+	return lp.ast, nil
+}
+
+// LexParseWithComments runs the lexer/parser machinery and returns the AST and
+// all of the comments. Comments can appear anywhere in the code, so they are not
+// stored in the AST, but each one has its position set so that tools such as a
+// code formatter can put them back where they were. They are returned in the
+// order in which they appear in the input.
+func LexParseWithComments(input io.Reader) (interfaces.Stmt, []*ast.StmtComment, error) {
+	lp, err := lexParse(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	comments := []*ast.StmtComment{}
+	for _, x := range lp.comments {
+		comment := &ast.StmtComment{
+			Value: x.value,
+		}
+		// the end is the start of the last token, so it's the same one
+		comment.Locate(x.row, x.col, x.row, x.col)
+		comments = append(comments, comment)
+	}
+	return lp.ast, comments, nil
+}
+
+// lexParse runs the lexer/parser machinery and returns the filled in struct.
+func lexParse(input io.Reader) (*lexParseAST, error) {
 	lp := &lexParseAST{}
 	// parseResult is a seemingly unused field in the Lexer struct for us...
 	lexer := NewLexerWithInit(input, func(y *Lexer) { y.parseResult = lp })
@@ -104,7 +146,7 @@ func LexParse(input io.Reader) (interfaces.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return lp.ast, nil
+	return lp, nil
 }
 
 // LexParseWithOffsets takes an io.Reader input and a list of corresponding
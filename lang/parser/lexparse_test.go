@@ -2171,6 +2171,172 @@ func TestLexParse0(t *testing.T) {
 			exp:  exp,
 		})
 	}
+	{
+		testCases = append(testCases, test{
+			name: "type alias",
+			code: `
+			type Config = struct{name str; port Port; tags []mod.Tag}
+			$x Config = $y
+			`,
+			fail: false,
+			exp: &ast.StmtProg{
+				Body: []interfaces.Stmt{
+					&ast.StmtType{
+						Name: "Config",
+						Type: &types.Type{
+							Kind: types.KindStruct,
+							Map: map[string]*types.Type{
+								"name": types.TypeStr,
+								"port": {Name: "Port"},
+								"tags": {
+									Kind: types.KindList,
+									Val:  &types.Type{Name: "mod.Tag"},
+								},
+							},
+							Ord: []string{"name", "port", "tags"},
+						},
+					},
+					&ast.StmtBind{
+						Ident: "x",
+						Value: &ast.ExprVar{
+							Name: "y",
+						},
+						Type: &types.Type{Name: "Config"},
+					},
+				},
+			},
+		})
+	}
+	{
+		testCases = append(testCases, test{
+			name: "type alias without type keyword",
+			code: `
+			alias Config = struct{name str}
+			`,
+			fail: true,
+		})
+	}
+	{
+		testCases = append(testCases, test{
+			name: "type alias lower case",
+			code: `
+			type config = struct{name str}
+			`,
+			fail: true,
+		})
+	}
+	{
+		testCases = append(testCases, test{
+			name: "match expression",
+			code: `
+			$x = match $y {
+				"a" => 1,
+				"b" => 2,
+				default => 0,
+			}
+			`,
+			fail: false,
+			exp: &ast.StmtProg{
+				Body: []interfaces.Stmt{
+					&ast.StmtBind{
+						Ident: "x",
+						Value: &ast.ExprMatch{
+							Subject: &ast.ExprVar{
+								Name: "y",
+							},
+							Arms: []*ast.ExprMatchArm{
+								{
+									Pattern: &ast.ExprStr{
+										V: "a",
+									},
+									Value: &ast.ExprInt{
+										V: 1,
+									},
+								},
+								{
+									Pattern: &ast.ExprStr{
+										V: "b",
+									},
+									Value: &ast.ExprInt{
+										V: 2,
+									},
+								},
+							},
+							Default: &ast.ExprInt{
+								V: 0,
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	{
+		testCases = append(testCases, test{
+			name: "match without default",
+			code: `
+			$x = match $y {
+				"a" => 1,
+			}
+			`,
+			fail: true,
+		})
+	}
+	{
+		testCases = append(testCases, test{
+			name: "match with two defaults",
+			code: `
+			$x = match $y {
+				default => 1,
+				default => 0,
+			}
+			`,
+			fail: true,
+		})
+	}
+	{
+		testCases = append(testCases, test{
+			name: "match with bad default",
+			code: `
+			$x = match $y {
+				"a" => 1,
+				otherwise => 0,
+			}
+			`,
+			fail: true,
+		})
+	}
+	{
+		testCases = append(testCases, test{
+			name: "match function",
+			code: `
+			import "regexp"
+			$x = regexp.match("^a$", $y)
+			`,
+			fail: false,
+			exp: &ast.StmtProg{
+				Body: []interfaces.Stmt{
+					&ast.StmtImport{
+						Name: "regexp",
+					},
+					&ast.StmtBind{
+						Ident: "x",
+						Value: &ast.ExprCall{
+							Name: "regexp.match",
+							Args: []interfaces.Expr{
+								&ast.ExprStr{
+									V: "^a$",
+								},
+								&ast.ExprVar{
+									Name: "y",
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
 
 	if testing.Short() {
 		t.Logf("available tests:")
@@ -2343,6 +2509,47 @@ func TestLexParse2(t *testing.T) {
 	}
 }
 
+func TestLexParseWithComments0(t *testing.T) {
+	code := strings.Join([]string{
+		`# header`,
+		`$a = "#not a comment" # trailing`,
+		`test "t1" {`,
+		`	#inside`,
+		`}`,
+	}, "\n")
+	str := strings.NewReader(code)
+	stmt, comments, err := LexParseWithComments(str)
+	if err != nil {
+		t.Errorf("lex/parse failed with: %+v", err)
+		return
+	}
+	if prog, ok := stmt.(*ast.StmtProg); !ok || len(prog.Body) != 2 {
+		t.Errorf("unexpected ast: %+v", stmt)
+	}
+
+	type comment struct {
+		value string
+		row   int
+		col   int
+	}
+	expected := []comment{
+		{" header", 0, 0},
+		{" trailing", 1, 22},
+		{"inside", 3, 1},
+	}
+	if l1, l2 := len(comments), len(expected); l1 != l2 {
+		t.Errorf("expected %d comments, got: %d", l2, l1)
+		return
+	}
+	for i, x := range comments {
+		row, col := x.Pos()
+		got := comment{x.Value, row, col}
+		if got != expected[i] {
+			t.Errorf("comment #%d: expected %+v, got: %+v", i, expected[i], got)
+		}
+	}
+}
+
 func TestLexParseWithOffsets1(t *testing.T) {
 	code1 := `
 	# "file1"
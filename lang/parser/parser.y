@@ -77,6 +77,9 @@ func init() {
 	structFields []*ast.ExprStructField
 	structField  *ast.ExprStructField
 
+	matchArms []*ast.ExprMatchArm
+	matchArm  *ast.ExprMatchArm
+
 	args []*interfaces.Arg
 	arg  *interfaces.Arg
 
@@ -105,6 +108,7 @@ func init() {
 %token IMPORT_IDENTIFIER AS_IDENTIFIER
 %token COMMENT ERROR
 %token COLLECT_IDENTIFIER
+%token MATCH_IDENTIFIER
 %token PANIC_IDENTIFIER
 
 // precedence table
@@ -184,6 +188,19 @@ stmt:
 		$$.stmt = $1.stmt
 		locate(yylex, $1, yyDollar[len(yyDollar)-1], $$.stmt)
 	}
+	// `type Name = <type>`
+|	IDENTIFIER CAPITALIZED_IDENTIFIER EQUALS type
+	{
+		// This isn't a keyword, so that `type` can be a resource field.
+		if $1.str != "type" {
+			yylex.Error(fmt.Sprintf("%s: unexpected `%s`, expected `type`", ErrParseError, $1.str))
+		}
+		$$.stmt = &ast.StmtType{
+			Name: typeName($2.str),
+			Type: $4.typ,
+		}
+		locate(yylex, $1, yyDollar[len(yyDollar)-1], $$.stmt)
+	}
 |	panic
 	{
 		$$.stmt = $1.stmt
@@ -292,6 +309,9 @@ stmt:
 				Ord:  ord,
 				Out:  $6.typ,
 			}
+		}
+		// Type aliases are resolved (and the type is set) in SetScope.
+		if isFullyTyped && !typ.HasRef() {
 			// XXX: We might still need to do this for now...
 			if err := fn.SetType(typ); err != nil {
 				// this will ultimately cause a parser error to occur...
@@ -474,6 +494,32 @@ expr:
 		}
 		locate(yylex, $1, yyDollar[len(yyDollar)-1], $$.expr)
 	}
+	// `match $x { "a" => 1, "b" => 2, default => 0, }`
+|	MATCH_IDENTIFIER expr OPEN_CURLY match_arms CLOSE_CURLY
+	{
+		arms := []*ast.ExprMatchArm{}
+		var def interfaces.Expr
+		for _, x := range $4.matchArms {
+			if def != nil {
+				yylex.Error(fmt.Sprintf("%s: the default arm must be the last one in a match", ErrParseError))
+				break
+			}
+			if x.Pattern == nil { // default arm
+				def = x.Value
+				continue
+			}
+			arms = append(arms, x)
+		}
+		if def == nil {
+			yylex.Error(fmt.Sprintf("%s: a match must have a default arm", ErrParseError))
+		}
+		$$.expr = &ast.ExprMatch{
+			Subject: $2.expr,
+			Arms:    arms,
+			Default: def,
+		}
+		locate(yylex, $1, yyDollar[len(yyDollar)-1], $$.expr)
+	}
 	// parenthesis wrap an expression for precedence
 |	OPEN_PAREN expr CLOSE_PAREN
 	{
@@ -542,6 +588,40 @@ map_kv:
 		}
 	}
 ;
+match_arms:
+	/* end of list */
+	{
+		posLast(yylex, yyDollar) // our pos
+		$$.matchArms = []*ast.ExprMatchArm{}
+	}
+|	match_arms match_arm
+	{
+		posLast(yylex, yyDollar) // our pos
+		$$.matchArms = append($1.matchArms, $2.matchArm)
+	}
+;
+match_arm:
+	expr ROCKET expr COMMA
+	{
+		posLast(yylex, yyDollar) // our pos
+		$$.matchArm = &ast.ExprMatchArm{
+			Pattern: $1.expr,
+			Value:   $3.expr,
+		}
+	}
+	// the default arm has no pattern, and must be the last one
+|	IDENTIFIER ROCKET expr COMMA
+	{
+		// This isn't a keyword, so that `default` can be a resource field.
+		if $1.str != "default" {
+			yylex.Error(fmt.Sprintf("%s: unexpected `%s`, expected `default`", ErrParseError, $1.str))
+		}
+		posLast(yylex, yyDollar) // our pos
+		$$.matchArm = &ast.ExprMatchArm{
+			Value: $3.expr,
+		}
+	}
+;
 struct:
 	// `struct{answer => 0, truth => false, hello => "world",}`
 	STRUCT_IDENTIFIER OPEN_CURLY struct_fields CLOSE_CURLY
@@ -928,13 +1008,17 @@ func:
 			m[a.Name] = a.Type
 			ord = append(ord, a.Name)
 		}
+		var typ *types.Type
 		if isFullyTyped {
-			typ := &types.Type{
+			typ = &types.Type{
 				Kind: types.KindFunc,
 				Map:  m,
 				Ord:  ord,
 				Out:  $5.typ,
 			}
+		}
+		// Type aliases are resolved (and the type is set) in SetScope.
+		if isFullyTyped && !typ.HasRef() {
 			if err := $$.expr.SetType(typ); err != nil {
 				// this will ultimately cause a parser error to occur...
 				yylex.Error(fmt.Sprintf("%s: %+v", ErrParseSetType, err))
@@ -993,9 +1077,12 @@ bind:
 	{
 		var expr interfaces.Expr = $4.expr
 		// XXX: We still need to do this for now it seems...
-		if err := expr.SetType($2.typ); err != nil {
-			// this will ultimately cause a parser error to occur...
-			yylex.Error(fmt.Sprintf("%s: %+v", ErrParseSetType, err))
+		// Type aliases are resolved in SetScope, so skip those.
+		if !$2.typ.HasRef() {
+			if err := expr.SetType($2.typ); err != nil {
+				// this will ultimately cause a parser error to occur...
+				yylex.Error(fmt.Sprintf("%s: %+v", ErrParseSetType, err))
+			}
 		}
 		$$.stmt = &ast.StmtBind{
 			Ident: $1.str,
@@ -1343,35 +1430,46 @@ type:
 	// list: []int or [][]str (with recursion)
 	{
 		posLast(yylex, yyDollar) // our pos
-		$$.typ = types.NewType("[]" + $3.typ.String())
+		$$.typ = &types.Type{
+			Kind: types.KindList,
+			Val:  $3.typ,
+		}
 	}
 |	MAP_IDENTIFIER OPEN_CURLY type COLON type CLOSE_CURLY
 	// map: map{str: int} or map{str: []int}
 	{
 		posLast(yylex, yyDollar) // our pos
-		$$.typ = types.NewType(fmt.Sprintf("map{%s: %s}", $3.typ.String(), $5.typ.String()))
+		$$.typ = &types.Type{
+			Kind: types.KindMap,
+			Key:  $3.typ,
+			Val:  $5.typ,
+		}
 	}
 |	STRUCT_IDENTIFIER OPEN_CURLY type_struct_fields CLOSE_CURLY
 	// struct: struct{} or struct{a bool} or struct{a bool; bb int}
 	{
 		posLast(yylex, yyDollar) // our pos
 
-		names := make(map[string]struct{})
-		strs := []string{}
+		m := make(map[string]*types.Type)
+		ord := []string{}
 		for _, arg := range $3.args {
-			s := fmt.Sprintf("%s %s", arg.Name, arg.Type.String())
-			if _, exists := names[arg.Name]; exists {
+			if _, exists := m[arg.Name]; exists {
 				// duplicate field name used
+				s := fmt.Sprintf("%s %s", arg.Name, arg.Type.String())
 				err := fmt.Errorf("duplicate struct field of `%s`", s)
 				// this will ultimately cause a parser error to occur...
 				yylex.Error(fmt.Sprintf("%s: %+v", ErrParseSetType, err))
 				break // we must skip, because code continues!
 			}
-			names[arg.Name] = struct{}{}
-			strs = append(strs, s)
+			m[arg.Name] = arg.Type
+			ord = append(ord, arg.Name)
 		}
 
-		$$.typ = types.NewType(fmt.Sprintf("%s{%s}", $1.str, strings.Join(strs, "; ")))
+		$$.typ = &types.Type{
+			Kind: types.KindStruct,
+			Map:  m,
+			Ord:  ord,
+		}
 	}
 |	FUNC_IDENTIFIER OPEN_PAREN type_func_args CLOSE_PAREN type
 	// XXX: should we allow named args in the type signature?
@@ -1417,6 +1515,27 @@ type:
 		posLast(yylex, yyDollar) // our pos
 		$$.typ = types.NewType($1.str) // "variant"
 	}
+|	type_alias
+	// alias: Config or from an import: pkg.Config
+	{
+		posLast(yylex, yyDollar) // our pos
+		// This is resolved to the real type once the scope is known.
+		$$.typ = &types.Type{
+			Name: $1.str,
+		}
+	}
+;
+type_alias:
+	CAPITALIZED_IDENTIFIER
+	{
+		posLast(yylex, yyDollar) // our pos
+		$$.str = typeName($1.str)
+	}
+|	IDENTIFIER DOT type_alias
+	{
+		posLast(yylex, yyDollar) // our pos
+		$$.str = $1.str + $2.str + $3.str
+	}
 ;
 type_struct_fields:
 	/* end of list */
@@ -1533,6 +1652,12 @@ dotted_identifier:
 		posLast(yylex, yyDollar) // our pos
 		$$.str = $1.str + interfaces.ModuleSep + $3.str
 	}
+	// a function could be named regexp.match()!
+|	dotted_identifier DOT MATCH_IDENTIFIER
+	{
+		posLast(yylex, yyDollar) // our pos
+		$$.str = $1.str + interfaces.ModuleSep + $3.str
+	}
 ;
 // there are different ways the lexer/parser might choose to represent this...
 dotted_var_identifier:
@@ -1568,6 +1693,15 @@ func pos(y yyLexer, dollar yySymType) {
 	return
 }
 
+// typeName returns the name of a type alias the way that it was written. The
+// lexer lower cases capitalized identifiers, so we put the capital back here.
+func typeName(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
 // cast is used to pull out the parser run-specific struct we store our AST in.
 // this is usually called in the parser.
 func cast(y yyLexer) *lexParseAST {
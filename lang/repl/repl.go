@@ -0,0 +1,496 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package repl implements an interactive read-eval-print loop for mcl. It keeps
+// the statements which were entered so far, and uses them as the scope for each
+// new statement or expression. Expressions are type checked, and then run with
+// the function engine, so that the values of streaming functions are printed as
+// they change.
+package repl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/purpleidea/mgmt/engine/local"
+	"github.com/purpleidea/mgmt/lang/ast"
+	_ "github.com/purpleidea/mgmt/lang/core" // import so the funcs register
+	"github.com/purpleidea/mgmt/lang/funcs/dage"
+	"github.com/purpleidea/mgmt/lang/funcs/vars"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/interpolate"
+	"github.com/purpleidea/mgmt/lang/parser"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/lang/unification"
+	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// resultVar is the name of the variable that an expression is bound to
+	// so that it can be compiled along with the statements in the session.
+	resultVar = "repl_result"
+)
+
+// entry is a chunk of code which was accepted into the session.
+type entry struct {
+	// keys are the names this defines, eg: `$x`, `func f` or `import fmt`.
+	keys []string
+
+	code string
+}
+
+// Session is the state of a REPL. The zero value is an empty session that is
+// ready to use once the public fields are set.
+type Session struct {
+	// ModulePath is the directory where imported modules are searched for.
+	ModulePath string
+
+	// Hostname is the value of the $hostname variable.
+	Hostname string
+
+	// Local is the local API which some functions need.
+	Local *local.API
+
+	Debug bool
+	Logf  func(format string, v ...interface{})
+
+	entries []*entry
+}
+
+// Code returns the statements in the session.
+func (obj *Session) Code() string {
+	return joinCode(obj.entries)
+}
+
+// Reset removes all of the statements from the session.
+func (obj *Session) Reset() {
+	obj.entries = nil
+}
+
+// IsStmt returns true if the code parses as a list of statements. Otherwise it
+// should be evaluated as an expression.
+func IsStmt(code string) bool {
+	_, err := parser.LexParse(strings.NewReader(code))
+	return err == nil
+}
+
+// Add type checks the statements and adds them to the session. A statement
+// that defines a variable, function, class or import which already exists in
+// the session replaces the earlier one. It returns a description of each new
+// variable with its type.
+func (obj *Session) Add(ctx context.Context, code string) ([]string, error) {
+	xast, err := parser.LexParse(strings.NewReader(code))
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not parse")
+	}
+	prog, ok := xast.(*ast.StmtProg)
+	if !ok {
+		// programming error
+		return nil, fmt.Errorf("unexpected AST: %T", xast)
+	}
+	e := &entry{
+		code: code,
+	}
+	for _, x := range prog.Body {
+		if key := stmtKey(x); key != "" {
+			e.keys = append(e.keys, key)
+		}
+	}
+
+	entries := []*entry{}
+	for _, x := range obj.entries {
+		if !overlaps(x.keys, e.keys) {
+			entries = append(entries, x)
+		}
+	}
+	entries = append(entries, e)
+
+	iast, err := obj.compile(ctx, joinCode(entries), len(prog.Body))
+	if err != nil {
+		return nil, err
+	}
+	obj.entries = entries // accepted
+
+	// The new statements are at the end of the program.
+	body := iast.(*ast.StmtProg).Body
+	out := []string{}
+	for _, x := range body[len(body)-len(prog.Body):] {
+		bind, ok := x.(*ast.StmtBind)
+		if !ok {
+			continue
+		}
+		typ, err := bind.Value.Type()
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "could not determine the type")
+		}
+		out = append(out, fmt.Sprintf("$%s :: %s", bind.Ident, typ))
+	}
+	return out, nil
+}
+
+// Expr is a compiled expression which is ready to be evaluated.
+type Expr struct {
+	// Type is the unified type of the expression.
+	Type *types.Type
+
+	graph *pgraph.Graph   // function graph
+	fn    interfaces.Func // the func whose output is our value
+}
+
+// Static returns true if the expression can only ever have one value. This is
+// the case when every function that it uses is pure.
+func (obj *Expr) Static() bool {
+	for _, v := range obj.graph.Vertices() {
+		f, ok := v.(interfaces.Func)
+		if !ok || !f.Info().Pure {
+			return false
+		}
+	}
+	return true
+}
+
+// Compile type checks an expression in the scope of the session, and builds the
+// function graph which is needed to evaluate it. The session isn't changed.
+func (obj *Session) Compile(ctx context.Context, code string) (*Expr, error) {
+	// Parse it alone first so that syntax errors point at the right spot.
+	if _, err := parser.LexParse(strings.NewReader("$x = " + code)); err != nil {
+		return nil, errwrap.Wrapf(err, "could not parse")
+	}
+
+	name := resultVar
+	for i := 1; obj.defines("$" + name); i++ { // avoid the user's names
+		name = fmt.Sprintf("%s%d", resultVar, i)
+	}
+	entries := append([]*entry{}, obj.entries...) // copy
+	entries = append(entries, &entry{
+		code: fmt.Sprintf("$%s = %s", name, code),
+	})
+
+	iast, err := obj.compile(ctx, joinCode(entries), 1)
+	if err != nil {
+		return nil, err
+	}
+	body := iast.(*ast.StmtProg).Body
+	bind, ok := body[len(body)-1].(*ast.StmtBind)
+	if !ok || bind.Ident != name {
+		return nil, fmt.Errorf("expected a single expression")
+	}
+	typ, err := bind.Value.Type()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not determine the type")
+	}
+
+	// Top-level variables are looked up through the scope, so the empty
+	// env is all that we need here.
+	graph, fn, err := bind.Value.Graph(interfaces.EmptyEnv())
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not generate function graph")
+	}
+	return &Expr{
+		Type:  typ,
+		graph: graph,
+		fn:    fn,
+	}, nil
+}
+
+// Eval runs the function engine for the expression and calls the callback with
+// each new value that it produces. If the expression is static, then it returns
+// after the first value. Otherwise it keeps running until the context closes.
+func (obj *Session) Eval(ctx context.Context, expr *Expr, callback func(types.Value) error) (reterr error) {
+	engine := &dage.Engine{
+		Name:     "repl",
+		Hostname: obj.Hostname,
+		Local:    obj.Local,
+		Debug:    obj.Debug,
+		Logf: func(format string, v ...interface{}) {
+			if obj.Debug {
+				obj.Logf("funcs: "+format, v...)
+			}
+		},
+	}
+	if err := engine.Setup(); err != nil {
+		return errwrap.Wrapf(err, "init error with func engine")
+	}
+	defer engine.Cleanup()
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	runCtx, cancel := context.WithCancel(context.Background()) // we cancel it
+	defer cancel()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := engine.Run(runCtx); err != nil {
+			reterr = errwrap.Append(reterr, err)
+		}
+	}()
+	<-engine.Started() // wait for startup (will not block forever)
+
+	defer wg.Wait()
+	defer cancel() // now cancel Run only after Reverse and Free are done!
+
+	txn := engine.Txn()
+	defer txn.Free() // remember to call Free()
+	txn.AddGraph(expr.graph)
+	if err := txn.Commit(); err != nil {
+		return errwrap.Wrapf(err, "error adding to function graph engine")
+	}
+	defer func() {
+		if err := txn.Reverse(); err != nil { // should remove everything we added
+			reterr = errwrap.Append(reterr, err)
+		}
+	}()
+
+	static := expr.Static()
+	var last types.Value
+	for {
+		select {
+		case err, ok := <-engine.Stream():
+			if !ok {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			value, exists := engine.Table()[expr.fn]
+			if !exists {
+				continue // not ready yet
+			}
+			if last != nil && value.Cmp(last) == nil {
+				continue // didn't change
+			}
+			last = value
+			if err := callback(value); err != nil {
+				return err
+			}
+			if static {
+				return nil
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// compile runs the code through the usual steps up to and including type
+// unification. It returns the interpolated AST. Variables are normally only
+// type checked where they are used, so the last n statements of the program are
+// also type checked if they bind a variable, which would otherwise be skipped.
+func (obj *Session) compile(ctx context.Context, code string, n int) (interfaces.Stmt, error) {
+	logf := func(format string, v ...interface{}) {
+		if obj.Debug {
+			obj.Logf(format, v...)
+		}
+	}
+
+	xast, err := parser.LexParse(bytes.NewReader([]byte(code)))
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not generate AST")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	osFs := afero.NewOsFs()
+	readOnlyOsFs := afero.NewReadOnlyFs(osFs)
+	afs := &afero.Afero{Fs: readOnlyOsFs} // wrap so that we're implementing ioutil
+	localFs := &util.AferoFs{Afero: afs}  // always the local fs
+
+	importGraph, err := pgraph.NewGraph("importGraph")
+	if err != nil {
+		return nil, err
+	}
+	importVertex := &pgraph.SelfVertex{
+		Name:  "",          // first node is the empty string
+		Graph: importGraph, // store a reference to ourself
+	}
+	importGraph.AddVertex(importVertex)
+
+	data := &interfaces.Data{
+		Fs:      localFs,
+		FsURI:   localFs.URI(),
+		Base:    wd + "/", // imports are relative to where we are
+		Files:   []string{},
+		Imports: importVertex,
+		Metadata: &interfaces.Metadata{
+			Main: interfaces.MainFilename,
+		},
+		Modules: obj.ModulePath,
+
+		LexParser:       parser.LexParse,
+		StrInterpolater: interpolate.StrInterpolate,
+		SourceFinder:    os.ReadFile,
+
+		Debug: obj.Debug,
+		Logf: func(format string, v ...interface{}) {
+			logf("ast: "+format, v...)
+		},
+	}
+	if err := xast.Init(data); err != nil {
+		return nil, errwrap.Wrapf(err, "could not init and validate AST")
+	}
+	iast, err := xast.Interpolate()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not interpolate AST")
+	}
+
+	variables := map[string]interfaces.Expr{
+		"purpleidea": &ast.ExprStr{V: "hello world!"}, // james says hi
+		"hostname":   &ast.ExprStr{V: obj.Hostname},
+	}
+	consts := ast.VarPrefixToVariablesScope(vars.ConstNamespace) // strips prefix!
+	addback := vars.ConstNamespace + interfaces.ModuleSep        // add it back...
+	variables, err = ast.MergeExprMaps(variables, consts, addback)
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "couldn't merge in consts")
+	}
+	scope := &interfaces.Scope{
+		Variables: variables,
+		// all the built-in top-level, core functions enter here...
+		Functions: ast.FuncPrefixToFunctionsScope(""), // runs funcs.LookupPrefix
+	}
+	if err := iast.SetScope(scope); err != nil {
+		return nil, errwrap.Wrapf(err, "could not set scope")
+	}
+
+	body := iast.(*ast.StmtProg).Body
+	checker := &checker{
+		Stmt: iast,
+	}
+	for _, x := range body[len(body)-n:] {
+		if bind, ok := x.(*ast.StmtBind); ok {
+			checker.binds = append(checker.binds, bind)
+		}
+	}
+
+	solver, err := unification.LookupDefault()
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "could not get default solver")
+	}
+	unifier := &unification.Unifier{
+		AST:          checker,
+		Solver:       solver,
+		Strategy:     make(map[string]string),
+		UnifiedState: types.NewUnifiedState(),
+		Debug:        obj.Debug,
+		Logf: func(format string, v ...interface{}) {
+			logf("unification: "+format, v...)
+		},
+	}
+	if err := unifier.Unify(ctx); err != nil {
+		return nil, errwrap.Wrapf(err, "could not unify types")
+	}
+	return iast, nil
+}
+
+// checker wraps a program so that some of its variables are type checked even
+// if nothing uses them.
+type checker struct {
+	interfaces.Stmt
+
+	binds []*ast.StmtBind
+}
+
+// TypeCheck returns the invariants of the program and of the extra binds.
+func (obj *checker) TypeCheck() ([]*interfaces.UnificationInvariant, error) {
+	invariants, err := obj.Stmt.TypeCheck()
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range obj.binds {
+		invars, err := x.TypeCheck()
+		if err != nil {
+			return nil, err
+		}
+		invariants = append(invariants, invars...)
+	}
+	return invariants, nil
+}
+
+// defines returns true if something in the session defines this key.
+func (obj *Session) defines(key string) bool {
+	for _, x := range obj.entries {
+		if overlaps(x.keys, []string{key}) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinCode returns the code of all the entries as one program.
+func joinCode(entries []*entry) string {
+	code := []string{}
+	for _, x := range entries {
+		code = append(code, x.code)
+	}
+	return strings.Join(code, "\n")
+}
+
+// stmtKey returns the name that a statement defines, or the empty string if it
+// doesn't define anything that can be redefined.
+func stmtKey(stmt interfaces.Stmt) string {
+	switch x := stmt.(type) {
+	case *ast.StmtBind:
+		return "$" + x.Ident
+	case *ast.StmtFunc:
+		return "func " + x.Name
+	case *ast.StmtClass:
+		return "class " + x.Name
+	case *ast.StmtType:
+		return "type " + x.Name
+	case *ast.StmtImport:
+		if x.Alias != "" {
+			return "import " + x.Alias
+		}
+		return "import " + x.Name
+	}
+	return ""
+}
+
+// overlaps returns true if the two lists have an element in common.
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package repl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/lang/types"
+)
+
+func TestSession1(t *testing.T) {
+	ctx := context.Background()
+	session := &Session{
+		Hostname: "h1",
+		Logf: func(format string, v ...interface{}) {
+			t.Logf("repl: "+format, v...)
+		},
+	}
+
+	if out, err := session.Add(ctx, `$x = 40`); err != nil {
+		t.Errorf("add failed: %+v", err)
+		return
+	} else if s := strings.Join(out, "\n"); s != "$x :: int" {
+		t.Errorf("unexpected output: %s", s)
+	}
+	if _, err := session.Add(ctx, `$y = $x + "a"`); err == nil {
+		t.Errorf("expected a type error")
+	}
+	if _, err := session.Add(ctx, `import "fmt"`); err != nil {
+		t.Errorf("add failed: %+v", err)
+		return
+	}
+	if _, err := session.Add(ctx, `$x = 2`); err != nil { // redefine
+		t.Errorf("add failed: %+v", err)
+		return
+	}
+	if s, exp := session.Code(), "import \"fmt\"\n$x = 2"; s != exp {
+		t.Errorf("unexpected code: %s", s)
+		t.Errorf("expected code: %s", exp)
+	}
+
+	expr, err := session.Compile(ctx, `fmt.printf("%d@%s", $x + 40, $hostname)`)
+	if err != nil {
+		t.Errorf("compile failed: %+v", err)
+		return
+	}
+	if s := expr.Type.String(); s != "str" {
+		t.Errorf("unexpected type: %s", s)
+	}
+	if !expr.Static() {
+		t.Errorf("expected a static expression")
+	}
+	values := []string{}
+	err = session.Eval(ctx, expr, func(value types.Value) error {
+		values = append(values, value.String())
+		return nil
+	})
+	if err != nil {
+		t.Errorf("eval failed: %+v", err)
+		return
+	}
+	if s := strings.Join(values, "\n"); s != `"42@h1"` {
+		t.Errorf("unexpected values: %s", s)
+	}
+
+	if _, err := session.Compile(ctx, `$nope`); err == nil {
+		t.Errorf("expected a scope error")
+	}
+	session.Reset()
+	if _, err := session.Compile(ctx, `$x`); err == nil {
+		t.Errorf("expected a scope error after reset")
+	}
+}
+
+func TestSessionStream1(t *testing.T) {
+	session := &Session{
+		Hostname: "h1",
+		Logf: func(format string, v ...interface{}) {
+			t.Logf("repl: "+format, v...)
+		},
+	}
+
+	if _, err := session.Add(context.Background(), `import "datetime"`); err != nil {
+		t.Errorf("add failed: %+v", err)
+		return
+	}
+	expr, err := session.Compile(context.Background(), `datetime.now()`)
+	if err != nil {
+		t.Errorf("compile failed: %+v", err)
+		return
+	}
+	if s := expr.Type.String(); s != "int" {
+		t.Errorf("unexpected type: %s", s)
+	}
+	if expr.Static() {
+		t.Errorf("expected a non-static expression")
+		return
+	}
+
+	const count = 3 // datetime.now sends a new value each second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	values := []int64{}
+	ch := make(chan error)
+	go func() {
+		defer close(ch)
+		ch <- session.Eval(ctx, expr, func(value types.Value) error {
+			values = append(values, value.Int())
+			if len(values) == count {
+				cancel() // we've seen enough, Eval should stop cleanly
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Errorf("eval failed: %+v", err)
+			return
+		}
+	case <-time.After(30 * time.Second):
+		t.Errorf("eval didn't stop")
+		return
+	}
+
+	if n := len(values); n < count { // one more might race with the cancel
+		t.Errorf("expected %d values, got: %d", count, n)
+		return
+	}
+	for i := 1; i < len(values); i++ {
+		if values[i] <= values[i-1] {
+			t.Errorf("expected increasing values, got: %+v", values)
+			return
+		}
+	}
+}
+
+func TestComplete1(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		code string
+		exp  bool
+	}
+	testCases := []test{
+		{"empty", "", true},
+		{"expr", "1 + 2", true},
+		{"open func", "func f($a) {", false},
+		{"closed func", "func f($a) {\n\t$a\n}", true},
+		{"open list", "[1, 2,", false},
+		{"bracket in string", `"{"`, true},
+		{"escaped quote", `"\"{"`, true},
+		{"open string", `"abc`, false},
+		{"bracket in comment", "$x = 1 # {", true},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			if out := complete(tc.code); out != tc.exp {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: got: %t, exp: %t", index, out, tc.exp)
+			}
+		})
+	}
+}
+
+func TestTool1(t *testing.T) {
+	input := strings.Join([]string{
+		`$x = 40`,
+		`[$x,`,
+		`2,]`,
+		`:type $x + 2`,
+		`bad +`,
+		`:list`,
+		`:nope`,
+		`:quit`,
+		`$never = 1`,
+	}, "\n")
+	stdout := &bytes.Buffer{}
+	tool := &Tool{
+		Stdin:  strings.NewReader(input),
+		Stdout: stdout,
+	}
+	info := &cliUtil.ToolInfo{
+		Args: &cliUtil.ReplArgs{
+			Hostname: "h1",
+		},
+		Logf: func(format string, v ...interface{}) {
+			t.Logf("repl: "+format, v...)
+		},
+	}
+	if err := tool.Main(context.Background(), info); err != nil {
+		t.Errorf("main failed: %+v", err)
+		return
+	}
+
+	exp := []string{
+		"mcl> $x :: int",
+		"mcl> ...> :: []int",
+		"[40, 2]",
+		"mcl> :: int",
+		"mcl> error: could not parse:",
+		"mcl> $x = 40",
+		"mcl> error: unknown command :nope, try :help",
+		"mcl> ",
+	}
+	lines := strings.Split(stdout.String(), "\n")
+	if len(lines) != len(exp) {
+		t.Errorf("unexpected output:\n%s", stdout.String())
+		return
+	}
+	for i, x := range exp {
+		if !strings.HasPrefix(lines[i], x) {
+			t.Errorf("line %d: got: %s", i, lines[i])
+			t.Errorf("line %d: exp: %s", i, x)
+		}
+	}
+}
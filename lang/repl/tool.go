@@ -0,0 +1,324 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package repl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/engine/local"
+	"github.com/purpleidea/mgmt/lang/types"
+)
+
+const (
+	// Name is the name of this tool.
+	Name = "repl"
+
+	// prompt is shown when we're waiting for new input.
+	prompt = "mcl> "
+
+	// promptMore is shown when the input so far isn't complete.
+	promptMore = "...> "
+
+	// help is the text of the :help command.
+	help = `Enter statements to add them to the session, or expressions to evaluate them.
+Streaming values are printed as they change until you press enter or ctrl-c.
+Commands:
+  :type <expr>  show the type of an expression without evaluating it
+  :list         show the statements in the session
+  :reset        remove all the statements from the session
+  :help         show this help
+  :quit         exit (ctrl-d works too)`
+)
+
+func init() {
+	cliUtil.RegisterTool(Name, func() cliUtil.Tool { return &Tool{} })
+}
+
+// Tool is the interactive mcl shell for the `repl` command.
+type Tool struct {
+	// Stdin is where the input is read from. It's os.Stdin if nil.
+	Stdin io.Reader
+
+	// Stdout is where the output goes. It's os.Stdout if nil.
+	Stdout io.Writer
+
+	stdout io.Writer
+	lines  chan string    // input lines, closed on EOF
+	sigint chan os.Signal // interrupts from ctrl-c
+	eof    bool           // did the input close during a stream?
+}
+
+// Main runs the REPL until the input closes or the user quits.
+func (obj *Tool) Main(ctx context.Context, info *cliUtil.ToolInfo) error {
+	args, ok := info.Args.(*cliUtil.ReplArgs)
+	if !ok {
+		// programming error
+		return fmt.Errorf("could not convert to our struct")
+	}
+	stdin := obj.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	obj.stdout = obj.Stdout
+	if obj.stdout == nil {
+		obj.stdout = os.Stdout
+	}
+
+	hostname := args.Hostname
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	modules := args.ModulePath
+	if modules != "" && !strings.HasSuffix(modules, "/") {
+		modules += "/"
+	}
+	prefix, err := os.MkdirTemp("", "mgmt-repl-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(prefix)
+
+	session := &Session{
+		ModulePath: modules,
+		Hostname:   hostname,
+		Local: (&local.API{
+			Prefix: prefix,
+			Debug:  info.Debug,
+			Logf:   info.Logf,
+		}).Init(),
+		Debug: info.Debug,
+		Logf:  info.Logf,
+	}
+
+	obj.lines = make(chan string)
+	go func() { // this leaks if stdin never closes, but we exit after
+		defer close(obj.lines)
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			obj.lines <- scanner.Text()
+		}
+	}()
+	obj.sigint = make(chan os.Signal, 1)
+	signal.Notify(obj.sigint, os.Interrupt)
+	defer signal.Stop(obj.sigint)
+
+	pending := "" // incomplete input so far
+	for !obj.eof {
+		if pending == "" {
+			fmt.Fprint(obj.stdout, prompt)
+		} else {
+			fmt.Fprint(obj.stdout, promptMore)
+		}
+
+		var line string
+		select {
+		case s, ok := <-obj.lines:
+			if !ok {
+				fmt.Fprintln(obj.stdout)
+				return nil
+			}
+			line = s
+
+		case <-obj.sigint:
+			fmt.Fprintln(obj.stdout) // discard the incomplete input
+			pending = ""
+			continue
+
+		case <-ctx.Done():
+			return nil
+		}
+
+		code := pending + line
+		if !complete(code) {
+			pending = code + "\n"
+			continue
+		}
+		pending = ""
+
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		if strings.HasPrefix(code, ":") {
+			if quit := obj.command(ctx, session, code); quit {
+				return nil
+			}
+			continue
+		}
+		if err := obj.input(ctx, session, code); err != nil {
+			fmt.Fprintf(obj.stdout, "error: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// command runs one of the colon commands. It returns true if we should exit.
+func (obj *Tool) command(ctx context.Context, session *Session, code string) bool {
+	cmd, arg, _ := strings.Cut(code, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case ":type", ":t":
+		expr, err := session.Compile(ctx, arg)
+		if err != nil {
+			fmt.Fprintf(obj.stdout, "error: %v\n", err)
+			return false
+		}
+		fmt.Fprintf(obj.stdout, ":: %s\n", expr.Type)
+
+	case ":list", ":l":
+		if code := session.Code(); code != "" {
+			fmt.Fprintln(obj.stdout, code)
+		}
+
+	case ":reset":
+		session.Reset()
+
+	case ":help", ":h", ":?":
+		fmt.Fprintln(obj.stdout, help)
+
+	case ":quit", ":q", ":exit":
+		return true
+
+	default:
+		fmt.Fprintf(obj.stdout, "error: unknown command %s, try :help\n", cmd)
+	}
+	return false
+}
+
+// input adds the code to the session if it's a statement, or otherwise it
+// evaluates it as an expression.
+func (obj *Tool) input(ctx context.Context, session *Session, code string) error {
+	if IsStmt(code) {
+		out, err := session.Add(ctx, code)
+		if err != nil {
+			return err
+		}
+		for _, x := range out {
+			fmt.Fprintln(obj.stdout, x)
+		}
+		return nil
+	}
+
+	expr, err := session.Compile(ctx, code)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(obj.stdout, ":: %s\n", expr.Type)
+	if expr.Type.Kind == types.KindFunc {
+		return nil // we can't print a function
+	}
+
+	// Stop when the user hits ctrl-c, or when they hit enter after the
+	// first value of a stream. We don't read input before then, since
+	// piped input would otherwise stop the stream at once.
+	static := expr.Static()
+	evalCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	first := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		select {
+		case <-first:
+		case <-obj.sigint:
+			return
+		case <-evalCtx.Done():
+			return
+		}
+		if static {
+			return // it's finished
+		}
+		select {
+		case _, ok := <-obj.lines:
+			obj.eof = !ok
+		case <-obj.sigint:
+		case <-evalCtx.Done():
+		}
+	}()
+	defer cancel() // runs before wg.Wait
+
+	count := 0
+	return session.Eval(evalCtx, expr, func(value types.Value) error {
+		fmt.Fprintln(obj.stdout, value)
+		if count++; count == 1 {
+			if !static {
+				fmt.Fprintln(obj.stdout, "(streaming, press enter to stop)")
+			}
+			close(first)
+		}
+		return nil
+	})
+}
+
+// complete returns false if the code has unclosed brackets, so that we know to
+// keep reading more lines. Brackets inside strings and comments don't count.
+func complete(code string) bool {
+	depth := 0
+	inStr, escaped, inComment := false, false, false
+	for _, c := range code {
+		switch {
+		case inComment:
+			if c == '\n' {
+				inComment = false
+			}
+		case inStr:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inStr = false
+			}
+		case c == '#':
+			inComment = true
+		case c == '"':
+			inStr = true
+		case c == '{' || c == '[' || c == '(':
+			depth++
+		case c == '}' || c == ']' || c == ')':
+			depth--
+		}
+	}
+	return depth <= 0 && !inStr
+}
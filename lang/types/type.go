@@ -94,6 +94,13 @@ type Type struct {
 
 	// unification variable (question mark, eg ?1, ?2)
 	Uni *Elem // if Kind == Unification (optional) use Uni only
+
+	// Name is the name of the type alias that this type was declared as, if
+	// any. It doesn't change the type, so it's ignored by Cmp and by String,
+	// but Pretty prints it so that error messages can show the name that the
+	// user wrote instead of the expanded type. If the Kind is KindNil, then
+	// this is a reference to a named type which hasn't been resolved yet.
+	Name string
 }
 
 // Elem is the type used for the unification variable in the Uni field of Type.
@@ -710,12 +717,24 @@ func (obj *Type) New() Value {
 // String returns the textual representation for this type.
 func (obj *Type) String() string {
 	table := make(map[*Elem]uint)
-	return obj.string(table)
+	return obj.string(table, false)
+}
+
+// Pretty returns the textual representation for this type as a human would
+// want to read it. Any named types (type aliases) are printed with their name
+// instead of being expanded. Unlike String, this can't be parsed by NewType.
+func (obj *Type) Pretty() string {
+	table := make(map[*Elem]uint)
+	return obj.string(table, true)
 }
 
 // string returns the textual representation for this type. This is a private
-// helper function that is used by the real String function.
-func (obj *Type) string(table map[*Elem]uint) string {
+// helper function that is used by the real String and Pretty functions.
+func (obj *Type) string(table map[*Elem]uint, pretty bool) string {
+	if obj.Name != "" && (pretty || obj.Kind == KindNil) {
+		return obj.Name // an alias, or an unresolved reference to one
+	}
+
 	switch obj.Kind {
 	case KindBool:
 		return "bool"
@@ -730,13 +749,13 @@ func (obj *Type) string(table map[*Elem]uint) string {
 		if obj.Val == nil {
 			panic("malformed list type")
 		}
-		return "[]" + obj.Val.string(table)
+		return "[]" + obj.Val.string(table, pretty)
 
 	case KindMap:
 		if obj.Key == nil || obj.Val == nil {
 			panic("malformed map type")
 		}
-		return fmt.Sprintf("map{%s: %s}", obj.Key.string(table), obj.Val.string(table))
+		return fmt.Sprintf("map{%s: %s}", obj.Key.string(table, pretty), obj.Val.string(table, pretty))
 
 	case KindStruct: // {a bool; b int}
 		if obj.Map == nil {
@@ -754,7 +773,7 @@ func (obj *Type) string(table map[*Elem]uint) string {
 			if t == nil {
 				panic("malformed struct field")
 			}
-			s[i] = fmt.Sprintf("%s %s", k, t.string(table))
+			s[i] = fmt.Sprintf("%s %s", k, t.string(table, pretty))
 		}
 		return fmt.Sprintf("struct{%s}", strings.Join(s, "; "))
 
@@ -777,12 +796,12 @@ func (obj *Type) string(table map[*Elem]uint) string {
 
 			// We need to print function arg names for Copy() to use
 			// the String() hack here and avoid erasing them here!
-			//s[i] = t.string(table)
-			s[i] = fmt.Sprintf("%s %s", k, t.string(table)) // strict
+			//s[i] = t.string(table, pretty)
+			s[i] = fmt.Sprintf("%s %s", k, t.string(table, pretty)) // strict
 		}
 		var out string
 		if obj.Out != nil {
-			out = fmt.Sprintf(" %s", obj.Out.string(table))
+			out = fmt.Sprintf(" %s", obj.Out.string(table, pretty))
 		}
 		return fmt.Sprintf("func(%s)%s", strings.Join(s, ", "), out)
 
@@ -793,6 +812,9 @@ func (obj *Type) string(table map[*Elem]uint) string {
 		if obj.Uni == nil {
 			panic("malformed unification variable")
 		}
+		if root := obj.Uni.Find(); pretty && root.Data != nil {
+			return root.Data.string(table, pretty) // show what we learned
+		}
 
 		// XXX: Should we instead run .IsConnected() on the two Elem
 		// unification variables to determine if they should have the
@@ -1008,10 +1030,49 @@ func (obj *Type) cmp(typ *Type, table1, table2 map[*Elem]uint) error {
 
 // Copy copies this type so that inplace modification won't affect the original.
 func (obj *Type) Copy() *Type {
+	if obj.HasName() { // the String() hack would erase the names
+		table := make(map[*Elem]*Elem)
+		return obj.copy(table)
+	}
 	// String() needs to print function arg names or they'd get erased here!
 	return NewType(obj.String()) // hack to do this easily
 }
 
+// copy is a private helper for Copy which keeps the names of any named types.
+// The table maps the unification variables that we've seen to the new ones we
+// made so that the copy is unified the same way that the original one was.
+func (obj *Type) copy(table map[*Elem]*Elem) *Type {
+	if obj == nil {
+		return nil
+	}
+	typ := &Type{
+		Kind: obj.Kind,
+		Val:  obj.Val.copy(table),
+		Key:  obj.Key.copy(table),
+		Out:  obj.Out.copy(table),
+		Var:  obj.Var.copy(table),
+		Name: obj.Name,
+	}
+	if obj.Map != nil {
+		typ.Map = make(map[string]*Type)
+		for k, t := range obj.Map {
+			typ.Map[k] = t.copy(table)
+		}
+	}
+	if obj.Ord != nil {
+		typ.Ord = append([]string{}, obj.Ord...)
+	}
+	if obj.Uni != nil {
+		uni, exists := table[obj.Uni]
+		if !exists {
+			uni = NewElem()
+			table[obj.Uni] = uni
+		}
+		typ.Uni = uni
+	}
+	return typ
+}
+
 // Reflect returns a representative type satisfying the golang Type Interface.
 // The lossy inverse of this is TypeOf.
 func (obj *Type) Reflect() reflect.Type {
@@ -1290,6 +1351,96 @@ func (obj *Type) HasUni() bool {
 	panic("malformed type")
 }
 
+// HasName tells us if the type contains any named types, which includes any
+// unresolved references to them.
+func (obj *Type) HasName() bool {
+	found := false
+	obj.walk(func(typ *Type) {
+		if typ.Name != "" {
+			found = true
+		}
+	})
+	return found
+}
+
+// HasRef tells us if the type contains any references to named types which
+// haven't been resolved yet. These come from the parser when a type alias is
+// used, and need to be resolved with Resolve before the type can be used.
+func (obj *Type) HasRef() bool {
+	found := false
+	obj.walk(func(typ *Type) {
+		if typ.Kind == KindNil && typ.Name != "" {
+			found = true
+		}
+	})
+	return found
+}
+
+// Resolve returns a copy of this type where each reference to a named type has
+// been replaced with the type that the lookup function returns for that name.
+// The lookup function should return a type which has its Name set, so that it
+// can be printed as the alias that it came from. If there aren't any references
+// in this type, then it is returned as is.
+func (obj *Type) Resolve(lookup func(name string) (*Type, error)) (*Type, error) {
+	if obj == nil || !obj.HasRef() {
+		return obj, nil
+	}
+	if obj.Kind == KindNil {
+		typ, err := lookup(obj.Name)
+		if err != nil {
+			return nil, err
+		}
+		if typ == nil || typ.HasRef() {
+			return nil, fmt.Errorf("type `%s` did not resolve", obj.Name)
+		}
+		return typ.Copy(), nil
+	}
+
+	var err error
+	typ := &Type{
+		Kind: obj.Kind,
+		Ord:  obj.Ord,
+		Uni:  obj.Uni,
+		Name: obj.Name,
+	}
+	if typ.Val, err = obj.Val.Resolve(lookup); err != nil {
+		return nil, err
+	}
+	if typ.Key, err = obj.Key.Resolve(lookup); err != nil {
+		return nil, err
+	}
+	if typ.Out, err = obj.Out.Resolve(lookup); err != nil {
+		return nil, err
+	}
+	if typ.Var, err = obj.Var.Resolve(lookup); err != nil {
+		return nil, err
+	}
+	if obj.Map != nil {
+		typ.Map = make(map[string]*Type)
+		for _, k := range obj.Ord { // deterministic order for errors
+			if typ.Map[k], err = obj.Map[k].Resolve(lookup); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return typ, nil
+}
+
+// walk runs the function on this type and on every type contained within it.
+func (obj *Type) walk(fn func(*Type)) {
+	if obj == nil {
+		return
+	}
+	fn(obj)
+	obj.Val.walk(fn)
+	obj.Key.walk(fn)
+	for _, k := range obj.Ord {
+		obj.Map[k].walk(fn)
+	}
+	obj.Out.walk(fn)
+	obj.Var.walk(fn)
+}
+
 // ComplexCmp tells us if the input type is compatible with the concrete one. It
 // can match against types containing variants, or against partial types. If the
 // two types are equivalent, it will return nil. If the input type is identical,
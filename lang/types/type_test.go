@@ -1498,6 +1498,82 @@ func TestTypeCopy0(t *testing.T) {
 	}
 }
 
+func TestTypeCopy1(t *testing.T) {
+	port := NewType("int")
+	port.Name = "Port"
+	typ := &Type{
+		Kind: KindStruct,
+		Map:  map[string]*Type{"a": port},
+		Ord:  []string{"a"},
+		Name: "Config",
+	}
+	cp := typ.Copy()
+	if s := cp.Pretty(); s != "Config" {
+		t.Errorf("incorrect name after Copy: %s", s)
+	}
+	cp.Name = ""
+	if s := cp.Pretty(); s != "struct{a Port}" {
+		t.Errorf("incorrect field name after Copy: %s", s)
+	}
+	if s := cp.String(); s != "struct{a int}" {
+		t.Errorf("incorrect string after Copy: %s", s)
+	}
+	if typ.Name != "Config" {
+		t.Errorf("original was modified by Copy")
+	}
+}
+
+func TestTypeResolve0(t *testing.T) {
+	config := NewType("struct{a int; b str}")
+	config.Name = "Config"
+	lookup := func(name string) (*Type, error) {
+		if name == "Config" {
+			return config, nil
+		}
+		return nil, fmt.Errorf("type `%s` not found", name)
+	}
+
+	ref := &Type{ // map{str: []Config}
+		Kind: KindMap,
+		Key:  TypeStr,
+		Val: &Type{
+			Kind: KindList,
+			Val:  &Type{Name: "Config"},
+		},
+	}
+	if !ref.HasRef() {
+		t.Errorf("expected a ref")
+	}
+	if s := ref.String(); s != "map{str: []Config}" {
+		t.Errorf("unexpected string before Resolve: %s", s)
+	}
+
+	typ, err := ref.Resolve(lookup)
+	if err != nil {
+		t.Errorf("unexpected error: %+v", err)
+		return
+	}
+	if typ.HasRef() {
+		t.Errorf("unexpected ref after Resolve")
+	}
+	if s := typ.String(); s != "map{str: []struct{a int; b str}}" {
+		t.Errorf("unexpected string after Resolve: %s", s)
+	}
+	if s := typ.Pretty(); s != "map{str: []Config}" {
+		t.Errorf("unexpected pretty after Resolve: %s", s)
+	}
+	if err := typ.Cmp(NewType("map{str: []struct{a int; b str}}")); err != nil {
+		t.Errorf("unexpected cmp error: %+v", err)
+	}
+
+	if _, err := (&Type{Name: "Missing"}).Resolve(lookup); err == nil {
+		t.Errorf("expected an error resolving a missing type")
+	}
+	if typ, err := TypeInt.Resolve(lookup); err != nil || typ != TypeInt {
+		t.Errorf("expected the same type back when there are no refs")
+	}
+}
+
 func TestUni0(t *testing.T) {
 	// good type strings
 	if NewType("?1") == nil {
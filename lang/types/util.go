@@ -214,7 +214,7 @@ type UnifiedState struct {
 
 // String returns a representation of the input type using the specified state.
 func (obj *UnifiedState) String(typ *Type) string {
-	return typ.string(obj.table)
+	return typ.string(obj.table, false)
 }
 
 // ListStrToValue is a simple helper function to convert from a list of strings
@@ -337,6 +337,14 @@ func Into(v Value, rv reflect.Value) error {
 	if typ == nil {
 		return fmt.Errorf("cannot Into() %+v of type %s into a nil type", v, v.Type())
 	}
+	// This is used when we are setting a resource field which wants the mcl
+	// value itself, so that it can keep all of the type information. (Eg: a
+	// struct with lowercase fields can't be represented as a golang value.)
+	if typ == reflect.TypeOf((*Value)(nil)).Elem() {
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+
 	// This is used when we are setting a resource field which has type of
 	// interface{} instead of a string, bool, list, etc...
 	if isInterface := typ.Kind() == reflect.Interface; isInterface {
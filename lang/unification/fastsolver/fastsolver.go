@@ -142,7 +142,10 @@ func (obj *FastInvariantSolver) Solve(ctx context.Context, data *unification.Dat
 			if highlight := displayer.HighlightText(); highlight != "" {
 				obj.Logf("%s: %s", err.Error(), highlight)
 			}
-			return nil, fmt.Errorf("%s: %s", err.Error(), displayer.Byline())
+			return nil, &interfaces.NodeError{ // adds the Byline
+				Err:  err,
+				Node: x.Node,
+			}
 		}
 		if obj.Debug {
 			e1, e2 := unificationUtil.Extract(x.Expect), unificationUtil.Extract(x.Actual)
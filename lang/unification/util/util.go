@@ -35,6 +35,7 @@ import (
 	"fmt"
 
 	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/util/errwrap"
 )
 
 // Unify takes two types and tries to make them equivalent. If they are both
@@ -121,6 +122,22 @@ func Unify(typ1, typ2 *types.Type) error {
 
 	// At this point, we've handled all the special cases with the ?1, ?2
 	// unification variables, so we now expect the kind's to match to unify.
+	err := unify(typ1, typ2)
+	if err == nil || (typ1.Name == "" && typ2.Name == "") {
+		return err
+	}
+	// If either side is a type alias, report it by name, since that's what
+	// the user wrote, and the expanded type can be very long to read.
+	if typ1.Kind != typ2.Kind {
+		return fmt.Errorf("type error: %s != %s", typ1.Pretty(), typ2.Pretty())
+	}
+	return errwrap.Wrapf(err, "type error: %s != %s", typ1.Pretty(), typ2.Pretty())
+}
+
+// unify is the part of Unify which runs once neither of the two types are
+// unification variables. It compares the kinds, and recurses into any of the
+// contained types with Unify.
+func unify(typ1, typ2 *types.Type) error {
 	if k1, k2 := typ1.Kind, typ2.Kind; k1 != k2 {
 		return fmt.Errorf("type error: %v != %v", k1, k2)
 	}
@@ -762,6 +779,7 @@ func UnifyCmp(typ1, typ2 *types.Type) error {
 func UnifyCopy(typ *types.Type) *types.Type {
 	ret := &types.Type{ // return at the end
 		Kind: typ.Kind,
+		Name: typ.Name,
 	}
 	switch typ.Kind {
 	case types.KindBool:
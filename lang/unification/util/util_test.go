@@ -128,6 +128,34 @@ func TestUnify4(t *testing.T) {
 	}
 }
 
+func TestUnifyAlias1(t *testing.T) {
+	port := types.NewType("int")
+	port.Name = "Port"
+	config := &types.Type{
+		Kind: types.KindStruct,
+		Map:  map[string]*types.Type{"a": port},
+		Ord:  []string{"a"},
+		Name: "Config",
+	}
+
+	if err := Unify(config, types.TypeStr); err == nil {
+		t.Errorf("unify didn't fail")
+	} else if s := err.Error(); s != "type error: Config != str" {
+		t.Errorf("unexpected error: %s", s)
+	}
+
+	typ := types.NewType("struct{a str}")
+	if err := Unify(config, typ); err == nil {
+		t.Errorf("unify didn't fail")
+	} else if s := err.Error(); s != "type error: Config != struct{a str}: type error: Port != str" {
+		t.Errorf("unexpected error: %s", s)
+	}
+
+	if err := Unify(config, types.NewType("struct{a int}")); err != nil {
+		t.Errorf("unify failed: %+v", err)
+	}
+}
+
 func TestUnifyTable(t *testing.T) {
 	type test struct { // an individual test
 		name string
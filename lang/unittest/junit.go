@@ -0,0 +1,136 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package unittest
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is the report for a single directory of tests.
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is the report for a single test.
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+// junitFailure is the reason that a test failed.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as a JUnit XML report, which is the format that
+// most CI systems understand.
+func WriteJUnit(w io.Writer, suites []*Suite) error {
+	report := &junitTestSuites{}
+	total := 0.0
+	for _, suite := range suites {
+		s := &junitTestSuite{
+			Name:     suite.Dir,
+			Tests:    len(suite.Results),
+			Failures: suite.Failed(),
+			Time:     junitTime(suite.Duration.Seconds()),
+		}
+		for _, result := range suite.Results {
+			c := &junitTestCase{
+				Name:      result.Test.Name(),
+				Classname: suite.Dir,
+				Time:      junitTime(result.Duration.Seconds()),
+			}
+			if !result.Passed() {
+				c.Failure = &junitFailure{
+					Message: result.Failures[0],
+					Text:    strings.Join(result.Failures, "\n"),
+				}
+				if result.Graph != nil {
+					c.Failure.Text += "\ngraph:\n" + strings.Join(result.Graph, "\n")
+				}
+			}
+			s.Cases = append(s.Cases, c)
+		}
+		report.Suites = append(report.Suites, s)
+		report.Tests += s.Tests
+		report.Failures += s.Failures
+		total += suite.Duration.Seconds()
+	}
+	report.Time = junitTime(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeJUnitFile writes the JUnit XML report to a file.
+func writeJUnitFile(path string, suites []*Suite) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteJUnit(f, suites); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// junitTime formats a number of seconds for the time attributes.
+func junitTime(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
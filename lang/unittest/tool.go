@@ -0,0 +1,263 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package unittest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// Name is the name of this tool.
+	Name = "test"
+)
+
+func init() {
+	cliUtil.RegisterTool(Name, func() cliUtil.Tool { return &Tool{} })
+}
+
+// Suite is the set of test results from a single directory. The directory plays
+// the same role that a package does for `go test`.
+type Suite struct {
+	Dir      string
+	Duration time.Duration
+	Results  []*Result
+}
+
+// Failed returns the number of tests in the suite which failed.
+func (obj *Suite) Failed() int {
+	count := 0
+	for _, x := range obj.Results {
+		if !x.Passed() {
+			count++
+		}
+	}
+	return count
+}
+
+// Tool runs the mcl unit tests for the `test` command. It prints the results
+// in the same format as `go test` does, and can also write a JUnit report for
+// CI systems to consume.
+type Tool struct {
+	// Stdout is where the results go. It's os.Stdout if nil.
+	Stdout io.Writer
+}
+
+// Main finds and runs all of the requested tests.
+func (obj *Tool) Main(ctx context.Context, info *cliUtil.ToolInfo) error {
+	args, ok := info.Args.(*cliUtil.TestArgs)
+	if !ok {
+		// programming error
+		return fmt.Errorf("could not convert to our struct")
+	}
+	stdout := obj.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+
+	var match *regexp.Regexp
+	if args.Run != "" {
+		var err error
+		if match, err = regexp.Compile(args.Run); err != nil {
+			return errwrap.Wrapf(err, "invalid --run regexp")
+		}
+	}
+	modules := args.ModulePath
+	if modules != "" && !strings.HasSuffix(modules, "/") {
+		modules += "/"
+	}
+
+	paths := args.Paths
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	dirs, err := findTests(paths)
+	if err != nil {
+		return err
+	}
+
+	runner := &Runner{
+		ModulePath: modules,
+		Timeout:    time.Duration(args.Timeout) * time.Second,
+		Debug:      info.Debug,
+		Logf:       info.Logf,
+	}
+
+	suites := []*Suite{}
+	failed := 0
+	for _, dir := range sortedKeys(dirs) {
+		suite := &Suite{Dir: dir}
+		start := time.Now()
+		for _, file := range dirs[dir] {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+
+			if match != nil && !match.MatchString(filepath.Base(file)) {
+				continue
+			}
+			result := obj.run(ctx, runner, file)
+			suite.Results = append(suite.Results, result)
+			printResult(stdout, result, args.Verbose)
+		}
+		suite.Duration = time.Since(start)
+		if len(suite.Results) == 0 {
+			continue // everything was filtered out
+		}
+		suites = append(suites, suite)
+		failed += suite.Failed()
+
+		if suite.Failed() > 0 {
+			fmt.Fprintf(stdout, "FAIL\n")
+			fmt.Fprintf(stdout, "FAIL\t%s\t%s\n", dir, seconds(suite.Duration))
+			continue
+		}
+		fmt.Fprintf(stdout, "ok  \t%s\t%s\n", dir, seconds(suite.Duration))
+	}
+
+	if args.JUnit != "" {
+		if err := writeJUnitFile(args.JUnit, suites); err != nil {
+			return errwrap.Wrapf(err, "could not write junit report")
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d test(s) failed", failed)
+	}
+	return nil
+}
+
+// run parses and runs a single test file.
+func (obj *Tool) run(ctx context.Context, runner *Runner, file string) *Result {
+	src, err := os.ReadFile(file)
+	if err == nil {
+		var test *Test
+		if test, err = ParseTest(file, src); err == nil {
+			return runner.Run(ctx, test)
+		}
+	}
+	return &Result{
+		Test:     &Test{Path: file},
+		Failures: []string{err.Error()},
+	}
+}
+
+// printResult prints the result of a single test like `go test` does.
+func printResult(w io.Writer, result *Result, verbose bool) {
+	name := result.Test.Name()
+	if verbose {
+		fmt.Fprintf(w, "=== RUN   %s\n", name)
+	}
+	if result.Passed() {
+		if verbose {
+			fmt.Fprintf(w, "--- PASS: %s (%s)\n", name, seconds(result.Duration))
+		}
+		return
+	}
+	fmt.Fprintf(w, "--- FAIL: %s (%s)\n", name, seconds(result.Duration))
+	for _, x := range result.Failures {
+		for _, line := range strings.Split(x, "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+	if result.Graph != nil {
+		fmt.Fprintf(w, "    graph:\n")
+	}
+	for _, x := range result.Graph {
+		fmt.Fprintf(w, "        %s\n", x)
+	}
+}
+
+// seconds formats a duration the same way that `go test` does.
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
+// findTests returns the test files from the list of paths, grouped by the
+// directory they are in. Each list of files is in lexical order. A path which
+// is a file is used as-is, even if it doesn't have the usual test suffix.
+func findTests(paths []string) (map[string][]string, error) {
+	dirs := make(map[string][]string)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			dir := filepath.Dir(path)
+			dirs[dir] = append(dirs[dir], path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && p != path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir // skip .git and friends
+			}
+			if !d.IsDir() && strings.HasSuffix(p, FileSuffix) {
+				dir := filepath.Dir(p)
+				dirs[dir] = append(dirs[dir], p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for dir, files := range dirs {
+		sort.Strings(files)
+		dirs[dir] = files
+	}
+	return dirs, nil
+}
+
+// sortedKeys returns the keys of the map in lexical order.
+func sortedKeys(m map[string][]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,622 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package unittest runs the unit tests of mcl modules. A test is an mcl file
+// whose name ends in _test.mcl. It is run through type unification, the
+// function engine and the interpreter exactly like `mgmt run` would, but the
+// resulting resource graph is never applied. Instead, comment directives in the
+// file mock out facts and functions, and make assertions about the graph. The
+// assertions use the same text format as the OUTPUT section of the TestAstFunc
+// fixtures. An example:
+//
+//	# mock: os.is_family_debian = true
+//	# expect: Vertex: pkg[nginx]
+//	# expect: Field: pkg[nginx].State = "installed"
+//	# expect: Edge: pkg[nginx] -> svc[nginx]
+//	# reject: Vertex: pkg[httpd]
+//	import "nginx/"
+//	include nginx.server()
+package unittest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cliUtil "github.com/purpleidea/mgmt/cli/util"
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/engine/graph/autoedge"
+	"github.com/purpleidea/mgmt/engine/local"
+	engineUtil "github.com/purpleidea/mgmt/engine/util"
+	"github.com/purpleidea/mgmt/gapi"
+	"github.com/purpleidea/mgmt/lang"
+	"github.com/purpleidea/mgmt/lang/ast"
+	"github.com/purpleidea/mgmt/lang/funcs"
+	"github.com/purpleidea/mgmt/lang/funcs/wrapped"
+	langGAPI "github.com/purpleidea/mgmt/lang/gapi"
+	"github.com/purpleidea/mgmt/lang/interfaces"
+	"github.com/purpleidea/mgmt/lang/parser"
+	"github.com/purpleidea/mgmt/lang/types"
+	"github.com/purpleidea/mgmt/pgraph"
+	"github.com/purpleidea/mgmt/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// FileSuffix is the suffix of the mcl files which contain tests.
+	FileSuffix = "_test" + interfaces.DotFileNameExtension
+
+	// DefaultHostname is the hostname that the code sees, unless the test
+	// sets a different one with the hostname directive.
+	DefaultHostname = "test"
+
+	// DefaultTimeout is how long we wait for the function engine to produce
+	// its first values before we give up on a test.
+	DefaultTimeout = 60 * time.Second
+
+	// DirectiveMock replaces a function with one that returns a constant.
+	// The syntax is `# mock: <name> = <value>` where value is a literal.
+	DirectiveMock = "mock:"
+
+	// DirectiveExpect asserts that a line is present in the output graph.
+	DirectiveExpect = "expect:"
+
+	// DirectiveReject asserts that a line is absent from the output graph.
+	DirectiveReject = "reject:"
+
+	// DirectiveError asserts that running the code fails with an error that
+	// contains this string. No graph assertions are made in this case.
+	DirectiveError = "error:"
+
+	// DirectiveHostname sets the hostname that the code sees.
+	DirectiveHostname = "hostname:"
+
+	// edgeNameSep separates an edge line from the name of the edge.
+	edgeNameSep = " # "
+)
+
+// Mock is a function that gets replaced for the duration of a test.
+type Mock struct {
+	// Name is the full name of the function, eg: os.is_family_debian
+	Name string
+
+	// Value is the mcl literal that the replacement function returns.
+	Value string
+}
+
+// Test is a single parsed test file.
+type Test struct {
+	// Path is the path to the mcl file.
+	Path string
+
+	// Hostname is the hostname that the code sees.
+	Hostname string
+
+	Mocks  []*Mock
+	Expect []string
+	Reject []string
+	Errors []string
+}
+
+// Name returns the name of this test, which is the base name of the file.
+func (obj *Test) Name() string {
+	return filepath.Base(obj.Path)
+}
+
+// ParseTest reads the directives out of the code of a test. Directives must be
+// on lines of their own.
+func ParseTest(path string, src []byte) (*Test, error) {
+	test := &Test{
+		Path:     path,
+		Hostname: DefaultHostname,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(s, "#") {
+			continue
+		}
+		s = strings.TrimSpace(strings.TrimPrefix(s, "#"))
+
+		var arg string
+		var found bool
+		switch {
+		case cut(s, DirectiveMock, &arg):
+			name, value, ok := strings.Cut(arg, "=")
+			name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+			if !ok || name == "" || value == "" {
+				return nil, fmt.Errorf("line %d: mock must look like: name = value", line)
+			}
+			test.Mocks = append(test.Mocks, &Mock{Name: name, Value: value})
+			found = true
+		case cut(s, DirectiveExpect, &arg):
+			test.Expect = append(test.Expect, arg)
+			found = true
+		case cut(s, DirectiveReject, &arg):
+			test.Reject = append(test.Reject, arg)
+			found = true
+		case cut(s, DirectiveError, &arg):
+			test.Errors = append(test.Errors, arg)
+			found = true
+		case cut(s, DirectiveHostname, &arg):
+			test.Hostname = arg
+			found = true
+		}
+		if found && arg == "" {
+			return nil, fmt.Errorf("line %d: empty directive", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(test.Errors) > 0 && (len(test.Expect) > 0 || len(test.Reject) > 0) {
+		return nil, fmt.Errorf("can't combine error directives with graph assertions")
+	}
+	return test, nil
+}
+
+// cut is a helper to match a directive at the start of a comment. If found, it
+// stores the trimmed remainder in arg.
+func cut(s, directive string, arg *string) bool {
+	after, found := strings.CutPrefix(s, directive)
+	if found {
+		*arg = strings.TrimSpace(after)
+	}
+	return found
+}
+
+// Result is the outcome of running a single test.
+type Result struct {
+	Test     *Test
+	Duration time.Duration
+
+	// Failures is the list of reasons this test failed. It is empty if the
+	// test passed.
+	Failures []string
+
+	// Graph is the text form of the output graph. It's only kept when a
+	// graph assertion failed, so that the user can see what was produced.
+	Graph []string
+}
+
+// Passed returns true if the test passed.
+func (obj *Result) Passed() bool {
+	return len(obj.Failures) == 0
+}
+
+// Runner runs tests.
+type Runner struct {
+	// ModulePath is the directory where imported modules are searched for.
+	ModulePath string
+
+	// Timeout is how long we wait for the function engine to produce its
+	// first values. If this is zero, then DefaultTimeout is used.
+	Timeout time.Duration
+
+	Debug bool
+	Logf  func(format string, v ...interface{})
+}
+
+// Run runs a single test. Errors running the test are reported as failures in
+// the result, since a broken test file shouldn't stop the others from running.
+func (obj *Runner) Run(ctx context.Context, test *Test) *Result {
+	result := &Result{
+		Test: test,
+	}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+	failf := func(format string, v ...interface{}) {
+		result.Failures = append(result.Failures, fmt.Sprintf(format, v...))
+	}
+
+	restore, err := installMocks(test.Mocks)
+	if err != nil {
+		failf("%v", err)
+		return result
+	}
+	defer restore()
+
+	lines, err := obj.run(ctx, test)
+	if len(test.Errors) > 0 {
+		if err == nil {
+			failf("expected an error, but the code ran successfully")
+			return result
+		}
+		for _, x := range test.Errors {
+			if !strings.Contains(err.Error(), x) {
+				failf("expected an error containing %q, got: %v", x, err)
+			}
+		}
+		return result
+	}
+	if err != nil {
+		failf("%v", err)
+		return result
+	}
+
+	for _, x := range test.Expect {
+		if !containsLine(lines, x) {
+			failf("missing: %s", x)
+		}
+	}
+	for _, x := range test.Reject {
+		if containsLine(lines, x) {
+			failf("unexpected: %s", x)
+		}
+	}
+	if !result.Passed() {
+		result.Graph = lines // show what we got instead
+	}
+	return result
+}
+
+// run runs the code in the test, and returns the lines of the output graph.
+func (obj *Runner) run(ctx context.Context, test *Test) (_ []string, reterr error) {
+	logf := func(format string, v ...interface{}) {
+		if obj.Debug && obj.Logf != nil {
+			obj.Logf(test.Name()+": "+format, v...)
+		}
+	}
+	timeout := obj.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	mmFs := afero.NewMemMapFs()
+	afs := &afero.Afero{Fs: mmFs} // wrap so that we're implementing ioutil
+	fs := &util.AferoFs{Afero: afs}
+
+	// This does the type unification and copies the code and any imported
+	// modules into our fs, exactly like `mgmt run` does.
+	hostname := test.Hostname
+	info := &gapi.Info{
+		Args: &cliUtil.LangArgs{
+			Input:      test.Path,
+			Depth:      -1,
+			ModulePath: obj.ModulePath,
+		},
+		Flags: &gapi.Flags{
+			Hostname: &hostname,
+		},
+		Fs:    fs,
+		Debug: obj.Debug,
+		Logf:  logf,
+	}
+	deploy, err := (&langGAPI.GAPI{}).Cli(info)
+	if err != nil {
+		return nil, err
+	}
+	langGapi, ok := deploy.GAPI.(*langGAPI.GAPI)
+	if !ok {
+		// programming error
+		return nil, fmt.Errorf("unexpected gapi: %T", deploy.GAPI)
+	}
+
+	prefix, err := os.MkdirTemp("", "mgmt-test-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(prefix)
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l := &lang.Lang{
+		Fs:       fs,
+		FsURI:    fs.URI(),
+		Input:    "/" + interfaces.MetadataFilename, // start path in fs
+		Data:     langGapi.Data,
+		Hostname: hostname,
+		Local: (&local.API{
+			Prefix: prefix,
+			Debug:  obj.Debug,
+			Logf:   logf,
+		}).Init(),
+		Prefix: prefix,
+		Debug:  obj.Debug,
+		Logf:   logf,
+	}
+	if err := l.Init(ctx); err != nil {
+		return nil, errwrap.Wrapf(err, "init failed")
+	}
+	defer l.Cleanup()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := l.Run(ctx); err != nil {
+			reterr = errwrap.Append(reterr, err)
+		}
+	}()
+	defer cancel() // shutdown the Run
+
+	// we only wait for the first event, instead of the continuous stream
+	select {
+	case err, ok := <-l.Stream():
+		if !ok {
+			return nil, fmt.Errorf("stream closed without event")
+		}
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "stream failed")
+		}
+
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out waiting for the function engine after %s", timeout)
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	graph, err := l.Interpret()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range graph.Vertices() {
+		res, ok := v.(engine.Res)
+		if !ok {
+			return nil, fmt.Errorf("unexpected non-resource: %s", v)
+		}
+		if err := engine.Validate(res); err != nil {
+			return nil, errwrap.Wrapf(err, "the resource %s is invalid", res)
+		}
+	}
+	if !deploy.NoAutoEdges {
+		if err := autoedge.AutoEdge(graph, obj.Debug, logf); err != nil {
+			return nil, errwrap.Wrapf(err, "automatic edges failed")
+		}
+	}
+
+	return Lines(graph)
+}
+
+// Lines returns the text form of a resource graph which the expect and reject
+// directives are compared with. It's the same format as the OUTPUT section of
+// the TestAstFunc fixtures, one line per vertex, edge and non-zero field.
+func Lines(graph *pgraph.Graph) ([]string, error) {
+	lines := []string{}
+	if s := graph.Sprint(); s != "" {
+		lines = strings.Split(s, "\n")
+	}
+	for _, v := range graph.Vertices() {
+		res, ok := v.(engine.Res)
+		if !ok {
+			return nil, fmt.Errorf("unexpected non-resource: %s", v)
+		}
+		m, err := engineUtil.ResToParamValues(res)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "can't read resource %s", res)
+		}
+		for field, value := range m {
+			lines = append(lines, fmt.Sprintf("Field: %s[%s].%s = %s", res.Kind(), res.Name(), field, value))
+		}
+	}
+	sort.Strings(lines) // sort for determinism
+	return lines, nil
+}
+
+// containsLine returns true if the line is in the list. Edge lines match with
+// or without the trailing edge name, since that's rarely interesting.
+func containsLine(lines []string, line string) bool {
+	for _, x := range lines {
+		if x == line {
+			return true
+		}
+		if strings.HasPrefix(x, "Edge: ") && !strings.Contains(line, edgeNameSep) {
+			if before, _, _ := strings.Cut(x, edgeNameSep); before == line {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// installMocks replaces each mocked function in the registry. It returns a
+// function which puts the originals back.
+func installMocks(mocks []*Mock) (func(), error) {
+	restores := []func(){}
+	restore := func() {
+		for i := len(restores) - 1; i >= 0; i-- { // in reverse
+			restores[i]()
+		}
+	}
+	for _, mock := range mocks {
+		fn, err := mockFunc(mock)
+		if err != nil {
+			restore()
+			return nil, errwrap.Wrapf(err, "can't mock %s", mock.Name)
+		}
+		r, err := funcs.Mock(mock.Name, fn)
+		if err != nil {
+			restore()
+			return nil, errwrap.Wrapf(err, "can't mock %s", mock.Name)
+		}
+		restores = append(restores, r)
+	}
+	return restore, nil
+}
+
+// mockFunc builds a replacement for the named function which has the same
+// signature, but which ignores its args and always returns the mock value.
+// Polymorphic functions can't be mocked since we wouldn't know which type the
+// value should have.
+func mockFunc(mock *Mock) (func() interfaces.Func, error) {
+	f, err := funcs.Lookup(mock.Name)
+	if err != nil {
+		return nil, fmt.Errorf("function not found")
+	}
+	sig := f.Info().Sig
+	if sig == nil || sig.Kind != types.KindFunc || sig.HasUni() {
+		return nil, fmt.Errorf("can't mock a polymorphic function")
+	}
+	value, err := ParseValue(mock.Value, sig.Out)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() interfaces.Func {
+		return &wrapped.Func{
+			Name: mock.Name,
+			FuncInfo: &wrapped.Info{
+				Pure: true,
+				Memo: false,
+				Fast: true,
+				Spec: true,
+			},
+			Type: sig,
+			Fn: &types.FuncValue{
+				T: sig,
+				V: func(ctx context.Context, args []types.Value) (types.Value, error) {
+					return value, nil
+				},
+			},
+		}
+	}, nil
+}
+
+// ParseValue parses an mcl literal into a value of the expected type. Lists,
+// maps and structs may be nested, but only literals are allowed inside them.
+func ParseValue(s string, typ *types.Type) (types.Value, error) {
+	xast, err := parser.LexParse(strings.NewReader("$x = " + s))
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "can't parse value `%s`", s)
+	}
+	prog, ok := xast.(*ast.StmtProg)
+	if !ok || len(prog.Body) != 1 {
+		return nil, fmt.Errorf("can't parse value `%s`", s)
+	}
+	bind, ok := prog.Body[0].(*ast.StmtBind)
+	if !ok {
+		return nil, fmt.Errorf("can't parse value `%s`", s)
+	}
+	return literal(bind.Value, typ)
+}
+
+// literal converts the expression into a value of the expected type.
+func literal(expr interfaces.Expr, typ *types.Type) (types.Value, error) {
+	switch x := expr.(type) {
+	case *ast.ExprBool:
+		if typ.Kind == types.KindBool {
+			return &types.BoolValue{V: x.V}, nil
+		}
+
+	case *ast.ExprStr:
+		if typ.Kind == types.KindStr {
+			return &types.StrValue{V: x.V}, nil
+		}
+
+	case *ast.ExprInt:
+		if typ.Kind == types.KindInt {
+			return &types.IntValue{V: x.V}, nil
+		}
+
+	case *ast.ExprFloat:
+		if typ.Kind == types.KindFloat {
+			return &types.FloatValue{V: x.V}, nil
+		}
+
+	case *ast.ExprList:
+		if typ.Kind != types.KindList {
+			break
+		}
+		list := types.NewList(typ)
+		for _, e := range x.Elements {
+			v, err := literal(e, typ.Val)
+			if err != nil {
+				return nil, err
+			}
+			if err := list.Add(v); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+
+	case *ast.ExprMap:
+		if typ.Kind != types.KindMap {
+			break
+		}
+		m := types.NewMap(typ)
+		for _, kv := range x.KVs {
+			k, err := literal(kv.Key, typ.Key)
+			if err != nil {
+				return nil, err
+			}
+			v, err := literal(kv.Val, typ.Val)
+			if err != nil {
+				return nil, err
+			}
+			if err := m.Add(k, v); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case *ast.ExprStruct:
+		if typ.Kind != types.KindStruct {
+			break
+		}
+		if len(x.Fields) != len(typ.Ord) {
+			return nil, fmt.Errorf("struct needs %d fields, got %d", len(typ.Ord), len(x.Fields))
+		}
+		st := types.NewStruct(typ)
+		for _, field := range x.Fields {
+			t, exists := typ.Map[field.Name]
+			if !exists {
+				return nil, fmt.Errorf("struct has no field named %s", field.Name)
+			}
+			v, err := literal(field.Value, t)
+			if err != nil {
+				return nil, err
+			}
+			if err := st.Set(field.Name, v); err != nil {
+				return nil, err
+			}
+		}
+		return st, nil
+
+	default:
+		return nil, fmt.Errorf("value must be a literal, got: %s", expr)
+	}
+
+	return nil, fmt.Errorf("value `%s` is not of type %s", expr, typ)
+}
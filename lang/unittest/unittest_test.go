@@ -0,0 +1,318 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package unittest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/purpleidea/mgmt/engine/resources" // import so the resources register
+	"github.com/purpleidea/mgmt/lang/types"
+)
+
+func TestParseTest1(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		code string
+		fail bool
+		exp  *Test
+	}
+	testCases := []test{
+		{
+			name: "empty",
+			code: `noop "n1" {}`,
+			exp: &Test{
+				Path:     "x_test.mcl",
+				Hostname: DefaultHostname,
+			},
+		},
+		{
+			name: "all directives",
+			code: `
+				# a normal comment
+				# mock: sys.cpu_count = 8
+				# mock: os.is_family_debian=true
+				# hostname: h1
+				# expect: Vertex: noop[n1]
+				#expect: Edge: noop[n1] -> noop[n2]
+				# reject: Vertex: noop[n3]
+				noop "n1" {} # expect: this is not a directive
+			`,
+			exp: &Test{
+				Path:     "x_test.mcl",
+				Hostname: "h1",
+				Mocks: []*Mock{
+					{Name: "sys.cpu_count", Value: "8"},
+					{Name: "os.is_family_debian", Value: "true"},
+				},
+				Expect: []string{
+					"Vertex: noop[n1]",
+					"Edge: noop[n1] -> noop[n2]",
+				},
+				Reject: []string{
+					"Vertex: noop[n3]",
+				},
+			},
+		},
+		{
+			name: "bad mock",
+			code: `# mock: sys.cpu_count`,
+			fail: true,
+		},
+		{
+			name: "empty expect",
+			code: `# expect:`,
+			fail: true,
+		},
+		{
+			name: "error with assertions",
+			code: "# error: could not unify\n# expect: Vertex: noop[n1]",
+			fail: true,
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			out, err := ParseTest("x_test.mcl", []byte(tc.code))
+			if !tc.fail && err != nil {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: error: %+v", index, err)
+				return
+			}
+			if tc.fail && err == nil {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: expected error, got: %+v", index, out)
+				return
+			}
+			if tc.fail {
+				return
+			}
+			if !reflect.DeepEqual(out, tc.exp) {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: got: %+v", index, out)
+				t.Errorf("test #%d: exp: %+v", index, tc.exp)
+			}
+		})
+	}
+}
+
+func TestParseValue1(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		typ  string
+		code string
+		fail bool
+		exp  string // the value as a string
+	}
+	testCases := []test{
+		{"bool", "bool", "true", false, "true"},
+		{"str", "str", `"hello"`, false, `"hello"`},
+		{"int", "int", "42", false, "42"},
+		{"float", "float", "3.5", false, "3.5"},
+		{"list", "[]int", "[1, 2, 3,]", false, "[1, 2, 3]"},
+		{"empty list", "[]str", "[]", false, "[]"},
+		{"map", "map{str: int}", `{"a" => 1,}`, false, `{"a": 1}`},
+		{"struct", "struct{a int; b str}", `struct{b => "x", a => 1,}`, false, `struct{a: 1; b: "x"}`},
+		{"nested", "[]map{str: bool}", `[{"x" => true,},]`, false, `[{"x": true}]`},
+		{"wrong type", "int", `"42"`, true, ""},
+		{"wrong elem", "[]int", `[1, "x",]`, true, ""},
+		{"missing field", "struct{a int; b str}", `struct{a => 1,}`, true, ""},
+		{"not a literal", "int", "1 + 2", true, ""},
+		{"junk", "int", "{{", true, ""},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			out, err := ParseValue(tc.code, types.NewType(tc.typ))
+			if !tc.fail && err != nil {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: error: %+v", index, err)
+				return
+			}
+			if tc.fail && err == nil {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: expected error, got: %s", index, out)
+				return
+			}
+			if tc.fail {
+				return
+			}
+			if s := out.String(); s != tc.exp {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: got: %s", index, s)
+				t.Errorf("test #%d: exp: %s", index, tc.exp)
+			}
+		})
+	}
+}
+
+func TestRun1(t *testing.T) {
+	type test struct { // an individual test
+		name string
+		code string
+		fail []string // expected failures, empty if it passes
+	}
+	testCases := []test{
+		{
+			name: "pass",
+			code: `
+				# mock: sys.cpu_count = 42
+				# hostname: h1
+				# expect: Vertex: test[h1]
+				# expect: Field: test[h1].Int64Ptr = 42
+				# expect: Edge: noop[n1] -> test[h1]
+				# reject: Vertex: noop[n2]
+				import "sys"
+				noop "n1" {}
+				test "${hostname}" {
+					int64ptr => sys.cpu_count(),
+				}
+				Noop["n1"] -> Test["${hostname}"]
+			`,
+		},
+		{
+			name: "assertions fail",
+			code: `
+				# expect: Vertex: noop[n2]
+				# reject: Vertex: noop[n1]
+				noop "n1" {}
+			`,
+			fail: []string{
+				"missing: Vertex: noop[n2]",
+				"unexpected: Vertex: noop[n1]",
+			},
+		},
+		{
+			name: "expected error",
+			code: `
+				# error: could not unify types
+				test "t1" {
+					int64ptr => "not an int",
+				}
+			`,
+		},
+		{
+			name: "unexpected success",
+			code: `
+				# error: could not unify types
+				noop "n1" {}
+			`,
+			fail: []string{
+				"expected an error, but the code ran successfully",
+			},
+		},
+		{
+			name: "polymorphic mock",
+			code: `
+				# mock: len = 3
+				noop "n1" {}
+			`,
+			fail: []string{
+				"can't mock len: can't mock a polymorphic function",
+			},
+		},
+	}
+
+	dir := t.TempDir()
+	for index, tc := range testCases { // run all the tests
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("t%d_test.mcl", index))
+			code := []byte(strings.ReplaceAll(tc.code, "\t\t\t\t", ""))
+			if err := os.WriteFile(path, code, 0600); err != nil {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: error: %+v", index, err)
+				return
+			}
+			test, err := ParseTest(path, code)
+			if err != nil {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: error: %+v", index, err)
+				return
+			}
+			runner := &Runner{
+				Debug: testing.Verbose(), // set via the -test.v flag to `go test`
+				Logf: func(format string, v ...interface{}) {
+					t.Logf(fmt.Sprintf("test #%d: ", index)+format, v...)
+				},
+			}
+			result := runner.Run(context.Background(), test)
+			if !reflect.DeepEqual(result.Failures, tc.fail) {
+				t.Errorf("test #%d: FAIL", index)
+				t.Errorf("test #%d: got: %q", index, result.Failures)
+				t.Errorf("test #%d: exp: %q", index, tc.fail)
+			}
+		})
+	}
+}
+
+func TestWriteJUnit1(t *testing.T) {
+	suites := []*Suite{
+		{
+			Dir: "modules/web",
+			Results: []*Result{
+				{
+					Test: &Test{Path: "modules/web/a_test.mcl"},
+				},
+				{
+					Test:     &Test{Path: "modules/web/b_test.mcl"},
+					Failures: []string{"missing: Vertex: noop[n1]"},
+					Graph:    []string{"Vertex: noop[n2]"},
+				},
+			},
+		},
+	}
+	b := &bytes.Buffer{}
+	if err := WriteJUnit(b, suites); err != nil {
+		t.Errorf("error: %+v", err)
+		return
+	}
+	exp := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="2" failures="1" time="0.000">
+	<testsuite name="modules/web" tests="2" failures="1" time="0.000">
+		<testcase name="a_test.mcl" classname="modules/web" time="0.000"></testcase>
+		<testcase name="b_test.mcl" classname="modules/web" time="0.000">
+			<failure message="missing: Vertex: noop[n1]">missing: Vertex: noop[n1]&#xA;graph:&#xA;Vertex: noop[n2]</failure>
+		</testcase>
+	</testsuite>
+</testsuites>
+`
+	if s := b.String(); s != exp {
+		t.Errorf("got:\n%s", s)
+		t.Errorf("exp:\n%s", exp)
+	}
+}
@@ -0,0 +1,170 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/etcd/deployer"
+	"github.com/purpleidea/mgmt/gapi"
+	"github.com/purpleidea/mgmt/pgp"
+	"github.com/purpleidea/mgmt/util"
+	"github.com/purpleidea/mgmt/util/errwrap"
+
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/openpgp"
+)
+
+// VerifyDeploy checks the signature of the deploy with this id against the
+// keyring, and returns the key that signed it. The data is the encoded deploy,
+// as it is stored. The deploy file system can change at any time, so the files
+// are checked in a copy of it, which is returned so that they're only ever read
+// from there.
+func VerifyDeploy(ctx context.Context, world engine.World, keyring openpgp.EntityList, id uint64, deploy *gapi.Deploy, data string) (engine.Fs, *openpgp.Entity, error) {
+	signature, err := world.GetDeploySignature(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if signature == "" {
+		return nil, nil, fmt.Errorf("the deploy isn't signed")
+	}
+	hash, err := world.GetDeployHash(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pHash string
+	if id > 1 {
+		if pHash, err = world.GetDeployHash(ctx, id-1); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The files are checked here, so the GAPI must read them from the same
+	// file system that we check.
+	uri := deploy.FsURI
+	if uri == "" {
+		return nil, nil, fmt.Errorf("the deploy has no file system to check")
+	}
+	if deploy.GAPI == nil || deploy.GAPI.Info().URI != uri {
+		return nil, nil, fmt.Errorf("the gapi doesn't use the signed file system")
+	}
+	fs, err := world.Fs(uri)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf(err, "can't open the deploy file system")
+	}
+	verifiedFs, err := copyDeployFs(fs, uri)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf(err, "can't copy the deploy file system")
+	}
+	fsHash, err := deployer.FsHash(verifiedFs)
+	if err != nil {
+		return nil, nil, errwrap.Wrapf(err, "can't hash the deploy file system")
+	}
+
+	msg := deployer.SignedMessage(id, hash, pHash, data, fsHash)
+	entity, err := pgp.Verify(keyring, msg, signature)
+	if err != nil {
+		return nil, nil, err
+	}
+	return verifiedFs, entity, nil
+}
+
+// VerifyRollback checks that this host may go back to the deploy with this id,
+// from the newest deploy that it ran after checking its signature. Only the
+// signed rollout of the newest deploy can send a host back, and only if it was
+// rolled back, or if the host was never admitted to it. Otherwise an old state
+// of a rollout, with fewer hosts in it, could be replayed to send us back.
+func VerifyRollback(ctx context.Context, world engine.World, keyring openpgp.EntityList, hostname string, id, newest uint64) error {
+	max, err := world.GetMaxDeployID(ctx)
+	if err != nil {
+		return err
+	}
+	rollout, err := world.GetRollout(ctx, max)
+	if err != nil {
+		return err
+	}
+	if rollout == nil || rollout.ID != max {
+		return fmt.Errorf("deploy id %d has no rollout", max)
+	}
+	if rollout.Signature == "" {
+		return fmt.Errorf("the rollout of deploy id %d isn't signed", max)
+	}
+	msg, err := rollout.SignedMessage()
+	if err != nil {
+		return err
+	}
+	if _, err := pgp.Verify(keyring, msg, rollout.Signature); err != nil {
+		return errwrap.Wrapf(err, "can't verify the rollout of deploy id %d", max)
+	}
+	if x := rollout.DeployFor(hostname); x != id {
+		return fmt.Errorf("the rollout of deploy id %d says to run id %d", max, x)
+	}
+	if rollout.Stage != deployer.RolloutStageRolledBack && max <= newest {
+		return fmt.Errorf("we were already admitted to the rollout of deploy id %d", max)
+	}
+	return nil
+}
+
+// copyDeployFs copies the deploy file system into memory. The copy keeps the
+// same URI, so that it can be used in place of the original one.
+func copyDeployFs(fs engine.Fs, uri string) (engine.Fs, error) {
+	scheme, p, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid uri: %s", uri)
+	}
+	afs := &afero.Afero{Fs: afero.NewMemMapFs()}
+	if err := util.CopyFs(fs, afs, "/", "/", true, false); err != nil {
+		return nil, err
+	}
+	return &util.AferoFs{
+		Afero:  afs,
+		Scheme: scheme,
+		Path:   p,
+	}, nil
+}
+
+// verifiedWorld is a World which returns the copy of the deploy file system
+// that was checked against the deploy signature, instead of opening it again.
+type verifiedWorld struct {
+	engine.World
+
+	fs engine.Fs
+}
+
+// Fs returns the verified copy for its URI, and opens any other one normally.
+func (obj *verifiedWorld) Fs(uri string) (engine.Fs, error) {
+	if uri == obj.fs.URI() {
+		return obj.fs, nil
+	}
+	return obj.World.Fs(uri)
+}
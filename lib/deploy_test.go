@@ -0,0 +1,240 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/purpleidea/mgmt/engine"
+	"github.com/purpleidea/mgmt/etcd/deployer"
+	"github.com/purpleidea/mgmt/pgp"
+	"github.com/purpleidea/mgmt/util"
+
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestCopyDeployFs1(t *testing.T) {
+	afs := &afero.Afero{Fs: afero.NewMemMapFs()}
+	if err := afs.MkdirAll("/dir/", 0755); err != nil {
+		t.Errorf("could not make dir: %+v", err)
+		return
+	}
+	if err := afs.WriteFile("/metadata.yaml", []byte("main: main.mcl\n"), 0644); err != nil {
+		t.Errorf("could not write file: %+v", err)
+		return
+	}
+	if err := afs.WriteFile("/dir/main.mcl", []byte("print \"hello\" {}\n"), 0644); err != nil {
+		t.Errorf("could not write file: %+v", err)
+		return
+	}
+	fs := &util.AferoFs{Afero: afs}
+
+	uri := "etcdfs:///fs/deploy-1"
+	cp, err := copyDeployFs(fs, uri)
+	if err != nil {
+		t.Errorf("could not copy: %+v", err)
+		return
+	}
+	if cp.URI() != uri {
+		t.Errorf("expected uri: %s, got: %s", uri, cp.URI())
+	}
+
+	exp, err := deployer.FsHash(fs)
+	if err != nil {
+		t.Errorf("could not hash: %+v", err)
+		return
+	}
+	hash, err := deployer.FsHash(cp)
+	if err != nil {
+		t.Errorf("could not hash copy: %+v", err)
+		return
+	}
+	if hash != exp {
+		t.Errorf("the copy has different contents")
+	}
+
+	// The copy must not change when the original does.
+	if err := afs.WriteFile("/dir/main.mcl", []byte("print \"evil\" {}\n"), 0644); err != nil {
+		t.Errorf("could not write file: %+v", err)
+		return
+	}
+	if hash2, err := deployer.FsHash(cp); err != nil || hash2 != hash {
+		t.Errorf("the copy changed: %+v", err)
+	}
+
+	w := &verifiedWorld{fs: cp}
+	if f, err := w.Fs(uri); err != nil || f != cp {
+		t.Errorf("the verified world didn't return the copy: %+v", err)
+	}
+}
+
+// rolloutWorld is a world with a single rollout of the newest deploy.
+type rolloutWorld struct {
+	engine.World // the rest isn't used
+
+	max     uint64
+	rollout *deployer.Rollout
+}
+
+func (obj *rolloutWorld) GetMaxDeployID(ctx context.Context) (uint64, error) {
+	return obj.max, nil
+}
+
+func (obj *rolloutWorld) GetRollout(ctx context.Context, id uint64) (*deployer.Rollout, error) {
+	if obj.rollout == nil || id != obj.max {
+		return nil, nil
+	}
+	rollout := *obj.rollout // copy
+	return &rollout, nil
+}
+
+func TestVerifyRollback1(t *testing.T) {
+	trusted, err := pgp.Generate("deployer", "test", "deployer@example.com", nil)
+	if err != nil {
+		t.Errorf("could not generate key: %+v", err)
+		return
+	}
+	other, err := pgp.Generate("other", "test", "other@example.com", nil)
+	if err != nil {
+		t.Errorf("could not generate key: %+v", err)
+		return
+	}
+	keyring := openpgp.EntityList{trusted.Entity}
+
+	sign := func(key *pgp.PGP, rollout *deployer.Rollout) *deployer.Rollout {
+		msg, err := rollout.SignedMessage()
+		if err != nil {
+			t.Errorf("could not encode: %+v", err)
+			return rollout
+		}
+		if rollout.Signature, err = key.Sign(msg); err != nil {
+			t.Errorf("could not sign: %+v", err)
+		}
+		return rollout
+	}
+
+	type test struct { // an individual test
+		name    string
+		max     uint64
+		rollout *deployer.Rollout
+		id      uint64 // where h1 is sent
+		newest  uint64 // the newest deploy that h1 ran
+		fail    bool
+	}
+	testCases := []test{
+		{
+			name:   "no rollout",
+			max:    7,
+			id:     4,
+			newest: 7,
+			fail:   true,
+		},
+		{
+			name:    "unsigned roll back",
+			max:     7,
+			rollout: &deployer.Rollout{ID: 7, Previous: 4, Stage: deployer.RolloutStageRolledBack, Hosts: []string{"h1"}},
+			id:      4,
+			newest:  7,
+			fail:    true,
+		},
+		{
+			name:    "untrusted roll back",
+			max:     7,
+			rollout: sign(other, &deployer.Rollout{ID: 7, Previous: 4, Stage: deployer.RolloutStageRolledBack, Hosts: []string{"h1"}}),
+			id:      4,
+			newest:  7,
+			fail:    true,
+		},
+		{
+			name:    "signed roll back",
+			max:     7,
+			rollout: sign(trusted, &deployer.Rollout{ID: 7, Previous: 4, Stage: deployer.RolloutStageRolledBack, Hosts: []string{"h1"}}),
+			id:      4,
+			newest:  7,
+			fail:    false,
+		},
+		{
+			name: "modified roll back",
+			max:  7,
+			rollout: func() *deployer.Rollout {
+				rollout := sign(trusted, &deployer.Rollout{ID: 7, Previous: 4, Stage: deployer.RolloutStageRolledBack})
+				rollout.Previous = 2
+				return rollout
+			}(),
+			id:     2,
+			newest: 7,
+			fail:   true,
+		},
+		{
+			// an old state of the rollout before h1 was admitted
+			name:    "replayed canary",
+			max:     7,
+			rollout: sign(trusted, &deployer.Rollout{ID: 7, Previous: 4, Stage: deployer.RolloutStageCanary, Hosts: []string{"h2"}}),
+			id:      4,
+			newest:  7,
+			fail:    true,
+		},
+		{
+			// h1 ran 7 as a canary, and isn't in the next rollout
+			name:    "not admitted yet",
+			max:     8,
+			rollout: sign(trusted, &deployer.Rollout{ID: 8, Previous: 4, Stage: deployer.RolloutStageCanary, Hosts: []string{"h2"}}),
+			id:      4,
+			newest:  7,
+			fail:    false,
+		},
+		{
+			name:    "another id",
+			max:     8,
+			rollout: sign(trusted, &deployer.Rollout{ID: 8, Previous: 4, Stage: deployer.RolloutStageCanary, Hosts: []string{"h2"}}),
+			id:      3,
+			newest:  7,
+			fail:    true,
+		},
+	}
+
+	for index, tc := range testCases { // run all the tests
+		t.Run(fmt.Sprintf("test #%d (%s)", index, tc.name), func(t *testing.T) {
+			world := &rolloutWorld{max: tc.max, rollout: tc.rollout}
+			err := VerifyRollback(context.Background(), world, keyring, "h1", tc.id, tc.newest)
+			if !tc.fail && err != nil {
+				t.Errorf("rollback should be allowed: %+v", err)
+			}
+			if tc.fail && err == nil {
+				t.Errorf("rollback should be refused")
+			}
+		})
+	}
+}
@@ -40,6 +40,7 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/purpleidea/mgmt/converger"
@@ -50,6 +51,7 @@ import (
 	_ "github.com/purpleidea/mgmt/engine/resources" // let register's run
 	"github.com/purpleidea/mgmt/etcd"
 	"github.com/purpleidea/mgmt/etcd/chooser"
+	"github.com/purpleidea/mgmt/etcd/deployer"
 	etcdInterfaces "github.com/purpleidea/mgmt/etcd/interfaces"
 	etcdSSH "github.com/purpleidea/mgmt/etcd/ssh"
 	"github.com/purpleidea/mgmt/gapi"
@@ -72,6 +74,16 @@ const (
 
 	// StoragePrefix is the etcd prefix where all our fs data lives.
 	StoragePrefix = "/storage"
+
+	// EngineDir is the name of the sub directory of the working prefix which
+	// the resource engine uses.
+	EngineDir = "engine"
+
+	// PlanFormatHuman is the plan format intended to be read by people.
+	PlanFormatHuman = "human"
+
+	// PlanFormatJSON is the plan format intended to be read by machines.
+	PlanFormatJSON = "json"
 )
 
 // Config is a struct of all the configuration values for the Main struct. By
@@ -123,6 +135,14 @@ type Config struct {
 	// Noop globally forces all resources into no-op mode.
 	Noop bool `arg:"--noop" help:"globally force all resources into no-op mode"`
 
+	// Plan runs CheckApply once on every resource of the first graph
+	// without applying anything, reports what would change, and exits.
+	Plan bool `arg:"--plan" help:"report what would change without applying anything and exit"`
+
+	// PlanFormat is the output format of the plan report. It can be
+	// either `human` or `json`.
+	PlanFormat string `arg:"--plan-format" default:"human" help:"output format of the plan report: human or json"`
+
 	// Sema adds a semaphore with this lock count to each resource. This is
 	// useful for reducing parallelism.
 	Sema int `arg:"--sema" default:"-1" help:"globally add a semaphore to downloads with this lock count"`
@@ -202,11 +222,25 @@ type Config struct {
 	// PgpIdentity is the user string used for pgp identity.
 	PgpIdentity *string `arg:"--pgp-identity" help:"default identity used for generation"`
 
+	// DeployKeyring is the path to a keyring of the public keys which we
+	// trust to sign deploys. If it's set, then we refuse every deploy from
+	// the cluster which isn't signed by one of them.
+	DeployKeyring string `arg:"--deploy-keyring,env:MGMT_DEPLOY_KEYRING" help:"only run deploys signed by a key in this keyring"`
+
 	// Prometheus enables prometheus metrics.
 	Prometheus bool `arg:"--prometheus" help:"start a prometheus instance"`
 
 	// PrometheusListen is the prometheus instance bind specification.
 	PrometheusListen string `arg:"--prometheus-listen" help:"specify prometheus instance binding"`
+
+	// TraceFuncs is an optional path to a file where a trace of every
+	// change to the running function graph is appended as JSON lines. This
+	// is a local setting of this host, and isn't part of the deploy.
+	TraceFuncs string `arg:"--trace-funcs" help:"append a JSON trace of every function graph change to this file"`
+
+	// TraceListen is an optional address to serve the live function graph
+	// trace on over http. This is a local setting of this host too.
+	TraceListen string `arg:"--trace-listen" help:"serve the live function graph trace over http on this address"`
 }
 
 // Main is the main struct for running the mgmt logic.
@@ -229,6 +263,8 @@ type Main struct {
 	embdEtcd *etcd.EmbdEtcd // TODO: can be an interface in the future...
 	ge       *graph.Engine
 
+	planReport *graph.PlanReport // result of the plan mode
+
 	exit    *util.EasyExit // exit signal
 	cleanup []func() error // list of functions to run on close
 }
@@ -250,6 +286,13 @@ func (obj *Main) Validate() error {
 		return fmt.Errorf("choosing a prefix and the request for a tmp prefix is illogical")
 	}
 
+	if obj.Plan && obj.Deploy == nil {
+		return fmt.Errorf("the plan mode needs a frontend to build a graph with")
+	}
+	if obj.Plan && obj.PlanFormat != PlanFormatHuman && obj.PlanFormat != PlanFormatJSON {
+		return fmt.Errorf("unknown plan format: %s", obj.PlanFormat)
+	}
+
 	return nil
 }
 
@@ -330,35 +373,10 @@ func (obj *Main) Run() error {
 		return fmt.Errorf("hostname cannot be empty")
 	}
 
-	user, err := user.Current()
+	prefix, err := DefaultPrefix(obj.Program)
 	if err != nil {
-		return errwrap.Wrapf(err, "can't get current user")
+		return err
 	}
-
-	// Use systemd StateDirectory if set. If not, use XDG_CACHE_DIR unless user
-	// is root, then use /var/lib/mgmt/.
-	var prefix = fmt.Sprintf("/var/lib/%s/", obj.Program) // default prefix
-	stateDir := os.Getenv("STATE_DIRECTORY")
-	// Ensure there is a / at the end of the directory path.
-	if stateDir != "" && !strings.HasSuffix(stateDir, "/") {
-		stateDir = stateDir + "/"
-	}
-
-	xdg := os.Getenv("XDG_CACHE_HOME")
-	// Ensure there is a / at the end of the directory path.
-	if xdg != "" && !strings.HasSuffix(xdg, "/") {
-		xdg = xdg + "/"
-	}
-	if xdg == "" && user.HomeDir != "" {
-		xdg = fmt.Sprintf("%s/.cache/%s/", user.HomeDir, obj.Program)
-	}
-
-	if stateDir != "" {
-		prefix = stateDir
-	} else if user.Uid != "0" {
-		prefix = xdg
-	}
-
 	if p := obj.Prefix; p != nil {
 		prefix = *p
 	}
@@ -651,16 +669,72 @@ func (obj *Main) Run() error {
 		}
 	}()
 
+	// Each host reports how it's doing with the deploy that it runs, so that
+	// a rollout can decide when to continue, or when to roll back. We only
+	// learn about convergence if there's a converged timeout.
+	var appliedID uint64 // the applied deploy id, accessed atomically
+	deployStatus := func(deploy *gapi.Deploy, status string) {
+		if deploy == nil || deploy.ID == 0 { // not from the cluster
+			return
+		}
+		if err := world.SetDeployStatus(exitCtx, deploy.ID, hostname, status); err != nil {
+			Logf("deploy: could not set %s status: %+v", status, err)
+		}
+	}
+	// deployFailed reports that the deploy failed. In plan mode there is no
+	// later graph which could fix it, so we exit with the error instead of
+	// waiting forever.
+	deployFailed := func(deploy *gapi.Deploy, err error) {
+		deployStatus(deploy, deployer.DeployStatusFailed)
+		if obj.Plan {
+			obj.exit.Done(err) // trigger exit
+		}
+	}
+	converger.AddStateFn("deploy-status", func(converged bool) error {
+		if id := atomic.LoadUint64(&appliedID); converged && id != 0 {
+			deployStatus(&gapi.Deploy{ID: id}, deployer.DeployStatusConverged)
+		}
+		return nil
+	})
+	defer converger.RemoveStateFn("deploy-status")
+
+	// only run deploys from the trusted deployers, if we were given any
+	var verifyDeploy func(ctx context.Context, deploy *gapi.Deploy, data string) error
+	var verifyRollback func(ctx context.Context, id, newest uint64) error
+	if p := obj.DeployKeyring; p != "" {
+		keyring, err := pgp.ReadKeyring(p)
+		if err != nil {
+			return errwrap.Wrapf(err, "can't read deploy keyring")
+		}
+		Logf("deploy: trusting %d key(s) from: %s", len(keyring), p)
+		verifyDeploy = func(ctx context.Context, deploy *gapi.Deploy, data string) error {
+			fs, entity, err := VerifyDeploy(ctx, world, keyring, deploy.ID, deploy, data)
+			if err != nil {
+				return err
+			}
+			for name := range entity.Identities {
+				Logf("deploy: id %d was signed by: %s", deploy.ID, name)
+				break // just show one
+			}
+			// The GAPI only ever reads the files that we checked.
+			deploy.SetVerifiedFs(fs)
+			return nil
+		}
+		verifyRollback = func(ctx context.Context, id, newest uint64) error {
+			return VerifyRollback(ctx, world, keyring, hostname, id, newest)
+		}
+	}
+
 	obj.ge = &graph.Engine{
-		Program:   obj.Program,
-		Version:   obj.Version,
-		Hostname:  hostname,
-		Converger: converger,
-		Local:     localAPI,
-		World:     world,
-		Prefix:    fmt.Sprintf("%s/", path.Join(prefix, "engine")),
-		//Prometheus: prom, // TODO: implement this via a general Status API
-		Debug: obj.Debug,
+		Program:    obj.Program,
+		Version:    obj.Version,
+		Hostname:   hostname,
+		Converger:  converger,
+		Local:      localAPI,
+		World:      world,
+		Prefix:     fmt.Sprintf("%s/", path.Join(prefix, EngineDir)),
+		Prometheus: prom,
+		Debug:      obj.Debug,
 		Logf: func(format string, v ...interface{}) {
 			obj.Logf("engine: "+format, v...)
 		},
@@ -724,12 +798,14 @@ func (obj *Main) Run() error {
 					continue
 				}
 				mainDeploy = deploy // save this one
+				atomic.StoreUint64(&appliedID, 0)
 				if id := mainDeploy.ID; id != 0 {
 					Logf("deploy: got id: %d", id)
 				}
 				gapiObj := mainDeploy.GAPI
 				if gapiObj == nil {
 					Logf("deploy: received empty gapi")
+					deployFailed(mainDeploy, fmt.Errorf("received empty gapi"))
 					continue
 				}
 
@@ -742,17 +818,28 @@ func (obj *Main) Run() error {
 				}
 				gapiImpl = gapiObj // copy it to active
 
+				gapiWorld := world
+				if fs := mainDeploy.VerifiedFs(); fs != nil {
+					// only read the files that were verified
+					gapiWorld = &verifiedWorld{
+						World: world,
+						fs:    fs,
+					}
+				}
 				data := &gapi.Data{
 					Program:  obj.Program,
 					Version:  obj.Version,
 					Hostname: hostname,
 					Local:    localAPI,
-					World:    world,
+					World:    gapiWorld,
 					Noop:     mainDeploy.Noop,
 					// FIXME: should the below flags come from the deploy struct?
 					//NoWatch:  obj.NoWatch,
 					NoStreamWatch: obj.NoStreamWatch,
 					Prefix:        fmt.Sprintf("%s/", path.Join(prefix, "gapi")),
+					Prometheus:    prom,
+					TraceFuncs:    obj.TraceFuncs,
+					TraceListen:   obj.TraceListen,
 					Debug:         obj.Debug,
 					Logf: func(format string, v ...interface{}) {
 						obj.Logf("gapi: "+format, v...)
@@ -763,6 +850,7 @@ func (obj *Main) Run() error {
 				}
 				if err := gapiImpl.Init(data); err != nil {
 					Logf("gapi: init failed: %+v", err)
+					deployFailed(mainDeploy, errwrap.Wrapf(err, "gapi: init failed"))
 					// TODO: consider running previous GAPI?
 				} else {
 					if obj.Debug {
@@ -780,6 +868,11 @@ func (obj *Main) Run() error {
 						Logf("gapi exited")
 					}
 					gapiChan = nil // disable it
+
+					// we never got a graph to plan
+					if obj.Plan {
+						obj.exit.Done(fmt.Errorf("the gapi exited without a graph"))
+					}
 					continue
 				}
 
@@ -795,6 +888,7 @@ func (obj *Main) Run() error {
 				// this means there was a failure, but not fatal
 				if err := next.Err; err != nil {
 					Logf("error with graph stream: %+v", err)
+					deployFailed(mainDeploy, errwrap.Wrapf(err, "error with graph stream"))
 					continue // wait for another event
 				}
 				// everything else passes through to cause a compile!
@@ -807,6 +901,7 @@ func (obj *Main) Run() error {
 
 			if gapiImpl == nil { // TODO: can this ever happen anymore?
 				Logf("gapi is empty!")
+				deployFailed(mainDeploy, fmt.Errorf("gapi is empty"))
 				continue
 			}
 			var timing time.Time
@@ -816,6 +911,7 @@ func (obj *Main) Run() error {
 			newGraph, err := gapiImpl.Graph() // generate graph!
 			if err != nil {
 				Logf("error creating new graph: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "error creating new graph"))
 				continue
 			}
 			Logf("new graph took: %s", time.Since(timing))
@@ -825,12 +921,14 @@ func (obj *Main) Run() error {
 
 			if err := obj.ge.Load(newGraph); err != nil { // copy in new graph
 				Logf("error copying in new graph: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "error copying in new graph"))
 				continue
 			}
 
 			if err := obj.ge.Validate(); err != nil { // validate the new graph
 				obj.ge.Abort() // delete graph
 				Logf("graph validate failed: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "graph validate failed"))
 				continue
 			}
 
@@ -864,6 +962,7 @@ func (obj *Main) Run() error {
 			}); err != nil { // apply an operation to the new graph
 				obj.ge.Abort() // delete graph
 				Logf("error applying operation to the new graph: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "error applying operation to the new graph"))
 				continue
 			}
 
@@ -876,6 +975,7 @@ func (obj *Main) Run() error {
 				if err := obj.ge.AutoEdge(); err != nil {
 					obj.ge.Abort() // delete graph
 					Logf("error running auto edges: %+v", err)
+					deployFailed(mainDeploy, errwrap.Wrapf(err, "error running auto edges"))
 					continue
 				}
 				Logf("auto edges took: %s", time.Since(timing))
@@ -887,6 +987,7 @@ func (obj *Main) Run() error {
 			if err := obj.ge.AutoGroup(&autogroup.NonReachabilityGrouper{}); err != nil {
 				obj.ge.Abort() // delete graph
 				Logf("error running auto grouping: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "error running auto grouping"))
 				continue
 			}
 			Logf("auto grouping took: %s", time.Since(timing))
@@ -896,6 +997,7 @@ func (obj *Main) Run() error {
 			if err := obj.ge.Reversals(); err != nil {
 				obj.ge.Abort() // delete graph
 				Logf("error running the reversals: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "error running the reversals"))
 				continue
 			}
 
@@ -967,6 +1069,7 @@ func (obj *Main) Run() error {
 			}); err != nil { // apply an operation to the new graph
 				obj.ge.Abort() // delete graph
 				Logf("error applying operation to the new graph: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "error applying operation to the new graph"))
 				continue
 			}
 			Logf("send/recv building took: %s", time.Since(timing))
@@ -979,6 +1082,7 @@ func (obj *Main) Run() error {
 			}); err != nil { // apply an operation to the new graph
 				obj.ge.Abort() // delete graph
 				Logf("error running the TopologicalSort: %+v", err)
+				deployFailed(mainDeploy, errwrap.Wrapf(err, "error running the TopologicalSort"))
 				continue
 			}
 			Logf("resource topological sort took: %s", time.Since(timing))
@@ -986,6 +1090,23 @@ func (obj *Main) Run() error {
 			// TODO: do we want to do a transitive reduction?
 			// FIXME: run a type checker that verifies all the send->recv relationships
 
+			// In plan mode we only look at the first graph, and we
+			// never commit it, so nothing will ever get applied.
+			if obj.Plan {
+				Logf("plan...")
+				timing = time.Now()
+				report, err := obj.ge.Plan(exitCtx)
+				obj.ge.Abort() // delete graph
+				if err != nil {
+					obj.exit.Done(errwrap.Wrapf(err, "plan failed"))
+					continue
+				}
+				Logf("plan took: %s", time.Since(timing))
+				obj.planReport = report
+				obj.exit.Done(nil) // we're done!
+				continue
+			}
+
 			// we need the vertices to be paused to work on them, so
 			// run graph vertex LOCK...
 			if started { // TODO: we can flatten this check out I think
@@ -1003,6 +1124,7 @@ func (obj *Main) Run() error {
 				// either shutdown or wait for the next deploy.
 				obj.ge.Abort() // delete graph
 				Logf("error running commit: %+v", err)
+				deployStatus(mainDeploy, deployer.DeployStatusFailed)
 				// block gapi until a newDeploy comes in...
 				if gapiImpl != nil { // currently running...
 					gapiChan = nil
@@ -1026,6 +1148,7 @@ func (obj *Main) Run() error {
 				// here which turns into a shutdown. Refactor!
 				obj.ge.Abort() // delete graph
 				Logf("error running the exporter Prune: %+v", err)
+				deployStatus(mainDeploy, deployer.DeployStatusFailed)
 				continue
 			}
 			Logf("export cleanup took: %s", time.Since(timing))
@@ -1036,10 +1159,15 @@ func (obj *Main) Run() error {
 			// resume anything that was pre-existing and was paused.
 			if err := obj.ge.Resume(); err != nil { // sync
 				Logf("error resuming graph: %+v", err)
+				deployStatus(mainDeploy, deployer.DeployStatusFailed)
 				continue
 			}
 			converger.Resume() // after Start()
 			started = true
+			if mainDeploy != nil {
+				atomic.StoreUint64(&appliedID, mainDeploy.ID)
+			}
+			deployStatus(mainDeploy, deployer.DeployStatusApplied)
 
 			Logf("graph: %+v", obj.ge.Graph()) // show graph
 			if obj.Graphviz != "" {
@@ -1125,6 +1253,7 @@ func (obj *Main) Run() error {
 		canceled := false
 
 		var last uint64
+		var newest uint64 // the newest deploy id that we verified
 		for {
 			if obj.NoDeployWatch && (obj.Deploy != nil || last > 0) {
 				// block here, because when we close the
@@ -1163,9 +1292,10 @@ func (obj *Main) Run() error {
 				//	return // exit via channel close instead
 			}
 
-			latest, err := world.GetMaxDeployID(ctx) // or zero
+			// this is usually the max id, unless there's a rollout
+			latest, err := world.GetDeployIDFor(ctx, hostname) // or zero
 			if err != nil {
-				Logf("error getting max deploy id: %+v", err)
+				Logf("error getting deploy id: %+v", err)
 				continue
 			}
 
@@ -1176,9 +1306,10 @@ func (obj *Main) Run() error {
 				continue
 			}
 
-			// if we're doing any deploy, don't run the previous one
+			// if we're doing any deploy, don't run the same one again
 			// (this might be useful if we get a double event here!)
-			if obj.Deploy == nil && latest <= last && latest != 0 {
+			// but an older one is okay, since a rollout can roll back
+			if obj.Deploy == nil && latest == last && latest != 0 {
 				// if latest and last are zero, pass through it!
 				continue
 			}
@@ -1188,6 +1319,16 @@ func (obj *Main) Run() error {
 				continue
 			}
 
+			// The rollout which picks our deploy isn't part of any
+			// signed deploy, so we only go back to an older deploy
+			// than one we already verified if it's signed as well.
+			if verifyRollback != nil && latest < newest {
+				if err := verifyRollback(ctx, latest, newest); err != nil {
+					Logf("deploy: refusing to go back from id %d to %d: %+v", newest, latest, err)
+					continue
+				}
+			}
+
 			// 0 passes through an empty deploy without an error...
 			// (unless there is some sort of etcd error that occurs)
 			str, err := world.GetDeploy(ctx, latest)
@@ -1225,9 +1366,21 @@ func (obj *Main) Run() error {
 			}
 			deploy.ID = latest // store the ID
 
+			// check the signature before the GAPI ever sees it
+			if verifyDeploy != nil {
+				if err := verifyDeploy(ctx, deploy, str); err != nil {
+					Logf("deploy: refusing id %d: %+v", latest, err)
+					deployStatus(&gapi.Deploy{ID: latest}, deployer.DeployStatusFailed)
+					continue
+				}
+			}
+
 			select {
 			case deployChan <- deploy:
 				last = latest // update last deployed
+				if verifyDeploy != nil && latest > newest {
+					newest = latest
+				}
 				// send
 				if obj.Debug {
 					Logf("deploy: sent new gapi")
@@ -1258,6 +1411,12 @@ func (obj *Main) Run() error {
 	return reterr
 }
 
+// PlanReport returns the report that was generated by the plan mode. It is nil
+// if we are not in plan mode, or if no plan was generated.
+func (obj *Main) PlanReport() *graph.PlanReport {
+	return obj.planReport
+}
+
 // Close contains a number of methods which must be run after the Run method.
 // You must run them to properly clean up after the main program execution.
 func (obj *Main) Close() error {
@@ -1297,3 +1456,37 @@ func (obj *Main) Interrupt(err error) {
 		obj.embdEtcd.Interrupt() // unblock borked clusters
 	}
 }
+
+// DefaultPrefix returns the working prefix directory which is used when none is
+// specified. It uses the systemd StateDirectory if it is set. If not, it uses
+// the XDG_CACHE_HOME directory unless the user is root, and then it uses
+// /var/lib/<program>/ instead.
+func DefaultPrefix(program string) (string, error) {
+	user, err := user.Current()
+	if err != nil {
+		return "", errwrap.Wrapf(err, "can't get current user")
+	}
+
+	var prefix = fmt.Sprintf("/var/lib/%s/", program) // default prefix
+	stateDir := os.Getenv("STATE_DIRECTORY")
+	// Ensure there is a / at the end of the directory path.
+	if stateDir != "" && !strings.HasSuffix(stateDir, "/") {
+		stateDir = stateDir + "/"
+	}
+
+	xdg := os.Getenv("XDG_CACHE_HOME")
+	// Ensure there is a / at the end of the directory path.
+	if xdg != "" && !strings.HasSuffix(xdg, "/") {
+		xdg = xdg + "/"
+	}
+	if xdg == "" && user.HomeDir != "" {
+		xdg = fmt.Sprintf("%s/.cache/%s/", user.HomeDir, program)
+	}
+
+	if stateDir != "" {
+		prefix = stateDir
+	} else if user.Uid != "0" {
+		prefix = xdg
+	}
+	return prefix, nil
+}
@@ -32,6 +32,7 @@ package main
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -40,7 +41,11 @@ import (
 	cliUtil "github.com/purpleidea/mgmt/cli/util"
 	"github.com/purpleidea/mgmt/entry"
 	_ "github.com/purpleidea/mgmt/gapi/empty"        // import so the gapi registers
+	_ "github.com/purpleidea/mgmt/lang/format"       // import so the tool registers
 	_ "github.com/purpleidea/mgmt/lang/gapi"         // import so the gapi registers
+	_ "github.com/purpleidea/mgmt/lang/lsp"          // import so the tool registers
+	_ "github.com/purpleidea/mgmt/lang/repl"         // import so the tool registers
+	_ "github.com/purpleidea/mgmt/lang/unittest"     // import so the tool registers
 	_ "github.com/purpleidea/mgmt/puppet"            // import so the gapi registers
 	_ "github.com/purpleidea/mgmt/puppet/langpuppet" // import so the gapi registers
 	"github.com/purpleidea/mgmt/util/pprof"
@@ -118,6 +123,12 @@ func main() {
 	}
 
 	if err := cli.CLI(context.Background(), data); err != nil {
+		var e *cliUtil.ExitError
+		if errors.As(err, &e) {
+			// stdout might have machine readable output on it
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(e.Code)
+		}
 		fmt.Println(err)
 		os.Exit(1)
 		return
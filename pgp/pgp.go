@@ -36,6 +36,7 @@ import (
 	"bytes"
 	"crypto"
 	"encoding/base64"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -70,10 +71,19 @@ func Import(privKeyPath string) (*PGP, error) {
 	}
 	defer privKeyFile.Close()
 
-	file := packet.NewReader(bufio.NewReader(privKeyFile))
-	entity, err := openpgp.ReadEntity(file)
-	if err != nil {
-		return nil, errwrap.Wrapf(err, "can't read entity from path")
+	r := bufio.NewReader(privKeyFile)
+	var entity *openpgp.Entity
+	if isArmored(r) { // as exported by `gpg --armor`
+		entities, err := openpgp.ReadArmoredKeyRing(r)
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "can't read armored entity from path")
+		}
+		entity = entities[0] // there's always at least one
+	} else {
+		entity, err = openpgp.ReadEntity(packet.NewReader(r))
+		if err != nil {
+			return nil, errwrap.Wrapf(err, "can't read entity from path")
+		}
 	}
 
 	obj := &PGP{
@@ -225,6 +235,60 @@ func (obj *PGP) GetIdentities() (string, error) {
 	return identities[0].Name, nil
 }
 
+// Sign returns an ASCII armored detached signature of the message.
+func (obj *PGP) Sign(msg []byte) (string, error) {
+	if obj.Entity.PrivateKey == nil {
+		return "", fmt.Errorf("can't sign without a private key")
+	}
+	if obj.Entity.PrivateKey.Encrypted {
+		return "", fmt.Errorf("can't sign with an encrypted private key")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := openpgp.ArmoredDetachSign(buf, obj.Entity, bytes.NewReader(msg), &CONFIG); err != nil {
+		return "", errwrap.Wrapf(err, "can't sign message")
+	}
+	return buf.String(), nil
+}
+
+// ReadKeyring reads a list of public keys from the file at the path. The keys
+// can be either ASCII armored or binary.
+func ReadKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if isArmored(r) {
+		keyring, err := openpgp.ReadArmoredKeyRing(r)
+		return keyring, errwrap.Wrapf(err, "can't read armored keyring")
+	}
+	keyring, err := openpgp.ReadKeyRing(r)
+	return keyring, errwrap.Wrapf(err, "can't read keyring")
+}
+
+// Verify checks that the ASCII armored detached signature of the message was
+// made by one of the keys in the keyring. It returns the key which signed it.
+func Verify(keyring openpgp.EntityList, msg []byte, signature string) (*openpgp.Entity, error) {
+	if signature == "" {
+		return nil, fmt.Errorf("the message is not signed")
+	}
+	entity, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(msg), strings.NewReader(signature))
+	if err != nil {
+		return nil, errwrap.Wrapf(err, "can't verify signature")
+	}
+	return entity, nil
+}
+
+// isArmored returns true if the reader starts with an ASCII armor header. It
+// doesn't consume anything from the reader.
+func isArmored(r *bufio.Reader) bool {
+	b, _ := r.Peek(len("-----BEGIN"))
+	return string(b) == "-----BEGIN"
+}
+
 // ParseIdentity parses an identity into name, comment and email components.
 func ParseIdentity(identity string) (name, comment, email string, err error) {
 	// get name
@@ -0,0 +1,85 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package pgp
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestSignVerify1(t *testing.T) {
+	deployer, err := Generate("deployer", "test", "deployer@example.com", nil)
+	if err != nil {
+		t.Errorf("could not generate key: %+v", err)
+		return
+	}
+	other, err := Generate("other", "test", "other@example.com", nil)
+	if err != nil {
+		t.Errorf("could not generate key: %+v", err)
+		return
+	}
+
+	// round trip through a file, like the cli does
+	p := filepath.Join(t.TempDir(), DefaultKeyringFile)
+	if err := deployer.SaveKey(p); err != nil {
+		t.Errorf("could not save key: %+v", err)
+		return
+	}
+	key, err := Import(p)
+	if err != nil {
+		t.Errorf("could not import key: %+v", err)
+		return
+	}
+
+	msg := []byte("id: 1\nhash: abc\n")
+	signature, err := key.Sign(msg)
+	if err != nil {
+		t.Errorf("could not sign: %+v", err)
+		return
+	}
+
+	keyring := openpgp.EntityList{deployer.Entity}
+	if _, err := Verify(keyring, msg, signature); err != nil {
+		t.Errorf("signature should verify: %+v", err)
+	}
+	if _, err := Verify(keyring, []byte("id: 2\nhash: abc\n"), signature); err == nil {
+		t.Errorf("signature of another message should not verify")
+	}
+	if _, err := Verify(openpgp.EntityList{other.Entity}, msg, signature); err == nil {
+		t.Errorf("signature should not verify with an untrusted key")
+	}
+	if _, err := Verify(keyring, msg, ""); err == nil {
+		t.Errorf("a missing signature should not verify")
+	}
+}
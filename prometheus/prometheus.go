@@ -36,6 +36,7 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -69,6 +70,15 @@ type Prometheus struct {
 	failedResourcesTotal   *prometheus.CounterVec // Total of failures since mgmt has started
 	failedResources        *prometheus.GaugeVec   // Number of current resources
 
+	checkApplyDuration *prometheus.HistogramVec // duration of each CheckApply
+	watchEventsTotal   *prometheus.CounterVec   // total of Watch events
+	retriesTotal       *prometheus.CounterVec   // total of Watch and CheckApply retries
+	graphSwapDuration  prometheus.Histogram     // duration of each graph swap
+
+	functionNodes             prometheus.Gauge         // number of nodes in the function graph
+	functionStreamEventsTotal *prometheus.CounterVec   // total of values that each function streamed
+	functionFirstValue        *prometheus.HistogramVec // time until each function streamed its first value
+
 	resourcesState map[string]resStateWithKind // Maps the resources with their current kind/state
 	mutex          *sync.Mutex                 // Mutex used to update resourcesState
 }
@@ -142,6 +152,77 @@ func (obj *Prometheus) Init() error {
 	)
 	prometheus.MustRegister(obj.failedResources)
 
+	obj.checkApplyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "mgmt_checkapply_duration_seconds",
+			Help: "Duration of CheckApply in seconds.",
+			// from 1ms to about 4 minutes, since packages are slow
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+		// kind: resource type: Svc, File, ...
+		[]string{"kind"},
+	)
+	prometheus.MustRegister(obj.checkApplyDuration)
+
+	obj.watchEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mgmt_watch_events_total",
+			Help: "Number of events that Watch has sent.",
+		},
+		// kind: resource type: Svc, File, ...
+		[]string{"kind"},
+	)
+	prometheus.MustRegister(obj.watchEventsTotal)
+
+	obj.retriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mgmt_retries_total",
+			Help: "Number of retries after a failure.",
+		},
+		// kind: resource type: Svc, File, ...
+		// type: what was retried: watch or checkapply
+		[]string{"kind", "type"},
+	)
+	prometheus.MustRegister(obj.retriesTotal)
+
+	obj.graphSwapDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "mgmt_graph_swap_duration_seconds",
+			Help:    "Duration of swapping in a new resource graph in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+	)
+	prometheus.MustRegister(obj.graphSwapDuration)
+
+	obj.functionNodes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mgmt_function_nodes",
+			Help: "Number of nodes in the function graph.",
+		},
+	)
+	prometheus.MustRegister(obj.functionNodes)
+
+	obj.functionStreamEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mgmt_function_stream_events_total",
+			Help: "Number of values that functions have streamed.",
+		},
+		// function: name of the function: datetime.now, const, ...
+		[]string{"function"},
+	)
+	prometheus.MustRegister(obj.functionStreamEventsTotal)
+
+	obj.functionFirstValue = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mgmt_function_first_value_seconds",
+			Help:    "Time from starting a function until it streams its first value in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		},
+		// function: name of the function: datetime.now, const, ...
+		[]string{"function"},
+	)
+	prometheus.MustRegister(obj.functionFirstValue)
+
 	return nil
 }
 
@@ -183,6 +264,11 @@ func (obj *Prometheus) InitKindMetrics(kinds []string) error {
 		}
 
 		obj.managedResources.With(prometheus.Labels{"kind": kind})
+		obj.checkApplyDuration.With(prometheus.Labels{"kind": kind})
+		obj.watchEventsTotal.With(prometheus.Labels{"kind": kind})
+		for _, t := range []string{"watch", "checkapply"} {
+			obj.retriesTotal.With(prometheus.Labels{"kind": kind, "type": t})
+		}
 
 		failures := []string{"soft", "hard"}
 		for _, f := range failures {
@@ -216,6 +302,74 @@ func (obj *Prometheus) UpdatePgraphStartTime() error {
 	return nil
 }
 
+// ObserveCheckApplyDuration adds the duration of a CheckApply to the histogram
+// for this kind of resource.
+func (obj *Prometheus) ObserveCheckApplyDuration(kind string, d time.Duration) error {
+	if obj == nil {
+		return nil // happens when mgmt is launched without --prometheus
+	}
+	obj.checkApplyDuration.With(prometheus.Labels{"kind": kind}).Observe(d.Seconds())
+	return nil
+}
+
+// UpdateWatchEventsTotal increments the Watch event counter for this kind of
+// resource.
+func (obj *Prometheus) UpdateWatchEventsTotal(kind string) error {
+	if obj == nil {
+		return nil // happens when mgmt is launched without --prometheus
+	}
+	obj.watchEventsTotal.With(prometheus.Labels{"kind": kind}).Inc()
+	return nil
+}
+
+// UpdateRetriesTotal increments the retry counter for this kind of resource.
+// The type is what was retried, which is either watch or checkapply.
+func (obj *Prometheus) UpdateRetriesTotal(kind, typ string) error {
+	if obj == nil {
+		return nil // happens when mgmt is launched without --prometheus
+	}
+	obj.retriesTotal.With(prometheus.Labels{"kind": kind, "type": typ}).Inc()
+	return nil
+}
+
+// ObserveGraphSwapDuration adds the duration of a graph swap to the histogram.
+func (obj *Prometheus) ObserveGraphSwapDuration(d time.Duration) error {
+	if obj == nil {
+		return nil // happens when mgmt is launched without --prometheus
+	}
+	obj.graphSwapDuration.Observe(d.Seconds())
+	return nil
+}
+
+// UpdateFunctionNodes sets the number of nodes in the function graph.
+func (obj *Prometheus) UpdateFunctionNodes(n int) error {
+	if obj == nil {
+		return nil // happens when mgmt is launched without --prometheus
+	}
+	obj.functionNodes.Set(float64(n))
+	return nil
+}
+
+// UpdateFunctionStreamEventsTotal increments the counter of streamed values for
+// this function.
+func (obj *Prometheus) UpdateFunctionStreamEventsTotal(function string) error {
+	if obj == nil {
+		return nil // happens when mgmt is launched without --prometheus
+	}
+	obj.functionStreamEventsTotal.With(prometheus.Labels{"function": function}).Inc()
+	return nil
+}
+
+// ObserveFunctionFirstValue adds the time that this function took to stream its
+// first value to the histogram.
+func (obj *Prometheus) ObserveFunctionFirstValue(function string, d time.Duration) error {
+	if obj == nil {
+		return nil // happens when mgmt is launched without --prometheus
+	}
+	obj.functionFirstValue.With(prometheus.Labels{"function": function}).Observe(d.Seconds())
+	return nil
+}
+
 // AddManagedResource increments the Managed Resource counter and updates the
 // resource status.
 func (obj *Prometheus) AddManagedResource(resUUID string, rtype string) error {
@@ -70,6 +70,15 @@ func TestInitKindMetrics(t *testing.T) {
 		"mgmt_resources": {
 			2, 0,
 		},
+		"mgmt_checkapply_duration_seconds": {
+			2, 0,
+		},
+		"mgmt_watch_events_total": {
+			2, 0,
+		},
+		"mgmt_retries_total": {
+			4, 0,
+		},
 	}
 
 	for _, metric := range metrics {
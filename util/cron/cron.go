@@ -0,0 +1,258 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+// Package cron implements a parser for cron style schedule expressions, and the
+// scheduling logic to find the next time that matches one of them. It supports
+// the usual five fields of minute, hour, day of month, month and day of week,
+// with lists, ranges, steps and the three letter month and day names. The
+// @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly macros
+// are supported too. Like with the traditional cron, if both the day of month
+// and the day of week are restricted, then a day matches if either one does.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// macros are the shortcuts that expand to full expressions.
+	macros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+
+	months = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	days   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// field describes the allowed values of one of the fields.
+type field struct {
+	name  string
+	min   uint
+	max   uint
+	names []string // names for the values, starting at min
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: months},
+	{name: "day of week", min: 0, max: 7, names: days}, // 7 is also sunday
+}
+
+// Schedule is a parsed cron expression. Build it with Parse.
+type Schedule struct {
+	minute uint64 // bit set of allowed values
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domStar bool // did the day of month start with a star?
+	dowStar bool // did the day of week start with a star?
+
+	spec string
+}
+
+// Parse parses a cron expression and returns the schedule it represents.
+func Parse(spec string) (*Schedule, error) {
+	s := strings.TrimSpace(spec)
+	if m, exists := macros[strings.ToLower(s)]; exists {
+		s = m
+	} else if strings.HasPrefix(s, "@") {
+		return nil, fmt.Errorf("unknown macro: %s", s)
+	}
+
+	split := strings.Fields(s)
+	if l := len(split); l != len(fields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(fields), l)
+	}
+
+	bits := []uint64{}
+	for i, f := range fields {
+		b, err := f.parse(split[i])
+		if err != nil {
+			return nil, err
+		}
+		bits = append(bits, b)
+	}
+
+	obj := &Schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+
+		domStar: strings.HasPrefix(split[2], "*") || split[2] == "?",
+		dowStar: strings.HasPrefix(split[4], "*") || split[4] == "?",
+
+		spec: spec,
+	}
+	if obj.dow&(1<<7) != 0 { // sunday is both 0 and 7
+		obj.dow |= 1 << 0
+	}
+	return obj, nil
+}
+
+// String returns the expression that this schedule was parsed from.
+func (obj *Schedule) String() string {
+	return obj.spec
+}
+
+// parse parses a single field into a bit set of the allowed values.
+func (obj field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, x := range strings.Split(s, ",") {
+		b, err := obj.parseRange(x)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s field `%s`: %v", obj.name, s, err)
+		}
+		bits |= b
+	}
+	return bits, nil
+}
+
+// parseRange parses a single element of a list, which is either a star, a
+// value, or a range, and which can have a step.
+func (obj field) parseRange(s string) (uint64, error) {
+	rng, step := s, uint(1)
+	if i := strings.Index(s, "/"); i >= 0 {
+		n, err := strconv.ParseUint(s[i+1:], 10, 8)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid step: %s", s[i+1:])
+		}
+		rng, step = s[:i], uint(n)
+	}
+
+	var start, end uint
+	switch i := strings.Index(rng, "-"); {
+	case rng == "*" || rng == "?":
+		start, end = obj.min, obj.max
+
+	case i >= 0:
+		var err error
+		if start, err = obj.value(rng[:i]); err != nil {
+			return 0, err
+		}
+		if end, err = obj.value(rng[i+1:]); err != nil {
+			return 0, err
+		}
+		if start > end {
+			return 0, fmt.Errorf("invalid range: %s", rng)
+		}
+
+	default:
+		v, err := obj.value(rng)
+		if err != nil {
+			return 0, err
+		}
+		start, end = v, v
+		if step > 1 { // the usual meaning of 5/15 is 5-max/15
+			end = obj.max
+		}
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << i
+	}
+	return bits, nil
+}
+
+// value parses a single value or name.
+func (obj field) value(s string) (uint, error) {
+	for i, x := range obj.names {
+		if strings.ToLower(s) == x {
+			return obj.min + uint(i), nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %s", s)
+	}
+	if v := uint(n); v < obj.min || v > obj.max {
+		return 0, fmt.Errorf("value %d is out of range %d-%d", v, obj.min, obj.max)
+	}
+	return uint(n), nil
+}
+
+// matchDay returns true if the day of this time is allowed by the schedule.
+func (obj *Schedule) matchDay(t time.Time) bool {
+	dom := obj.dom&(1<<uint(t.Day())) != 0
+	dow := obj.dow&(1<<uint(t.Weekday())) != 0
+	if obj.domStar || obj.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the next time after the given one that matches the schedule. The
+// result is in the same location as the input. If no time matches within the
+// next few years, such as with the 30th of February, then the zero time is
+// returned.
+func (obj *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	// start at the beginning of the next minute
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if obj.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !obj.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if obj.hour&(1<<uint(t.Hour())) == 0 {
+			// this adds a duration so that it can't get stuck on an
+			// hour which is repeated when the DST ends
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if obj.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,112 @@
+// Mgmt
+// Copyright (C) James Shubin and the project contributors
+// Written by James Shubin <james@shubin.ca> and the project contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+//
+// Additional permission under GNU GPL version 3 section 7
+//
+// If you modify this program, or any covered work, by linking or combining it
+// with embedded mcl code and modules (and that the embedded mcl code and
+// modules which link with this program, contain a copy of their source code in
+// the authoritative form) containing parts covered by the terms of any other
+// license, the licensors of this program grant you additional permission to
+// convey the resulting work. Furthermore, the licensors of this program grant
+// the original author, James Shubin, additional permission to update this
+// additional permission if he deems it necessary to achieve the goals of this
+// additional permission.
+
+//go:build !root
+
+package cron
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseFail(t *testing.T) {
+	for index, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@sometimes",
+	} {
+		t.Run(fmt.Sprintf("test #%d (%s)", index, spec), func(t *testing.T) {
+			if _, err := Parse(spec); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	type test struct { // an individual test
+		spec string
+		now  string
+		next string
+	}
+	testCases := []test{
+		{"* * * * *", "2024-03-04 10:20:30", "2024-03-04 10:21:00"},
+		{"* * * * *", "2024-03-04 10:20:00", "2024-03-04 10:21:00"},
+		{"*/15 * * * *", "2024-03-04 10:20:30", "2024-03-04 10:30:00"},
+		{"5/15 * * * *", "2024-03-04 10:51:00", "2024-03-04 11:05:00"},
+		{"0 3 * * *", "2024-03-04 10:20:30", "2024-03-05 03:00:00"},
+		{"30 2-4 * * *", "2024-03-04 03:30:00", "2024-03-04 04:30:00"},
+		{"0 0 1,15 * *", "2024-03-04 10:20:30", "2024-03-15 00:00:00"},
+		{"0 0 * * mon", "2024-03-04 10:20:30", "2024-03-11 00:00:00"},
+		{"0 0 * * 7", "2024-03-04 10:20:30", "2024-03-10 00:00:00"},
+		{"0 0 13 * fri", "2024-03-04 10:20:30", "2024-03-08 00:00:00"}, // either day matches
+		{"0 0 29 feb *", "2024-03-04 10:20:30", "2028-02-29 00:00:00"},
+		{"0 0 31 * *", "2024-04-01 10:20:30", "2024-05-31 00:00:00"},
+		{"@yearly", "2024-03-04 10:20:30", "2025-01-01 00:00:00"},
+		{"@hourly", "2024-12-31 23:20:30", "2025-01-01 00:00:00"},
+		{"0 0 30 feb *", "2024-03-04 10:20:30", ""}, // never
+	}
+
+	for index, tc := range testCases {
+		spec, now, next := tc.spec, tc.now, tc.next
+		t.Run(fmt.Sprintf("test #%d (%s)", index, spec), func(t *testing.T) {
+			sched, err := Parse(spec)
+			if err != nil {
+				t.Errorf("parse failed with: %v", err)
+				return
+			}
+			n, err := time.ParseInLocation(time.DateTime, now, time.UTC)
+			if err != nil {
+				t.Errorf("bad test time: %v", err)
+				return
+			}
+			got := sched.Next(n)
+			if next == "" {
+				if !got.IsZero() {
+					t.Errorf("expected zero time, got: %s", got)
+				}
+				return
+			}
+			if s := got.Format(time.DateTime); s != next {
+				t.Errorf("expected: %s, got: %s", next, s)
+			}
+		})
+	}
+}
@@ -48,6 +48,9 @@ const (
 	// FamilyArchLinux represents primarily ArchLinux.
 	FamilyArchLinux = "archlinux"
 
+	// FamilyAlpine represents primarily Alpine Linux.
+	FamilyAlpine = "alpine"
+
 	// DistroDebian is the Debian distro.
 	DistroDebian = "debian"
 
@@ -72,6 +75,15 @@ var (
 		},
 	}
 
+	// MapFamilyToPackageManager is a map of distro family to the name of
+	// the native package manager that it uses.
+	MapFamilyToPackageManager = map[string]string{
+		FamilyRedHat:    "dnf",
+		FamilyDebian:    "apt",
+		FamilyArchLinux: "pacman",
+		FamilyAlpine:    "apk",
+	}
+
 	// MapDistroToGuestfsPackages is a map of distro to packages needed to
 	// run the virt-builder software and guestfs suite.
 	MapDistroToGuestfsPackages = map[string][]string{
@@ -100,6 +112,13 @@ func ToGuestfsPackages(distro string) ([]string, bool) {
 	return l, exists
 }
 
+// ToPackageManager returns the name of the native package manager that is used
+// by the distro family. This returns false if the value doesn't exist.
+func ToPackageManager(family string) (string, bool) {
+	s, exists := MapFamilyToPackageManager[family]
+	return s, exists
+}
+
 // Family returns the distro family.
 func Family(ctx context.Context) (string, error) {
 	if b, err := IsFamilyRedHat(ctx); err != nil {
@@ -117,6 +136,11 @@ func Family(ctx context.Context) (string, error) {
 	} else if b {
 		return FamilyArchLinux, nil
 	}
+	if b, err := IsFamilyAlpine(ctx); err != nil {
+		return "", err
+	} else if b {
+		return FamilyAlpine, nil
+	}
 	return "", nil // unknown
 }
 
@@ -159,6 +183,19 @@ func IsFamilyArchLinux(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+// IsFamilyAlpine detects if the os family is alpine.
+func IsFamilyAlpine(ctx context.Context) (bool, error) {
+	// TODO: use ctx around io operations
+	_, err := os.Stat("/etc/alpine-release")
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // Distro returns the distro name.
 func Distro(ctx context.Context) (string, error) {
 	output, err := parseOSRelease(ctx)
@@ -168,6 +205,16 @@ func Distro(ctx context.Context) (string, error) {
 	return output.ID, nil
 }
 
+// Codename returns the codename of the distro release, such as bookworm. Some
+// distros don't have one, in which case this is empty.
+func Codename(ctx context.Context) (string, error) {
+	output, err := parseOSRelease(ctx)
+	if err != nil {
+		return "", err
+	}
+	return output.VERSION_CODENAME, nil
+}
+
 // IsDistroDebian detects if the os distro is debian. (Not ubuntu!)
 func IsDistroDebian(ctx context.Context) (bool, error) {
 	output, err := parseOSRelease(ctx)
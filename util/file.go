@@ -31,6 +31,7 @@ package util
 
 import (
 	"os"
+	"path/filepath"
 )
 
 // AppendFile writes data to the named file, creating it if necessary. If it
@@ -51,3 +52,34 @@ func AppendFile(name string, data []byte, perm os.FileMode) error {
 	}
 	return err
 }
+
+// WriteFileAtomic writes data to the named file like os.WriteFile does, but it
+// first writes to a temporary file in the same directory and then renames it
+// over the original. As a result, a reader sees either the old contents or the
+// new ones, but never a partially written file. If the name is a symlink, then
+// the link itself gets replaced. The file gets exactly the permissions perm.
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(name)
+	f, err := os.CreateTemp(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // ignore error, it's gone after a rename
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	// CreateTemp makes the file 0600, so set the mode we want to end up with.
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -306,7 +306,9 @@ func (obj *RecWatcher) Watch() error {
 				// if event.Name startswith safename, send event, we're already deeper
 			} else if util.HasPathPrefix(event.Name, obj.safename) {
 				//obj.options.logf("event2!")
-				send = true
+				if !obj.skip(event.Name, isDir(event.Name)) {
+					send = true
+				}
 			}
 
 			// do all our event sending all together to avoid duplicate msgs
@@ -345,6 +347,9 @@ func (obj *RecWatcher) addSubFolders(p string) error {
 			return nil
 		}
 		if info.IsDir() {
+			if path != p && obj.skip(path, true) {
+				return filepath.SkipDir // don't watch filtered dirs
+			}
 			obj.watches[path] = struct{}{} // add key
 			err := obj.watcher.Add(path)
 			if err != nil {
@@ -357,12 +362,21 @@ func (obj *RecWatcher) addSubFolders(p string) error {
 	return err
 }
 
+// skip returns true if the path has been filtered out by the Filter option.
+func (obj *RecWatcher) skip(p string, isDir bool) bool {
+	if obj.options.filter == nil || p == obj.safename {
+		return false // we never filter out the path we're watching
+	}
+	return obj.options.filter(p, isDir)
+}
+
 // Option is a type that can be used to configure the recwatcher.
 type Option func(*recwatchOptions)
 
 type recwatchOptions struct {
-	debug bool
-	logf  func(format string, v ...interface{})
+	debug  bool
+	logf   func(format string, v ...interface{})
+	filter func(path string, isDir bool) bool
 	// TODO: add more options
 }
 
@@ -380,6 +394,16 @@ func Logf(logf func(format string, v ...interface{})) Option {
 	}
 }
 
+// Filter passes a function which decides if a path should be skipped. It gets
+// called with the absolute path, and whether it's a directory, and it returns
+// true if we should skip it. A skipped directory won't be watched recursively
+// and a skipped path won't generate any events.
+func Filter(filter func(path string, isDir bool) bool) Option {
+	return func(rwo *recwatchOptions) {
+		rwo.filter = filter
+	}
+}
+
 func isDir(path string) bool {
 	finfo, err := os.Stat(path)
 	if err != nil {
@@ -31,6 +31,19 @@ package util
 
 import (
 	"fmt"
+	"os/user"
+	"path"
+
+	"github.com/purpleidea/mgmt/util/errwrap"
+)
+
+const (
+	// SystemdUnitDirSystem is the directory for local system unit files.
+	SystemdUnitDirSystem = "/etc/systemd/system/"
+
+	// SystemdUnitDirUser is the directory in a users home for their local
+	// session unit files.
+	SystemdUnitDirUser = ".config/systemd/user/"
 )
 
 // UnitData is the data struct used to build a systemd unit file. This isn't an
@@ -132,3 +145,20 @@ func (obj *UnitData) Template() (string, error) {
 
 	return data, nil
 }
+
+// SystemdUnitDir returns the directory that local systemd unit files go in. If
+// session is true, then this is the directory for the current user's session.
+// The returned path has a trailing slash.
+func SystemdUnitDir(session bool) (string, error) {
+	if !session {
+		return SystemdUnitDirSystem, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", errwrap.Wrapf(err, "error getting current user")
+	}
+	if u.HomeDir == "" {
+		return "", fmt.Errorf("user has no home directory")
+	}
+	return path.Join(u.HomeDir, SystemdUnitDirUser) + "/", nil
+}